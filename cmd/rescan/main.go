@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"database/sql"
 	"encoding/hex"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"github.com/beyondxinxin/nixvis/internal/storage"
 	"github.com/beyondxinxin/nixvis/internal/util"
 	_ "modernc.org/sqlite"
 )
@@ -23,6 +25,16 @@ var (
 )
 
 func main() {
+	siteName := flag.String("site", "", "只处理指定的站点（站点名称），恢复归档时必须指定")
+	listArchives := flag.Bool("archives", false, "列出站点已归档的日期")
+	restoreDate := flag.String("restore", "", "恢复站点某一天的归档日志，格式 2006-01-02")
+	flag.Parse()
+
+	if *listArchives || *restoreDate != "" {
+		restoreArchive(*siteName, *listArchives, *restoreDate)
+		return
+	}
+
 	fmt.Println("开始重新扫描日志并标记蜘蛛和可疑 IP...")
 
 	err := netparser.InitIPGeoLocation()
@@ -48,6 +60,9 @@ func main() {
 	totalUpdated := 0
 
 	for _, website := range cfg.Websites {
+		if *siteName != "" && website.Name != *siteName {
+			continue
+		}
 		websiteID := generateID(website.Name)
 
 		fmt.Printf("\n处理网站: %s (%s)\n", website.Name, websiteID)
@@ -67,6 +82,55 @@ func main() {
 	fmt.Printf("更新记录数: %d\n", totalUpdated)
 }
 
+// restoreArchive 列出或恢复站点的归档日志。服务运行时建议在日志页面或通过 /api/archive/restore 恢复，
+// 服务会暂停扫描并在恢复后刷新统计缓存
+func restoreArchive(siteName string, list bool, date string) {
+	if siteName == "" {
+		fmt.Println("用法: rescan -site <站点名称> [-archives | -restore 2006-01-02]")
+		return
+	}
+
+	cfg := util.ReadConfig()
+	found := false
+	for _, website := range cfg.Websites {
+		if website.Name == siteName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		fmt.Printf("站点 %s 不存在\n", siteName)
+		return
+	}
+	websiteID := util.GenerateID(siteName)
+
+	repo, err := storage.NewRepository()
+	if err != nil {
+		fmt.Printf("打开数据库失败: %v\n", err)
+		return
+	}
+	defer repo.Close()
+
+	if list {
+		entries, err := repo.ListArchivedDays(websiteID)
+		if err != nil {
+			fmt.Printf("读取归档清单失败: %v\n", err)
+			return
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s  %d 条  %s\n", entry.Date, entry.Rows, entry.File)
+		}
+		return
+	}
+
+	restored, err := repo.RestoreArchivedDay(websiteID, date)
+	if err != nil {
+		fmt.Printf("恢复失败: %v\n", err)
+		return
+	}
+	fmt.Printf("站点 %s 在 %s 的 %d 条日志已恢复\n", siteName, date, restored)
+}
+
 func processWebsite(db *sql.DB, websiteID, logPath string) (int, int, error) {
 	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
 
@@ -0,0 +1,287 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/stats"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRunningJobs 同时执行的后台导出任务数，其余任务排队等待
+	maxRunningJobs = 2
+	// progressInterval 后台导出每写入多少行更新一次任务进度
+	progressInterval = 10000
+)
+
+// logColumns 日志导出的列，与日志查询接口返回的字段一致
+var logColumns = []string{
+	"id", "time", "timestamp", "ip", "method", "url", "status_code", "bytes_sent", "referer",
+	"user_browser", "user_os", "user_device", "domestic_location", "global_location", "pageview_flag",
+	"is_spider", "spider_type", "spider_name", "is_suspicious", "suspicious_type", "suspicious_reason",
+}
+
+// Manager 将统计结果和日志查询导出为文件，可以直接写入响应，也可以作为后台任务生成文件
+type Manager struct {
+	statsFactory *stats.StatsFactory
+	repo         *storage.Repository
+	slots        chan struct{}
+
+	mu      sync.Mutex
+	running map[int64]runningJob // 排队或执行中的任务
+}
+
+// runningJob 排队或执行中的导出任务
+type runningJob struct {
+	websiteID string
+	cancel    context.CancelFunc
+}
+
+// NewManager 创建导出管理器，上次服务停止时未完成的任务标记为失败
+func NewManager(statsFactory *stats.StatsFactory, repo *storage.Repository) *Manager {
+	if err := repo.FailUnfinishedExportJobs(); err != nil {
+		logrus.WithError(err).Error("更新中断的导出任务失败")
+	}
+	return &Manager{
+		statsFactory: statsFactory,
+		repo:         repo,
+		slots:        make(chan struct{}, maxRunningJobs),
+		running:      make(map[int64]runningJob),
+	}
+}
+
+// Prepare 校验导出类型和查询参数，参数与 /api/stats/:type 相同。
+// 导出日志时忽略分页参数，排序未指定时按时间降序
+func (m *Manager) Prepare(exportType string, params map[string]string) (stats.StatsQuery, error) {
+	if exportType == "logs" {
+		defaults := map[string]string{"page": "1", "pageSize": "1", "sortField": "timestamp", "sortOrder": "desc"}
+		withDefaults := make(map[string]string, len(params)+len(defaults))
+		for key, value := range params {
+			if key == "cursor" {
+				continue
+			}
+			withDefaults[key] = value
+		}
+		for key, value := range defaults {
+			if withDefaults[key] == "" {
+				withDefaults[key] = value
+			}
+		}
+		params = withDefaults
+	}
+	return m.statsFactory.BuildQueryFromRequest(exportType, params)
+}
+
+// Write 按格式写出导出内容，返回写入的数据行数。
+// 统计结果先完成查询再开始写出，查询出错时 w 中不会有任何内容
+func (m *Manager) Write(ctx context.Context, w io.Writer, format, exportType string, query stats.StatsQuery,
+	progress func(rows int)) (int, error) {
+
+	if exportType == "logs" {
+		return m.writeLogs(ctx, w, format, query, progress)
+	}
+
+	result, err := m.statsFactory.QueryStats(exportType, query)
+	if err != nil {
+		return 0, fmt.Errorf("查询统计数据失败: %v", err)
+	}
+	tables := Tables(exportType, result)
+
+	writer, err := NewWriter(format, w, len(tables))
+	if err != nil {
+		return 0, err
+	}
+	rows := 0
+	for _, t := range tables {
+		if err := writer.Begin(t.Name, t.Columns); err != nil {
+			return rows, err
+		}
+		for _, row := range t.Rows {
+			if err := writer.Row(row); err != nil {
+				return rows, err
+			}
+			rows++
+		}
+	}
+	return rows, writer.Close()
+}
+
+// writeLogs 逐批读取并写出匹配的日志
+func (m *Manager) writeLogs(ctx context.Context, w io.Writer, format string, query stats.StatsQuery,
+	progress func(rows int)) (int, error) {
+
+	writer, err := NewWriter(format, w, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err := writer.Begin("logs", logColumns); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	values := make([]interface{}, len(logColumns))
+	err = m.statsFactory.StreamLogs(query, func(log stats.LogEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		values[0], values[1], values[2], values[3] = log.ID, log.Time, log.Timestamp, log.IP
+		values[4], values[5], values[6], values[7] = log.Method, log.URL, log.StatusCode, log.BytesSent
+		values[8], values[9], values[10], values[11] = log.Referer, log.UserBrowser, log.UserOS, log.UserDevice
+		values[12], values[13], values[14] = log.DomesticLocation, log.GlobalLocation, log.PageviewFlag
+		values[15], values[16], values[17] = log.IsSpider, log.SpiderType, log.SpiderName
+		values[18], values[19], values[20] = log.IsSuspicious, log.SuspiciousType, log.SuspiciousReason
+		if err := writer.Row(values); err != nil {
+			return err
+		}
+		rows++
+		if progress != nil && rows%progressInterval == 0 {
+			progress(rows)
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, writer.Close()
+}
+
+// Submit 创建后台导出任务，任务在空闲的导出槽位中执行
+func (m *Manager) Submit(job storage.ExportJob) (storage.ExportJob, error) {
+	if err := ValidFormat(job.Format); err != nil {
+		return job, err
+	}
+	query, err := m.Prepare(job.Type, job.Params)
+	if err != nil {
+		return job, err
+	}
+	job.WebsiteID = query.WebsiteID
+	job.FileName = FileName(job.Type, job.WebsiteID, job.Format, time.Now())
+
+	job, err = m.repo.CreateExportJob(job)
+	if err != nil {
+		return job, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.running[job.ID] = runningJob{websiteID: job.WebsiteID, cancel: cancel}
+	m.mu.Unlock()
+
+	go m.run(ctx, job, query)
+	return job, nil
+}
+
+// Delete 删除用户的导出任务及文件，任务尚未完成时先取消
+func (m *Manager) Delete(userID, id int64) error {
+	if _, err := m.repo.GetExportJob(userID, id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if job, ok := m.running[id]; ok {
+		job.cancel()
+	}
+	m.mu.Unlock()
+	return m.repo.DeleteExportJob(userID, id)
+}
+
+// CancelWebsite 取消站点排队或执行中的导出任务，站点数据被清除前调用。
+// 任务记录和已生成的文件随站点数据一起删除
+func (m *Manager) CancelWebsite(websiteID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.running {
+		if job.websiteID == websiteID {
+			job.cancel()
+		}
+	}
+}
+
+// run 等待导出槽位后执行任务，文件先写入临时文件，完成后再改名，下载时不会读到写了一半的文件
+func (m *Manager) run(ctx context.Context, job storage.ExportJob, query stats.StatsQuery) {
+	defer func() {
+		m.mu.Lock()
+		if running, ok := m.running[job.ID]; ok {
+			running.cancel()
+			delete(m.running, job.ID)
+		}
+		m.mu.Unlock()
+	}()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	if err := m.repo.UpdateExportProgress(job.ID, storage.ExportRunning, 0); err != nil {
+		logrus.WithError(err).Error("更新导出任务状态失败")
+	}
+	rows, size, err := m.writeFile(ctx, job, query)
+	if ctx.Err() != nil {
+		// 任务已被删除，删除在改名之前时文件仍会留下
+		os.Remove(job.FilePath())
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("导出任务 %d 失败", job.ID)
+	} else {
+		logrus.Infof("导出任务 %d 完成，共 %d 行", job.ID, rows)
+	}
+	if err := m.repo.FinishExportJob(job.ID, rows, size, err); err != nil {
+		logrus.WithError(err).Error("更新导出任务状态失败")
+	}
+}
+
+func (m *Manager) writeFile(ctx context.Context, job storage.ExportJob, query stats.StatsQuery) (int, int64, error) {
+	path := job.FilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, 0, fmt.Errorf("创建导出目录失败: %v", err)
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("创建导出文件失败: %v", err)
+	}
+	defer os.Remove(tmpPath)
+
+	rows, err := m.Write(ctx, f, job.Format, job.Type, query, func(rows int) {
+		if err := m.repo.UpdateExportProgress(job.ID, storage.ExportRunning, rows); err != nil {
+			logrus.WithError(err).Error("更新导出任务进度失败")
+		}
+	})
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("写入导出文件失败: %v", closeErr)
+	}
+	if err != nil {
+		return rows, 0, err
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return rows, 0, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return rows, 0, fmt.Errorf("保存导出文件失败: %v", err)
+	}
+	return rows, info.Size(), nil
+}
+
+// FileName 生成导出文件的下载文件名，如 nixvis-logs-a1b2-20240101-150405.csv
+func FileName(exportType, websiteID, format string, at time.Time) string {
+	site := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:/\"*?<>| `, r) {
+			return '-'
+		}
+		return r
+	}, websiteID)
+	return fmt.Sprintf("nixvis-%s-%s-%s.%s", exportType, site, at.Format("20060102-150405"), format)
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Table 导出的一个表格
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Tables 将统计结果展开为表格，列名为结果的 JSON 字段名：
+//   - 标量字段合成一行的汇总表
+//   - 等长的数组字段（如 key、pv、uv）按下标合成一张表，长度不同的数组各自成表
+//   - 对象数组每个元素一行，元素中的嵌套数组和对象以 JSON 文本写入单元格
+//   - 嵌套的对象（如对比周期 compare）按同样的规则展开，表名为字段名
+//
+// 主表和汇总表以统计类型为名，其他表以字段名为名，如 url、url.summary、compare、codes
+func Tables(statsType string, result interface{}) []Table {
+	var tables []Table
+	flatten(&tables, statsType, "", reflect.ValueOf(result))
+	return tables
+}
+
+// flatten 展开一个结构体，path 为表名，prefix 为字段表名的前缀（根结构体为空）
+func flatten(tables *[]Table, path, prefix string, v reflect.Value) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	summary := Table{Rows: [][]interface{}{{}}}
+	var series []Table
+	var rest []func()
+
+	for _, f := range jsonFields(v) {
+		field := v.Field(f.index)
+		switch {
+		case isScalar(field.Type()):
+			summary.Columns = append(summary.Columns, f.name)
+			summary.Rows[0] = append(summary.Rows[0], cellValue(field))
+
+		case field.Kind() == reflect.Slice && structElem(field.Type()):
+			name := prefix + f.name
+			rest = append(rest, func() { *tables = append(*tables, structTable(name, field)) })
+
+		case field.Kind() == reflect.Slice || field.Kind() == reflect.Array:
+			series = appendSeries(series, f.name, field)
+
+		case field.Kind() == reflect.Struct || field.Kind() == reflect.Pointer:
+			name := prefix + f.name
+			rest = append(rest, func() { flatten(tables, name, name+".", field) })
+
+		default:
+			summary.Columns = append(summary.Columns, f.name)
+			summary.Rows[0] = append(summary.Rows[0], cellValue(field))
+		}
+	}
+
+	// 主表使用结构体的表名，汇总表在有主表时加上 .summary 后缀
+	for i := range series {
+		if i == 0 {
+			series[i].Name = path
+		} else {
+			series[i].Name = prefix + series[i].Name
+		}
+	}
+	if len(summary.Columns) > 0 {
+		summary.Name = path
+		if len(series) > 0 {
+			summary.Name = path + ".summary"
+		}
+		*tables = append(*tables, summary)
+	}
+	*tables = append(*tables, series...)
+	for _, add := range rest {
+		add()
+	}
+}
+
+// appendSeries 将数组字段并入与第一个数组等长的表，长度不同时单独成表
+func appendSeries(series []Table, name string, field reflect.Value) []Table {
+	n := field.Len()
+	if len(series) == 0 || len(series[0].Rows) != n {
+		t := Table{Name: name, Columns: []string{name}, Rows: make([][]interface{}, n)}
+		for i := 0; i < n; i++ {
+			t.Rows[i] = []interface{}{cellValue(field.Index(i))}
+		}
+		return append(series, t)
+	}
+
+	series[0].Columns = append(series[0].Columns, name)
+	for i := 0; i < n; i++ {
+		series[0].Rows[i] = append(series[0].Rows[i], cellValue(field.Index(i)))
+	}
+	return series
+}
+
+// structTable 将对象数组展开为表格，每个元素一行
+func structTable(name string, field reflect.Value) Table {
+	elemType := field.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	fields := jsonFields(reflect.New(elemType).Elem())
+
+	t := Table{Name: name, Rows: make([][]interface{}, 0, field.Len())}
+	for _, f := range fields {
+		t.Columns = append(t.Columns, f.name)
+	}
+	for i := 0; i < field.Len(); i++ {
+		elem := reflect.Indirect(field.Index(i))
+		row := make([]interface{}, len(fields))
+		if elem.IsValid() {
+			for j, f := range fields {
+				row[j] = cellValue(elem.Field(f.index))
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+type jsonField struct {
+	index int
+	name  string
+}
+
+// jsonFields 获取结构体参与 JSON 序列化的字段，omitempty 且为零值的字段跳过
+func jsonFields(v reflect.Value) []jsonField {
+	t := v.Type()
+	fields := make([]jsonField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		if strings.Contains(opts, "omitempty") && v.Field(i).IsZero() {
+			continue
+		}
+		fields = append(fields, jsonField{index: i, name: name})
+	}
+	return fields
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func structElem(t reflect.Type) bool {
+	elem := t.Elem()
+	for elem.Kind() == reflect.Pointer {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct
+}
+
+// cellValue 将字段值转换为单元格的值：数字统一为 int64 或 float64，其他复合值为 JSON 文本
+func cellValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
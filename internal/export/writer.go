@@ -0,0 +1,183 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 支持的导出格式
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson" // 每行一个 JSON 对象
+	FormatXLSX   = "xlsx"
+)
+
+// Writer 按表格逐行写出导出内容，写入的内容不会在内存中累积
+type Writer interface {
+	// Begin 开始一个新的表格，columns 为列名
+	Begin(name string, columns []string) error
+	// Row 写入一行，values 与列一一对应
+	Row(values []interface{}) error
+	// Close 写完剩余内容，不关闭底层的 io.Writer
+	Close() error
+}
+
+// ValidFormat 检查导出格式是否支持
+func ValidFormat(format string) error {
+	switch format {
+	case FormatCSV, FormatNDJSON, FormatXLSX:
+		return nil
+	}
+	return fmt.Errorf("不支持的导出格式: %s", format)
+}
+
+// ContentType 导出格式对应的 MIME 类型
+func ContentType(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson; charset=utf-8"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// NewWriter 创建指定格式的 Writer。tables 为将要写出的表格数，
+// 多于一个时 CSV 在每个表格前写一行 "# 表名"，NDJSON 在每行加上 "_table" 字段
+func NewWriter(format string, w io.Writer, tables int) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w), labeled: tables > 1}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{w: bufio.NewWriter(w), labeled: tables > 1}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w), nil
+	}
+	return nil, ValidFormat(format)
+}
+
+// csvWriter 输出 CSV，多个表格之间以空行分隔
+type csvWriter struct {
+	w       *csv.Writer
+	labeled bool
+	tables  int
+	record  []string
+}
+
+func (cw *csvWriter) Begin(name string, columns []string) error {
+	if cw.tables > 0 {
+		if err := cw.w.Write(nil); err != nil {
+			return err
+		}
+	}
+	cw.tables++
+	if cw.labeled {
+		if err := cw.w.Write([]string{"# " + name}); err != nil {
+			return err
+		}
+	}
+	return cw.w.Write(columns)
+}
+
+func (cw *csvWriter) Row(values []interface{}) error {
+	cw.record = cw.record[:0]
+	for _, v := range values {
+		cw.record = append(cw.record, csvCell(v))
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csvCell 格式化单元格。以 = + - @ 开头的文本在表格软件中会被当作公式，
+// URL、Referer 等来自请求的内容前加单引号，避免打开导出文件时执行（单独的 "-" 除外）
+func csvCell(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		return formatValue(v)
+	}
+	if s == "" || s == "-" || s == "+" {
+		return s
+	}
+	if strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ndjsonWriter 每行输出一个 JSON 对象，字段顺序与列的顺序一致
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	labeled bool
+	name    []byte
+	keys    [][]byte
+}
+
+func (nw *ndjsonWriter) Begin(name string, columns []string) error {
+	nw.name, _ = json.Marshal(name)
+	nw.keys = nw.keys[:0]
+	for _, col := range columns {
+		key, _ := json.Marshal(col)
+		nw.keys = append(nw.keys, key)
+	}
+	return nil
+}
+
+func (nw *ndjsonWriter) Row(values []interface{}) error {
+	nw.w.WriteByte('{')
+	if nw.labeled {
+		nw.w.WriteString(`"_table":`)
+		nw.w.Write(nw.name)
+		if len(values) > 0 {
+			nw.w.WriteByte(',')
+		}
+	}
+	for i, v := range values {
+		if i > 0 {
+			nw.w.WriteByte(',')
+		}
+		nw.w.Write(nw.keys[i])
+		nw.w.WriteByte(':')
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		nw.w.Write(data)
+	}
+	nw.w.WriteByte('}')
+	_, err := nw.w.WriteString("\n")
+	return err
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nw.w.Flush()
+}
+
+// formatValue 将单元格的值格式化为文本
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+)
+
+// writeTables 用指定格式写出若干表格，返回写出的内容
+func writeTables(t *testing.T, format string, tables []Table) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, len(tables))
+	if err != nil {
+		t.Fatalf("NewWriter(%s) 出错: %v", format, err)
+	}
+	for _, table := range tables {
+		if err := w.Begin(table.Name, table.Columns); err != nil {
+			t.Fatalf("Begin 出错: %v", err)
+		}
+		for _, row := range table.Rows {
+			if err := w.Row(row); err != nil {
+				t.Fatalf("Row 出错: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close 出错: %v", err)
+	}
+	return buf.String()
+}
+
+func TestTextWriters(t *testing.T) {
+	urls := Table{
+		Name:    "页面",
+		Columns: []string{"url", "pv", "ratio"},
+		Rows: [][]interface{}{
+			{"/a,b", 10, 0.5},
+			{`/say "hi"`, int64(3), nil},
+		},
+	}
+	flags := Table{
+		Name:    "蜘蛛",
+		Columns: []string{"name", "spider"},
+		Rows:    [][]interface{}{{"Google", true}},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		tables []Table
+		want   string
+	}{
+		{
+			name:   "单个表格的 CSV 不带表名",
+			format: FormatCSV,
+			tables: []Table{urls},
+			want:   "url,pv,ratio\n\"/a,b\",10,0.5\n\"/say \"\"hi\"\"\",3,\n",
+		},
+		{
+			name:   "多个表格的 CSV 带表名并以空行分隔",
+			format: FormatCSV,
+			tables: []Table{urls, flags},
+			want: "# 页面\nurl,pv,ratio\n\"/a,b\",10,0.5\n\"/say \"\"hi\"\"\",3,\n" +
+				"\n# 蜘蛛\nname,spider\nGoogle,true\n",
+		},
+		{
+			name:   "单个表格的 NDJSON 保持列顺序",
+			format: FormatNDJSON,
+			tables: []Table{urls},
+			want: `{"url":"/a,b","pv":10,"ratio":0.5}` + "\n" +
+				`{"url":"/say \"hi\"","pv":3,"ratio":null}` + "\n",
+		},
+		{
+			name:   "多个表格的 NDJSON 带 _table 字段",
+			format: FormatNDJSON,
+			tables: []Table{flags, {Name: "空行", Columns: nil, Rows: [][]interface{}{{}}}},
+			want:   `{"_table":"蜘蛛","name":"Google","spider":true}` + "\n" + `{"_table":"空行"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeTables(t, tt.format, tt.tables); got != tt.want {
+				t.Errorf("输出 = %q\n期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"", ""},
+		{"-", "-"},
+		{"+", "+"},
+		{"/index.html", "/index.html"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1", "'+1"},
+		{"-1+2", "'-1+2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{-5, "-5"},
+		{int64(1) << 40, "1099511627776"},
+		{1.25, "1.25"},
+		{false, "false"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%#v) = %q, 期望 %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewWriterInvalidFormat(t *testing.T) {
+	if _, err := NewWriter("pdf", &bytes.Buffer{}, 1); err == nil {
+		t.Error("不支持的格式应返回错误")
+	}
+}
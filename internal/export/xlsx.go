@@ -0,0 +1,239 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// xlsxMaxRows 单个工作表的最大行数，超出后在续表中继续写入
+	xlsxMaxRows = 1048576
+	// xlsxMaxCellChars 单元格文本的最大长度
+	xlsxMaxCellChars = 32767
+	// xlsxMaxSheetName 工作表名称的最大长度
+	xlsxMaxSheetName = 31
+)
+
+// xlsxWriter 直接生成 Office Open XML 工作簿。每个表格对应一个工作表，
+// 文本使用内联字符串而不是共享字符串表，行写出后即可释放，不需要缓存整个表格
+type xlsxWriter struct {
+	zw      *zip.Writer
+	sheet   *bufio.Writer
+	sheets  []string
+	name    string
+	columns []string
+	rows    int // 当前工作表已写入的行数（含表头）
+	part    int // 当前表格的第几个工作表，超出行数后递增
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(w)}
+}
+
+func (xw *xlsxWriter) Begin(name string, columns []string) error {
+	xw.name = name
+	xw.columns = columns
+	xw.part = 1
+	return xw.openSheet()
+}
+
+// openSheet 结束上一个工作表并开始新的工作表，首行为表头并冻结
+func (xw *xlsxWriter) openSheet() error {
+	if err := xw.closeSheet(); err != nil {
+		return err
+	}
+
+	name := xw.name
+	if xw.part > 1 {
+		name = fmt.Sprintf("%s (%d)", name, xw.part)
+	}
+	xw.sheets = append(xw.sheets, xw.sheetName(name))
+
+	f, err := xw.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(xw.sheets)))
+	if err != nil {
+		return err
+	}
+	xw.sheet = bufio.NewWriter(f)
+	xw.rows = 0
+	xw.sheet.WriteString(xml.Header)
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	xw.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+	xw.sheet.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	xw.sheet.WriteString(`</sheetView></sheetViews><sheetData>`)
+
+	header := make([]interface{}, len(xw.columns))
+	for i, col := range xw.columns {
+		header[i] = col
+	}
+	return xw.writeRow(header)
+}
+
+func (xw *xlsxWriter) closeSheet() error {
+	if xw.sheet == nil {
+		return nil
+	}
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	err := xw.sheet.Flush()
+	xw.sheet = nil
+	return err
+}
+
+func (xw *xlsxWriter) Row(values []interface{}) error {
+	if xw.sheet == nil {
+		return fmt.Errorf("写入行之前需要先开始表格")
+	}
+	if xw.rows >= xlsxMaxRows {
+		xw.part++
+		if err := xw.openSheet(); err != nil {
+			return err
+		}
+	}
+	return xw.writeRow(values)
+}
+
+func (xw *xlsxWriter) writeRow(values []interface{}) error {
+	xw.rows++
+	row := strconv.Itoa(xw.rows)
+	xw.sheet.WriteString(`<row r="` + row + `">`)
+	for i, v := range values {
+		ref := columnName(i) + row
+		switch val := v.(type) {
+		case nil:
+			continue
+		case bool:
+			b := "0"
+			if val {
+				b = "1"
+			}
+			xw.sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + b + `</v></c>`)
+		case int, int64, float64:
+			xw.sheet.WriteString(`<c r="` + ref + `"><v>` + formatValue(val) + `</v></c>`)
+		default:
+			text := formatValue(val)
+			if len(text) > xlsxMaxCellChars {
+				text = truncateRunes(text, xlsxMaxCellChars)
+			}
+			xw.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(xw.sheet, []byte(text))
+			xw.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Close 写入工作簿、关系和内容类型等清单文件，没有任何表格时生成一个空工作表
+func (xw *xlsxWriter) Close() error {
+	if len(xw.sheets) == 0 {
+		if err := xw.Begin("Sheet1", nil); err != nil {
+			return err
+		}
+	}
+	if err := xw.closeSheet(); err != nil {
+		return err
+	}
+
+	var workbook, rels, types strings.Builder
+	workbook.WriteString(xml.Header)
+	workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(xml.Header)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	types.WriteString(xml.Header)
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+
+	for i, name := range xw.sheets {
+		n := i + 1
+		workbook.WriteString(fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeAttr(name), n, n))
+		rels.WriteString(fmt.Sprintf(`<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, n, n))
+		types.WriteString(fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n))
+	}
+	workbook.WriteString(`</sheets></workbook>`)
+	rels.WriteString(`</Relationships>`)
+	types.WriteString(`</Types>`)
+
+	files := []struct{ name, content string }{
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" ` +
+			`Target="xl/workbook.xml"/></Relationships>`},
+	}
+	for _, file := range files {
+		f, err := xw.zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			return err
+		}
+	}
+	return xw.zw.Close()
+}
+
+// sheetName 生成合法且不重复的工作表名称：去掉不允许的字符并限制长度
+func (xw *xlsxWriter) sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet"
+	}
+	name = truncateRunes(name, xlsxMaxSheetName)
+
+	candidate := name
+	for n := 2; xw.hasSheet(candidate); n++ {
+		suffix := fmt.Sprintf("_%d", n)
+		candidate = truncateRunes(name, xlsxMaxSheetName-len(suffix)) + suffix
+	}
+	return candidate
+}
+
+func (xw *xlsxWriter) hasSheet(name string) bool {
+	for _, s := range xw.sheets {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnName 将从 0 开始的列序号转换为列名，如 0 -> A，26 -> AA
+func columnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// escapeAttr 转义属性值，xml.EscapeText 同时转义了引号
+func escapeAttr(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
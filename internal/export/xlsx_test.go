@@ -0,0 +1,168 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xlsxCell 工作表中的一个单元格
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+// readXLSX 解析工作簿，返回工作表名称和各工作表的单元格
+func readXLSX(t *testing.T, data string) ([]string, [][]xlsxCell) {
+	t.Helper()
+	zr, err := zip.NewReader(strings.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("不是有效的 zip 文件: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("打开 %s 出错: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("缺少 %s", name)
+		}
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal([]byte(files["xl/workbook.xml"]), &workbook); err != nil {
+		t.Fatalf("解析 workbook.xml 出错: %v", err)
+	}
+
+	var names []string
+	var sheets [][]xlsxCell
+	for i, sheet := range workbook.Sheets {
+		names = append(names, sheet.Name)
+		var ws struct {
+			Cells []xlsxCell `xml:"sheetData>row>c"`
+		}
+		content, ok := files[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)]
+		if !ok {
+			t.Fatalf("缺少第 %d 个工作表", i+1)
+		}
+		if err := xml.Unmarshal([]byte(content), &ws); err != nil {
+			t.Fatalf("解析第 %d 个工作表出错: %v", i+1, err)
+		}
+		sheets = append(sheets, ws.Cells)
+	}
+	return names, sheets
+}
+
+func TestXLSXWriter(t *testing.T) {
+	long := strings.Repeat("长", xlsxMaxCellChars+10)
+
+	tests := []struct {
+		name       string
+		tables     []Table
+		wantNames  []string
+		wantSheets [][]xlsxCell
+	}{
+		{
+			name:       "没有表格时生成空工作表",
+			wantNames:  []string{"Sheet1"},
+			wantSheets: [][]xlsxCell{nil},
+		},
+		{
+			name: "按类型写入单元格，nil 留空",
+			tables: []Table{{
+				Name:    "url",
+				Columns: []string{"url", "pv", "ok"},
+				Rows:    [][]interface{}{{"<a&b>", int64(7), true}, {nil, 1.5, false}},
+			}},
+			wantNames: []string{"url"},
+			wantSheets: [][]xlsxCell{{
+				{Ref: "A1", Type: "inlineStr", Inline: "url"},
+				{Ref: "B1", Type: "inlineStr", Inline: "pv"},
+				{Ref: "C1", Type: "inlineStr", Inline: "ok"},
+				{Ref: "A2", Type: "inlineStr", Inline: "<a&b>"},
+				{Ref: "B2", Value: "7"},
+				{Ref: "C2", Type: "b", Value: "1"},
+				{Ref: "B3", Value: "1.5"},
+				{Ref: "C3", Type: "b", Value: "0"},
+			}},
+		},
+		{
+			name: "过长的文本截断",
+			tables: []Table{{
+				Name:    "长文本",
+				Columns: nil,
+				Rows:    [][]interface{}{{long}},
+			}},
+			wantNames: []string{"长文本"},
+			wantSheets: [][]xlsxCell{{
+				{Ref: "A2", Type: "inlineStr", Inline: long[:len("长")*xlsxMaxCellChars]},
+			}},
+		},
+		{
+			name: "工作表名称去掉非法字符并去重",
+			tables: []Table{
+				{Name: "a/b:c"},
+				{Name: "A_B_C"},
+				{Name: strings.Repeat("x", 40)},
+			},
+			wantNames:  []string{"a_b_c", "A_B_C_2", strings.Repeat("x", xlsxMaxSheetName)},
+			wantSheets: [][]xlsxCell{nil, nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, sheets := readXLSX(t, writeTables(t, FormatXLSX, tt.tables))
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("工作表 = %q, 期望 %q", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(sheets, tt.wantSheets) {
+				t.Errorf("单元格 = %+v\n期望 %+v", sheets, tt.wantSheets)
+			}
+		})
+	}
+}
+
+func TestXLSXRowBeforeBegin(t *testing.T) {
+	w := newXLSXWriter(io.Discard)
+	if err := w.Row([]interface{}{1}); err == nil {
+		t.Error("开始表格之前写入行应返回错误")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+
+	for _, tt := range tests {
+		if got := columnName(tt.index); got != tt.want {
+			t.Errorf("columnName(%d) = %q, 期望 %q", tt.index, got, tt.want)
+		}
+	}
+}
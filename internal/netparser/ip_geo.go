@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/util"
@@ -88,31 +89,43 @@ func InitIPGeoLocation() error {
 	return nil
 }
 
+// IPLocation IP 的地理位置。国内的 Domestic 为省份，国外为"国外"；
+// City 和 ISP 查不到时为"未知"
+type IPLocation struct {
+	Domestic string
+	Global   string
+	City     string
+	ISP      string
+}
+
+// unknownLocation 查询失败时的地理位置
+var unknownLocation = IPLocation{Domestic: "未知", Global: "未知", City: "未知", ISP: "未知"}
+
 // GetIPLocation 获取 IP 的地理位置信息
-func GetIPLocation(ip string) (string, string, error) {
+func GetIPLocation(ip string) (IPLocation, error) {
 	// 处理无效 IP
 	if ip == "" || ip == "localhost" || ip == "127.0.0.1" {
-		return "本地", "本地", nil
+		return IPLocation{Domestic: "本地", Global: "本地", City: "本地", ISP: "本地"}, nil
 	}
 
 	// 检查是否是内网 IP
 	if isPrivateIP(net.ParseIP(ip)) {
-		return "内网", "本地网络", nil
+		return IPLocation{Domestic: "内网", Global: "本地网络", City: "内网", ISP: "内网"}, nil
 	}
 
 	// 查询数据库
-	domestic, global, err := queryIPLocation(ip)
+	location, err := queryIPLocation(ip)
 	if err != nil {
-		return "未知", "未知", err
+		return unknownLocation, err
 	}
 
-	return domestic, global, nil
+	return location, nil
 }
 
 // 查询 IP 地理位置
-func queryIPLocation(ip string) (string, string, error) {
+func queryIPLocation(ip string) (IPLocation, error) {
 	if ipSearcher == nil {
-		return "未知", "未知", fmt.Errorf("ip2region 未初始化")
+		return unknownLocation, fmt.Errorf("ip2region 未初始化")
 	}
 
 	// 设置 50 毫秒超时
@@ -140,44 +153,48 @@ func queryIPLocation(ip string) (string, string, error) {
 	// 等待结果或超时
 	select {
 	case <-ctx.Done():
-		return "未知", "未知", fmt.Errorf("IP 查询超时")
+		return unknownLocation, fmt.Errorf("IP 查询超时")
 	case result := <-resultCh:
 		if result.err != nil {
-			return "未知", "未知", result.err
+			return unknownLocation, result.err
 		}
-		return parseIPRegion(result.region)
+		return parseIPRegion(result.region), nil
 	}
 }
 
 // 解析 ip2region 返回的地区信息
-func parseIPRegion(region string) (string, string, error) {
+func parseIPRegion(region string) IPLocation {
 	// 返回格式: 国家|区域|省份|城市|ISP
 	parts := splitRegion(region)
-	var domestic, global string
+	location := unknownLocation
 
 	// 国内
 	if parts[0] == "中国" {
 		if parts[2] != "" && parts[2] != "0" {
-			domestic = removeSuffixes(parts[2])
+			location.Domestic = removeSuffixes(parts[2])
 		} else if parts[3] != "" && parts[3] != "0" {
-			domestic = parts[3]
+			location.Domestic = parts[3]
 		} else {
-			domestic = "中国"
+			location.Domestic = "中国"
 		}
-	} else if parts[0] == "0" || parts[0] == "" {
-		domestic = "未知"
-	} else {
-		domestic = "国外"
+	} else if parts[0] != "0" && parts[0] != "" {
+		location.Domestic = "国外"
 	}
 
 	// 全球
 	if parts[0] != "0" && parts[0] != "" {
-		global = parts[0]
-	} else {
-		global = "未知"
+		location.Global = parts[0]
+	}
+
+	// 城市和运营商
+	if parts[3] != "" && parts[3] != "0" {
+		location.City = removeCitySuffix(parts[3])
+	}
+	if parts[4] != "" && parts[4] != "0" {
+		location.ISP = parts[4]
 	}
 
-	return domestic, global, nil
+	return location
 }
 
 // 解析 ip2region
@@ -226,3 +243,11 @@ func removeSuffixes(name string) string {
 	}
 	return name
 }
+
+// 去掉城市名称的"市"后缀，与省份名称的写法一致（如"北京"）
+func removeCitySuffix(name string) string {
+	if trimmed := strings.TrimSuffix(name, "市"); trimmed != "" {
+		return trimmed
+	}
+	return name
+}
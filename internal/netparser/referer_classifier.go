@@ -0,0 +1,159 @@
+package netparser
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// 来源类别
+const (
+	SourceDirect   = "direct"   // 直接访问（无来源）
+	SourceSearch   = "search"   // 搜索引擎
+	SourceSocial   = "social"   // 社交网络
+	SourceInternal = "internal" // 站内跳转
+	SourceOther    = "other"    // 其他外部网站
+)
+
+// RefererRule 来源分类规则
+type RefererRule struct {
+	Name        string   `json:"name"`                  // 来源名称，如 "Google"
+	Category    string   `json:"category"`              // 来源类别，search 或 social 等
+	Domains     []string `json:"domains"`               // 匹配的域名，"google.*" 匹配任意后缀
+	QueryParams []string `json:"queryParams,omitempty"` // 搜索关键词所在的查询参数
+}
+
+// RefererSource 来源分类结果
+type RefererSource struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`    // 命中规则的名称，未命中时为域名
+	Domain   string `json:"domain"`  // 规范化后的来源域名
+	Keyword  string `json:"keyword"` // 搜索关键词，仅搜索引擎且来源中带有关键词时存在
+}
+
+var refererRules []RefererRule
+
+// InitRefererClassifier 初始化来源分类规则，规则文件中的规则优先于内置规则
+func InitRefererClassifier() {
+	rules := make([]RefererRule, 0)
+
+	path := util.GetRefererRulesFile()
+	if data, err := os.ReadFile(path); err == nil {
+		var custom []RefererRule
+		if err := json.Unmarshal(data, &custom); err != nil {
+			logrus.WithError(err).Warnf("解析来源分类规则文件 %s 失败，使用内置规则", path)
+		} else {
+			rules = append(rules, custom...)
+			logrus.Infof("已加载 %d 条自定义来源分类规则", len(custom))
+		}
+	} else if !os.IsNotExist(err) {
+		logrus.WithError(err).Warnf("读取来源分类规则文件 %s 失败，使用内置规则", path)
+	}
+
+	refererRules = append(rules, defaultRefererRules()...)
+}
+
+func defaultRefererRules() []RefererRule {
+	return []RefererRule{
+		{Name: "Google", Category: SourceSearch, Domains: []string{"google.*"}, QueryParams: []string{"q"}},
+		{Name: "Bing", Category: SourceSearch, Domains: []string{"bing.com", "cn.bing.com"}, QueryParams: []string{"q"}},
+		{Name: "百度", Category: SourceSearch, Domains: []string{"baidu.com"}, QueryParams: []string{"wd", "word", "kw"}},
+		{Name: "搜狗", Category: SourceSearch, Domains: []string{"sogou.com"}, QueryParams: []string{"query", "keyword"}},
+		{Name: "360搜索", Category: SourceSearch, Domains: []string{"so.com"}, QueryParams: []string{"q"}},
+		{Name: "神马", Category: SourceSearch, Domains: []string{"sm.cn"}, QueryParams: []string{"q"}},
+		{Name: "头条搜索", Category: SourceSearch, Domains: []string{"so.toutiao.com"}, QueryParams: []string{"keyword"}},
+		{Name: "Yandex", Category: SourceSearch, Domains: []string{"yandex.*"}, QueryParams: []string{"text"}},
+		{Name: "DuckDuckGo", Category: SourceSearch, Domains: []string{"duckduckgo.com"}, QueryParams: []string{"q"}},
+		{Name: "Yahoo", Category: SourceSearch, Domains: []string{"search.yahoo.com"}, QueryParams: []string{"p"}},
+		{Name: "Naver", Category: SourceSearch, Domains: []string{"naver.com"}, QueryParams: []string{"query"}},
+		{Name: "Ecosia", Category: SourceSearch, Domains: []string{"ecosia.org"}, QueryParams: []string{"q"}},
+		{Name: "Startpage", Category: SourceSearch, Domains: []string{"startpage.com"}, QueryParams: []string{"query"}},
+
+		{Name: "微信", Category: SourceSocial, Domains: []string{"weixin.qq.com", "wx.qq.com"}},
+		{Name: "QQ空间", Category: SourceSocial, Domains: []string{"qzone.qq.com"}},
+		{Name: "微博", Category: SourceSocial, Domains: []string{"weibo.com", "weibo.cn", "t.cn"}},
+		{Name: "知乎", Category: SourceSocial, Domains: []string{"zhihu.com"}},
+		{Name: "豆瓣", Category: SourceSocial, Domains: []string{"douban.com"}},
+		{Name: "哔哩哔哩", Category: SourceSocial, Domains: []string{"bilibili.com", "b23.tv"}},
+		{Name: "小红书", Category: SourceSocial, Domains: []string{"xiaohongshu.com", "xhslink.com"}},
+		{Name: "抖音", Category: SourceSocial, Domains: []string{"douyin.com"}},
+		{Name: "V2EX", Category: SourceSocial, Domains: []string{"v2ex.com"}},
+		{Name: "Facebook", Category: SourceSocial, Domains: []string{"facebook.com", "fb.com", "fb.me"}},
+		{Name: "Twitter", Category: SourceSocial, Domains: []string{"twitter.com", "x.com", "t.co"}},
+		{Name: "LinkedIn", Category: SourceSocial, Domains: []string{"linkedin.com", "lnkd.in"}},
+		{Name: "Reddit", Category: SourceSocial, Domains: []string{"reddit.com"}},
+		{Name: "YouTube", Category: SourceSocial, Domains: []string{"youtube.com", "youtu.be"}},
+		{Name: "Instagram", Category: SourceSocial, Domains: []string{"instagram.com"}},
+		{Name: "Pinterest", Category: SourceSocial, Domains: []string{"pinterest.*"}},
+		{Name: "Telegram", Category: SourceSocial, Domains: []string{"t.me", "telegram.org"}},
+		{Name: "Hacker News", Category: SourceSocial, Domains: []string{"news.ycombinator.com"}},
+	}
+}
+
+// ClassifyReferer 对来源分类，internalHosts 为站点自身的域名（已规范化），
+// 来源域名与其相同或为其子域名时视为站内跳转
+func ClassifyReferer(referer string, internalHosts []string) RefererSource {
+	referer = strings.TrimSpace(referer)
+	if referer == "" || referer == "-" {
+		return RefererSource{Category: SourceDirect, Name: SourceDirect}
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return RefererSource{Category: SourceOther, Name: referer}
+	}
+	domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	for _, host := range internalHosts {
+		if matchDomain(domain, host) {
+			return RefererSource{Category: SourceInternal, Name: domain, Domain: domain}
+		}
+	}
+
+	rules := refererRules
+	if rules == nil {
+		rules = defaultRefererRules()
+	}
+	for _, rule := range rules {
+		for _, pattern := range rule.Domains {
+			if !matchDomain(domain, strings.ToLower(pattern)) {
+				continue
+			}
+			source := RefererSource{Category: rule.Category, Name: rule.Name, Domain: domain}
+			if rule.Category == SourceSearch {
+				source.Keyword = searchKeyword(u, rule.QueryParams)
+			}
+			return source
+		}
+	}
+
+	return RefererSource{Category: SourceOther, Name: domain, Domain: domain}
+}
+
+// matchDomain 判断域名是否匹配规则：相同或为其子域名；"google.*" 匹配任意顶级域名后缀
+func matchDomain(domain, pattern string) bool {
+	if base, ok := strings.CutSuffix(pattern, ".*"); ok {
+		return strings.HasPrefix(domain, base+".") || strings.Contains(domain, "."+base+".")
+	}
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
+
+// searchKeyword 从查询参数（包括 "#" 之后的部分）中取出搜索关键词
+func searchKeyword(u *url.URL, params []string) string {
+	values := u.Query()
+	if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+		for k, v := range fragment {
+			values[k] = append(values[k], v...)
+		}
+	}
+	for _, param := range params {
+		if keyword := strings.TrimSpace(values.Get(param)); keyword != "" {
+			return strings.ToLower(strings.Join(strings.Fields(keyword), " "))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package netparser
+
+import "testing"
+
+func TestMatchDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		pattern string
+		want    bool
+	}{
+		{"zhihu.com", "zhihu.com", true},
+		{"zhuanlan.zhihu.com", "zhihu.com", true},
+		{"notzhihu.com", "zhihu.com", false},
+		{"zhihu.com.example.net", "zhihu.com", false},
+		{"t.com", "t.co", false},
+		{"google.com", "google.*", true},
+		{"google.co.uk", "google.*", true},
+		{"news.google.com", "google.*", true},
+		{"google", "google.*", false},
+		{"notgoogle.com", "google.*", false},
+		{"googleusercontent.com", "google.*", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchDomain(tt.domain, tt.pattern); got != tt.want {
+			t.Errorf("matchDomain(%q, %q) = %v, 期望 %v", tt.domain, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyReferer(t *testing.T) {
+	internal := []string{"example.com"}
+
+	tests := []struct {
+		name    string
+		referer string
+		want    RefererSource
+	}{
+		{
+			name:    "空来源为直接访问",
+			referer: "",
+			want:    RefererSource{Category: SourceDirect, Name: SourceDirect},
+		},
+		{
+			name:    "横线为直接访问",
+			referer: " - ",
+			want:    RefererSource{Category: SourceDirect, Name: SourceDirect},
+		},
+		{
+			name:    "无法解析出域名",
+			referer: "not a url",
+			want:    RefererSource{Category: SourceOther, Name: "not a url"},
+		},
+		{
+			name:    "站点自身域名，忽略大小写、www 和端口",
+			referer: "http://WWW.Example.com:8080/a",
+			want:    RefererSource{Category: SourceInternal, Name: "example.com", Domain: "example.com"},
+		},
+		{
+			name:    "站点子域名为站内跳转",
+			referer: "https://blog.example.com/post",
+			want:    RefererSource{Category: SourceInternal, Name: "blog.example.com", Domain: "blog.example.com"},
+		},
+		{
+			name:    "仅后缀相同不算站内",
+			referer: "https://example.com.cn/",
+			want:    RefererSource{Category: SourceOther, Name: "example.com.cn", Domain: "example.com.cn"},
+		},
+		{
+			name:    "通配后缀匹配搜索引擎并规范化关键词",
+			referer: "https://www.google.co.jp/search?q=Nginx++%E6%97%A5%E5%BF%97",
+			want:    RefererSource{Category: SourceSearch, Name: "Google", Domain: "google.co.jp", Keyword: "nginx 日志"},
+		},
+		{
+			name:    "按参数顺序取关键词",
+			referer: "https://www.baidu.com/s?word=x&wd=%20Go%20",
+			want:    RefererSource{Category: SourceSearch, Name: "百度", Domain: "baidu.com", Keyword: "go"},
+		},
+		{
+			name:    "关键词在 # 之后",
+			referer: "https://duckduckgo.com/#q=hello",
+			want:    RefererSource{Category: SourceSearch, Name: "DuckDuckGo", Domain: "duckduckgo.com", Keyword: "hello"},
+		},
+		{
+			name:    "搜索引擎来源不带关键词",
+			referer: "https://cn.bing.com/search",
+			want:    RefererSource{Category: SourceSearch, Name: "Bing", Domain: "cn.bing.com"},
+		},
+		{
+			name:    "子域名不会误配短域名规则",
+			referer: "https://so.toutiao.com/search?keyword=nixvis",
+			want:    RefererSource{Category: SourceSearch, Name: "头条搜索", Domain: "so.toutiao.com", Keyword: "nixvis"},
+		},
+		{
+			name:    "社交网络子域名",
+			referer: "https://zhuanlan.zhihu.com/p/1",
+			want:    RefererSource{Category: SourceSocial, Name: "知乎", Domain: "zhuanlan.zhihu.com"},
+		},
+		{
+			name:    "社交网络短链",
+			referer: "https://t.co/abc",
+			want:    RefererSource{Category: SourceSocial, Name: "Twitter", Domain: "t.co"},
+		},
+		{
+			name:    "未命中规则的外部网站",
+			referer: "https://example.org/x",
+			want:    RefererSource{Category: SourceOther, Name: "example.org", Domain: "example.org"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyReferer(tt.referer, internal); got != tt.want {
+				t.Errorf("ClassifyReferer(%q) = %+v, 期望 %+v", tt.referer, got, tt.want)
+			}
+		})
+	}
+}
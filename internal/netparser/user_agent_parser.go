@@ -1,34 +1,150 @@
 package netparser
 
-import "github.com/mileusna/useragent"
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mileusna/useragent"
+)
+
+// UserAgentInfo User-Agent 的解析结果。版本字段带有名称前缀（如 "Chrome 120"），
+// 可以直接作为排行的键；UA 中没有版本时只有名称
+type UserAgentInfo struct {
+	Browser        string
+	BrowserMajor   string // 浏览器主版本，如 "Chrome 120"
+	BrowserVersion string // 浏览器完整版本，如 "Chrome 120.0.6099.109"
+	OS             string
+	OSMajor        string // 系统主版本，如 "iOS 17"、"Windows 7"
+	OSVersion      string // 系统完整版本，如 "iOS 17.1.2"
+	Device         string // 设备类型：手机、平板、桌面设备等
+	Brand          string // 设备品牌，UA 中看不出时为"未知品牌"
+	Model          string // 设备型号，UA 中看不出时为"未知型号"
+}
+
+// windowsVersions Windows NT 内核版本对应的系统版本，Windows 11 的 UA 仍为 10.0
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.2":  "XP",
+	"5.1":  "XP",
+}
+
+// deviceBrands 按型号识别安卓设备品牌，按顺序匹配
+var deviceBrands = []struct {
+	pattern *regexp.Regexp
+	brand   string
+}{
+	{regexp.MustCompile(`^(SM-|GT-|SAMSUNG|Galaxy)`), "Samsung"},
+	{regexp.MustCompile(`(?i)^(Pixel|Nexus)`), "Google"},
+	{regexp.MustCompile(`(?i)^HONOR`), "Honor"},
+	{regexp.MustCompile(`(?i)^HUAWEI|^[A-Z]{3}-[A-Z]{2}\d{2}`), "Huawei"},
+	{regexp.MustCompile(`(?i)^(Redmi|MI |Mi\d|MIX|POCO)|^M\d{4}[A-Z]\d+|^\d{7,8}[A-Z]{1,2}$`), "Xiaomi"},
+	{regexp.MustCompile(`(?i)^vivo|^V\d{4}[A-Z]{0,2}$`), "vivo"},
+	{regexp.MustCompile(`(?i)^OPPO|^CPH\d{4}|^P[A-H][A-Z]{2}\d{2}$`), "OPPO"},
+	{regexp.MustCompile(`(?i)^(realme|RMX\d)`), "realme"},
+	{regexp.MustCompile(`(?i)^ONEPLUS`), "OnePlus"},
+	{regexp.MustCompile(`(?i)^moto`), "Motorola"},
+	{regexp.MustCompile(`(?i)^Nokia`), "Nokia"},
+	{regexp.MustCompile(`^LM-`), "LG"},
+}
+
+// harmonyModel 鸿蒙 UA 中型号在 HarmonyOS 标记之后，如 "Android 10; HarmonyOS; ELS-AN00"
+var harmonyModel = regexp.MustCompile(`HarmonyOS; ([^;)]+)`)
 
 // ParseUserAgent 解析 User-Agent 字符串
-func ParseUserAgent(uaString string) (browser, os, device string) {
+func ParseUserAgent(uaString string) UserAgentInfo {
 	userAgent := useragent.Parse(uaString)
 
 	if userAgent.Bot {
-		return "蜘蛛", "蜘蛛", "蜘蛛"
+		return UserAgentInfo{
+			Browser: "蜘蛛", BrowserMajor: "蜘蛛", BrowserVersion: "蜘蛛",
+			OS: "蜘蛛", OSMajor: "蜘蛛", OSVersion: "蜘蛛",
+			Device: "蜘蛛", Brand: "蜘蛛", Model: "蜘蛛",
+		}
 	}
 
-	browser = userAgent.Name
-	if browser == "" {
-		browser = "未知浏览器"
+	info := UserAgentInfo{
+		Browser: userAgent.Name,
+		OS:      userAgent.OS,
+		Brand:   "未知品牌",
+		Model:   "未知型号",
+	}
+	if info.Browser == "" {
+		info.Browser = "未知浏览器"
+	}
+	if info.OS == "" {
+		info.OS = "未知操作系统"
 	}
 
-	os = userAgent.OS
-	if os == "" {
-		os = "未知操作系统"
+	info.BrowserMajor, info.BrowserVersion = versionKeys(info.Browser, userAgent.Version, majorVersion(userAgent.Version))
+	info.OSMajor, info.OSVersion = versionKeys(info.OS, userAgent.OSVersion, osMajorVersion(info.OS, userAgent.OSVersion))
+	if info.OS == useragent.Windows && info.OSMajor != info.OS {
+		info.OSVersion = "Windows NT " + userAgent.OSVersion
 	}
 
 	if userAgent.Mobile {
-		device = "手机"
+		info.Device = "手机"
 	} else if userAgent.Tablet {
-		device = "平板"
+		info.Device = "平板"
 	} else if userAgent.Desktop {
-		device = "桌面设备"
+		info.Device = "桌面设备"
 	} else {
-		device = "其他设备"
+		info.Device = "其他设备"
 	}
 
-	return browser, os, device
+	switch {
+	case userAgent.Device == "iPhone" || userAgent.Device == "iPad":
+		info.Brand, info.Model = "Apple", userAgent.Device
+	case userAgent.OS == useragent.MacOS:
+		info.Brand, info.Model = "Apple", "Mac"
+	case userAgent.OS == useragent.Android && userAgent.Device != "":
+		info.Model = userAgent.Device
+		if userAgent.Device == "HarmonyOS" {
+			info.Model = "未知型号"
+			if m := harmonyModel.FindStringSubmatch(uaString); m != nil {
+				info.Model = strings.TrimSpace(m[1])
+			}
+		}
+		for _, b := range deviceBrands {
+			if b.pattern.MatchString(info.Model) {
+				info.Brand = b.brand
+				break
+			}
+		}
+	}
+
+	return info
+}
+
+// versionKeys 生成带名称前缀的主版本和完整版本，版本不是以数字开头时（如 Linux 的 x86_64）视为没有版本
+func versionKeys(name, version, major string) (string, string) {
+	if version == "" || version[0] < '0' || version[0] > '9' {
+		return name, name
+	}
+	return name + " " + major, name + " " + version
+}
+
+// majorVersion 取版本号的第一段
+func majorVersion(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+// osMajorVersion 系统的主版本：Windows 换算为发行版本，macOS 10.x 取前两段
+func osMajorVersion(os, version string) string {
+	switch os {
+	case useragent.Windows:
+		if name, ok := windowsVersions[version]; ok {
+			return name
+		}
+	case useragent.MacOS:
+		if strings.HasPrefix(version, "10.") {
+			parts := strings.SplitN(version, ".", 3)
+			return parts[0] + "." + parts[1]
+		}
+	}
+	return majorVersion(version)
 }
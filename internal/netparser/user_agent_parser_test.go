@@ -0,0 +1,121 @@
+package netparser
+
+import "testing"
+
+func TestVersionKeys(t *testing.T) {
+	tests := []struct {
+		name, version, major string
+		wantMajor, wantFull  string
+	}{
+		{"Chrome", "120.0.6099.109", "120", "Chrome 120", "Chrome 120.0.6099.109"},
+		{"Firefox", "121", "121", "Firefox 121", "Firefox 121"},
+		{"Linux", "x86_64", "x86_64", "Linux", "Linux"},
+		{"未知浏览器", "", "", "未知浏览器", "未知浏览器"},
+	}
+
+	for _, tt := range tests {
+		major, full := versionKeys(tt.name, tt.version, tt.major)
+		if major != tt.wantMajor || full != tt.wantFull {
+			t.Errorf("versionKeys(%q, %q, %q) = %q, %q, 期望 %q, %q",
+				tt.name, tt.version, tt.major, major, full, tt.wantMajor, tt.wantFull)
+		}
+	}
+}
+
+func TestOSMajorVersion(t *testing.T) {
+	tests := []struct {
+		os, version string
+		want        string
+	}{
+		{"Windows", "10.0", "10"},
+		{"Windows", "6.1", "7"},
+		{"Windows", "5.1", "XP"},
+		{"Windows", "11.0", "11"},
+		{"macOS", "10.15.7", "10.15"},
+		{"macOS", "10.9", "10.9"},
+		{"macOS", "14.2.1", "14"},
+		{"iOS", "17.1.2", "17"},
+		{"Android", "14", "14"},
+		{"Linux", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := osMajorVersion(tt.os, tt.version); got != tt.want {
+			t.Errorf("osMajorVersion(%q, %q) = %q, 期望 %q", tt.os, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseUserAgentVersions(t *testing.T) {
+	tests := []struct {
+		name               string
+		ua                 string
+		wantBrowserMajor   string
+		wantBrowserVersion string
+		wantOSMajor        string
+		wantOSVersion      string
+	}{
+		{
+			name:               "Windows 上的 Chrome",
+			ua:                 "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36",
+			wantBrowserMajor:   "Chrome 120",
+			wantBrowserVersion: "Chrome 120.0.6099.109",
+			wantOSMajor:        "Windows 10",
+			wantOSVersion:      "Windows NT 10.0",
+		},
+		{
+			name:               "Windows 7 上的 Firefox",
+			ua:                 "Mozilla/5.0 (Windows NT 6.1; Win64; x64; rv:115.0) Gecko/20100101 Firefox/115.0",
+			wantBrowserMajor:   "Firefox 115",
+			wantBrowserVersion: "Firefox 115.0",
+			wantOSMajor:        "Windows 7",
+			wantOSVersion:      "Windows NT 6.1",
+		},
+		{
+			name:               "macOS 10.x 主版本取前两段",
+			ua:                 "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			wantBrowserMajor:   "Safari 17",
+			wantBrowserVersion: "Safari 17.1",
+			wantOSMajor:        "macOS 10.15",
+			wantOSVersion:      "macOS 10.15.7",
+		},
+		{
+			name:               "iPhone 上的 Safari",
+			ua:                 "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1",
+			wantBrowserMajor:   "Safari 17",
+			wantBrowserVersion: "Safari 17.1.2",
+			wantOSMajor:        "iOS 17",
+			wantOSVersion:      "iOS 17.1.2",
+		},
+		{
+			name:               "Linux 没有系统版本",
+			ua:                 "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			wantBrowserMajor:   "Firefox 121",
+			wantBrowserVersion: "Firefox 121.0",
+			wantOSMajor:        "Linux",
+			wantOSVersion:      "Linux",
+		},
+		{
+			name:               "蜘蛛不区分版本",
+			ua:                 "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			wantBrowserMajor:   "蜘蛛",
+			wantBrowserVersion: "蜘蛛",
+			wantOSMajor:        "蜘蛛",
+			wantOSVersion:      "蜘蛛",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := ParseUserAgent(tt.ua)
+			if info.BrowserMajor != tt.wantBrowserMajor || info.BrowserVersion != tt.wantBrowserVersion {
+				t.Errorf("浏览器版本 = %q, %q, 期望 %q, %q",
+					info.BrowserMajor, info.BrowserVersion, tt.wantBrowserMajor, tt.wantBrowserVersion)
+			}
+			if info.OSMajor != tt.wantOSMajor || info.OSVersion != tt.wantOSVersion {
+				t.Errorf("系统版本 = %q, %q, 期望 %q, %q",
+					info.OSMajor, info.OSVersion, tt.wantOSMajor, tt.wantOSVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 通知渠道的类型
+const (
+	ChannelWebhook  = "webhook"  // 通用 webhook，POST 告警事件的 JSON
+	ChannelSlack    = "slack"    // Slack Incoming Webhook
+	ChannelDingTalk = "dingtalk" // 钉钉群机器人
+	ChannelFeishu   = "feishu"   // 飞书群机器人
+	ChannelWeCom    = "wecom"    // 企业微信群机器人
+	ChannelEmail    = "email"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// PostWebhook 向 webhook 推送消息。通用 webhook 发送 payload 的 JSON，
+// 其他类型按各自机器人的格式发送 text
+func PostWebhook(channelType, url, text string, payload interface{}) error {
+	var body interface{}
+	switch channelType {
+	case ChannelSlack:
+		body = map[string]string{"text": text}
+	case ChannelDingTalk, ChannelWeCom:
+		body = map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+		}
+	case ChannelFeishu:
+		body = map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+	default:
+		body = payload
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("请求 webhook 失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d: %s", resp.StatusCode, respBody)
+	}
+
+	// 钉钉、飞书和企业微信出错时状态码仍为 200，错误码在响应中
+	if channelType != ChannelDingTalk && channelType != ChannelFeishu && channelType != ChannelWeCom {
+		return nil
+	}
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    int    `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if json.Unmarshal(respBody, &result) == nil {
+		if result.ErrCode != 0 {
+			return fmt.Errorf("webhook 返回错误 %d: %s", result.ErrCode, result.ErrMsg)
+		}
+		if result.Code != 0 {
+			return fmt.Errorf("webhook 返回错误 %d: %s", result.Code, result.Msg)
+		}
+	}
+	return nil
+}
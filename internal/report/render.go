@@ -0,0 +1,110 @@
+package report
+
+import (
+	"bytes"
+	"html/template"
+)
+
+// emailTemplate 报表邮件的 HTML。邮件客户端大多不支持 <style>，样式都写在元素上
+var emailTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ranking": func(title, column string, items []RankItem) rankingSection {
+		return rankingSection{Title: title, Column: column, Items: items}
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head><meta charset="UTF-8"><title>{{.Subject}}</title></head>
+<body style="margin:0;padding:24px;background:#f4f6f8;font-family:-apple-system,'Segoe UI','PingFang SC','Microsoft YaHei',sans-serif;color:#333;">
+<div style="max-width:680px;margin:0 auto;background:#fff;border-radius:8px;padding:24px;">
+  <h1 style="margin:0 0 4px;font-size:20px;">{{.SiteName}} {{.Kind}}</h1>
+  <p style="margin:0 0 20px;color:#888;font-size:13px;">{{.Period}}</p>
+
+  <h2 style="font-size:16px;margin:20px 0 8px;">总体概况</h2>
+  <table style="width:100%;border-collapse:collapse;font-size:14px;">
+    <tr style="background:#f7f8fa;color:#666;">
+      <th style="text-align:left;padding:8px;">指标</th>
+      <th style="text-align:right;padding:8px;">本期</th>
+      <th style="text-align:right;padding:8px;">上期</th>
+      <th style="text-align:right;padding:8px;">变化</th>
+    </tr>
+    {{- range .Metrics}}
+    <tr style="border-top:1px solid #eee;">
+      <td style="padding:8px;">{{.Name}}</td>
+      <td style="padding:8px;text-align:right;font-weight:bold;">{{.Value}}</td>
+      <td style="padding:8px;text-align:right;color:#888;">{{.Previous}}</td>
+      <td style="padding:8px;text-align:right;color:{{if eq .Trend "good"}}#2e9d5b{{else if eq .Trend "bad"}}#d9534f{{else}}#888{{end}};">{{if .Change}}{{.Change}}{{else}}-{{end}}</td>
+    </tr>
+    {{- end}}
+  </table>
+
+  {{template "ranking" (ranking "热门页面" "页面" .Pages)}}
+  {{template "ranking" (ranking "主要来源" "来源" .Referers)}}
+  {{template "ranking" (ranking "访客地区" "地区" .Locations)}}
+
+  <h2 style="font-size:16px;margin:24px 0 8px;">可疑 IP</h2>
+  {{- if .Suspicious}}
+  <table style="width:100%;border-collapse:collapse;font-size:14px;">
+    <tr style="background:#f7f8fa;color:#666;">
+      {{- if .MultiSite}}<th style="text-align:left;padding:8px;">站点</th>{{end}}
+      <th style="text-align:left;padding:8px;">IP</th>
+      <th style="text-align:left;padding:8px;">类型</th>
+      <th style="text-align:right;padding:8px;">可疑请求</th>
+      <th style="text-align:right;padding:8px;">状态</th>
+    </tr>
+    {{- range .Suspicious}}
+    <tr style="border-top:1px solid #eee;">
+      {{- if $.MultiSite}}<td style="padding:8px;">{{.Site}}</td>{{end}}
+      <td style="padding:8px;font-family:monospace;">{{.IP}}</td>
+      <td style="padding:8px;">{{.Reason}}</td>
+      <td style="padding:8px;text-align:right;">{{.Requests}}</td>
+      <td style="padding:8px;text-align:right;color:{{if .Blocked}}#888{{else}}#d9534f{{end}};">{{if .Blocked}}已封禁{{else}}未封禁{{end}}</td>
+    </tr>
+    {{- end}}
+  </table>
+  {{- else}}
+  <p style="color:#888;font-size:14px;">本期没有可疑请求。</p>
+  {{- end}}
+
+  <p style="margin:24px 0 0;color:#aaa;font-size:12px;">由 NixVis 于 {{.GeneratedAt.Format "2006-01-02 15:04"}} 生成。UV 按 IP 统计，多站点汇总时为各站点之和。</p>
+</div>
+</body>
+</html>
+
+{{define "ranking"}}
+  <h2 style="font-size:16px;margin:24px 0 8px;">{{.Title}}</h2>
+  {{- if .Items}}
+  <table style="width:100%;border-collapse:collapse;font-size:14px;table-layout:fixed;">
+    <tr style="background:#f7f8fa;color:#666;">
+      <th style="text-align:left;padding:8px;">{{.Column}}</th>
+      <th style="text-align:right;padding:8px;width:80px;">PV</th>
+      <th style="text-align:right;padding:8px;width:80px;">UV</th>
+      <th style="text-align:right;padding:8px;width:60px;">占比</th>
+    </tr>
+    {{- range .Items}}
+    <tr style="border-top:1px solid #eee;">
+      <td style="padding:8px;overflow:hidden;text-overflow:ellipsis;white-space:nowrap;">{{.Key}}</td>
+      <td style="padding:8px;text-align:right;">{{.PV}}</td>
+      <td style="padding:8px;text-align:right;">{{.UV}}</td>
+      <td style="padding:8px;text-align:right;color:#888;">{{.Percent}}%</td>
+    </tr>
+    {{- end}}
+  </table>
+  {{- else}}
+  <p style="color:#888;font-size:14px;">本期没有数据。</p>
+  {{- end}}
+{{end}}`))
+
+// rankingSection 排行表格的模板参数
+type rankingSection struct {
+	Title  string
+	Column string
+	Items  []RankItem
+}
+
+// Render 生成报表邮件的 HTML
+func (r *Report) Render() (string, error) {
+	var buf bytes.Buffer
+	if err := emailTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,297 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/stats"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// rankingLimit 报表中各排行的条数
+const rankingLimit = 10
+
+// frequencyNames 发送频率对应的报表名称
+var frequencyNames = map[string]string{
+	storage.ReportDaily:   "日报",
+	storage.ReportWeekly:  "周报",
+	storage.ReportMonthly: "月报",
+}
+
+// Report 一期报表的内容
+type Report struct {
+	SiteName    string
+	Kind        string // 日报 / 周报 / 月报
+	Period      string // 报表周期，如 "2024-01-01 ~ 2024-01-07"
+	StartTime   time.Time
+	EndTime     time.Time
+	MultiSite   bool
+	Metrics     []Metric
+	Pages       []RankItem
+	Referers    []RankItem
+	Locations   []RankItem
+	Suspicious  []SuspiciousIP
+	GeneratedAt time.Time
+}
+
+// Metric 总体指标及与上一周期的对比
+type Metric struct {
+	Name     string
+	Value    string
+	Previous string
+	Change   string // 如 "+12.5%"，上一周期没有数据时为空
+	Trend    string // good / bad / 空，决定变化值的颜色
+}
+
+// RankItem 排行中的一项
+type RankItem struct {
+	Key     string
+	PV      int
+	UV      int
+	Percent int // 占总 PV 的百分比
+}
+
+// SuspiciousIP 周期内发出可疑请求的 IP
+type SuspiciousIP struct {
+	Site     string
+	IP       string
+	Requests int
+	Reason   string
+	Blocked  bool
+}
+
+// Subject 邮件标题
+func (r *Report) Subject() string {
+	return fmt.Sprintf("[NixVis] %s %s（%s）", r.SiteName, r.Kind, r.Period)
+}
+
+// build 汇总订阅在 at 时刻可以发送的最近一个完整周期的报表
+func build(statsFactory *stats.StatsFactory, repo *storage.Repository,
+	sub storage.ReportSubscription, at time.Time) (*Report, error) {
+
+	startTime, endTime := sub.Period(at)
+	prevStart, prevEnd := sub.PreviousPeriod(startTime)
+
+	report := &Report{
+		SiteName:    scopeName(sub.WebsiteID),
+		Kind:        frequencyNames[sub.Frequency],
+		Period:      periodLabel(sub.Frequency, startTime, endTime),
+		StartTime:   startTime,
+		EndTime:     endTime,
+		MultiSite:   util.IsSiteScope(sub.WebsiteID),
+		GeneratedAt: time.Now(),
+	}
+
+	current, err := queryOverall(statsFactory, sub.WebsiteID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := queryOverall(statsFactory, sub.WebsiteID, prevStart, prevEnd)
+	if err != nil {
+		return nil, err
+	}
+	report.Metrics = overallMetrics(current, previous)
+
+	rankings := []struct {
+		statsType string
+		extra     map[string]interface{}
+		target    *[]RankItem
+	}{
+		{"url", nil, &report.Pages},
+		{"referer", nil, &report.Referers},
+		{"location", map[string]interface{}{"locationType": "domestic"}, &report.Locations},
+	}
+	for _, ranking := range rankings {
+		extra := map[string]interface{}{"limit": rankingLimit}
+		for key, value := range ranking.extra {
+			extra[key] = value
+		}
+		result, err := statsFactory.QueryStats(ranking.statsType,
+			stats.NewRangeQuery(sub.WebsiteID, startTime, endTime, extra))
+		if err != nil {
+			return nil, fmt.Errorf("查询%s排行失败: %v", ranking.statsType, err)
+		}
+		*ranking.target = rankItems(result.(stats.ClientStats))
+	}
+
+	report.Suspicious, err = suspiciousIPs(repo, sub.WebsiteID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func queryOverall(statsFactory *stats.StatsFactory, websiteID string, startTime, endTime time.Time) (stats.OverallStats, error) {
+	result, err := statsFactory.QueryStats("overall", stats.NewRangeQuery(websiteID, startTime, endTime, nil))
+	if err != nil {
+		return stats.OverallStats{}, fmt.Errorf("查询总体统计失败: %v", err)
+	}
+	return result.(stats.OverallStats), nil
+}
+
+// overallMetrics 生成总体指标的对比，跳出率的变化以百分点表示，下降为好
+func overallMetrics(current, previous stats.OverallStats) []Metric {
+	count := func(name string, cur, prev int64, format func(int64) string) Metric {
+		m := Metric{Name: name, Value: format(cur), Previous: format(prev)}
+		if prev > 0 {
+			change := math.Round(float64(cur-prev)/float64(prev)*1000) / 10
+			m.Change = fmt.Sprintf("%+.1f%%", change)
+			m.Trend = trend(change, false)
+		}
+		return m
+	}
+
+	bounce := Metric{
+		Name:     "跳出率",
+		Value:    fmt.Sprintf("%.1f%%", current.BounceRate),
+		Previous: fmt.Sprintf("%.1f%%", previous.BounceRate),
+	}
+	if previous.Visits > 0 {
+		change := math.Round((current.BounceRate-previous.BounceRate)*10) / 10
+		bounce.Change = fmt.Sprintf("%+.1f 个百分点", change)
+		bounce.Trend = trend(change, true)
+	}
+
+	return []Metric{
+		count("浏览量（PV）", int64(current.PV), int64(previous.PV), formatCount),
+		count("访客数（UV）", int64(current.UV), int64(previous.UV), formatCount),
+		count("访问次数", int64(current.Visits), int64(previous.Visits), formatCount),
+		count("新访客", int64(current.NewVisitors), int64(previous.NewVisitors), formatCount),
+		bounce,
+		count("平均访问时长", current.AvgVisitDuration, previous.AvgVisitDuration, formatDuration),
+		count("流量", current.Traffic, previous.Traffic, formatBytes),
+	}
+}
+
+// trend 根据变化方向判断好坏，lowerIsBetter 的指标下降为好
+func trend(change float64, lowerIsBetter bool) string {
+	switch {
+	case change == 0:
+		return ""
+	case (change > 0) != lowerIsBetter:
+		return "good"
+	default:
+		return "bad"
+	}
+}
+
+func rankItems(result stats.ClientStats) []RankItem {
+	items := make([]RankItem, 0, len(result.Key))
+	for i, key := range result.Key {
+		items = append(items, RankItem{
+			Key:     key,
+			PV:      result.PV[i],
+			UV:      result.UV[i],
+			Percent: result.PVPercent[i],
+		})
+	}
+	return items
+}
+
+// suspiciousIPs 获取周期内可疑请求最多的 IP，多站点范围按请求数合并各站点的结果
+func suspiciousIPs(repo *storage.Repository, websiteID string, startTime, endTime time.Time) ([]SuspiciousIP, error) {
+	siteIDs := []string{websiteID}
+	if util.IsSiteScope(websiteID) {
+		var err error
+		if siteIDs, err = util.ResolveSiteScope(websiteID); err != nil {
+			return nil, err
+		}
+	}
+
+	ips := make([]SuspiciousIP, 0)
+	for _, siteID := range siteIDs {
+		siteIPs, err := repo.ReportSuspiciousIPs(siteID, startTime, endTime, rankingLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range siteIPs {
+			ips = append(ips, SuspiciousIP{
+				Site:     scopeName(siteID),
+				IP:       ip.IP,
+				Requests: ip.Requests,
+				Reason:   ip.Reason,
+				Blocked:  ip.Blocked,
+			})
+		}
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].Requests > ips[j].Requests
+	})
+	if len(ips) > rankingLimit {
+		ips = ips[:rankingLimit]
+	}
+	return ips, nil
+}
+
+// scopeName 站点或多站点范围的显示名称
+func scopeName(websiteID string) string {
+	switch {
+	case websiteID == util.SiteScopeAll:
+		return "全部站点"
+	case strings.HasPrefix(websiteID, util.SiteGroupPrefix):
+		return strings.TrimPrefix(websiteID, util.SiteGroupPrefix)
+	}
+	if website, ok := util.GetWebsiteByID(websiteID); ok {
+		return website.Name
+	}
+	return websiteID
+}
+
+// periodLabel 报表周期的显示文本，结束时间不含在周期内
+func periodLabel(frequency string, startTime, endTime time.Time) string {
+	lastDay := endTime.AddDate(0, 0, -1)
+	switch frequency {
+	case storage.ReportMonthly:
+		return startTime.Format("2006年1月")
+	case storage.ReportWeekly:
+		return startTime.Format("2006-01-02") + " ~ " + lastDay.Format("2006-01-02")
+	default:
+		weekdays := []string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}
+		return startTime.Format("2006-01-02") + " " + weekdays[startTime.Weekday()]
+	}
+}
+
+// formatCount 带千位分隔符的整数
+func formatCount(n int64) string {
+	s := fmt.Sprintf("%d", n)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// formatBytes 以合适的单位显示字节数
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
+
+// formatDuration 以分秒显示秒数
+func formatDuration(seconds int64) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%d秒", seconds)
+	}
+	return fmt.Sprintf("%d分%d秒", seconds/60, seconds%60)
+}
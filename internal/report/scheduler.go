@@ -0,0 +1,91 @@
+package report
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/notify"
+	"github.com/beyondxinxin/nixvis/internal/stats"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// checkInterval 检查到期订阅的间隔
+const checkInterval = time.Minute
+
+// Scheduler 按订阅定期生成并发送邮件报表
+type Scheduler struct {
+	statsFactory *stats.StatsFactory
+	repo         *storage.Repository
+	mu           sync.Mutex // 同一时间只发送一封报表，避免定时发送和立即发送重复查询
+}
+
+// NewScheduler 创建报表调度器
+func NewScheduler(statsFactory *stats.StatsFactory, repo *storage.Repository) *Scheduler {
+	return &Scheduler{
+		statsFactory: statsFactory,
+		repo:         repo,
+	}
+}
+
+// Start 在后台每分钟检查一次到期的订阅
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.runDue(now)
+		}
+	}()
+}
+
+// runDue 发送到期的报表。服务停止期间错过的多个周期只补发最近一期，
+// 发送失败的报表不自动重试，原因记录在订阅的 last_error 中
+func (s *Scheduler) runDue(now time.Time) {
+	subs, err := s.repo.DueReportSubscriptions(now)
+	if err != nil {
+		logrus.WithError(err).Error("查询到期的报表订阅失败")
+		return
+	}
+
+	for _, sub := range subs {
+		sendErr := s.Send(sub, now)
+		if sendErr != nil {
+			logrus.WithError(sendErr).Errorf("发送报表 %d（站点 %s）失败", sub.ID, sub.WebsiteID)
+		} else {
+			logrus.Infof("已发送报表 %d（站点 %s）给 %d 个收件人", sub.ID, sub.WebsiteID, len(sub.Recipients))
+		}
+
+		next := sub.NextSendTime(now).Unix()
+		if err := s.repo.RecordReportSent(sub.ID, now, sendErr, next); err != nil {
+			logrus.WithError(err).Error("更新报表发送状态失败")
+		}
+	}
+}
+
+// Preview 生成订阅在 at 时刻对应的报表，返回邮件标题和 HTML
+func (s *Scheduler) Preview(sub storage.ReportSubscription, at time.Time) (string, string, error) {
+	report, err := build(s.statsFactory, s.repo, sub, at)
+	if err != nil {
+		return "", "", err
+	}
+	html, err := report.Render()
+	if err != nil {
+		return "", "", fmt.Errorf("生成报表失败: %v", err)
+	}
+	return report.Subject(), html, nil
+}
+
+// Send 生成订阅在 at 时刻对应的报表并发送给全部收件人
+func (s *Scheduler) Send(sub storage.ReportSubscription, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subject, html, err := s.Preview(sub, at)
+	if err != nil {
+		return err
+	}
+	return notify.SendMail(util.GetSMTPConfig(), sub.Recipients, subject, html)
+}
@@ -0,0 +1,243 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// aggregateTypes 支持多站点范围（id=all 或 id=group:<名称>）的统计类型。
+// 多站点的 UV 为各站点 UV 之和，同一 IP 访问多个站点会被计为多个访客。
+var aggregateTypes = map[string]bool{
+	"overall": true, "timeseries": true, "sites": true,
+	"url": true, "referer": true, "browser": true, "os": true, "device": true, "location": true,
+}
+
+// queryAcrossSites 对范围内的每个站点分别查询（各站点结果走各自的缓存）后合并
+func (f *StatsFactory) queryAcrossSites(managerType string, query StatsQuery) (StatsResult, error) {
+	siteIDs, err := util.ResolveSiteScope(query.WebsiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]StatsResult, 0, len(siteIDs))
+	for _, siteID := range siteIDs {
+		siteQuery := StatsQuery{WebsiteID: siteID, ExtraParam: query.ExtraParam}
+		result, err := f.QueryStats(managerType, siteQuery)
+		if err != nil {
+			return nil, fmt.Errorf("查询站点 %s 失败: %v", siteID, err)
+		}
+		results = append(results, result)
+	}
+
+	switch managerType {
+	case "overall":
+		return mergeOverallStats(results), nil
+	case "timeseries":
+		return mergeTimeSeriesStats(results), nil
+	default:
+		limit, _ := query.ExtraParam["limit"].(int)
+		return mergeClientStats(results, limit), nil
+	}
+}
+
+func (v *visitSummary) add(other visitSummary) {
+	v.Visits += other.Visits
+	v.Bounces += other.Bounces
+	v.Pageviews += other.Pageviews
+	v.Duration += other.Duration
+}
+
+// mergeOverallStats 合并各站点的总体统计，目标转化属于单个站点，不参与合并
+func mergeOverallStats(results []StatsResult) OverallStats {
+	merged := OverallStats{}
+	var visits visitSummary
+	var compare *OverallComparison
+
+	for _, r := range results {
+		s := r.(OverallStats)
+		merged.PV += s.PV
+		merged.UV += s.UV
+		merged.Traffic += s.Traffic
+		merged.NewVisitors += s.NewVisitors
+		merged.ReturningVisitors += s.ReturningVisitors
+		visits.add(s.visitTotals)
+
+		if s.Compare != nil {
+			if compare == nil {
+				compare = &OverallComparison{
+					Period:    s.Compare.Period,
+					StartTime: s.Compare.StartTime,
+					EndTime:   s.Compare.EndTime,
+				}
+			}
+			compare.PV += s.Compare.PV
+			compare.UV += s.Compare.UV
+			compare.Traffic += s.Compare.Traffic
+		}
+	}
+
+	merged.visitTotals = visits
+	merged.Visits = visits.Visits
+	merged.BounceRate = visits.bounceRate()
+	merged.PagesPerVisit = visits.pagesPerVisit()
+	merged.AvgVisitDuration = visits.avgDuration()
+
+	if compare != nil {
+		compare.PVChange = percentChange(int64(merged.PV), int64(compare.PV))
+		compare.UVChange = percentChange(int64(merged.UV), int64(compare.UV))
+		compare.TrafficChange = percentChange(merged.Traffic, compare.Traffic)
+		merged.Compare = compare
+	}
+
+	return merged
+}
+
+// mergeTimeSeriesStats 按区间合并各站点的时间序列，各站点的区间划分相同
+func mergeTimeSeriesStats(results []StatsResult) TimeSeriesStats {
+	first := results[0].(TimeSeriesStats)
+	n := len(first.Labels)
+	merged := TimeSeriesStats{
+		Labels:            first.Labels,
+		Visitors:          make([]int, n),
+		Pageviews:         make([]int, n),
+		PvMinusUv:         make([]int, n),
+		Visits:            make([]int, n),
+		BounceRate:        make([]float64, n),
+		PagesPerVisit:     make([]float64, n),
+		AvgVisitDuration:  make([]int64, n),
+		NewVisitors:       make([]int, n),
+		ReturningVisitors: make([]int, n),
+		ComparePeriod:     first.ComparePeriod,
+	}
+	if first.ComparePeriod != "" {
+		merged.CompareVisitors = make([]int, n)
+		merged.ComparePageviews = make([]int, n)
+	}
+
+	visits := make([]visitSummary, n)
+	for _, r := range results {
+		s := r.(TimeSeriesStats)
+		for i := 0; i < n && i < len(s.Labels); i++ {
+			merged.Visitors[i] += s.Visitors[i]
+			merged.Pageviews[i] += s.Pageviews[i]
+			merged.PvMinusUv[i] += s.PvMinusUv[i]
+			merged.NewVisitors[i] += s.NewVisitors[i]
+			merged.ReturningVisitors[i] += s.ReturningVisitors[i]
+			if i < len(s.visitTotals) {
+				visits[i].add(s.visitTotals[i])
+			}
+
+			if merged.ComparePeriod != "" && i < len(s.CompareVisitors) {
+				merged.CompareVisitors[i] += s.CompareVisitors[i]
+				merged.ComparePageviews[i] += s.ComparePageviews[i]
+			}
+		}
+	}
+
+	merged.visitTotals = visits
+	for i, v := range visits {
+		merged.Visits[i] = v.Visits
+		merged.BounceRate[i] = v.bounceRate()
+		merged.PagesPerVisit[i] = v.pagesPerVisit()
+		merged.AvgVisitDuration[i] = v.avgDuration()
+	}
+
+	return merged
+}
+
+// mergeClientStats 按键合并各站点的排行后重新排序。
+// 每个站点只取前 limit 项，排名靠后但在多个站点都出现的项可能被低估。
+func mergeClientStats(results []StatsResult, limit int) ClientStats {
+	type item struct {
+		key    string
+		pv, uv int
+	}
+	items := make(map[string]*item)
+	for _, r := range results {
+		s := r.(ClientStats)
+		for i, key := range s.Key {
+			it, ok := items[key]
+			if !ok {
+				it = &item{key: key}
+				items[key] = it
+			}
+			it.pv += s.PV[i]
+			it.uv += s.UV[i]
+		}
+	}
+
+	sorted := make([]*item, 0, len(items))
+	for _, it := range items {
+		sorted = append(sorted, it)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].uv != sorted[j].uv {
+			return sorted[i].uv > sorted[j].uv
+		}
+		if sorted[i].pv != sorted[j].pv {
+			return sorted[i].pv > sorted[j].pv
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+
+	merged := ClientStats{
+		Key:       make([]string, 0, len(sorted)),
+		PV:        make([]int, 0, len(sorted)),
+		UV:        make([]int, 0, len(sorted)),
+		PVPercent: make([]int, 0, len(sorted)),
+		UVPercent: make([]int, 0, len(sorted)),
+	}
+	totalPV, totalUV := 0, 0
+	for _, it := range sorted {
+		merged.Key = append(merged.Key, it.key)
+		merged.PV = append(merged.PV, it.pv)
+		merged.UV = append(merged.UV, it.uv)
+		totalPV += it.pv
+		totalUV += it.uv
+	}
+	if totalPV > 0 && totalUV > 0 {
+		for i := range merged.PV {
+			merged.PVPercent = append(merged.PVPercent,
+				int(math.Round(float64(merged.PV[i])/float64(totalPV)*100)))
+			merged.UVPercent = append(merged.UVPercent,
+				int(math.Round(float64(merged.UV[i])/float64(totalUV)*100)))
+		}
+	}
+
+	return merged
+}
+
+// logTimeBounds 获取站点或多站点范围内日志的最早和最晚时间
+func (f *StatsFactory) logTimeBounds(websiteID string) (int64, int64, error) {
+	if !util.IsSiteScope(websiteID) {
+		return f.repo.GetLogTimeBounds(websiteID)
+	}
+
+	siteIDs, err := util.ResolveSiteScope(websiteID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var minTs, maxTs int64
+	for _, siteID := range siteIDs {
+		siteMin, siteMax, err := f.repo.GetLogTimeBounds(siteID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if siteMin == 0 && siteMax == 0 {
+			continue
+		}
+		if minTs == 0 || siteMin < minTs {
+			minTs = siteMin
+		}
+		if siteMax > maxTs {
+			maxTs = siteMax
+		}
+	}
+	return minTs, maxTs, nil
+}
@@ -0,0 +1,45 @@
+package stats
+
+import "testing"
+
+func TestMergeVisitTotals(t *testing.T) {
+	// 两个站点的跳出率分别为 1/3 和 2/3，四舍五入后的比率无法还原出准确的合计
+	siteA := visitSummary{Visits: 3, Bounces: 1, Pageviews: 7, Duration: 100}
+	siteB := visitSummary{Visits: 3, Bounces: 2, Pageviews: 4, Duration: 50}
+
+	overall := mergeOverallStats([]StatsResult{
+		OverallStats{Visits: 3, visitTotals: siteA},
+		OverallStats{Visits: 3, visitTotals: siteB},
+	})
+	if overall.Visits != 6 || overall.BounceRate != 50 || overall.PagesPerVisit != 1.83 ||
+		overall.AvgVisitDuration != 25 {
+		t.Errorf("合并的总体访问指标 = %d %v %v %d, 期望 6 50 1.83 25",
+			overall.Visits, overall.BounceRate, overall.PagesPerVisit, overall.AvgVisitDuration)
+	}
+
+	series := func(totals ...visitSummary) TimeSeriesStats {
+		n := len(totals)
+		return TimeSeriesStats{
+			Labels: make([]string, n), Visitors: make([]int, n), Pageviews: make([]int, n),
+			PvMinusUv: make([]int, n), NewVisitors: make([]int, n), ReturningVisitors: make([]int, n),
+			visitTotals: totals,
+		}
+	}
+	merged := mergeTimeSeriesStats([]StatsResult{
+		series(siteA, visitSummary{}),
+		series(siteB, siteB),
+	})
+	want := []visitSummary{
+		{Visits: 6, Bounces: 3, Pageviews: 11, Duration: 150},
+		siteB,
+	}
+	for i, w := range want {
+		if merged.visitTotals[i] != w {
+			t.Errorf("区间 %d 的合计 = %+v, 期望 %+v", i, merged.visitTotals[i], w)
+		}
+		if merged.BounceRate[i] != w.bounceRate() || merged.PagesPerVisit[i] != w.pagesPerVisit() {
+			t.Errorf("区间 %d 的跳出率和浏览页数 = %v %v, 期望 %v %v", i,
+				merged.BounceRate[i], merged.PagesPerVisit[i], w.bounceRate(), w.pagesPerVisit())
+		}
+	}
+}
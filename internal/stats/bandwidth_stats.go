@@ -0,0 +1,333 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// 按扩展名划分的内容类别，没有扩展名的请求归为页面
+var contentClasses = map[string][]string{
+	"page":     {"", "html", "htm", "php", "asp", "aspx", "jsp", "shtml"},
+	"image":    {"jpg", "jpeg", "png", "gif", "webp", "svg", "ico", "bmp", "avif"},
+	"script":   {"js", "mjs", "map"},
+	"style":    {"css"},
+	"font":     {"woff", "woff2", "ttf", "otf", "eot"},
+	"media":    {"mp4", "webm", "mp3", "ogg", "wav", "flac", "m4a", "mov", "m3u8", "ts"},
+	"document": {"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "md"},
+	"archive":  {"zip", "gz", "tgz", "rar", "7z", "tar", "bz2", "xz", "iso", "dmg", "exe", "apk", "deb", "rpm"},
+	"data":     {"json", "xml", "csv", "rss", "atom"},
+}
+
+// contentClassOf 返回扩展名所属的内容类别
+func contentClassOf(ext string) string {
+	for class, exts := range contentClasses {
+		for _, e := range exts {
+			if e == ext {
+				return class
+			}
+		}
+	}
+	return "other"
+}
+
+// BandwidthStats 流量统计，统计全部请求（含静态资源）而不只是 PV
+type BandwidthStats struct {
+	Total          int64           `json:"total"` // 总流量（字节）
+	Requests       int             `json:"requests"`
+	Series         BandwidthSeries `json:"series"`
+	TopURLs        []BandwidthItem `json:"top_urls"`
+	TopIPs         []BandwidthItem `json:"top_ips"`
+	Extensions     []BandwidthItem `json:"extensions"`      // 按扩展名，空字符串表示无扩展名
+	ContentClasses []BandwidthItem `json:"content_classes"` // 按内容类别
+	InternalHosts  []string        `json:"internal_hosts"`  // 判断盗链时视为站内的域名
+	Hotlinks       []HotlinkSource `json:"hotlinks"`        // 外部站点引用本站资源的情况
+}
+
+// BandwidthSeries 按时间区间的流量
+type BandwidthSeries struct {
+	Labels   []string `json:"labels"`
+	Bytes    []int64  `json:"bytes"`
+	Requests []int    `json:"requests"`
+}
+
+// BandwidthItem 某一项的流量
+type BandwidthItem struct {
+	Key      string  `json:"key"`
+	Bytes    int64   `json:"bytes"`
+	Requests int     `json:"requests"`
+	Percent  float64 `json:"percent"` // 占总流量的百分比
+}
+
+// HotlinkSource 引用本站资源的外部来源
+type HotlinkSource struct {
+	Host     string `json:"host"`
+	Bytes    int64  `json:"bytes"`
+	Requests int    `json:"requests"`
+	TopURL   string `json:"top_url"` // 被引用最多的资源
+}
+
+func (s BandwidthStats) GetType() string {
+	return "bandwidth"
+}
+
+type BandwidthStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewBandwidthStatsManager(userRepoPtr *storage.Repository) *BandwidthStatsManager {
+	return &BandwidthStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *BandwidthStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := BandwidthStats{
+		TopURLs:        make([]BandwidthItem, 0),
+		TopIPs:         make([]BandwidthItem, 0),
+		Extensions:     make([]BandwidthItem, 0),
+		ContentClasses: make([]BandwidthItem, 0),
+		Hotlinks:       make([]HotlinkSource, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+	excludeSpiders, _ := query.ExtraParam["excludeSpiders"].(bool)
+	filters := queryFilters(query)
+	filterCond, filterArgs := filters.condition("")
+	cond := spiderCondition("is_spider", excludeSpiders) + filterCond
+	db := s.repo.GetDB()
+
+	err = db.QueryRow(fmt.Sprintf(`
+        SELECT COALESCE(SUM(bytes_sent), 0), COUNT(*)
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE timestamp >= ? AND timestamp < ? %[2]s`, query.WebsiteID, cond),
+		append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)...).Scan(&result.Total, &result.Requests)
+	if err != nil {
+		return result, fmt.Errorf("查询总流量失败: %v", err)
+	}
+
+	timePoints, labels, seriesEnd, err := queryTimePoints(query)
+	if err != nil {
+		return result, err
+	}
+	seriesCond, seriesArgs := filters.condition("l")
+	result.Series, err = s.querySeries(query.WebsiteID,
+		spiderCondition("l.is_spider", excludeSpiders)+seriesCond, seriesArgs, timePoints, seriesEnd)
+	if err != nil {
+		return result, err
+	}
+	result.Series.Labels = labels
+
+	if result.TopURLs, err = s.queryTop(query.WebsiteID, "url", cond, filterArgs,
+		startTime, endTime, limit, result.Total); err != nil {
+		return result, err
+	}
+	if result.TopIPs, err = s.queryTop(query.WebsiteID, "ip", cond, filterArgs,
+		startTime, endTime, limit, result.Total); err != nil {
+		return result, err
+	}
+	// 扩展名种类有限，全部取出后再汇总为内容类别
+	if result.Extensions, err = s.queryTop(query.WebsiteID, "url_ext(url)", cond, filterArgs,
+		startTime, endTime, -1, result.Total); err != nil {
+		return result, err
+	}
+	result.ContentClasses = groupContentClasses(result.Extensions, result.Total)
+
+	result.InternalHosts, err = s.repo.InternalHosts(query.WebsiteID)
+	if err != nil {
+		return result, err
+	}
+	if len(result.InternalHosts) > 0 {
+		if result.Hotlinks, err = s.queryHotlinks(query.WebsiteID, cond, filterArgs,
+			result.InternalHosts, startTime, endTime, limit); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// querySeries 按区间统计流量和请求数
+func (s *BandwidthStatsManager) querySeries(websiteID, cond string, condArgs []any,
+	timePoints []time.Time, endTime time.Time) (BandwidthSeries, error) {
+
+	n := len(timePoints)
+	series := BandwidthSeries{
+		Bytes:    make([]int64, n),
+		Requests: make([]int, n),
+	}
+	if n == 0 {
+		return series, nil
+	}
+
+	args := make([]any, 0, n*2)
+	for i := range timePoints {
+		rangeEnd := endTime
+		if i+1 < n {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
+	}
+	args = append(args, condArgs...)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %[1]s
+        )
+        SELECT tr.range_index, COALESCE(SUM(l.bytes_sent), 0), COUNT(l.id)
+        FROM time_ranges tr
+        LEFT JOIN "%[2]s_nginx_logs" l INDEXED BY idx_%[2]s_timestamp
+            ON l.timestamp >= tr.start_time AND l.timestamp < tr.end_time %[3]s
+        GROUP BY tr.range_index
+        ORDER BY tr.range_index`,
+		formatRangeValues(n), websiteID, cond), args...)
+	if err != nil {
+		return series, fmt.Errorf("查询流量趋势失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i int
+		var bytes int64
+		var requests int
+		if err := rows.Scan(&i, &bytes, &requests); err != nil {
+			return series, fmt.Errorf("解析流量趋势失败: %v", err)
+		}
+		series.Bytes[i] = bytes
+		series.Requests[i] = requests
+	}
+
+	return series, rows.Err()
+}
+
+// queryTop 按 keyExpr 分组统计流量最多的项，limit 为 -1 时不限制
+func (s *BandwidthStatsManager) queryTop(websiteID, keyExpr, cond string, condArgs []any,
+	startTime, endTime time.Time, limit int, total int64) ([]BandwidthItem, error) {
+
+	items := make([]BandwidthItem, 0)
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT %[2]s AS key, COALESCE(SUM(bytes_sent), 0) AS bytes, COUNT(*) AS requests
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE timestamp >= ? AND timestamp < ? %[3]s
+        GROUP BY key
+        ORDER BY bytes DESC
+        LIMIT ?`, websiteID, keyExpr, cond),
+		append(append([]any{startTime.Unix(), endTime.Unix()}, condArgs...), limit)...)
+	if err != nil {
+		return items, fmt.Errorf("查询流量排行失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item BandwidthItem
+		if err := rows.Scan(&item.Key, &item.Bytes, &item.Requests); err != nil {
+			return items, fmt.Errorf("解析流量排行失败: %v", err)
+		}
+		item.Percent = bytesPercent(item.Bytes, total)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// queryHotlinks 统计外部来源引用本站静态资源（非页面）的请求。
+// 与来源分析相同，站点域名及其子域名都算作站内
+func (s *BandwidthStatsManager) queryHotlinks(websiteID, cond string, condArgs []any, internalHosts []string,
+	startTime, endTime time.Time, limit int) ([]HotlinkSource, error) {
+
+	hotlinks := make([]HotlinkSource, 0)
+
+	pageExts := contentClasses["page"]
+	args := []interface{}{startTime.Unix(), endTime.Unix()}
+	args = append(args, condArgs...)
+	for _, ext := range pageExts {
+		args = append(args, ext)
+	}
+	args = append(args, strings.Join(internalHosts, ","), limit)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH assets AS (
+            SELECT url_host(referer) AS host, referer, url, bytes_sent
+            FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+            WHERE timestamp >= ? AND timestamp < ? %[2]s
+              AND url_ext(url) NOT IN (%[3]s)
+        ),
+        foreign_assets AS (
+            SELECT * FROM assets
+            WHERE host != '' AND referer_category(referer, ?) NOT IN ('direct', 'internal')
+        ),
+        top_hosts AS (
+            SELECT host, SUM(bytes_sent) AS bytes, COUNT(*) AS requests
+            FROM foreign_assets
+            GROUP BY host
+            ORDER BY bytes DESC
+            LIMIT ?
+        )
+        SELECT t.host, t.bytes, t.requests,
+            (SELECT url FROM foreign_assets f WHERE f.host = t.host
+             GROUP BY url ORDER BY COUNT(*) DESC LIMIT 1) AS top_url
+        FROM top_hosts t
+        ORDER BY t.bytes DESC`,
+		websiteID, cond, placeholders(len(pageExts))), args...)
+	if err != nil {
+		return hotlinks, fmt.Errorf("查询盗链失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h HotlinkSource
+		if err := rows.Scan(&h.Host, &h.Bytes, &h.Requests, &h.TopURL); err != nil {
+			return hotlinks, fmt.Errorf("解析盗链失败: %v", err)
+		}
+		hotlinks = append(hotlinks, h)
+	}
+
+	return hotlinks, rows.Err()
+}
+
+// groupContentClasses 把按扩展名的流量汇总为内容类别
+func groupContentClasses(extensions []BandwidthItem, total int64) []BandwidthItem {
+	byClass := make(map[string]*BandwidthItem)
+	for _, ext := range extensions {
+		class := contentClassOf(ext.Key)
+		item, ok := byClass[class]
+		if !ok {
+			item = &BandwidthItem{Key: class}
+			byClass[class] = item
+		}
+		item.Bytes += ext.Bytes
+		item.Requests += ext.Requests
+	}
+
+	classes := make([]BandwidthItem, 0, len(byClass))
+	for _, item := range byClass {
+		item.Percent = bytesPercent(item.Bytes, total)
+		classes = append(classes, *item)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Bytes > classes[j].Bytes
+	})
+	return classes
+}
+
+// bytesPercent 计算流量占比，保留一位小数
+func bytesPercent(bytes, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(bytes)/float64(total)*1000) / 10
+}
+
+// placeholders 生成 n 个以逗号分隔的 SQL 占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -3,9 +3,9 @@ package stats
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/storage"
-	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
 type ClientStats struct {
@@ -14,6 +14,34 @@ type ClientStats struct {
 	UV        []int    `json:"uv"`         // 独立访客数
 	PVPercent []int    `json:"pv_percent"` // PV 百分比
 	UVPercent []int    `json:"uv_percent"` // UV 百分比
+
+	Compare *ClientComparison `json:"compare,omitempty"` // 对比周期的数据
+}
+
+// ClientComparison 排行中各项在对比周期的数据，与 Key 一一对应
+type ClientComparison struct {
+	Period     string     `json:"period"`
+	PV         []int      `json:"pv"`
+	UV         []int      `json:"uv"`
+	PVChange   []*float64 `json:"pv_change"` // 变化百分比，对比值为 0 时为 null
+	UVChange   []*float64 `json:"uv_change"`
+	PrevRank   []int      `json:"prev_rank"`   // 对比周期的排名，0 表示未出现
+	RankChange []int      `json:"rank_change"` // 排名变化，正数表示上升
+}
+
+// locationColumns locationType 对应的日志列
+var locationColumns = map[string]string{
+	"domestic": "domestic_location", // 国内为省份
+	"global":   "global_location",   // 国家
+	"city":     "city",
+	"isp":      "isp", // 运营商
+}
+
+// rankingLevels 浏览器、系统和设备排行的汇总粒度及对应的日志列，第一个为默认值
+var rankingLevels = map[string][]struct{ level, column string }{
+	"user_browser": {{"name", "user_browser"}, {"major", "browser_major"}, {"full", "browser_version"}},
+	"user_os":      {{"name", "user_os"}, {"major", "os_major"}, {"full", "os_version"}},
+	"user_device":  {{"class", "user_device"}, {"brand", "device_brand"}, {"model", "device_model"}},
 }
 
 func (s ClientStats) GetType() string {
@@ -79,15 +107,24 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 	statsType := s.statsType
 	if s.statsType == "location" {
-		statsType = query.ExtraParam["locationType"].(string) + "_location"
+		statsType = locationColumns[query.ExtraParam["locationType"].(string)]
+	}
+	if level, ok := query.ExtraParam["level"].(string); ok {
+		for _, l := range rankingLevels[s.statsType] {
+			if l.level == level {
+				statsType = l.column
+			}
+		}
 	}
 	limit, _ := query.ExtraParam["limit"].(int)
-	timeRange := query.ExtraParam["timeRange"].(string)
-	startTime, endTime, err := util.TimePeriod(timeRange)
+	startTime, endTime, err := queryTimePeriod(query)
 	if err != nil {
 		return result, err
 	}
 
+	filters := queryFilters(query)
+	filterCond, filterArgs := filters.condition("")
+
 	// 构建、执行查询
 	dbQueryStr := fmt.Sprintf(`
         SELECT 
@@ -95,13 +132,14 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
             COUNT(*) AS pv,
             COUNT(DISTINCT ip) AS uv
         FROM "%[2]s_nginx_logs" INDEXED BY idx_%[2]s_pv_ts_ip
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
+        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ? %[3]s
         GROUP BY %[1]s
         ORDER BY uv DESC
         LIMIT ?`,
-		statsType, query.WebsiteID)
+		statsType, query.WebsiteID, filterCond)
 
-	rows, err := s.repo.GetDB().Query(dbQueryStr, startTime.Unix(), endTime.Unix(), limit)
+	args := append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)
+	rows, err := s.repo.GetDB().Query(dbQueryStr, append(args, limit)...)
 	if err != nil {
 		return result, fmt.Errorf("查询URL统计失败: %v", err)
 	}
@@ -138,6 +176,98 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		}
 	}
 
+	compareStart, compareEnd, ok, err := queryComparePeriod(query)
+	if err != nil {
+		return result, err
+	}
+	if ok {
+		compare, err := s.compareRanking(query.WebsiteID, statsType, filters, result, compareStart, compareEnd)
+		if err != nil {
+			return result, err
+		}
+		compare.Period = query.ExtraParam["compare"].(string)
+		result.Compare = compare
+	}
+
 	return result, nil
 
 }
+
+// compareRanking 查询当前排行中各项在对比周期的 PV、UV 和排名
+func (s *ClientStatsManager) compareRanking(
+	websiteID, statsType string, filters Filters, current ClientStats,
+	startTime, endTime time.Time) (*ClientComparison, error) {
+
+	n := len(current.Key)
+	compare := &ClientComparison{
+		PV:         make([]int, n),
+		UV:         make([]int, n),
+		PVChange:   make([]*float64, n),
+		UVChange:   make([]*float64, n),
+		PrevRank:   make([]int, n),
+		RankChange: make([]int, n),
+	}
+	if n == 0 {
+		return compare, nil
+	}
+
+	// 排名按对比周期内的全部项计算，再取出当前排行中的项
+	filterCond, filterArgs := filters.condition("")
+	dbQueryStr := fmt.Sprintf(`
+        SELECT key, pv, uv, rank FROM (
+            SELECT 
+                %[1]s AS key, 
+                COUNT(*) AS pv,
+                COUNT(DISTINCT ip) AS uv,
+                ROW_NUMBER() OVER (ORDER BY COUNT(DISTINCT ip) DESC) AS rank
+            FROM "%[2]s_nginx_logs" INDEXED BY idx_%[2]s_pv_ts_ip
+            WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ? %[4]s
+            GROUP BY %[1]s
+        )
+        WHERE key IN (%[3]s)`,
+		statsType, websiteID, placeholders(n), filterCond)
+
+	args := make([]interface{}, 0, n+2+len(filterArgs))
+	args = append(args, startTime.Unix(), endTime.Unix())
+	args = append(args, filterArgs...)
+	for _, key := range current.Key {
+		args = append(args, key)
+	}
+
+	rows, err := s.repo.GetDB().Query(dbQueryStr, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询对比周期排行失败: %v", err)
+	}
+	defer rows.Close()
+
+	index := make(map[string]int, n)
+	for i, key := range current.Key {
+		index[key] = i
+	}
+
+	for rows.Next() {
+		var key string
+		var pv, uv, rank int
+		if err := rows.Scan(&key, &pv, &uv, &rank); err != nil {
+			return nil, fmt.Errorf("解析对比周期排行失败: %v", err)
+		}
+		if i, ok := index[key]; ok {
+			compare.PV[i] = pv
+			compare.UV[i] = uv
+			compare.PrevRank[i] = rank
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历对比周期排行失败: %v", err)
+	}
+
+	for i := range current.Key {
+		compare.PVChange[i] = percentChange(int64(current.PV[i]), int64(compare.PV[i]))
+		compare.UVChange[i] = percentChange(int64(current.UV[i]), int64(compare.UV[i]))
+		if compare.PrevRank[i] > 0 {
+			compare.RankChange[i] = compare.PrevRank[i] - (i + 1)
+		}
+	}
+
+	return compare, nil
+}
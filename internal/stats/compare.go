@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// 对比周期
+const (
+	comparePrevious = "previous" // 紧邻的上一个等长周期
+	compareLastWeek = "lastweek" // 上周同期
+	compareLastYear = "lastyear" // 去年同期
+)
+
+// compareShifter 返回把当前周期 [start, end) 内的时间平移到对比周期的函数。
+// 命名时间范围按日历平移：今天对比昨天，本周和最近 7 天对比前 7 天，最近 30 天对比前 30 天，
+// 本月对比上一个自然月，上个月没有的日期（如 3 月 31 日）平移到上个月末；自定义范围按长度平移
+func compareShifter(compare, timeRange string, start, end time.Time) func(time.Time) time.Time {
+	switch compare {
+	case compareLastWeek:
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -7) }
+	case compareLastYear:
+		return func(t time.Time) time.Time { return t.AddDate(-1, 0, 0) }
+	}
+
+	switch timeRange {
+	case "today", "yesterday":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -1) }
+	case "week", "last7days":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -7) }
+	case "last30days":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -30) }
+	case "month":
+		return func(t time.Time) time.Time {
+			shifted := t.AddDate(0, -1, 0)
+			if shifted.After(start) { // 日期溢出到了本月，截止到上个月末
+				return start
+			}
+			return shifted
+		}
+	default:
+		span := end.Sub(start)
+		return func(t time.Time) time.Time { return t.Add(-span) }
+	}
+}
+
+// periodEnd 返回当前周期不含在内的结束时间。
+// 命名时间范围的结束时间是最后一天的某个时刻，取次日零点；进行中的周期截止到 now，
+// 对比周期也只统计到相同的进度，如今天 10 点对比昨天 0 点到 10 点
+func periodEnd(timeRange string, end, now time.Time) time.Time {
+	if timeRange != customTimeRange {
+		end = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, end.Location())
+	}
+	if end.After(now) {
+		return now
+	}
+	return end
+}
+
+// queryComparePeriod 获取查询的对比周期起止时间，未设置 compare 时 ok 为 false
+func queryComparePeriod(query StatsQuery) (startTime, endTime time.Time, ok bool, err error) {
+	compare, _ := query.ExtraParam["compare"].(string)
+	if compare == "" {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	currentStart, currentEnd, err := queryTimePeriod(query)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	timeRange, _ := query.ExtraParam["timeRange"].(string)
+	currentEnd = periodEnd(timeRange, currentEnd, time.Now())
+	shift := compareShifter(compare, timeRange, currentStart, currentEnd)
+	return shift(currentStart), shift(currentEnd), true, nil
+}
+
+// percentChange 计算相对对比值的变化百分比，保留一位小数；对比值为 0 时返回 nil
+func percentChange(current, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &change
+}
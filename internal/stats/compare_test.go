@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComparePeriod(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name      string
+		compare   string
+		timeRange string
+		start     time.Time
+		end       time.Time // 与 util.TimePeriod 一致的结束时间
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "今天截止到当前时刻对比昨天同一时刻",
+			compare:   comparePrevious,
+			timeRange: "today",
+			start:     at(2024, 3, 15, 0, 0),
+			end:       at(2024, 3, 15, 23, 59).Add(59 * time.Second),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 3, 14, 0, 0),
+			wantEnd:   at(2024, 3, 14, 10, 30),
+		},
+		{
+			name:      "昨天对比前天整天",
+			compare:   comparePrevious,
+			timeRange: "yesterday",
+			start:     at(2024, 3, 14, 0, 0),
+			end:       at(2024, 3, 14, 23, 59).Add(59 * time.Second),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 3, 13, 0, 0),
+			wantEnd:   at(2024, 3, 14, 0, 0),
+		},
+		{
+			name:      "本周对比上周同一进度",
+			compare:   comparePrevious,
+			timeRange: "week",
+			start:     at(2024, 3, 11, 0, 0),
+			end:       at(2024, 3, 17, 23, 0),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 3, 4, 0, 0),
+			wantEnd:   at(2024, 3, 8, 10, 30),
+		},
+		{
+			name:      "本月对比上一个自然月",
+			compare:   comparePrevious,
+			timeRange: "month",
+			start:     at(2024, 3, 1, 0, 0),
+			end:       at(2024, 3, 31, 23, 0),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 2, 1, 0, 0),
+			wantEnd:   at(2024, 2, 15, 10, 30),
+		},
+		{
+			name:      "本月超出上个月天数时截止到上个月末",
+			compare:   comparePrevious,
+			timeRange: "month",
+			start:     at(2024, 3, 1, 0, 0),
+			end:       at(2024, 3, 31, 23, 0),
+			now:       at(2024, 3, 31, 12, 0),
+			wantStart: at(2024, 2, 1, 0, 0),
+			wantEnd:   at(2024, 3, 1, 0, 0),
+		},
+		{
+			name:      "最近 30 天对比前 30 天",
+			compare:   comparePrevious,
+			timeRange: "last30days",
+			start:     at(2024, 2, 15, 0, 0),
+			end:       at(2024, 3, 15, 23, 59).Add(59 * time.Second),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 1, 16, 0, 0),
+			wantEnd:   at(2024, 2, 14, 10, 30),
+		},
+		{
+			name:      "自定义范围按长度平移",
+			compare:   comparePrevious,
+			timeRange: customTimeRange,
+			start:     at(2024, 3, 1, 0, 0),
+			end:       at(2024, 3, 4, 0, 0),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 2, 27, 0, 0),
+			wantEnd:   at(2024, 3, 1, 0, 0),
+		},
+		{
+			name:      "上周同期",
+			compare:   compareLastWeek,
+			timeRange: "today",
+			start:     at(2024, 3, 15, 0, 0),
+			end:       at(2024, 3, 15, 23, 59).Add(59 * time.Second),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2024, 3, 8, 0, 0),
+			wantEnd:   at(2024, 3, 8, 10, 30),
+		},
+		{
+			name:      "去年同期",
+			compare:   compareLastYear,
+			timeRange: "yesterday",
+			start:     at(2024, 3, 14, 0, 0),
+			end:       at(2024, 3, 14, 23, 59).Add(59 * time.Second),
+			now:       at(2024, 3, 15, 10, 30),
+			wantStart: at(2023, 3, 14, 0, 0),
+			wantEnd:   at(2023, 3, 15, 0, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := periodEnd(tt.timeRange, tt.end, tt.now)
+			shift := compareShifter(tt.compare, tt.timeRange, tt.start, end)
+			if got := shift(tt.start); !got.Equal(tt.wantStart) {
+				t.Errorf("对比开始时间 = %v, 期望 %v", got, tt.wantStart)
+			}
+			if got := shift(end); !got.Equal(tt.wantEnd) {
+				t.Errorf("对比结束时间 = %v, 期望 %v", got, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	tests := []struct {
+		current, previous int64
+		want              *float64
+	}{
+		{120, 100, floatPtr(20.0)},
+		{50, 200, floatPtr(-75.0)},
+		{1, 3, floatPtr(-66.7)},
+		{10, 0, nil},
+	}
+
+	for _, tt := range tests {
+		got := percentChange(tt.current, tt.previous)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("percentChange(%d, %d) = %v, 期望 %v", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,187 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterParamPrefix 维度过滤参数的前缀，如 filter.url=/pricing&filter.location=北京
+const filterParamPrefix = "filter."
+
+// filterDimensions 支持的过滤维度
+var filterDimensions = []string{
+	"url", "referer", "browser", "browser_version", "os", "os_version", "device", "brand", "model",
+	"location", "city", "isp", "status", "spider", "suspicious",
+}
+
+// Filters 维度过滤条件，键为维度名，同一查询的多个维度之间为"且"的关系
+type Filters map[string]string
+
+// String 按维度名排序输出，用于缓存键
+func (f Filters) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+f[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// parseFilters 从请求参数中取出维度过滤并校验取值
+func parseFilters(params map[string]string) (Filters, error) {
+	filters := make(Filters)
+	for _, dim := range filterDimensions {
+		value, ok := params[filterParamPrefix+dim]
+		if !ok || value == "" {
+			continue
+		}
+		if _, _, err := filterCondition(dim, value, ""); err != nil {
+			return nil, err
+		}
+		filters[dim] = value
+	}
+	for key := range params {
+		if dim, ok := strings.CutPrefix(key, filterParamPrefix); ok && !isFilterDimension(dim) {
+			return nil, fmt.Errorf("不支持的过滤维度: %s", dim)
+		}
+	}
+	return filters, nil
+}
+
+func isFilterDimension(dim string) bool {
+	for _, d := range filterDimensions {
+		if d == dim {
+			return true
+		}
+	}
+	return false
+}
+
+// queryFilters 获取查询中的维度过滤，没有时返回空过滤
+func queryFilters(query StatsQuery) Filters {
+	filters, _ := query.ExtraParam["filters"].(Filters)
+	return filters
+}
+
+// condition 生成附加在 WHERE 或 JOIN 条件之后的 SQL 片段（以 "AND" 开头）及参数，
+// alias 为日志表的别名，为空时直接使用列名
+func (f Filters) condition(alias string) (string, []any) {
+	if len(f) == 0 {
+		return "", nil
+	}
+
+	dims := make([]string, 0, len(f))
+	for dim := range f {
+		dims = append(dims, dim)
+	}
+	sort.Strings(dims)
+
+	var sb strings.Builder
+	args := make([]any, 0, len(f))
+	for _, dim := range dims {
+		cond, condArgs, err := filterCondition(dim, f[dim], alias)
+		if err != nil {
+			// 取值已在 parseFilters 中校验
+			continue
+		}
+		sb.WriteString(" AND ")
+		sb.WriteString(cond)
+		args = append(args, condArgs...)
+	}
+	return sb.String(), args
+}
+
+// sessionCondition 生成会话的过滤条件：只保留包含符合条件的请求的会话，
+// sessionID 为会话 ID 所在的列
+func (f Filters) sessionCondition(websiteID, sessionID string) (string, []any) {
+	cond, args := f.condition("fl")
+	if cond == "" {
+		return "", nil
+	}
+	return fmt.Sprintf(` AND EXISTS (
+                SELECT 1 FROM "%[1]s_nginx_logs" fl INDEXED BY idx_%[1]s_session_id
+                WHERE fl.session_id = %[2]s %[3]s)`, websiteID, sessionID, cond), args
+}
+
+// filterCondition 生成单个维度的参数化条件：
+//   - url、referer 精确匹配，以 * 结尾时为前缀匹配
+//   - browser_version、os_version 同时匹配主版本和完整版本，如 "Chrome 120"
+//   - location 同时匹配国内和全球地域，city、isp 精确匹配
+//   - status 可以是状态码（404）或类别（4xx）
+//   - spider 为 true/false 或蜘蛛名称，suspicious 为 true/false
+func filterCondition(dim, value, alias string) (string, []any, error) {
+	col := func(name string) string {
+		if alias == "" {
+			return name
+		}
+		return alias + "." + name
+	}
+
+	switch dim {
+	case "url", "referer":
+		if prefix, ok := strings.CutSuffix(value, "*"); ok {
+			return fmt.Sprintf("substr(%[1]s, 1, length(?)) = ?", col(dim)), []any{prefix, prefix}, nil
+		}
+		return col(dim) + " = ?", []any{value}, nil
+
+	case "browser", "os", "device":
+		return col("user_"+dim) + " = ?", []any{value}, nil
+
+	case "browser_version", "os_version":
+		major := strings.TrimSuffix(dim, "_version") + "_major"
+		return fmt.Sprintf("(%s = ? OR %s = ?)", col(major), col(dim)), []any{value, value}, nil
+
+	case "brand", "model":
+		return col("device_"+dim) + " = ?", []any{value}, nil
+
+	case "location":
+		return fmt.Sprintf("(%s = ? OR %s = ?)", col("domestic_location"), col("global_location")),
+			[]any{value, value}, nil
+
+	case "city", "isp":
+		return col(dim) + " = ?", []any{value}, nil
+
+	case "status":
+		if class, ok := strings.CutSuffix(strings.ToLower(value), "xx"); ok {
+			n, err := strconv.Atoi(class)
+			if err != nil || n < 1 || n > 5 {
+				return "", nil, fmt.Errorf("status 过滤无效: %s", value)
+			}
+			return col("status_code") + " BETWEEN ? AND ?", []any{n * 100, n*100 + 99}, nil
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 100 || code > 599 {
+			return "", nil, fmt.Errorf("status 过滤无效: %s", value)
+		}
+		return col("status_code") + " = ?", []any{code}, nil
+
+	case "spider":
+		switch value {
+		case "true":
+			return col("is_spider") + " = 1", nil, nil
+		case "false":
+			return col("is_spider") + " = 0", nil, nil
+		default:
+			return col("spider_name") + " = ?", []any{value}, nil
+		}
+
+	case "suspicious":
+		switch value {
+		case "true":
+			return col("is_suspicious") + " = 1", nil, nil
+		case "false":
+			return col("is_suspicious") + " = 0", nil, nil
+		default:
+			return "", nil, fmt.Errorf("suspicious 过滤只能是 true 或 false")
+		}
+	}
+
+	return "", nil, fmt.Errorf("不支持的过滤维度: %s", dim)
+}
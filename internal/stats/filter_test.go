@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterCondition(t *testing.T) {
+	tests := []struct {
+		dim, value, alias string
+		wantCond          string
+		wantArgs          []any
+	}{
+		{"url", "/pricing", "", "url = ?", []any{"/pricing"}},
+		{"url", "/blog/*", "l", "substr(l.url, 1, length(?)) = ?", []any{"/blog/", "/blog/"}},
+		{"referer", "https://a.com/*", "", "substr(referer, 1, length(?)) = ?", []any{"https://a.com/", "https://a.com/"}},
+		{"browser", "Chrome", "", "user_browser = ?", []any{"Chrome"}},
+		{"device", "手机", "fl", "fl.user_device = ?", []any{"手机"}},
+		{"browser_version", "Chrome 120", "", "(browser_major = ? OR browser_version = ?)", []any{"Chrome 120", "Chrome 120"}},
+		{"os_version", "iOS 17", "", "(os_major = ? OR os_version = ?)", []any{"iOS 17", "iOS 17"}},
+		{"brand", "Apple", "", "device_brand = ?", []any{"Apple"}},
+		{"location", "北京", "", "(domestic_location = ? OR global_location = ?)", []any{"北京", "北京"}},
+		{"city", "杭州", "", "city = ?", []any{"杭州"}},
+		{"status", "404", "", "status_code = ?", []any{404}},
+		{"status", "5XX", "", "status_code BETWEEN ? AND ?", []any{500, 599}},
+		{"spider", "true", "", "is_spider = 1", nil},
+		{"spider", "Google", "", "spider_name = ?", []any{"Google"}},
+		{"suspicious", "false", "", "is_suspicious = 0", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dim+"="+tt.value, func(t *testing.T) {
+			cond, args, err := filterCondition(tt.dim, tt.value, tt.alias)
+			if err != nil {
+				t.Fatalf("filterCondition 出错: %v", err)
+			}
+			if cond != tt.wantCond {
+				t.Errorf("条件 = %q, 期望 %q", cond, tt.wantCond)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("参数 = %#v, 期望 %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterConditionInvalid(t *testing.T) {
+	tests := []struct {
+		dim, value string
+	}{
+		{"status", "abc"},
+		{"status", "600"},
+		{"status", "6xx"},
+		{"suspicious", "maybe"},
+		{"host", "a.com"},
+	}
+
+	for _, tt := range tests {
+		if _, _, err := filterCondition(tt.dim, tt.value, ""); err == nil {
+			t.Errorf("filterCondition(%q, %q) 应返回错误", tt.dim, tt.value)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    Filters
+		wantErr string
+	}{
+		{
+			name:   "忽略空取值和其他参数",
+			params: map[string]string{"filter.url": "/a", "filter.os": "", "timeRange": "today"},
+			want:   Filters{"url": "/a"},
+		},
+		{
+			name:    "不支持的维度",
+			params:  map[string]string{"filter.host": "a.com"},
+			wantErr: "不支持的过滤维度: host",
+		},
+		{
+			name:    "取值无效",
+			params:  map[string]string{"filter.status": "9xx"},
+			wantErr: "status 过滤无效",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := parseFilters(tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseFilters 错误 = %v, 期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilters 出错: %v", err)
+			}
+			if !reflect.DeepEqual(filters, tt.want) {
+				t.Errorf("parseFilters = %v, 期望 %v", filters, tt.want)
+			}
+		})
+	}
+}
+
+func TestFiltersConditionAndString(t *testing.T) {
+	filters := Filters{"url": "/a", "status": "5xx", "browser": "Chrome"}
+
+	// 按维度名排序，参数顺序与条件一致
+	cond, args := filters.condition("l")
+	wantCond := " AND l.user_browser = ? AND l.status_code BETWEEN ? AND ? AND l.url = ?"
+	if cond != wantCond {
+		t.Errorf("条件 = %q, 期望 %q", cond, wantCond)
+	}
+	if wantArgs := []any{"Chrome", 500, 599, "/a"}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("参数 = %#v, 期望 %#v", args, wantArgs)
+	}
+
+	if got, want := filters.String(), "browser=Chrome&status=5xx&url=/a"; got != want {
+		t.Errorf("String() = %q, 期望 %q", got, want)
+	}
+
+	if cond, args := (Filters{}).condition(""); cond != "" || args != nil {
+		t.Errorf("空过滤的条件 = %q, %v, 期望为空", cond, args)
+	}
+}
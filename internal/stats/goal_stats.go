@@ -0,0 +1,188 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// GoalConversion 单个目标在时间范围内的转化
+type GoalConversion struct {
+	ID             int64   `json:"id"`
+	Name           string  `json:"name"`
+	Conversions    int     `json:"conversions"`     // 达成目标的访问次数
+	ConversionRate float64 `json:"conversion_rate"` // 转化次数 / 访问次数（百分比）
+}
+
+// GoalSeries 单个目标按时间区间的转化
+type GoalSeries struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Conversions    []int     `json:"conversions"`
+	ConversionRate []float64 `json:"conversionRate"` // 百分比
+}
+
+// goalConversionsByTimePoints 按区间统计达成目标的访问次数，同一访问多次达成只计一次。
+// 第 i 个区间为 [timePoints[i], timePoints[i+1])，最后一个区间截止到 endTime。
+func goalConversionsByTimePoints(db *sql.DB, websiteID string, goal storage.Goal,
+	timePoints []time.Time, endTime time.Time, filters Filters) ([]int, error) {
+
+	results := make([]int, len(timePoints))
+	if len(timePoints) == 0 {
+		return results, nil
+	}
+
+	args := make([]any, 0, len(timePoints)*2+4)
+	for i := range timePoints {
+		rangeEnd := endTime
+		if i+1 < len(timePoints) {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
+	}
+
+	cond, condArgs := goal.Condition("l")
+	args = append(args, condArgs...)
+	filterCond, filterArgs := filters.condition("l")
+	args = append(args, filterArgs...)
+
+	rows, err := db.Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %s
+        )
+        SELECT tr.range_index, COUNT(DISTINCT l.session_id)
+        FROM time_ranges tr
+        LEFT JOIN "%s_nginx_logs" l INDEXED BY idx_%[2]s_timestamp
+            ON l.timestamp >= tr.start_time AND l.timestamp < tr.end_time
+            AND l.session_id > 0 AND %s %s
+        GROUP BY tr.range_index
+        ORDER BY tr.range_index`,
+		formatRangeValues(len(timePoints)), websiteID, cond, filterCond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询目标 %s 的转化失败: %v", goal.Name, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rangeIdx, conversions int
+		if err := rows.Scan(&rangeIdx, &conversions); err != nil {
+			return nil, fmt.Errorf("解析目标转化失败: %v", err)
+		}
+		results[rangeIdx] = conversions
+	}
+
+	return results, rows.Err()
+}
+
+// FunnelStats 漏斗各步骤的访问次数和流失
+type FunnelStats struct {
+	ID    int64        `json:"id"`
+	Name  string       `json:"name"`
+	Steps []FunnelStep `json:"steps"`
+}
+
+// FunnelStep 漏斗中的一步
+type FunnelStep struct {
+	GoalID         int64   `json:"goal_id"`
+	Name           string  `json:"name"`
+	Visits         int     `json:"visits"`          // 按顺序到达该步的访问次数
+	ConversionRate float64 `json:"conversion_rate"` // 相对第一步的比例（百分比）
+	DropOff        int     `json:"drop_off"`        // 相对上一步流失的访问次数
+	DropOffRate    float64 `json:"drop_off_rate"`   // 相对上一步的流失比例（百分比）
+}
+
+func (s FunnelStats) GetType() string {
+	return "funnel"
+}
+
+type FunnelStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewFunnelStatsManager(userRepoPtr *storage.Repository) *FunnelStatsManager {
+	return &FunnelStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *FunnelStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := FunnelStats{Steps: make([]FunnelStep, 0)}
+
+	funnelID, _ := query.ExtraParam["funnel"].(int)
+	funnel, goals, err := s.repo.GetFunnel(query.WebsiteID, int64(funnelID))
+	if err != nil {
+		return result, err
+	}
+	result.ID = funnel.ID
+	result.Name = funnel.Name
+
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	// 第一步在时间范围内达成，之后每一步须在同一访问内、且不早于上一步达成；
+	// 有维度过滤时只统计包含符合条件的请求的访问
+	sessionCond, sessionArgs := queryFilters(query).sessionCondition(query.WebsiteID, "l.session_id")
+	ctes := make([]string, 0, len(goals))
+	selects := make([]string, 0, len(goals))
+	args := make([]interface{}, 0)
+	for i, goal := range goals {
+		cond, condArgs := goal.Condition("l")
+		if i == 0 {
+			ctes = append(ctes, fmt.Sprintf(`
+            step0 AS (
+                SELECT l.session_id, MIN(l.timestamp) AS ts
+                FROM "%s_nginx_logs" l INDEXED BY idx_%[1]s_timestamp
+                WHERE l.timestamp >= ? AND l.timestamp < ? AND l.session_id > 0 AND %s%s
+                GROUP BY l.session_id
+            )`, query.WebsiteID, cond, sessionCond))
+			args = append(args, startTime.Unix(), endTime.Unix())
+			args = append(args, condArgs...)
+			args = append(args, sessionArgs...)
+		} else {
+			ctes = append(ctes, fmt.Sprintf(`
+            step%d AS (
+                SELECT l.session_id, MIN(l.timestamp) AS ts
+                FROM "%s_nginx_logs" l INDEXED BY idx_%[2]s_session_id
+                JOIN step%d p ON l.session_id = p.session_id AND l.timestamp >= p.ts
+                WHERE %s
+                GROUP BY l.session_id
+            )`, i, query.WebsiteID, i-1, cond))
+			args = append(args, condArgs...)
+		}
+		selects = append(selects, fmt.Sprintf("(SELECT COUNT(*) FROM step%d)", i))
+	}
+
+	counts := make([]int, len(goals))
+	dest := make([]interface{}, len(goals))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+
+	funnelQuery := fmt.Sprintf("WITH %s\nSELECT %s",
+		strings.Join(ctes, ","), strings.Join(selects, ", "))
+	if err := s.repo.GetDB().QueryRow(funnelQuery, args...).Scan(dest...); err != nil {
+		return result, fmt.Errorf("查询漏斗 %s 失败: %v", funnel.Name, err)
+	}
+
+	for i, goal := range goals {
+		step := FunnelStep{
+			GoalID:         goal.ID,
+			Name:           goal.Name,
+			Visits:         counts[i],
+			ConversionRate: ratePercent(counts[i], counts[0]),
+		}
+		if i > 0 {
+			step.DropOff = counts[i-1] - counts[i]
+			step.DropOffRate = ratePercent(step.DropOff, counts[i-1])
+		}
+		result.Steps = append(result.Steps, step)
+	}
+
+	return result, nil
+}
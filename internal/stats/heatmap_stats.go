@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// maxHeatmapHours 热力图最多覆盖的小时数，每个小时占用两个 SQL 参数
+const maxHeatmapHours = 24 * 400
+
+// heatmapDays 热力图的行，从周一开始
+var heatmapDays = []string{"周一", "周二", "周三", "周四", "周五", "周六", "周日"}
+
+// HeatmapStats 按星期和小时汇总的 7x24 矩阵，第 i 行为 heatmapDays[i]，第 j 列为 j 点。
+// metric 为 pageviews 时填充 PV/UV，为 requests 时填充 Requests/Errors。
+type HeatmapStats struct {
+	Timezone string   `json:"timezone"` // 划分星期和小时使用的时区
+	Metric   string   `json:"metric"`
+	Days     []string `json:"days"`
+	Hours    [][]int  `json:"hours"` // 每个格子在时间范围内包含的小时数，用于计算平均值
+	PV       [][]int  `json:"pv,omitempty"`
+	UV       [][]int  `json:"uv,omitempty"` // 格子内的独立访客数，不是各小时之和
+	Requests [][]int  `json:"requests,omitempty"`
+	Errors   [][]int  `json:"errors,omitempty"` // 4xx 和 5xx 请求数
+}
+
+func (s HeatmapStats) GetType() string {
+	return "heatmap"
+}
+
+type HeatmapStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewHeatmapStatsManager(userRepoPtr *storage.Repository) *HeatmapStatsManager {
+	return &HeatmapStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *HeatmapStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	metric := query.ExtraParam["metric"].(string)
+	loc := util.GetWebsiteLocation(query.WebsiteID)
+	result := HeatmapStats{
+		Timezone: loc.String(),
+		Metric:   metric,
+		Days:     heatmapDays,
+		Hours:    newHeatmapMatrix(),
+	}
+
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	// 把时间范围切成整点小时（按站点时区对齐），同一星期和小时的区间归入同一个格子
+	args := make([]any, 0)
+	cells := make([]string, 0)
+	hour := startTime.In(loc)
+	hour = time.Date(hour.Year(), hour.Month(), hour.Day(), hour.Hour(), 0, 0, 0, loc)
+	for ; hour.Before(endTime); hour = hour.Add(time.Hour) {
+		if len(cells) >= maxHeatmapHours {
+			return result, fmt.Errorf("热力图的时间范围不能超过 %d 天", maxHeatmapHours/24)
+		}
+		day := (int(hour.Weekday()) + 6) % 7
+		cell := day*24 + hour.Hour()
+
+		cells = append(cells, fmt.Sprintf("(%d, ?, ?)", cell))
+		rangeStart, rangeEnd := hour, hour.Add(time.Hour)
+		if rangeStart.Before(startTime) {
+			rangeStart = startTime
+		}
+		if rangeEnd.After(endTime) {
+			rangeEnd = endTime
+		}
+		args = append(args, rangeStart.Unix(), rangeEnd.Unix())
+		result.Hours[day][hour.Hour()]++
+	}
+	if len(cells) == 0 {
+		return result, nil
+	}
+
+	filterCond, filterArgs := queryFilters(query).condition("l")
+	args = append(args, filterArgs...)
+
+	var selectCols, joinCond, index string
+	if metric == "requests" {
+		result.Requests, result.Errors = newHeatmapMatrix(), newHeatmapMatrix()
+		selectCols = "COUNT(*), COALESCE(SUM(l.status_code >= 400), 0)"
+		joinCond = "l.timestamp >= tr.start_time AND l.timestamp < tr.end_time"
+		if excludeSpiders, _ := query.ExtraParam["excludeSpiders"].(bool); excludeSpiders {
+			joinCond += " AND l.is_spider = 0"
+		}
+		index = "idx_%s_timestamp"
+	} else {
+		result.PV, result.UV = newHeatmapMatrix(), newHeatmapMatrix()
+		selectCols = "COUNT(*), COUNT(DISTINCT l.ip)"
+		joinCond = "l.pageview_flag = 1 AND l.timestamp >= tr.start_time AND l.timestamp < tr.end_time"
+		index = "idx_%s_pv_ts_ip"
+	}
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH time_ranges(cell_index, start_time, end_time) AS (
+            VALUES %s
+        )
+        SELECT tr.cell_index, %s
+        FROM time_ranges tr
+        JOIN "%s_nginx_logs" l INDEXED BY %s
+            ON %s %s
+        GROUP BY tr.cell_index`,
+		strings.Join(cells, ", "), selectCols, query.WebsiteID,
+		fmt.Sprintf(index, query.WebsiteID), joinCond, filterCond), args...)
+	if err != nil {
+		return result, fmt.Errorf("查询热力图失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cell, first, second int
+		if err := rows.Scan(&cell, &first, &second); err != nil {
+			return result, fmt.Errorf("解析热力图失败: %v", err)
+		}
+		day, h := cell/24, cell%24
+		if metric == "requests" {
+			result.Requests[day][h], result.Errors[day][h] = first, second
+		} else {
+			result.PV[day][h], result.UV[day][h] = first, second
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// newHeatmapMatrix 创建 7x24 的全零矩阵
+func newHeatmapMatrix() [][]int {
+	matrix := make([][]int, len(heatmapDays))
+	for i := range matrix {
+		matrix[i] = make([]int, 24)
+	}
+	return matrix
+}
@@ -0,0 +1,431 @@
+package stats
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 日志查询语言，用于日志查询接口的 filter 参数。条件以空格分隔，需同时满足：
+//   - field:value 按字段过滤，如 status:>=500 spider:Googlebot url:/api/* ip:1.2.3.0/24
+//   - 条件前加 "-" 表示取反，如 -method:HEAD
+//   - 不带字段的词为关键字，在 URL、来源、User-Agent、IP 和国内地域中模糊匹配，
+//     至少 3 个字符时使用全文索引，更短的关键字逐行匹配且不搜索 User-Agent
+//   - 取值包含空格或冒号时用双引号括起，如 referer:"a b"，引号内用 \" 和 \\ 转义
+//
+// 查询解析为参数化的 SQL 条件，取值不会拼接到 SQL 中
+
+// maxLogQueryTerms 单个查询最多的条件数
+const maxLogQueryTerms = 32
+
+// QueryError 日志查询的语法错误，Pos 为出错位置（从 0 开始的字符序号）
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("查询语法错误（第 %d 个字符）: %s", e.Pos+1, e.Msg)
+}
+
+// logTerm 查询中的一个条件
+type logTerm struct {
+	field    string // 为空时为关键字
+	op       string // 比较运算符 >= <= > <，只用于数值字段
+	value    string
+	negate   bool
+	pos      int // 条件的起始位置
+	opPos    int // 运算符的起始位置
+	valuePos int // 取值的起始位置
+}
+
+// logQueryField 将一个字段条件转换为 SQL 条件及参数，searchTable 为站点的全文索引表（没有时为空）。
+// 返回的错误不是 *QueryError 时定位到取值的起始位置
+type logQueryField func(t logTerm, searchTable string) (string, []any, error)
+
+// logQueryFields 查询语言支持的字段
+var logQueryFields = map[string]logQueryField{
+	"status":          numberField("status_code", parseStatusCode, true),
+	"bytes":           numberField("bytes_sent", parseByteSize, false),
+	"method":          methodField,
+	"url":             patternField("url"),
+	"referer":         patternField("referer"),
+	"ip":              ipField,
+	"spider":          flagField("is_spider", "spider_name", "spider_type"),
+	"suspicious":      flagField("is_suspicious", "suspicious_type"),
+	"pv":              boolField("pv", "pageview_flag"),
+	"browser":         dimensionField("browser"),
+	"browser_version": dimensionField("browser_version"),
+	"os":              dimensionField("os"),
+	"os_version":      dimensionField("os_version"),
+	"device":          dimensionField("device"),
+	"brand":           dimensionField("brand"),
+	"model":           dimensionField("model"),
+	"location":        dimensionField("location"),
+	"city":            dimensionField("city"),
+	"isp":             dimensionField("isp"),
+}
+
+// ValidateLogQuery 检查日志查询的语法，错误为 *QueryError
+func ValidateLogQuery(q string) error {
+	_, _, err := logQueryCondition(q, "")
+	return err
+}
+
+// logQueryCondition 将日志查询转换为以 AND 连接的 SQL 条件（不含 WHERE）及参数，查询为空时条件为空。
+// searchTable 为站点的全文索引表，为空时关键字使用 LIKE 匹配
+func logQueryCondition(q, searchTable string) (string, []any, error) {
+	terms, err := parseLogQuery(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conds := make([]string, 0, len(terms))
+	var args []any
+	for _, t := range terms {
+		var cond string
+		var condArgs []any
+		if t.field == "" {
+			cond, condArgs = keywordCondition(t.value, searchTable)
+		} else {
+			compile := logQueryFields[t.field]
+			if t.op != "" && !numberFields[t.field] {
+				return "", nil, &QueryError{Pos: t.opPos, Msg: fmt.Sprintf("字段 %s 不支持比较运算符 %s", t.field, t.op)}
+			}
+			cond, condArgs, err = compile(t, searchTable)
+			if qe, ok := err.(*QueryError); ok {
+				return "", nil, qe
+			} else if err != nil {
+				return "", nil, &QueryError{Pos: t.valuePos, Msg: err.Error()}
+			}
+		}
+		if t.negate {
+			cond = "NOT (" + cond + ")"
+		}
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// numberFields 支持比较运算符的字段
+var numberFields = map[string]bool{"status": true, "bytes": true}
+
+// parseLogQuery 将查询拆分为条件
+func parseLogQuery(q string) ([]logTerm, error) {
+	src := []rune(q)
+	n := len(src)
+	var terms []logTerm
+
+	for i := 0; ; {
+		for i < n && unicode.IsSpace(src[i]) {
+			i++
+		}
+		if i >= n {
+			return terms, nil
+		}
+		if len(terms) == maxLogQueryTerms {
+			return nil, &QueryError{Pos: i, Msg: fmt.Sprintf("条件过多，最多 %d 个", maxLogQueryTerms)}
+		}
+
+		t := logTerm{pos: i}
+		if src[i] == '-' {
+			t.negate = true
+			i++
+			if i >= n || unicode.IsSpace(src[i]) {
+				return nil, &QueryError{Pos: t.pos, Msg: `"-" 之后缺少条件`}
+			}
+		}
+
+		// 字段名：字母、数字和下划线，紧跟冒号
+		if src[i] != '"' {
+			j := i
+			for j < n && (src[j] == '_' || unicode.IsLetter(src[j]) || unicode.IsDigit(src[j])) {
+				j++
+			}
+			if j > i && j < n && src[j] == ':' {
+				field := strings.ToLower(string(src[i:j]))
+				if _, ok := logQueryFields[field]; !ok {
+					return nil, &QueryError{Pos: i, Msg: fmt.Sprintf(
+						"未知的字段 %q，可用的字段: %s；关键字包含冒号时请用双引号括起",
+						string(src[i:j]), strings.Join(logQueryFieldNames(), ", "))}
+				}
+				t.field = field
+				i = j + 1
+			}
+		}
+
+		t.valuePos = i
+		if i < n && src[i] == '"' {
+			value, end, err := parseQuoted(src, i)
+			if err != nil {
+				return nil, err
+			}
+			t.value = value
+			i = end
+		} else {
+			if t.field != "" {
+				for _, op := range []string{">=", "<=", ">", "<"} {
+					if strings.HasPrefix(string(src[i:min(i+2, n)]), op) {
+						t.op, t.opPos = op, i
+						i += len(op)
+						t.valuePos = i
+						break
+					}
+				}
+			}
+			j := i
+			for j < n && !unicode.IsSpace(src[j]) {
+				j++
+			}
+			t.value = string(src[i:j])
+			i = j
+		}
+
+		if t.value == "" {
+			if t.field == "" {
+				return nil, &QueryError{Pos: t.valuePos, Msg: "关键字不能为空"}
+			}
+			return nil, &QueryError{Pos: t.valuePos, Msg: fmt.Sprintf("字段 %s 缺少取值", t.field)}
+		}
+		terms = append(terms, t)
+	}
+}
+
+// parseQuoted 解析从 start 开始的双引号字符串，返回内容和结束引号之后的位置
+func parseQuoted(src []rune, start int) (string, int, error) {
+	var sb strings.Builder
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			if i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\\') {
+				i++
+			}
+			sb.WriteRune(src[i])
+		case '"':
+			if i+1 < len(src) && !unicode.IsSpace(src[i+1]) {
+				return "", 0, &QueryError{Pos: i + 1, Msg: "结束引号之后需要空格"}
+			}
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(src[i])
+		}
+	}
+	return "", 0, &QueryError{Pos: start, Msg: "引号没有闭合"}
+}
+
+func logQueryFieldNames() []string {
+	names := make([]string, 0, len(logQueryFields))
+	for name := range logQueryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ftsMinChars trigram 全文索引能够搜索的最短子串
+const ftsMinChars = 3
+
+// keywordCondition 关键字模糊匹配。有全文索引且关键字足够长时在索引中查找，
+// 否则在 URL、IP、来源和国内地域中逐行 LIKE 匹配
+func keywordCondition(keyword, searchTable string) (string, []any) {
+	if searchTable != "" && utf8.RuneCountInString(keyword) >= ftsMinChars {
+		return searchCondition(searchTable), []any{ftsPhrase(keyword)}
+	}
+	arg := "%" + escapeLike(keyword) + "%"
+	return `(url LIKE ? ESCAPE '\' OR ip LIKE ? ESCAPE '\' OR referer LIKE ? ESCAPE '\' OR domestic_location LIKE ? ESCAPE '\')`,
+		[]any{arg, arg, arg, arg}
+}
+
+// searchCondition 日志 id 在全文索引中匹配的条件，参数为 FTS5 查询
+func searchCondition(searchTable string) string {
+	return fmt.Sprintf(`id IN (SELECT rowid FROM "%[1]s" WHERE "%[1]s" MATCH ?)`, searchTable)
+}
+
+// ftsPhrase 将文本转换为 FTS5 短语，trigram 分词下短语按子串匹配（不区分大小写）
+func ftsPhrase(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// escapeLike 转义 LIKE 中的通配符，配合 ESCAPE '\' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// globPattern 将只含 * 通配符的取值转换为 GLOB 模式，? 和 [ 按字面匹配
+func globPattern(s string) string {
+	return strings.NewReplacer("[", "[[]", "?", "[?]").Replace(s)
+}
+
+// numberField 数值字段：支持比较运算符（>=500）、范围（200..299）和精确值，
+// classes 为 true 时还支持状态码类别（5xx）
+func numberField(col string, parse func(string) (int64, error), classes bool) logQueryField {
+	return func(t logTerm, _ string) (string, []any, error) {
+		if t.op != "" {
+			v, err := parse(t.value)
+			if err != nil {
+				return "", nil, err
+			}
+			return fmt.Sprintf("%s %s ?", col, t.op), []any{v}, nil
+		}
+		if classes {
+			if class, ok := strings.CutSuffix(strings.ToLower(t.value), "xx"); ok {
+				n, err := strconv.Atoi(class)
+				if err != nil || n < 1 || n > 5 {
+					return "", nil, fmt.Errorf("状态码类别无效: %s，应为 1xx-5xx", t.value)
+				}
+				return col + " BETWEEN ? AND ?", []any{n * 100, n*100 + 99}, nil
+			}
+		}
+		if lo, hi, ok := strings.Cut(t.value, ".."); ok {
+			from, err := parse(lo)
+			if err != nil {
+				return "", nil, err
+			}
+			to, err := parse(hi)
+			if err != nil {
+				// 定位到范围的上限
+				return "", nil, &QueryError{Pos: t.valuePos + utf8.RuneCountInString(lo) + 2, Msg: err.Error()}
+			}
+			if from > to {
+				return "", nil, fmt.Errorf("范围 %s 的下限大于上限", t.value)
+			}
+			return col + " BETWEEN ? AND ?", []any{from, to}, nil
+		}
+		v, err := parse(t.value)
+		if err != nil {
+			return "", nil, err
+		}
+		return col + " = ?", []any{v}, nil
+	}
+}
+
+func parseStatusCode(s string) (int64, error) {
+	code, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("状态码无效: %s，应为 100-599 之间的数字", s)
+	}
+	return code, nil
+}
+
+// parseByteSize 解析字节数，可带 k、m、g 单位（按 1024 换算）
+func parseByteSize(s string) (int64, error) {
+	number, unit := s, int64(1)
+	if len(s) > 1 {
+		switch strings.ToLower(s[len(s)-1:]) {
+		case "k":
+			unit = 1 << 10
+		case "m":
+			unit = 1 << 20
+		case "g":
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			number = s[:len(s)-1]
+		}
+	}
+	v, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || v < 0 || v > (1<<62)/unit {
+		return 0, fmt.Errorf("字节数无效: %s，应为非负整数，可带 k、m、g 单位", s)
+	}
+	return v * unit, nil
+}
+
+// methodField 请求方法精确匹配，不区分大小写
+func methodField(t logTerm, _ string) (string, []any, error) {
+	return "method = ?", []any{strings.ToUpper(t.value)}, nil
+}
+
+// patternField 精确匹配，包含 * 时为通配符匹配，如 /api/*。
+// 以 * 开头的模式无法使用索引，其中有足够长的字面片段时先用全文索引缩小范围
+func patternField(col string) logQueryField {
+	return func(t logTerm, searchTable string) (string, []any, error) {
+		if !strings.Contains(t.value, "*") {
+			return col + " = ?", []any{t.value}, nil
+		}
+
+		cond, args := col+" GLOB ?", []any{globPattern(t.value)}
+		if searchTable != "" && strings.HasPrefix(t.value, "*") {
+			longest := ""
+			for _, part := range strings.Split(t.value, "*") {
+				if utf8.RuneCountInString(part) > utf8.RuneCountInString(longest) {
+					longest = part
+				}
+			}
+			if utf8.RuneCountInString(longest) >= ftsMinChars {
+				cond += " AND " + searchCondition(searchTable)
+				args = append(args, col+" : "+ftsPhrase(longest))
+			}
+		}
+		return cond, args, nil
+	}
+}
+
+// ipField 精确匹配、通配符匹配（1.2.3.*）或 CIDR 网段（1.2.3.0/24）。
+// 按整字节划分的 IPv4 网段转换为前缀匹配以便使用索引，其他网段调用 ip_in_cidr 逐行判断
+func ipField(t logTerm, _ string) (string, []any, error) {
+	if strings.Contains(t.value, "/") {
+		prefix, err := netip.ParsePrefix(t.value)
+		if err != nil {
+			return "", nil, fmt.Errorf("IP 网段无效: %s，应为 CIDR 格式，如 1.2.3.0/24", t.value)
+		}
+		prefix = prefix.Masked()
+		bits := prefix.Bits()
+		if prefix.Addr().Is4() && bits%8 == 0 && bits > 0 {
+			octets := strings.Split(prefix.Addr().String(), ".")[:bits/8]
+			if bits == 32 {
+				return "ip = ?", []any{strings.Join(octets, ".")}, nil
+			}
+			return "ip GLOB ?", []any{strings.Join(octets, ".") + ".*"}, nil
+		}
+		return "ip_in_cidr(ip, ?)", []any{prefix.String()}, nil
+	}
+	if strings.Contains(t.value, "*") {
+		return "ip GLOB ?", []any{globPattern(t.value)}, nil
+	}
+	return "ip = ?", []any{t.value}, nil
+}
+
+// flagField true/false 匹配标记列，其他取值匹配任一名称列（不区分大小写），
+// 如蜘蛛可以按名称（Google）或类型（Googlebot）查询
+func flagField(flagCol string, nameCols ...string) logQueryField {
+	return func(t logTerm, _ string) (string, []any, error) {
+		switch strings.ToLower(t.value) {
+		case "true":
+			return flagCol + " = 1", nil, nil
+		case "false":
+			return flagCol + " = 0", nil, nil
+		}
+		conds := make([]string, len(nameCols))
+		args := make([]any, len(nameCols))
+		for i, col := range nameCols {
+			conds[i] = col + " = ? COLLATE NOCASE"
+			args[i] = t.value
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", args, nil
+	}
+}
+
+// boolField 只接受 true/false 的标记列
+func boolField(name, col string) logQueryField {
+	return func(t logTerm, _ string) (string, []any, error) {
+		switch strings.ToLower(t.value) {
+		case "true":
+			return col + " = 1", nil, nil
+		case "false":
+			return col + " = 0", nil, nil
+		}
+		return "", nil, fmt.Errorf("%s 只能是 true 或 false", name)
+	}
+}
+
+// dimensionField 与维度过滤参数相同的精确匹配
+func dimensionField(dim string) logQueryField {
+	return func(t logTerm, _ string) (string, []any, error) {
+		return filterCondition(dim, t.value, "")
+	}
+}
@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogQueryCondition(t *testing.T) {
+	const fts = "a_logs_fts"
+
+	tests := []struct {
+		name     string
+		query    string
+		table    string
+		wantCond string
+		wantArgs []any
+	}{
+		{
+			name:  "空查询",
+			query: "   ",
+		},
+		{
+			name:     "状态码比较",
+			query:    "status:>=500",
+			wantCond: "status_code >= ?",
+			wantArgs: []any{int64(500)},
+		},
+		{
+			name:     "状态码类别",
+			query:    "status:4xx",
+			wantCond: "status_code BETWEEN ? AND ?",
+			wantArgs: []any{400, 499},
+		},
+		{
+			name:     "字节数范围带单位",
+			query:    "bytes:1k..2m",
+			wantCond: "bytes_sent BETWEEN ? AND ?",
+			wantArgs: []any{int64(1024), int64(2 << 20)},
+		},
+		{
+			name:     "取反和请求方法",
+			query:    "-method:head",
+			wantCond: "NOT (method = ?)",
+			wantArgs: []any{"HEAD"},
+		},
+		{
+			name:     "URL 通配符转义 ? 和 [",
+			query:    "url:/api/*?[x]",
+			wantCond: "url GLOB ?",
+			wantArgs: []any{"/api/*[?][[]x]"},
+		},
+		{
+			name:     "以 * 开头的模式用全文索引缩小范围",
+			query:    "url:*/login",
+			table:    fts,
+			wantCond: `url GLOB ? AND id IN (SELECT rowid FROM "a_logs_fts" WHERE "a_logs_fts" MATCH ?)`,
+			wantArgs: []any{"*/login", `url : "/login"`},
+		},
+		{
+			name:     "整字节网段转换为前缀匹配",
+			query:    "ip:10.1.2.3/16",
+			wantCond: "ip GLOB ?",
+			wantArgs: []any{"10.1.*"},
+		},
+		{
+			name:     "其他网段逐行判断",
+			query:    "ip:10.1.2.0/23",
+			wantCond: "ip_in_cidr(ip, ?)",
+			wantArgs: []any{"10.1.2.0/23"},
+		},
+		{
+			name:     "蜘蛛名称或类型",
+			query:    "spider:Googlebot",
+			wantCond: "(spider_name = ? COLLATE NOCASE OR spider_type = ? COLLATE NOCASE)",
+			wantArgs: []any{"Googlebot", "Googlebot"},
+		},
+		{
+			name:     "维度字段与过滤参数一致",
+			query:    "location:北京 pv:true",
+			wantCond: "(domestic_location = ? OR global_location = ?) AND pageview_flag = 1",
+			wantArgs: []any{"北京", "北京"},
+		},
+		{
+			name:     "短关键字逐行匹配并转义通配符",
+			query:    "a%",
+			table:    fts,
+			wantCond: `(url LIKE ? ESCAPE '\' OR ip LIKE ? ESCAPE '\' OR referer LIKE ? ESCAPE '\' OR domestic_location LIKE ? ESCAPE '\')`,
+			wantArgs: []any{`%a\%%`, `%a\%%`, `%a\%%`, `%a\%%`},
+		},
+		{
+			name:     "长关键字使用全文索引",
+			query:    `"say \"hi\""`,
+			table:    fts,
+			wantCond: `id IN (SELECT rowid FROM "a_logs_fts" WHERE "a_logs_fts" MATCH ?)`,
+			wantArgs: []any{`"say ""hi"""`},
+		},
+		{
+			name:     "引号内的冒号和空格",
+			query:    `referer:"http://a b"`,
+			wantCond: "referer = ?",
+			wantArgs: []any{"http://a b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, err := logQueryCondition(tt.query, tt.table)
+			if err != nil {
+				t.Fatalf("logQueryCondition(%q) 出错: %v", tt.query, err)
+			}
+			if cond != tt.wantCond {
+				t.Errorf("条件 = %q\n期望 %q", cond, tt.wantCond)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("参数 = %#v\n期望 %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestLogQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantPos int
+		wantMsg string
+	}{
+		{"foo:bar", 0, "未知的字段"},
+		{"status:200 -", 11, `"-" 之后缺少条件`},
+		{"url:", 4, "缺少取值"},
+		{`""`, 0, "关键字不能为空"},
+		{`referer:"abc`, 8, "引号没有闭合"},
+		{`"abc"def`, 5, "结束引号之后需要空格"},
+		{"status:abc", 7, "状态码无效"},
+		{"status:200..abc", 12, "状态码无效"},
+		{"status:300..200", 7, "下限大于上限"},
+		{"status:9xx", 7, "状态码类别无效"},
+		{"url:>/a", 4, "不支持比较运算符"},
+		{"bytes:>=-1", 8, "字节数无效"},
+		{"pv:maybe", 3, "只能是 true 或 false"},
+		{"ip:1.2.3.4/99", 3, "IP 网段无效"},
+		{"中文 status:x", 10, "状态码无效"},
+		{strings.Repeat("a ", maxLogQueryTerms) + "b", 2 * maxLogQueryTerms, "条件过多"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			err := ValidateLogQuery(tt.query)
+			var qe *QueryError
+			if !errors.As(err, &qe) {
+				t.Fatalf("ValidateLogQuery(%q) = %v, 期望 *QueryError", tt.query, err)
+			}
+			if qe.Pos != tt.wantPos {
+				t.Errorf("位置 = %d, 期望 %d（%s）", qe.Pos, tt.wantPos, qe.Msg)
+			}
+			if !strings.Contains(qe.Msg, tt.wantMsg) {
+				t.Errorf("错误信息 = %q, 期望包含 %q", qe.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package stats
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -25,17 +27,30 @@ type LogEntry struct {
 	DomesticLocation string `json:"domestic_location"`
 	GlobalLocation   string `json:"global_location"`
 	PageviewFlag     bool   `json:"pageview_flag"`
+	IsSpider         bool   `json:"is_spider"`
+	SpiderType       string `json:"spider_type"`
+	SpiderName       string `json:"spider_name"`
+	IsSuspicious     bool   `json:"is_suspicious"`
+	SuspiciousType   string `json:"suspicious_type"`
+	SuspiciousReason string `json:"suspicious_reason"`
 }
 
 // LogsStats 日志查询结果
 type LogsStats struct {
-	Logs       []LogEntry `json:"logs"`
-	Pagination struct {
-		Total    int `json:"total"`
-		Page     int `json:"page"`
-		PageSize int `json:"pageSize"`
-		Pages    int `json:"pages"`
-	} `json:"pagination"`
+	Logs       []LogEntry     `json:"logs"`
+	Pagination LogsPagination `json:"pagination"`
+}
+
+// LogsPagination 日志查询的分页信息。总数最多精确统计到 logsCountLimit 行，
+// 超出时 capped 或 estimated 为 true，翻页应使用 nextCursor 而不是页码
+type LogsPagination struct {
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"pageSize"`
+	Pages      int    `json:"pages"`
+	Capped     bool   `json:"capped"`     // 匹配的日志超过计数上限，total 为下限
+	Estimated  bool   `json:"estimated"`  // 没有过滤条件时按 id 范围估算的 total
+	NextCursor string `json:"nextCursor"` // 下一页的游标，没有下一页时为空
 }
 
 // GetType 实现 StatsResult 接口
@@ -55,96 +70,200 @@ func NewLogsStatsManager(userRepoPtr *storage.Repository) *LogsStatsManager {
 	}
 }
 
-// Query 实现 StatsManager 接口
+// logSortFields 日志可用的排序字段，排序字段直接拼接到 SQL 中，必须在此列表内。
+// 每个字段都有单列索引（索引中隐含 id），按（排序字段, id）排序和分页都可以沿索引进行
+var logSortFields = map[string]bool{
+	"timestamp": true, "ip": true, "url": true, "status_code": true,
+}
+
+const (
+	// logsMaxPageSize 每页最多的行数
+	logsMaxPageSize = 1000
+	// logsMaxOffset 按页码跳转时最多跳过的行数，更深的分页需要使用游标
+	logsMaxOffset = 100000
+	// logsCountLimit 统计日志总数时最多计数的行数
+	logsCountLimit = 10000
+	// logsStreamBatch 导出日志时每批读取的行数
+	logsStreamBatch = 1000
+)
+
+const logColumns = `id, ip, timestamp, method, url, status_code,
+            bytes_sent, referer, user_browser, user_os, user_device,
+            domestic_location, global_location, pageview_flag,
+            is_spider, spider_type, spider_name, is_suspicious, suspicious_type, suspicious_reason`
+
+// Query 实现 StatsManager 接口。带 cursor 参数时从游标之后读取一页，否则按页码跳过前面的行
 func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	result := LogsStats{}
 
-	// 从查询参数中获取分页和排序信息
+	// 从查询参数中获取分页信息
 	page := 1
 	pageSize := 100
-	sortField := "timestamp"
-	sortOrder := "desc"
-	var filter string
 
 	if pageVal, ok := query.ExtraParam["page"].(int); ok && pageVal > 0 {
 		page = pageVal
 	}
 
 	if pageSizeVal, ok := query.ExtraParam["pageSize"].(int); ok && pageSizeVal > 0 {
-		pageSize = pageSizeVal
-		if pageSize > 1000 {
-			pageSize = 1000 // 设置上限以防过大查询
+		pageSize = min(pageSizeVal, logsMaxPageSize)
+	}
+
+	sortField, sortOrder := logsSort(query)
+	whereClause, whereArgs := m.logsWhere(query)
+
+	var after *logCursor
+	offset := (page - 1) * pageSize
+	if value, ok := query.ExtraParam["cursor"].(string); ok && value != "" {
+		cursor, err := decodeLogCursor(value, sortField, sortOrder)
+		if err != nil {
+			return result, err
 		}
+		after, offset = &cursor, 0
+	}
+
+	// 多读一行判断是否还有下一页
+	logs, err := m.queryPage(query.WebsiteID, whereClause, whereArgs, sortField, sortOrder, after, offset, pageSize+1)
+	if err != nil {
+		return result, err
+	}
+	if len(logs) > pageSize {
+		logs = logs[:pageSize]
+		result.Pagination.NextCursor = encodeLogCursor(sortField, sortOrder, logs[len(logs)-1], page+1)
+	}
+
+	if err := m.countLogs(query.WebsiteID, whereClause, whereArgs, &result.Pagination); err != nil {
+		return result, err
+	}
+
+	// 设置返回结果
+	result.Logs = logs
+	result.Pagination.Page = page
+	result.Pagination.PageSize = pageSize
+	result.Pagination.Pages = (result.Pagination.Total + pageSize - 1) / pageSize
+	if result.Pagination.NextCursor != "" && result.Pagination.Pages <= page {
+		// 计数有上限，实际还有更多页
+		result.Pagination.Pages = page + 1
 	}
 
-	if field, ok := query.ExtraParam["sortField"].(string); ok && field != "" {
-		// 验证字段名有效性，防止SQL注入
-		validFields := map[string]bool{
-			"timestamp": true, "ip": true, "url": true,
-			"status_code": true, "bytes_sent": true,
+	return result, nil
+}
+
+// Stream 按查询的过滤和排序依次读取全部匹配的日志（忽略分页参数），fn 返回错误时停止。
+// 每批读取 logsStreamBatch 行，批次之间按（排序字段, id）定位下一批的起点，
+// 不会在导出期间一直占用数据库连接和读事务
+func (m *LogsStatsManager) Stream(query StatsQuery, fn func(LogEntry) error) error {
+	sortField, sortOrder := logsSort(query)
+	whereClause, whereArgs := m.logsWhere(query)
+
+	var after *logCursor
+	for {
+		logs, err := m.queryPage(query.WebsiteID, whereClause, whereArgs, sortField, sortOrder, after, 0, logsStreamBatch)
+		if err != nil {
+			return err
 		}
-		if validFields[field] {
-			sortField = field
+		for _, log := range logs {
+			if err := fn(log); err != nil {
+				return err
+			}
 		}
+		if len(logs) < logsStreamBatch {
+			return nil
+		}
+		last := logs[len(logs)-1]
+		after = &logCursor{Value: logSortValue(last, sortField), ID: last.ID}
 	}
+}
 
-	if order, ok := query.ExtraParam["sortOrder"].(string); ok {
-		if order == "asc" || order == "desc" {
-			sortOrder = order
-		}
+// queryPage 按（排序字段, id）的顺序读取最多 limit 行日志。after 不为空时读取游标之后的行，否则跳过 offset 行。
+// 游标条件拆成"排序字段相等且 id 在后"和"排序字段在后"两部分分别查询再合并，
+// 两部分都能沿索引直接定位，不会因为排序字段取值重复很多（如状态码）而逐行跳过
+func (m *LogsStatsManager) queryPage(websiteID, whereClause string, whereArgs []interface{},
+	sortField, sortOrder string, after *logCursor, offset, limit int) ([]LogEntry, error) {
+
+	table := fmt.Sprintf(`"%s_nginx_logs"`, websiteID)
+	orderBy := fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s", sortField, sortOrder)
+
+	if after == nil {
+		query := fmt.Sprintf("SELECT %s FROM %s%s%s LIMIT ? OFFSET ?", logColumns, table, whereClause, orderBy)
+		args := append(append([]interface{}{}, whereArgs...), limit, offset)
+		return m.queryLogs(query, args...)
 	}
 
-	if filterVal, ok := query.ExtraParam["filter"].(string); ok {
-		filter = filterVal
+	cmp := "<"
+	if sortOrder == "asc" {
+		cmp = ">"
+	}
+	where := " WHERE "
+	if whereClause != "" {
+		where = whereClause + " AND "
 	}
 
-	// 计算分页
-	offset := (page - 1) * pageSize
-	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
-
-	// 构建查询语句
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString(fmt.Sprintf(`
-        SELECT 
-            id, ip, timestamp, method, url, status_code, 
-            bytes_sent, referer, user_browser, user_os, user_device, 
-            domestic_location, global_location, pageview_flag
-        FROM "%s"`, tableName))
-
-	// 添加过滤条件
-	var args []interface{}
-	if filter != "" {
-		queryBuilder.WriteString(" WHERE url LIKE ? OR ip LIKE ? OR referer LIKE ? OR domestic_location LIKE ?")
-		filterArg := "%" + filter + "%"
-		args = append(args, filterArg, filterArg, filterArg, filterArg)
-	}
-
-	// 添加排序
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s %s", sortField, sortOrder))
-
-	// 添加分页
-	queryBuilder.WriteString(" LIMIT ? OFFSET ?")
-	args = append(args, pageSize, offset)
-
-	// 执行查询
-	rows, err := m.repo.GetDB().Query(queryBuilder.String(), args...)
+	query := fmt.Sprintf(`
+        SELECT %[1]s FROM (
+            SELECT * FROM (
+                SELECT %[1]s FROM %[2]s%[3]s%[4]s = ? AND id %[5]s ? ORDER BY id %[6]s LIMIT ?)
+            UNION ALL
+            SELECT * FROM (
+                SELECT %[1]s FROM %[2]s%[3]s%[4]s %[5]s ?%[7]s LIMIT ?)
+        )%[7]s LIMIT ?`, logColumns, table, where, sortField, cmp, sortOrder, orderBy)
+
+	args := append([]interface{}{}, whereArgs...)
+	args = append(args, after.Value, after.ID, limit)
+	args = append(args, whereArgs...)
+	args = append(args, after.Value, limit, limit)
+	return m.queryLogs(query, args...)
+}
+
+// countLogs 统计匹配的日志数，最多数到 logsCountLimit 行，不会因为匹配的日志很多而扫描整张表。
+// 超出上限时，没有过滤条件的按 id 范围估算总数，有过滤条件的返回上限并标记 capped
+func (m *LogsStatsManager) countLogs(websiteID, whereClause string, whereArgs []interface{}, p *LogsPagination) error {
+	table := fmt.Sprintf(`"%s_nginx_logs"`, websiteID)
+
+	var total int
+	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT 1 FROM %s%s LIMIT ?)`, table, whereClause)
+	args := append(append([]interface{}{}, whereArgs...), logsCountLimit+1)
+	if err := m.repo.GetDB().QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return fmt.Errorf("获取日志总数失败: %v", err)
+	}
+	if total <= logsCountLimit {
+		p.Total = total
+		return nil
+	}
+
+	if whereClause != "" {
+		p.Total, p.Capped = logsCountLimit, true
+		return nil
+	}
+
+	// id 自增，删除的旧日志集中在最小的 id 一端，范围之内的空洞很少
+	if err := m.repo.GetDB().QueryRow(
+		fmt.Sprintf(`SELECT COALESCE(MAX(id) - MIN(id) + 1, 0) FROM %s`, table)).Scan(&total); err != nil {
+		return fmt.Errorf("获取日志总数失败: %v", err)
+	}
+	p.Total, p.Estimated = max(total, logsCountLimit), true
+	return nil
+}
+
+// queryLogs 执行日志查询并解析结果，查询的列为 logColumns
+func (m *LogsStatsManager) queryLogs(query string, args ...interface{}) ([]LogEntry, error) {
+	rows, err := m.repo.GetDB().Query(query, args...)
 	if err != nil {
-		return result, fmt.Errorf("查询日志失败: %v", err)
+		return nil, fmt.Errorf("查询日志失败: %v", err)
 	}
 	defer rows.Close()
 
-	// 处理结果
 	logs := make([]LogEntry, 0)
 	for rows.Next() {
 		var log LogEntry
-		var pageviewFlag int
+		var pageviewFlag, isSpider, isSuspicious int
 
 		err := rows.Scan(&log.ID, &log.IP, &log.Timestamp, &log.Method, &log.URL, &log.StatusCode,
 			&log.BytesSent, &log.Referer, &log.UserBrowser, &log.UserOS, &log.UserDevice,
-			&log.DomesticLocation, &log.GlobalLocation, &pageviewFlag)
+			&log.DomesticLocation, &log.GlobalLocation, &pageviewFlag,
+			&isSpider, &log.SpiderType, &log.SpiderName, &isSuspicious, &log.SuspiciousType, &log.SuspiciousReason)
 
 		if err != nil {
-			return result, fmt.Errorf("解析日志行失败: %v", err)
+			return nil, fmt.Errorf("解析日志行失败: %v", err)
 		}
 
 		// 处理时间
@@ -152,33 +271,121 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 
 		// 处理pageview_flag (SQLite中存储为0/1)
 		log.PageviewFlag = pageviewFlag == 1
+		log.IsSpider = isSpider == 1
+		log.IsSuspicious = isSuspicious == 1
 
 		logs = append(logs, log)
 	}
+	return logs, rows.Err()
+}
 
-	// 查询总记录数
-	var countQuery strings.Builder
-	countQuery.WriteString(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, tableName))
+// logsSort 获取查询的排序字段和顺序，无效的取值使用默认的按时间降序
+func logsSort(query StatsQuery) (string, string) {
+	sortField := "timestamp"
+	sortOrder := "desc"
 
-	var countArgs []interface{}
-	if filter != "" {
-		countQuery.WriteString(" WHERE url LIKE ? OR ip LIKE ? OR referer LIKE ? OR domestic_location LIKE ?")
-		filterArg := "%" + filter + "%"
-		countArgs = append(countArgs, filterArg, filterArg, filterArg, filterArg)
+	if field, ok := query.ExtraParam["sortField"].(string); ok && logSortFields[field] {
+		sortField = field
 	}
+	if order, ok := query.ExtraParam["sortOrder"].(string); ok {
+		if order == "asc" || order == "desc" {
+			sortOrder = order
+		}
+	}
+	return sortField, sortOrder
+}
 
-	var total int
-	err = m.repo.GetDB().QueryRow(countQuery.String(), countArgs...).Scan(&total)
+// logsWhere 生成日志查询的 WHERE 子句：时间范围、查询语句与维度过滤同时生效
+func (m *LogsStatsManager) logsWhere(query StatsQuery) (string, []interface{}) {
+	var conds []string
+	var whereArgs []interface{}
+	if startTime, ok := query.ExtraParam["startTime"].(time.Time); ok {
+		conds = append(conds, "timestamp >= ?")
+		whereArgs = append(whereArgs, startTime.Unix())
+	}
+	if endTime, ok := query.ExtraParam["endTime"].(time.Time); ok {
+		conds = append(conds, "timestamp < ?")
+		whereArgs = append(whereArgs, endTime.Unix())
+	}
+	if filter, ok := query.ExtraParam["filter"].(string); ok && filter != "" {
+		// 查询语句已在 BuildQueryFromRequest 中校验
+		searchTable := m.repo.LogSearchTable(query.WebsiteID)
+		if cond, args, err := logQueryCondition(filter, searchTable); err == nil && cond != "" {
+			conds = append(conds, cond)
+			whereArgs = append(whereArgs, args...)
+		}
+	}
+	if dimCond, dimArgs := queryFilters(query).condition(""); dimCond != "" {
+		conds = append(conds, strings.TrimPrefix(dimCond, " AND "))
+		whereArgs = append(whereArgs, dimArgs...)
+	}
+	if len(conds) == 0 {
+		return "", whereArgs
+	}
+	return " WHERE " + strings.Join(conds, " AND "), whereArgs
+}
+
+// logCursor 键集分页的游标：上一页最后一行的排序字段取值和 id，以及游标所指的页码
+type logCursor struct {
+	Field string      `json:"f"`
+	Order string      `json:"o"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+	Page  int         `json:"p,omitempty"` // 旧版本的游标没有页码
+}
+
+// encodeLogCursor 生成指向 log 之后、即第 page 页的游标，游标中记录排序方式，换了排序方式的游标无效
+func encodeLogCursor(sortField, sortOrder string, log LogEntry, page int) string {
+	data, _ := json.Marshal(logCursor{
+		Field: sortField, Order: sortOrder, Value: logSortValue(log, sortField), ID: log.ID, Page: page,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeLogCursor 解析游标并检查与当前的排序方式一致
+func decodeLogCursor(value, sortField, sortOrder string) (logCursor, error) {
+	var cursor logCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
 	if err != nil {
-		return result, fmt.Errorf("获取日志总数失败: %v", err)
+		return cursor, fmt.Errorf("cursor 参数无效")
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return cursor, fmt.Errorf("cursor 参数无效")
+	}
+	if cursor.Field != sortField || cursor.Order != sortOrder {
+		return cursor, fmt.Errorf("cursor 与当前的排序方式不一致，请从第一页重新查询")
 	}
 
-	// 设置返回结果
-	result.Logs = logs
-	result.Pagination.Total = total
-	result.Pagination.Page = page
-	result.Pagination.PageSize = pageSize
-	result.Pagination.Pages = (total + pageSize - 1) / pageSize
+	// 数值字段的取值解析为整数，文本字段必须是字符串
+	switch v := cursor.Value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil || (sortField != "timestamp" && sortField != "status_code") {
+			return cursor, fmt.Errorf("cursor 参数无效")
+		}
+		cursor.Value = n
+	case string:
+		if sortField == "timestamp" || sortField == "status_code" {
+			return cursor, fmt.Errorf("cursor 参数无效")
+		}
+	default:
+		return cursor, fmt.Errorf("cursor 参数无效")
+	}
+	return cursor, nil
+}
 
-	return result, nil
+// logSortValue 获取日志在排序字段上的取值，作为下一批的定位条件
+func logSortValue(log LogEntry, field string) interface{} {
+	switch field {
+	case "ip":
+		return log.IP
+	case "url":
+		return log.URL
+	case "status_code":
+		return log.StatusCode
+	default:
+		return log.Timestamp
+	}
 }
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestLogCursorRoundTrip(t *testing.T) {
+	log := LogEntry{
+		ID:         42,
+		IP:         "10.0.0.1",
+		Timestamp:  1700000000,
+		URL:        "/a?b=中文&c=\"d\"",
+		StatusCode: 404,
+	}
+
+	tests := []struct {
+		field     string
+		order     string
+		wantValue interface{}
+	}{
+		{"timestamp", "desc", int64(1700000000)},
+		{"status_code", "asc", int64(404)},
+		{"ip", "asc", "10.0.0.1"},
+		{"url", "desc", "/a?b=中文&c=\"d\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+" "+tt.order, func(t *testing.T) {
+			value := encodeLogCursor(tt.field, tt.order, log, 2)
+			if strings.ContainsAny(value, "+/=") {
+				t.Errorf("游标 %q 不能直接用于 URL", value)
+			}
+
+			cursor, err := decodeLogCursor(value, tt.field, tt.order)
+			if err != nil {
+				t.Fatalf("decodeLogCursor 出错: %v", err)
+			}
+			if cursor.Value != tt.wantValue || cursor.ID != log.ID || cursor.Page != 2 {
+				t.Errorf("游标 = (%#v, %d, 第 %d 页), 期望 (%#v, %d, 第 2 页)",
+					cursor.Value, cursor.ID, cursor.Page, tt.wantValue, log.ID)
+			}
+		})
+	}
+}
+
+func TestDecodeLogCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	valid := encodeLogCursor("timestamp", "desc", LogEntry{ID: 1, Timestamp: 100}, 2)
+
+	tests := []struct {
+		name    string
+		value   string
+		field   string
+		order   string
+		wantMsg string
+	}{
+		{"不是 base64", "!!!", "timestamp", "desc", "cursor 参数无效"},
+		{"不是 JSON", encode("abc"), "timestamp", "desc", "cursor 参数无效"},
+		{"排序字段不同", valid, "ip", "desc", "排序方式不一致"},
+		{"排序方向不同", valid, "timestamp", "asc", "排序方式不一致"},
+		{"数值字段的取值是字符串", encode(`{"f":"timestamp","o":"desc","v":"100","id":1}`), "timestamp", "desc", "cursor 参数无效"},
+		{"数值字段的取值是小数", encode(`{"f":"status_code","o":"desc","v":1.5,"id":1}`), "status_code", "desc", "cursor 参数无效"},
+		{"文本字段的取值是数字", encode(`{"f":"url","o":"asc","v":1,"id":1}`), "url", "asc", "cursor 参数无效"},
+		{"缺少取值", encode(`{"f":"ip","o":"asc","id":1}`), "ip", "asc", "cursor 参数无效"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeLogCursor(tt.value, tt.field, tt.order)
+			if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("decodeLogCursor 错误 = %v, 期望包含 %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestParseLogPaging(t *testing.T) {
+	page3 := encodeLogCursor("timestamp", "desc", LogEntry{ID: 1, Timestamp: 100}, 3)
+	legacy := base64.RawURLEncoding.EncodeToString([]byte(`{"f":"timestamp","o":"desc","v":100,"id":1}`))
+
+	tests := []struct {
+		name       string
+		params     map[string]string
+		wantPage   int
+		wantCursor bool
+		wantErr    string
+	}{
+		{"按页码翻页", map[string]string{"page": "2"}, 2, false, ""},
+		{"缺少页码", map[string]string{}, 0, false, "缺少必要参数: page"},
+		{"页码无效", map[string]string{"page": "0"}, 0, false, "page 参数无效"},
+		{"页码过大", map[string]string{"page": "100000"}, 0, false, "页码过大"},
+		{"带游标时可以省略页码", map[string]string{"cursor": page3}, 3, true, ""},
+		{"页码与游标一致", map[string]string{"cursor": page3, "page": "3"}, 3, true, ""},
+		{"页码与游标不一致", map[string]string{"cursor": page3, "page": "1"}, 0, false, "page 参数与 cursor 不一致"},
+		{"旧游标使用请求的页码", map[string]string{"cursor": legacy, "page": "5"}, 5, true, ""},
+		{"旧游标省略页码", map[string]string{"cursor": legacy}, 1, true, ""},
+		{"页码过大时仍可以用游标", map[string]string{"cursor": legacy, "page": "100000"}, 100000, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := StatsQuery{ExtraParam: map[string]interface{}{
+				"sortField": "timestamp", "sortOrder": "desc", "pageSize": 100,
+			}}
+			err := parseLogPaging(tt.params, &query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseLogPaging 错误 = %v, 期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLogPaging 出错: %v", err)
+			}
+			if page := query.ExtraParam["page"]; page != tt.wantPage {
+				t.Errorf("page = %v, 期望 %d", page, tt.wantPage)
+			}
+			if _, ok := query.ExtraParam["cursor"]; ok != tt.wantCursor {
+				t.Errorf("是否使用游标 = %v, 期望 %v", ok, tt.wantCursor)
+			}
+		})
+	}
+}
@@ -5,13 +5,38 @@ import (
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/storage"
-	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
 type OverallStats struct {
-	PV      int   `json:"pv"`      // 页面浏览量
-	UV      int   `json:"uv"`      // 独立访客数
-	Traffic int64 `json:"traffic"` // 流量（字节）
+	PV      int                `json:"pv"`                // 页面浏览量
+	UV      int                `json:"uv"`                // 独立访客数
+	Traffic int64              `json:"traffic"`           // 流量（字节）
+	Compare *OverallComparison `json:"compare,omitempty"` // 对比周期的数据
+
+	Visits           int     `json:"visits"`             // 访问次数（会话数）
+	BounceRate       float64 `json:"bounce_rate"`        // 跳出率（百分比）
+	PagesPerVisit    float64 `json:"pages_per_visit"`    // 平均每次访问浏览页数
+	AvgVisitDuration int64   `json:"avg_visit_duration"` // 平均访问时长（秒）
+
+	NewVisitors       int `json:"new_visitors"`       // 首次访问在时间范围内的访客数
+	ReturningVisitors int `json:"returning_visitors"` // 之前访问过的访客数
+
+	Goals []GoalConversion `json:"goals,omitempty"` // 站点定义的各目标的转化
+
+	visitTotals visitSummary // 访问指标的原始计数，合并多个站点时按计数相加
+}
+
+// OverallComparison 对比周期的总体统计及变化百分比
+type OverallComparison struct {
+	Period        string   `json:"period"`
+	StartTime     int64    `json:"start_time"`
+	EndTime       int64    `json:"end_time"`
+	PV            int      `json:"pv"`
+	UV            int      `json:"uv"`
+	Traffic       int64    `json:"traffic"`
+	PVChange      *float64 `json:"pv_change"` // 变化百分比，对比值为 0 时为 null
+	UVChange      *float64 `json:"uv_change"`
+	TrafficChange *float64 `json:"traffic_change"`
 }
 
 // OverallStats 实现 StatsResult 接口
@@ -39,23 +64,65 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		Traffic: 0,
 	}
 
-	timeRange := query.ExtraParam["timeRange"].(string)
-	startTime, endTime, err := util.TimePeriod(timeRange)
+	startTime, endTime, err := queryTimePeriod(query)
 	if err != nil {
 		return result, err
 	}
 
-	err = s.statsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime, &result)
+	filters := queryFilters(query)
+	err = s.statsByTimeRangeForWebsite(query.WebsiteID, startTime, endTime, filters, &result)
 	if err != nil {
 		return result, fmt.Errorf("获取总体统计失败: %v", err)
 	}
 
+	goals, err := s.repo.ListGoals(query.WebsiteID)
+	if err != nil {
+		return result, err
+	}
+	for _, goal := range goals {
+		conversions, err := goalConversionsByTimePoints(
+			s.repo.GetDB(), query.WebsiteID, goal, []time.Time{startTime}, endTime, filters)
+		if err != nil {
+			return result, err
+		}
+		result.Goals = append(result.Goals, GoalConversion{
+			ID:             goal.ID,
+			Name:           goal.Name,
+			Conversions:    conversions[0],
+			ConversionRate: ratePercent(conversions[0], result.Visits),
+		})
+	}
+
+	compareStart, compareEnd, ok, err := queryComparePeriod(query)
+	if err != nil {
+		return result, err
+	}
+	if ok {
+		previous := OverallStats{}
+		err = s.statsByTimeRangeForWebsite(query.WebsiteID, compareStart, compareEnd, filters, &previous)
+		if err != nil {
+			return result, fmt.Errorf("获取对比周期统计失败: %v", err)
+		}
+
+		result.Compare = &OverallComparison{
+			Period:        query.ExtraParam["compare"].(string),
+			StartTime:     compareStart.Unix(),
+			EndTime:       compareEnd.Unix(),
+			PV:            previous.PV,
+			UV:            previous.UV,
+			Traffic:       previous.Traffic,
+			PVChange:      percentChange(int64(result.PV), int64(previous.PV)),
+			UVChange:      percentChange(int64(result.UV), int64(previous.UV)),
+			TrafficChange: percentChange(result.Traffic, previous.Traffic),
+		}
+	}
+
 	return result, nil
 }
 
 // StatsByTimePoints 直接使用 db.Query() 方法查询数据库获取指定时间点的统计数据
 func (s *OverallStatsManager) statsByTimeRangeForWebsite(
-	websiteID string, startTime, endTime time.Time, overall *OverallStats) error {
+	websiteID string, startTime, endTime time.Time, filters Filters, overall *OverallStats) error {
 
 	// 初始化结果
 	overall.PV = 0
@@ -63,6 +130,7 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 	overall.Traffic = 0
 
 	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
+	filterCond, filterArgs := filters.condition("")
 
 	// 为更精确的统计，直接在数据库中进行全范围的唯一IP计数
 	countQuery := fmt.Sprintf(`
@@ -71,15 +139,35 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
             COUNT(DISTINCT ip) as uv,
             COALESCE(SUM(bytes_sent), 0) as traffic
         FROM "%s" INDEXED BY idx_%s_pv_ts_ip
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
-		tableName, websiteID)
+        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ? %s`,
+		tableName, websiteID, filterCond)
 
 	// 执行全范围查询
-	row := s.repo.GetDB().QueryRow(countQuery, startTime.Unix(), endTime.Unix())
+	args := append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)
+	row := s.repo.GetDB().QueryRow(countQuery, args...)
 
 	if err := row.Scan(&overall.PV, &overall.UV, &overall.Traffic); err != nil {
 		return fmt.Errorf("查询总体统计数据失败: %v", err)
 	}
 
+	visits, err := visitSummariesByTimePoints(
+		s.repo.GetDB(), websiteID, []time.Time{startTime}, endTime, filters)
+	if err != nil {
+		return err
+	}
+	overall.visitTotals = visits[0]
+	overall.Visits = visits[0].Visits
+	overall.BounceRate = visits[0].bounceRate()
+	overall.PagesPerVisit = visits[0].pagesPerVisit()
+	overall.AvgVisitDuration = visits[0].avgDuration()
+
+	splits, err := visitorSplitsByTimePoints(
+		s.repo.GetDB(), websiteID, []time.Time{startTime}, endTime, filters)
+	if err != nil {
+		return err
+	}
+	overall.NewVisitors = splits[0].New
+	overall.ReturningVisitors = splits[0].Returning
+
 	return nil
 }
@@ -0,0 +1,282 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// EntryPageStats 入口页排行，按会话开始时间统计
+type EntryPageStats struct {
+	Key        []string  `json:"key"`         // 入口页 URL
+	Entries    []int     `json:"entries"`     // 以该页面开始的访问次数
+	BounceRate []float64 `json:"bounce_rate"` // 以该页面开始的访问中只浏览一页的比例（百分比）
+}
+
+func (s EntryPageStats) GetType() string {
+	return "entry"
+}
+
+// ExitPageStats 退出页排行，按会话结束时间统计
+type ExitPageStats struct {
+	Key      []string  `json:"key"`       // 退出页 URL
+	Exits    []int     `json:"exits"`     // 在该页面结束的访问次数
+	PV       []int     `json:"pv"`        // 该页面的浏览量
+	ExitRate []float64 `json:"exit_rate"` // 退出次数 / 浏览量（百分比）
+}
+
+func (s ExitPageStats) GetType() string {
+	return "exit"
+}
+
+// NextPageStats 某个页面之后访客接着浏览的页面
+type NextPageStats struct {
+	URL     string   `json:"url"`
+	PV      int      `json:"pv"`      // 该页面在会话中的浏览量
+	Exits   int      `json:"exits"`   // 浏览该页面后离开的次数
+	Key     []string `json:"key"`     // 下一个页面
+	Count   []int    `json:"count"`   // 跳转次数
+	Percent []int    `json:"percent"` // 占该页面浏览量的百分比
+}
+
+func (s NextPageStats) GetType() string {
+	return "nextpage"
+}
+
+type PageFlowStatsManager struct {
+	repo     *storage.Repository
+	flowType string // entry / exit / nextpage
+}
+
+func NewEntryPageStatsManager(userRepoPtr *storage.Repository) *PageFlowStatsManager {
+	return &PageFlowStatsManager{
+		repo:     userRepoPtr,
+		flowType: "entry",
+	}
+}
+
+func NewExitPageStatsManager(userRepoPtr *storage.Repository) *PageFlowStatsManager {
+	return &PageFlowStatsManager{
+		repo:     userRepoPtr,
+		flowType: "exit",
+	}
+}
+
+func NewNextPageStatsManager(userRepoPtr *storage.Repository) *PageFlowStatsManager {
+	return &PageFlowStatsManager{
+		repo:     userRepoPtr,
+		flowType: "nextpage",
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *PageFlowStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	switch s.flowType {
+	case "exit":
+		return s.queryExitPages(query)
+	case "nextpage":
+		return s.queryNextPages(query)
+	default:
+		return s.queryEntryPages(query)
+	}
+}
+
+// queryEntryPages 统计时间范围内开始的访问的入口页
+func (s *PageFlowStatsManager) queryEntryPages(query StatsQuery) (StatsResult, error) {
+	result := EntryPageStats{
+		Key:        make([]string, 0),
+		Entries:    make([]int, 0),
+		BounceRate: make([]float64, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	sessionCond, filterArgs := queryFilters(query).sessionCondition(query.WebsiteID, "s.id")
+	args := append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT
+            s.entry_url,
+            COUNT(*) AS entries,
+            SUM(s.pageviews = 1) AS bounces
+        FROM "%s_sessions" s
+        WHERE s.start_time >= ? AND s.start_time < ?%s
+        GROUP BY s.entry_url
+        ORDER BY entries DESC
+        LIMIT ?`, query.WebsiteID, sessionCond),
+		append(args, limit)...)
+	if err != nil {
+		return result, fmt.Errorf("查询入口页统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url string
+		var entries, bounces int
+		if err := rows.Scan(&url, &entries, &bounces); err != nil {
+			return result, fmt.Errorf("解析入口页统计结果失败: %v", err)
+		}
+		result.Key = append(result.Key, url)
+		result.Entries = append(result.Entries, entries)
+		result.BounceRate = append(result.BounceRate, ratePercent(bounces, entries))
+	}
+
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历入口页统计结果失败: %v", err)
+	}
+
+	return result, nil
+}
+
+// queryExitPages 统计时间范围内结束的访问的退出页及退出率
+func (s *PageFlowStatsManager) queryExitPages(query StatsQuery) (StatsResult, error) {
+	result := ExitPageStats{
+		Key:      make([]string, 0),
+		Exits:    make([]int, 0),
+		PV:       make([]int, 0),
+		ExitRate: make([]float64, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	filters := queryFilters(query)
+	sessionCond, sessionArgs := filters.sessionCondition(query.WebsiteID, "s.id")
+	filterCond, filterArgs := filters.condition("l")
+	args := []any{startTime.Unix(), endTime.Unix()}
+	args = append(args, sessionArgs...)
+	args = append(args, limit, startTime.Unix(), endTime.Unix())
+	args = append(args, filterArgs...)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH exits AS (
+            SELECT s.exit_url AS url, COUNT(*) AS exits
+            FROM "%[1]s_sessions" s
+            WHERE s.end_time >= ? AND s.end_time < ?%[2]s
+            GROUP BY s.exit_url
+            ORDER BY exits DESC
+            LIMIT ?
+        )
+        SELECT
+            e.url,
+            e.exits,
+            (SELECT COUNT(*) FROM "%[1]s_nginx_logs" l
+             WHERE l.url = e.url AND l.pageview_flag = 1
+               AND l.timestamp >= ? AND l.timestamp < ? %[3]s) AS pv
+        FROM exits e
+        ORDER BY e.exits DESC`, query.WebsiteID, sessionCond, filterCond),
+		args...)
+	if err != nil {
+		return result, fmt.Errorf("查询退出页统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url string
+		var exits, pv int
+		if err := rows.Scan(&url, &exits, &pv); err != nil {
+			return result, fmt.Errorf("解析退出页统计结果失败: %v", err)
+		}
+		result.Key = append(result.Key, url)
+		result.Exits = append(result.Exits, exits)
+		result.PV = append(result.PV, pv)
+		result.ExitRate = append(result.ExitRate, ratePercent(exits, pv))
+	}
+
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历退出页统计结果失败: %v", err)
+	}
+
+	return result, nil
+}
+
+// queryNextPages 统计同一访问中浏览指定页面后紧接着浏览的页面
+func (s *PageFlowStatsManager) queryNextPages(query StatsQuery) (StatsResult, error) {
+	url, _ := query.ExtraParam["url"].(string)
+	result := NextPageStats{
+		URL:     url,
+		Key:     make([]string, 0),
+		Count:   make([]int, 0),
+		Percent: make([]int, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	// 只取包含该页面（且符合过滤条件）的会话，再在会话内按时间排序找出下一页
+	filterCond, filterArgs := queryFilters(query).condition("")
+	args := []any{startTime.Unix(), endTime.Unix(), url, startTime.Unix(), endTime.Unix()}
+	args = append(args, filterArgs...)
+	args = append(args, url)
+
+	flowQuery := fmt.Sprintf(`
+        WITH flow AS (
+            SELECT
+                url,
+                LEAD(url) OVER (PARTITION BY session_id ORDER BY timestamp, id) AS next_url
+            FROM "%[1]s_nginx_logs"
+            WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
+              AND session_id IN (
+                  SELECT DISTINCT session_id FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_url
+                  WHERE url = ? AND session_id > 0 AND timestamp >= ? AND timestamp < ? %[2]s)
+        )
+        SELECT next_url, COUNT(*) AS cnt
+        FROM flow
+        WHERE url = ?
+        GROUP BY next_url
+        ORDER BY cnt DESC`, query.WebsiteID, filterCond)
+
+	rows, err := s.repo.GetDB().Query(flowQuery, args...)
+	if err != nil {
+		return result, fmt.Errorf("查询页面流向失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nextURL *string
+		var count int
+		if err := rows.Scan(&nextURL, &count); err != nil {
+			return result, fmt.Errorf("解析页面流向结果失败: %v", err)
+		}
+
+		result.PV += count
+		if nextURL == nil {
+			result.Exits = count
+			continue
+		}
+		if len(result.Key) < limit {
+			result.Key = append(result.Key, *nextURL)
+			result.Count = append(result.Count, count)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历页面流向结果失败: %v", err)
+	}
+
+	for _, count := range result.Count {
+		result.Percent = append(result.Percent,
+			int(math.Round(float64(count)/float64(result.PV)*100)))
+	}
+
+	return result, nil
+}
+
+// ratePercent 计算百分比，保留一位小数
+func ratePercent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*1000) / 10
+}
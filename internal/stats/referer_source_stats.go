@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// sourceCategories 来源类别的固定顺序，没有访问的类别也会返回
+var sourceCategories = []string{
+	netparser.SourceDirect,
+	netparser.SourceSearch,
+	netparser.SourceSocial,
+	netparser.SourceOther,
+	netparser.SourceInternal,
+}
+
+// RefererSourceStats 来源分类统计，基于 PV
+type RefererSourceStats struct {
+	Categories    []SourceCategory `json:"categories"`     // 各来源类别的访问量
+	Domains       []SourceDomain   `json:"domains"`        // 外部来源域名，不含直接访问和站内跳转
+	Keywords      []SearchKeyword  `json:"keywords"`       // 搜索关键词
+	InternalHosts []string         `json:"internal_hosts"` // 视为站内跳转的域名
+}
+
+// SourceCategory 一类来源的访问量
+type SourceCategory struct {
+	Category string  `json:"category"`
+	PV       int     `json:"pv"`
+	UV       int     `json:"uv"`
+	Percent  float64 `json:"percent"` // 占 PV 的百分比
+}
+
+// SourceDomain 来源域名及其类别
+type SourceDomain struct {
+	Domain   string `json:"domain"`
+	Name     string `json:"name"` // 命中规则的来源名称，如 "Google"
+	Category string `json:"category"`
+	PV       int    `json:"pv"`
+	UV       int    `json:"uv"`
+}
+
+// SearchKeyword 搜索关键词
+type SearchKeyword struct {
+	Keyword string `json:"keyword"`
+	PV      int    `json:"pv"`
+	UV      int    `json:"uv"`
+}
+
+func (s RefererSourceStats) GetType() string {
+	return "source"
+}
+
+type RefererSourceStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewRefererSourceStatsManager(userRepoPtr *storage.Repository) *RefererSourceStatsManager {
+	return &RefererSourceStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *RefererSourceStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := RefererSourceStats{
+		Categories: make([]SourceCategory, 0),
+		Domains:    make([]SourceDomain, 0),
+		Keywords:   make([]SearchKeyword, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	hosts, err := s.repo.InternalHosts(query.WebsiteID)
+	if err != nil {
+		return result, err
+	}
+	result.InternalHosts = hosts
+	hostList := strings.Join(hosts, ",")
+	filters := queryFilters(query)
+
+	if result.Categories, err = s.queryCategories(
+		query.WebsiteID, hostList, filters, startTime, endTime); err != nil {
+		return result, err
+	}
+	if result.Domains, err = s.queryDomains(
+		query.WebsiteID, hostList, filters, startTime, endTime, limit); err != nil {
+		return result, err
+	}
+	if result.Keywords, err = s.queryKeywords(
+		query.WebsiteID, filters, startTime, endTime, limit); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// queryCategories 按来源类别统计 PV 和 UV
+func (s *RefererSourceStatsManager) queryCategories(websiteID, hostList string, filters Filters,
+	startTime, endTime time.Time) ([]SourceCategory, error) {
+
+	filterCond, filterArgs := filters.condition("")
+	args := append([]any{hostList, startTime.Unix(), endTime.Unix()}, filterArgs...)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT referer_category(referer, ?) AS category, COUNT(*) AS pv, COUNT(DISTINCT ip) AS uv
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_pv_ts_ip
+        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ? %[2]s
+        GROUP BY category`, websiteID, filterCond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询来源类别统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]SourceCategory)
+	total := 0
+	for rows.Next() {
+		var c SourceCategory
+		if err := rows.Scan(&c.Category, &c.PV, &c.UV); err != nil {
+			return nil, fmt.Errorf("解析来源类别统计失败: %v", err)
+		}
+		counts[c.Category] = c
+		total += c.PV
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历来源类别统计失败: %v", err)
+	}
+
+	categories := make([]SourceCategory, 0, len(sourceCategories))
+	for _, name := range sourceCategories {
+		c := counts[name]
+		c.Category = name
+		c.Percent = ratePercent(c.PV, total)
+		categories = append(categories, c)
+		delete(counts, name)
+	}
+	// 自定义规则中的类别按 PV 排在内置类别之后
+	custom := make([]SourceCategory, 0, len(counts))
+	for _, c := range counts {
+		c.Percent = ratePercent(c.PV, total)
+		custom = append(custom, c)
+	}
+	sort.Slice(custom, func(i, j int) bool {
+		if custom[i].PV != custom[j].PV {
+			return custom[i].PV > custom[j].PV
+		}
+		return custom[i].Category < custom[j].Category
+	})
+	return append(categories, custom...), nil
+}
+
+// queryDomains 按规范化的来源域名统计，排除直接访问和站内跳转
+func (s *RefererSourceStatsManager) queryDomains(websiteID, hostList string, filters Filters,
+	startTime, endTime time.Time, limit int) ([]SourceDomain, error) {
+
+	filterCond, filterArgs := filters.condition("")
+	args := append([]any{startTime.Unix(), endTime.Unix(), hostList}, filterArgs...)
+
+	domains := make([]SourceDomain, 0)
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT url_host(referer) AS domain, COUNT(*) AS pv, COUNT(DISTINCT ip) AS uv
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_pv_ts_ip
+        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
+            AND referer_category(referer, ?) NOT IN ('direct', 'internal') %[2]s
+        GROUP BY domain
+        HAVING domain != ''
+        ORDER BY pv DESC, domain
+        LIMIT ?`, websiteID, filterCond),
+		append(args, limit)...)
+	if err != nil {
+		return domains, fmt.Errorf("查询来源域名统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d SourceDomain
+		if err := rows.Scan(&d.Domain, &d.PV, &d.UV); err != nil {
+			return domains, fmt.Errorf("解析来源域名统计失败: %v", err)
+		}
+		source := netparser.ClassifyReferer("http://"+d.Domain+"/", nil)
+		d.Name, d.Category = source.Name, source.Category
+		domains = append(domains, d)
+	}
+
+	return domains, rows.Err()
+}
+
+// queryKeywords 统计搜索引擎来源中的关键词
+func (s *RefererSourceStatsManager) queryKeywords(websiteID string, filters Filters,
+	startTime, endTime time.Time, limit int) ([]SearchKeyword, error) {
+
+	filterCond, filterArgs := filters.condition("")
+	args := append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)
+
+	keywords := make([]SearchKeyword, 0)
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT search_keyword(referer) AS keyword, COUNT(*) AS pv, COUNT(DISTINCT ip) AS uv
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_pv_ts_ip
+        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ? AND referer != '' %[2]s
+        GROUP BY keyword
+        HAVING keyword != ''
+        ORDER BY pv DESC, keyword
+        LIMIT ?`, websiteID, filterCond),
+		append(args, limit)...)
+	if err != nil {
+		return keywords, fmt.Errorf("查询搜索关键词失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k SearchKeyword
+		if err := rows.Scan(&k.Keyword, &k.PV, &k.UV); err != nil {
+			return keywords, fmt.Errorf("解析搜索关键词失败: %v", err)
+		}
+		keywords = append(keywords, k)
+	}
+
+	return keywords, rows.Err()
+}
@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// SitesStats 多站点对比表，每个站点一行，另附合计
+type SitesStats struct {
+	Sites []SiteSummary `json:"sites"`
+	Total SiteSummary   `json:"total"` // UV 为各站点之和
+}
+
+// SiteSummary 单个站点在时间范围内的主要指标
+type SiteSummary struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	PV        int     `json:"pv"`
+	UV        int     `json:"uv"`
+	Traffic   int64   `json:"traffic"`    // 页面流量（字节），与总体统计一致
+	Requests  int     `json:"requests"`   // 全部请求数
+	Errors    int     `json:"errors"`     // 4xx 和 5xx 请求数
+	ErrorRate float64 `json:"error_rate"` // 错误请求占全部请求的百分比
+}
+
+func (s SitesStats) GetType() string {
+	return "sites"
+}
+
+type SitesStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewSitesStatsManager(userRepoPtr *storage.Repository) *SitesStatsManager {
+	return &SitesStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口，id 可以是单个站点或多站点范围
+func (s *SitesStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := SitesStats{
+		Sites: make([]SiteSummary, 0),
+		Total: SiteSummary{Name: "合计"},
+	}
+
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	siteIDs := []string{query.WebsiteID}
+	if util.IsSiteScope(query.WebsiteID) {
+		if siteIDs, err = util.ResolveSiteScope(query.WebsiteID); err != nil {
+			return result, err
+		}
+	}
+
+	filters := queryFilters(query)
+	for _, siteID := range siteIDs {
+		summary, err := s.querySite(siteID, filters, startTime, endTime)
+		if err != nil {
+			return result, err
+		}
+		result.Sites = append(result.Sites, summary)
+
+		result.Total.PV += summary.PV
+		result.Total.UV += summary.UV
+		result.Total.Traffic += summary.Traffic
+		result.Total.Requests += summary.Requests
+		result.Total.Errors += summary.Errors
+	}
+	result.Total.ErrorRate = ratePercent(result.Total.Errors, result.Total.Requests)
+
+	return result, nil
+}
+
+// querySite 统计单个站点的 PV、UV、流量和错误率
+func (s *SitesStatsManager) querySite(siteID string, filters Filters,
+	startTime, endTime time.Time) (SiteSummary, error) {
+	summary := SiteSummary{ID: siteID}
+	if website, ok := util.GetWebsiteByID(siteID); ok {
+		summary.Name = website.Name
+	}
+
+	filterCond, filterArgs := filters.condition("")
+	err := s.repo.GetDB().QueryRow(fmt.Sprintf(`
+        SELECT
+            COALESCE(SUM(pageview_flag = 1), 0),
+            COUNT(DISTINCT CASE WHEN pageview_flag = 1 THEN ip END),
+            COALESCE(SUM(CASE WHEN pageview_flag = 1 THEN bytes_sent ELSE 0 END), 0),
+            COUNT(*),
+            COALESCE(SUM(status_code >= 400), 0)
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE timestamp >= ? AND timestamp < ? %[2]s`, siteID, filterCond),
+		append([]any{startTime.Unix(), endTime.Unix()}, filterArgs...)...).Scan(
+		&summary.PV, &summary.UV, &summary.Traffic, &summary.Requests, &summary.Errors)
+	if err != nil {
+		return summary, fmt.Errorf("查询站点 %s 的汇总失败: %v", siteID, err)
+	}
+	summary.ErrorRate = ratePercent(summary.Errors, summary.Requests)
+
+	return summary, nil
+}
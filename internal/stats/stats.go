@@ -2,6 +2,7 @@ package stats
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,6 +12,27 @@ import (
 	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
+// customTimeRange 自定义时间范围的 timeRange 取值，起止时间存放在 startTime/endTime
+const customTimeRange = "custom"
+
+// compareTypes 支持 compare 参数的统计类型
+var compareTypes = map[string]bool{
+	"overall": true, "timeseries": true,
+	"url": true, "referer": true, "browser": true, "os": true, "device": true, "location": true,
+}
+
+// levelTypes 支持 level 参数的排行及可选值，第一个为默认值
+var levelTypes = map[string][]string{
+	"browser": {"name", "major", "full"},
+	"os":      {"name", "major", "full"},
+	"device":  {"class", "brand", "model"},
+}
+
+// spiderFilterTypes 统计全部请求、支持 excludeSpiders 参数的统计类型
+var spiderFilterTypes = map[string]bool{
+	"status": true, "bandwidth": true, "heatmap": true,
+}
+
 // StatsResult 统计结果的基础接口
 type StatsResult interface {
 	GetType() string
@@ -44,7 +66,7 @@ func NewStatsFactory(repo *storage.Repository) *StatsFactory {
 	factory := &StatsFactory{
 		repo:        repo,
 		managers:    make(map[string]StatsManager),
-		cache:       NewStatsCache(),
+		cache:       NewStatsCache(util.GetStatsCacheSize()),
 		cacheExpiry: expiry,
 	}
 
@@ -70,6 +92,20 @@ func (f *StatsFactory) registerDefaultManagers() {
 
 	f.managers["location"] = NewLocationStatsManager(f.repo)
 
+	f.managers["entry"] = NewEntryPageStatsManager(f.repo)
+	f.managers["exit"] = NewExitPageStatsManager(f.repo)
+	f.managers["nextpage"] = NewNextPageStatsManager(f.repo)
+	f.managers["source"] = NewRefererSourceStatsManager(f.repo)
+
+	f.managers["funnel"] = NewFunnelStatsManager(f.repo)
+	f.managers["retention"] = NewRetentionStatsManager(f.repo)
+
+	f.managers["status"] = NewStatusStatsManager(f.repo)
+	f.managers["bandwidth"] = NewBandwidthStatsManager(f.repo)
+	f.managers["heatmap"] = NewHeatmapStatsManager(f.repo)
+
+	f.managers["sites"] = NewSitesStatsManager(f.repo)
+
 	f.managers["logs"] = NewLogsStatsManager(f.repo)
 }
 
@@ -84,32 +120,53 @@ func (f *StatsFactory) GetManager(managerType string) (StatsManager, bool) {
 
 // QueryStats 通过指定类型的管理器查询统计数据
 func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsResult, error) {
-	// 构建缓存键
-	cacheKey := f.buildCacheKey(managerType, query)
-
-	// 尝试从缓存获取
-	if cachedResult, ok := f.cache.Get(cacheKey, f.cacheExpiry); ok {
-		return cachedResult.(StatsResult), nil
-	}
-
 	// 获取对应的管理器
 	manager, exists := f.GetManager(managerType)
 	if !exists {
 		return nil, fmt.Errorf("未找到统计管理器: %s", managerType)
 	}
 
-	// 执行查询
-	result, err := manager.Query(query)
+	cacheKey := f.buildCacheKey(managerType, query)
+	data, err := f.cache.GetOrLoad(query.WebsiteID, cacheKey, f.cacheExpiry, func() (interface{}, bool, error) {
+		// 执行查询，多站点范围由各站点的结果合并而成
+		var result StatsResult
+		var err error
+		if util.IsSiteScope(query.WebsiteID) && managerType != "sites" {
+			result, err = f.queryAcrossSites(managerType, query)
+		} else {
+			result, err = manager.Query(query)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		// 只缓存非空结果（避免空结果被缓存导致数据更新后不显示）
+		return result, !f.isEmptyResult(result), nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// 只缓存非空结果（避免空结果被缓存导致数据更新后不显示）
-	if !f.isEmptyResult(result) {
-		f.cache.Set(cacheKey, result)
+	return data.(StatsResult), nil
+}
+
+// StreamLogs 依次读取日志查询匹配的全部日志，不经过缓存，用于导出
+func (f *StatsFactory) StreamLogs(query StatsQuery, fn func(LogEntry) error) error {
+	manager, exists := f.GetManager("logs")
+	if !exists {
+		return fmt.Errorf("未找到统计管理器: logs")
 	}
+	return manager.(*LogsStatsManager).Stream(query, fn)
+}
 
-	return result, nil
+// InvalidateWebsite 站点数据变化（新日志入库、恢复归档、清除数据）后删除相关的缓存
+func (f *StatsFactory) InvalidateWebsite(websiteID string) {
+	f.cache.InvalidateWebsite(websiteID)
+}
+
+// CacheMetrics 返回统计缓存的命中情况
+func (f *StatsFactory) CacheMetrics() CacheMetrics {
+	return f.cache.Metrics()
 }
 
 // isEmptyResult 检查结果是否为空（不同类型有不同的判断标准）
@@ -123,28 +180,34 @@ func (f *StatsFactory) isEmptyResult(result StatsResult) bool {
 	}
 }
 
-// buildCacheKey 构建缓存键
+// buildCacheKey 构建缓存键，参数按名称排序，相同的查询总是得到相同的键
 func (f *StatsFactory) buildCacheKey(managerType string, query StatsQuery) string {
 	// 基础键：统计类型-网站ID
 	key := fmt.Sprintf("%s-%s", managerType, query.WebsiteID)
 
+	paramKeys := make([]string, 0, len(query.ExtraParam))
+	for paramKey := range query.ExtraParam {
+		paramKeys = append(paramKeys, paramKey)
+	}
+	sort.Strings(paramKeys)
+
 	// 拼接所有额外参数
-	if query.ExtraParam != nil {
-		for paramKey, paramValue := range query.ExtraParam {
-			switch v := paramValue.(type) {
-			case string:
-				key = fmt.Sprintf("%s-%s:%s", key, paramKey, v)
-			case int:
-				key = fmt.Sprintf("%s-%s:%d", key, paramKey, v)
-			case float64:
-				key = fmt.Sprintf("%s-%s:%f", key, paramKey, v)
-			case bool:
-				key = fmt.Sprintf("%s-%s:%t", key, paramKey, v)
-			case time.Time:
-				key = fmt.Sprintf("%s-%s:%d", key, paramKey, v.Unix())
-			default:
-				key = fmt.Sprintf("%s-%s:%v", key, paramKey, v)
-			}
+	for _, paramKey := range paramKeys {
+		switch v := query.ExtraParam[paramKey].(type) {
+		case string:
+			key = fmt.Sprintf("%s-%s:%s", key, paramKey, v)
+		case int:
+			key = fmt.Sprintf("%s-%s:%d", key, paramKey, v)
+		case float64:
+			key = fmt.Sprintf("%s-%s:%f", key, paramKey, v)
+		case bool:
+			key = fmt.Sprintf("%s-%s:%t", key, paramKey, v)
+		case time.Time:
+			key = fmt.Sprintf("%s-%s:%d", key, paramKey, v.Unix())
+		case Filters:
+			key = fmt.Sprintf("%s-%s:%s", key, paramKey, v.String())
+		default:
+			key = fmt.Sprintf("%s-%s:%v", key, paramKey, v)
 		}
 	}
 
@@ -162,15 +225,25 @@ func (f *StatsFactory) BuildQueryFromRequest(
 
 	// 定义每种统计类型需要的参数
 	requiredParams := map[string]map[string]string{
-		"timeseries": {"id": "string", "timeRange": "string", "viewType": "string"},
-		"overall":    {"id": "string", "timeRange": "string"},
-		"url":        {"id": "string", "timeRange": "string", "limit": "int"},
-		"referer":    {"id": "string", "timeRange": "string", "limit": "int"},
-		"browser":    {"id": "string", "timeRange": "string", "limit": "int"},
-		"os":         {"id": "string", "timeRange": "string", "limit": "int"},
-		"device":     {"id": "string", "timeRange": "string", "limit": "int"},
-		"location":   {"id": "string", "timeRange": "string", "limit": "int", "locationType": "string"},
-		"logs":       {"id": "string", "page": "int", "pageSize": "int", "sortField": "string", "sortOrder": "enum:asc,desc"},
+		"timeseries": {"id": "string", "timeRange": "timerange", "viewType": "string"},
+		"overall":    {"id": "string", "timeRange": "timerange"},
+		"url":        {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"referer":    {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"browser":    {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"os":         {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"device":     {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"location":   {"id": "string", "timeRange": "timerange", "limit": "int", "locationType": "enum:domestic,global,city,isp"},
+		"entry":      {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"exit":       {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"nextpage":   {"id": "string", "timeRange": "timerange", "limit": "int", "url": "string"},
+		"source":     {"id": "string", "timeRange": "timerange", "limit": "int"},
+		"funnel":     {"id": "string", "timeRange": "timerange", "funnel": "int"},
+		"retention":  {"id": "string", "weeks": "int"},
+		"status":     {"id": "string", "timeRange": "timerange", "viewType": "string", "limit": "int"},
+		"bandwidth":  {"id": "string", "timeRange": "timerange", "viewType": "string", "limit": "int"},
+		"heatmap":    {"id": "string", "timeRange": "timerange", "metric": "enum:pageviews,requests"},
+		"sites":      {"id": "string", "timeRange": "timerange"},
+		"logs":       {"id": "string", "pageSize": "int", "sortField": "enum:timestamp,ip,url,status_code", "sortOrder": "enum:asc,desc"},
 	}
 
 	// 检查是否支持的统计类型
@@ -186,6 +259,16 @@ func (f *StatsFactory) BuildQueryFromRequest(
 	}
 	query.WebsiteID = websiteID
 
+	// 多站点范围
+	if util.IsSiteScope(websiteID) {
+		if !aggregateTypes[statsType] {
+			return query, fmt.Errorf("统计类型 %s 不支持多站点汇总", statsType)
+		}
+		if _, err := util.ResolveSiteScope(websiteID); err != nil {
+			return query, err
+		}
+	}
+
 	// 处理其他参数
 	for paramName, paramType := range paramDefs {
 		// 跳过已处理的id参数
@@ -194,6 +277,11 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		}
 
 		switch {
+		case paramType == "timerange":
+			if err := f.parseTimeRange(params, &query); err != nil {
+				return query, err
+			}
+
 		case paramType == "string":
 			value, err := getRequiredString(params, paramName)
 			if err != nil {
@@ -220,15 +308,255 @@ func (f *StatsFactory) BuildQueryFromRequest(
 	}
 
 	// 处理特殊可选参数
+	if compareTypes[statsType] && params["compare"] != "" {
+		value, err := getRequiredStringEnum(params, "compare",
+			[]string{comparePrevious, compareLastWeek, compareLastYear})
+		if err != nil {
+			return query, err
+		}
+		query.ExtraParam["compare"] = value
+
+		// 各站点的对比排名无法合并
+		if util.IsSiteScope(websiteID) && statsType != "overall" && statsType != "timeseries" {
+			return query, fmt.Errorf("多站点汇总的排行不支持 compare 参数")
+		}
+	}
+
+	if levels, ok := levelTypes[statsType]; ok {
+		query.ExtraParam["level"] = levels[0]
+		if params["level"] != "" {
+			value, err := getRequiredStringEnum(params, "level", levels)
+			if err != nil {
+				return query, err
+			}
+			query.ExtraParam["level"] = value
+		}
+	}
+
+	if spiderFilterTypes[statsType] {
+		query.ExtraParam["excludeSpiders"] = params["excludeSpiders"] == "true"
+	}
+
+	filters, err := parseFilters(params)
+	if err != nil {
+		return query, err
+	}
+	if len(filters) > 0 {
+		query.ExtraParam["filters"] = filters
+	}
+
 	if statsType == "logs" {
 		if filter, ok := params["filter"]; ok && filter != "" {
+			if err := ValidateLogQuery(filter); err != nil {
+				return query, err
+			}
 			query.ExtraParam["filter"] = filter
 		}
+		if err := parseLogTimeBounds(params, &query, time.Now()); err != nil {
+			return query, err
+		}
+		if err := parseLogPaging(params, &query); err != nil {
+			return query, err
+		}
 	}
 
 	return query, nil
 }
 
+// parseTimeRange 解析时间范围，支持命名范围 timeRange 或自定义的 start/end。
+// 自定义范围必须落在站点已有数据的时间范围内。
+func (f *StatsFactory) parseTimeRange(params map[string]string, query *StatsQuery) error {
+	startStr, endStr := params["start"], params["end"]
+
+	if startStr == "" && endStr == "" {
+		timeRange, err := getRequiredString(params, "timeRange")
+		if err != nil {
+			return err
+		}
+		if !util.IsNamedTimeRange(timeRange) {
+			return fmt.Errorf("timeRange 参数无效: %s", timeRange)
+		}
+		query.ExtraParam["timeRange"] = timeRange
+		return nil
+	}
+
+	if startStr == "" || endStr == "" {
+		return fmt.Errorf("自定义时间范围需要同时提供 start 和 end 参数")
+	}
+
+	startTime, err := util.ParseRangeBound(startStr, false)
+	if err != nil {
+		return fmt.Errorf("start 参数无效: %v", err)
+	}
+	endTime, err := util.ParseRangeBound(endStr, true)
+	if err != nil {
+		return fmt.Errorf("end 参数无效: %v", err)
+	}
+	if !endTime.After(startTime) {
+		return fmt.Errorf("end 必须晚于 start")
+	}
+
+	minTs, maxTs, err := f.logTimeBounds(query.WebsiteID)
+	if err != nil {
+		return err
+	}
+	if minTs == 0 && maxTs == 0 {
+		return fmt.Errorf("站点暂无数据，无法查询自定义时间范围")
+	}
+
+	earliest := time.Unix(minTs, 0)
+	earliest = time.Date(earliest.Year(), earliest.Month(), earliest.Day(), 0, 0, 0, 0, earliest.Location())
+	now := time.Now()
+	latest := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	if startTime.Before(earliest) || endTime.After(latest) {
+		return fmt.Errorf("时间范围超出可用数据范围 %s ~ %s",
+			earliest.Format("2006-01-02"), latest.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+
+	query.ExtraParam["timeRange"] = customTimeRange
+	query.ExtraParam["startTime"] = startTime
+	query.ExtraParam["endTime"] = endTime
+	return nil
+}
+
+// parseLogPaging 校验日志的翻页方式：带 cursor 时从游标之后读取，游标必须与排序方式一致，
+// page 可以省略，省略时取游标所指的页码，提供时必须与游标一致；
+// 否则 page 必须提供，按页码跳过前面的行，跳过的行数不能超过 logsMaxOffset
+func parseLogPaging(params map[string]string, query *StatsQuery) error {
+	sortField, sortOrder := query.ExtraParam["sortField"].(string), query.ExtraParam["sortOrder"].(string)
+
+	page := 0
+	if params["page"] != "" {
+		value, err := getRequiredInt(params, "page", 1)
+		if err != nil {
+			return err
+		}
+		page = value
+	}
+
+	if value := params["cursor"]; value != "" {
+		cursor, err := decodeLogCursor(value, sortField, sortOrder)
+		if err != nil {
+			return err
+		}
+		if page == 0 {
+			page = max(cursor.Page, 1)
+		} else if cursor.Page != 0 && page != cursor.Page {
+			return fmt.Errorf("page 参数与 cursor 不一致，游标指向第 %d 页", cursor.Page)
+		}
+		query.ExtraParam["page"] = page
+		query.ExtraParam["cursor"] = value
+		return nil
+	}
+
+	if page == 0 {
+		return fmt.Errorf("缺少必要参数: page")
+	}
+	query.ExtraParam["page"] = page
+
+	pageSize := query.ExtraParam["pageSize"].(int)
+	if (page-1)*min(pageSize, logsMaxPageSize) > logsMaxOffset {
+		return fmt.Errorf("页码过大，最多跳过 %d 条日志，请使用 cursor 参数翻页", logsMaxOffset)
+	}
+	return nil
+}
+
+// parseLogTimeBounds 解析日志查询的时间范围 start/end，两者都是可选的。
+// 取值可以是日期时间、Unix 时间戳（秒）或相对当前时间的时长，如 30m、1h、7d 表示 30 分钟前、1 小时前、7 天前
+func parseLogTimeBounds(params map[string]string, query *StatsQuery, now time.Time) error {
+	bounds := []struct {
+		param, key string
+		isEnd      bool
+	}{
+		{"start", "startTime", false},
+		{"end", "endTime", true},
+	}
+	for _, b := range bounds {
+		value := params[b.param]
+		if value == "" {
+			continue
+		}
+		t, err := parseLogTimeBound(value, b.isEnd, now)
+		if err != nil {
+			return fmt.Errorf("%s 参数无效: %v", b.param, err)
+		}
+		query.ExtraParam[b.key] = t
+	}
+
+	startTime, hasStart := query.ExtraParam["startTime"].(time.Time)
+	endTime, hasEnd := query.ExtraParam["endTime"].(time.Time)
+	if hasStart && hasEnd && !endTime.After(startTime) {
+		return fmt.Errorf("end 必须晚于 start")
+	}
+	return nil
+}
+
+// logDurationUnits 相对时长支持的单位
+var logDurationUnits = map[byte]time.Duration{
+	's': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour,
+}
+
+func parseLogTimeBound(value string, isEnd bool, now time.Time) (time.Time, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil && ts >= 0 {
+		return time.Unix(ts, 0), nil
+	}
+	if unit, ok := logDurationUnits[value[len(value)-1]]; ok {
+		if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n > 0 {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+	if t, err := util.ParseRangeBound(value, isEnd); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("时间格式无效: %s，应为 2006-01-02 15:04:05、Unix 时间戳或 30m、1h、7d 这样的相对时长", value)
+}
+
+// NewRangeQuery 构建指定起止时间的查询，extra 为其他参数（如 limit）。
+// 与 start/end 请求参数不同，不检查时间范围是否落在已有数据内，供定时报表等内部调用使用
+func NewRangeQuery(websiteID string, startTime, endTime time.Time, extra map[string]interface{}) StatsQuery {
+	query := StatsQuery{
+		WebsiteID:  websiteID,
+		ExtraParam: make(map[string]interface{}, len(extra)+3),
+	}
+	for key, value := range extra {
+		query.ExtraParam[key] = value
+	}
+	query.ExtraParam["timeRange"] = customTimeRange
+	query.ExtraParam["startTime"] = startTime
+	query.ExtraParam["endTime"] = endTime
+	return query
+}
+
+// queryTimePeriod 获取查询的起止时间，兼容命名范围和自定义范围
+func queryTimePeriod(query StatsQuery) (time.Time, time.Time, error) {
+	timeRange, _ := query.ExtraParam["timeRange"].(string)
+	if timeRange == customTimeRange {
+		startTime, _ := query.ExtraParam["startTime"].(time.Time)
+		endTime, _ := query.ExtraParam["endTime"].(time.Time)
+		return startTime, endTime, nil
+	}
+	return util.TimePeriod(timeRange)
+}
+
+// queryTimePoints 获取时间序列各区间的起点、标签，以及最后一个区间的终点
+func queryTimePoints(query StatsQuery) ([]time.Time, []string, time.Time, error) {
+	timeRange, _ := query.ExtraParam["timeRange"].(string)
+	viewType, _ := query.ExtraParam["viewType"].(string)
+
+	if timeRange == customTimeRange {
+		startTime, endTime, _ := queryTimePeriod(query)
+		timePoints, labels := util.TimePointsForRange(startTime, endTime, viewType)
+		return timePoints, labels, endTime, nil
+	}
+
+	timePoints, labels := util.TimePointsAndLabels(timeRange, viewType)
+	if len(timePoints) < 2 {
+		return nil, nil, time.Time{}, fmt.Errorf("不支持的时间范围: %s", timeRange)
+	}
+	endTime := timePoints[len(timePoints)-1].Add(timePoints[1].Sub(timePoints[0]))
+	return timePoints, labels, endTime, nil
+}
+
 // getRequiredInt 获取并验证必须的整数参数
 func getRequiredInt(params map[string]string, key string, minValue int) (int, error) {
 	if valueStr, ok := params[key]; ok && valueStr != "" {
@@ -1,54 +1,203 @@
 package stats
 
 import (
+	"container/list"
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
 // CacheItem 缓存项，包含数据和时间戳
 type CacheItem struct {
+	Key       string
+	WebsiteID string // 多站点范围的缓存项在任一站点失效时一起失效
 	Data      interface{}
 	Timestamp time.Time
 }
 
-// StatsCache 通用缓存组件
+// CacheMetrics 缓存的命中情况，计数从启动时开始累计
+type CacheMetrics struct {
+	Size          int     `json:"size"`
+	Capacity      int     `json:"capacity"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Shared        int64   `json:"shared"`        // 等待同一查询结果、未访问数据库的请求数
+	Evictions     int64   `json:"evictions"`     // 因容量不足淘汰的项数
+	Invalidations int64   `json:"invalidations"` // 因日志入库而失效的项数
+	HitRate       float64 `json:"hit_rate"`      // 命中（含共享结果）占全部请求的百分比
+}
+
+// errLoadAborted 查询异常中止时等待同一结果的请求收到的错误
+var errLoadAborted = errors.New("统计查询异常中止")
+
+// cacheCall 正在执行的查询，相同的并发查询等待同一个结果
+type cacheCall struct {
+	wg   sync.WaitGroup
+	data interface{}
+	err  error
+}
+
+// StatsCache 按最近使用淘汰的统计缓存。站点有新日志入库时删除该站点的缓存项，
+// 相同的并发查询只访问一次数据库
 type StatsCache struct {
-	items map[string]CacheItem
-	mutex sync.RWMutex
+	capacity int
+	lru      *list.List               // 表头为最近使用的项
+	items    map[string]*list.Element // 键到链表节点
+	bySite   map[string]map[string]*list.Element
+	calls    map[string]*cacheCall
+	version  uint64 // 每次失效时加一，查询期间发生过失效的结果不写入缓存
+	metrics  CacheMetrics
+	mutex    sync.Mutex
 }
 
-// NewStatsCache 创建一个新的统计缓存
-func NewStatsCache() *StatsCache {
+// NewStatsCache 创建一个最多保存 capacity 项的统计缓存
+func NewStatsCache(capacity int) *StatsCache {
 	return &StatsCache{
-		items: make(map[string]CacheItem),
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		bySite:   make(map[string]map[string]*list.Element),
+		calls:    make(map[string]*cacheCall),
 	}
 }
 
 // Get 从缓存中获取数据，带过期检查
 func (c *StatsCache) Get(key string, expiry time.Duration) (interface{}, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	item, exists := c.items[key]
+	elem, exists := c.items[key]
 	if !exists {
 		return nil, false
 	}
 
 	// 检查是否过期
+	item := elem.Value.(*CacheItem)
 	if time.Since(item.Timestamp) > expiry {
+		c.removeElement(elem)
 		return nil, false
 	}
 
+	c.lru.MoveToFront(elem)
 	return item.Data, true
 }
 
-// Set 添加数据到缓存
-func (c *StatsCache) Set(key string, value interface{}) {
+// Set 添加数据到缓存，超出容量时淘汰最久未使用的项
+func (c *StatsCache) Set(websiteID, key string, value interface{}) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.items[key] = CacheItem{
+	c.set(websiteID, key, value)
+}
+
+func (c *StatsCache) set(websiteID, key string, value interface{}) {
+	if elem, exists := c.items[key]; exists {
+		c.removeElement(elem)
+	}
+
+	elem := c.lru.PushFront(&CacheItem{
+		Key:       key,
+		WebsiteID: websiteID,
 		Data:      value,
 		Timestamp: time.Now(),
+	})
+	c.items[key] = elem
+	if c.bySite[websiteID] == nil {
+		c.bySite[websiteID] = make(map[string]*list.Element)
+	}
+	c.bySite[websiteID][key] = elem
+
+	for c.lru.Len() > c.capacity {
+		c.removeElement(c.lru.Back())
+		c.metrics.Evictions++
+	}
+}
+
+// GetOrLoad 返回缓存的数据，未命中时调用 load 查询。相同键的并发调用共享同一次查询，
+// store 为 false 的结果（如空结果）不写入缓存
+func (c *StatsCache) GetOrLoad(websiteID, key string, expiry time.Duration,
+	load func() (interface{}, bool, error)) (interface{}, error) {
+
+	if data, ok := c.Get(key, expiry); ok {
+		c.mutex.Lock()
+		c.metrics.Hits++
+		c.mutex.Unlock()
+		return data, nil
+	}
+
+	c.mutex.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.metrics.Shared++
+		c.mutex.Unlock()
+		call.wg.Wait()
+		return call.data, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.metrics.Misses++
+	version := c.version
+	c.mutex.Unlock()
+
+	// load 发生 panic 时也要移除正在执行的查询并唤醒等待者，否则相同的查询会永远阻塞
+	call.err = errLoadAborted
+	defer func() {
+		c.mutex.Lock()
+		delete(c.calls, key)
+		c.mutex.Unlock()
+		call.wg.Done()
+	}()
+
+	data, store, err := load()
+	call.data, call.err = data, err
+
+	c.mutex.Lock()
+	if err == nil && store && c.version == version {
+		c.set(websiteID, key, data)
+	}
+	c.mutex.Unlock()
+
+	return data, err
+}
+
+// InvalidateWebsite 删除站点的缓存项以及所有多站点范围的缓存项
+func (c *StatsCache) InvalidateWebsite(websiteID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	for siteID, elems := range c.bySite {
+		if siteID != websiteID && !util.IsSiteScope(siteID) {
+			continue
+		}
+		for _, elem := range elems {
+			c.removeElement(elem)
+			c.metrics.Invalidations++
+		}
+	}
+}
+
+// Metrics 返回缓存的命中情况
+func (c *StatsCache) Metrics() CacheMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	metrics := c.metrics
+	metrics.Size = c.lru.Len()
+	metrics.Capacity = c.capacity
+	metrics.HitRate = ratePercent(int(metrics.Hits+metrics.Shared), int(metrics.Hits+metrics.Shared+metrics.Misses))
+	return metrics
+}
+
+func (c *StatsCache) removeElement(elem *list.Element) {
+	item := c.lru.Remove(elem).(*CacheItem)
+	delete(c.items, item.Key)
+	if elems := c.bySite[item.WebsiteID]; elems != nil {
+		delete(elems, item.Key)
+		if len(elems) == 0 {
+			delete(c.bySite, item.WebsiteID)
+		}
 	}
 }
@@ -0,0 +1,245 @@
+package stats
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildCacheKey(t *testing.T) {
+	factory := &StatsFactory{}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		a, b StatsQuery
+		same bool
+	}{
+		{
+			name: "参数顺序不影响键",
+			a: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"timeRange": "today", "limit": 10, "viewType": "hourly", "compare": "previous"}},
+			b: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"compare": "previous", "viewType": "hourly", "limit": 10, "timeRange": "today"}},
+			same: true,
+		},
+		{
+			name: "过滤条件的顺序不影响键",
+			a: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"filters": Filters{"url": "/a", "status": "5xx", "location": "北京"}}},
+			b: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"filters": Filters{"location": "北京", "url": "/a", "status": "5xx"}}},
+			same: true,
+		},
+		{
+			name: "时间按秒比较",
+			a:    StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{"startTime": start}},
+			b:    StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{"startTime": start.Add(time.Millisecond)}},
+			same: true,
+		},
+		{
+			name: "站点不同",
+			a:    StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{"timeRange": "today"}},
+			b:    StatsQuery{WebsiteID: "b", ExtraParam: map[string]interface{}{"timeRange": "today"}},
+		},
+		{
+			name: "取值不同",
+			a:    StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{"limit": 10}},
+			b:    StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{"limit": 100}},
+		},
+		{
+			name: "过滤条件不同",
+			a: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"filters": Filters{"url": "/a"}}},
+			b: StatsQuery{WebsiteID: "a", ExtraParam: map[string]interface{}{
+				"filters": Filters{"referer": "/a"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyA := factory.buildCacheKey("overall", tt.a)
+			// map 的遍历顺序随机，多次生成应得到相同的键
+			for range 20 {
+				if key := factory.buildCacheKey("overall", tt.a); key != keyA {
+					t.Fatalf("同一查询的键不稳定: %q 和 %q", keyA, key)
+				}
+			}
+			keyB := factory.buildCacheKey("overall", tt.b)
+			if (keyA == keyB) != tt.same {
+				t.Errorf("键 %q 和 %q 相同 = %t, 期望 %t", keyA, keyB, keyA == keyB, tt.same)
+			}
+		})
+	}
+}
+
+func TestStatsCacheEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		capacity  int
+		ops       []string // set:<键> 或 get:<键>
+		wantKeys  []string
+		wantGone  []string
+		evictions int64
+	}{
+		{
+			name:      "超出容量时淘汰最久未使用的项",
+			capacity:  2,
+			ops:       []string{"set:a", "set:b", "set:c"},
+			wantKeys:  []string{"b", "c"},
+			wantGone:  []string{"a"},
+			evictions: 1,
+		},
+		{
+			name:      "读取会更新使用顺序",
+			capacity:  2,
+			ops:       []string{"set:a", "set:b", "get:a", "set:c"},
+			wantKeys:  []string{"a", "c"},
+			wantGone:  []string{"b"},
+			evictions: 1,
+		},
+		{
+			name:      "覆盖已有的键不淘汰其他项",
+			capacity:  2,
+			ops:       []string{"set:a", "set:b", "set:a", "set:a"},
+			wantKeys:  []string{"a", "b"},
+			evictions: 0,
+		},
+		{
+			name:      "容量为 1",
+			capacity:  1,
+			ops:       []string{"set:a", "set:b", "set:c"},
+			wantKeys:  []string{"c"},
+			wantGone:  []string{"a", "b"},
+			evictions: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewStatsCache(tt.capacity)
+			for _, op := range tt.ops {
+				action, key, _ := strings.Cut(op, ":")
+				if action == "set" {
+					cache.Set("site", key, key)
+				} else {
+					cache.Get(key, time.Minute)
+				}
+			}
+
+			for _, key := range tt.wantKeys {
+				if data, ok := cache.Get(key, time.Minute); !ok || data != key {
+					t.Errorf("键 %s 应在缓存中", key)
+				}
+			}
+			for _, key := range tt.wantGone {
+				if _, ok := cache.Get(key, time.Minute); ok {
+					t.Errorf("键 %s 应已被淘汰", key)
+				}
+			}
+			metrics := cache.Metrics()
+			if metrics.Evictions != tt.evictions || metrics.Size != len(tt.wantKeys) {
+				t.Errorf("淘汰 %d 项、剩余 %d 项, 期望淘汰 %d 项、剩余 %d 项",
+					metrics.Evictions, metrics.Size, tt.evictions, len(tt.wantKeys))
+			}
+		})
+	}
+}
+
+func TestStatsCacheInvalidateWebsite(t *testing.T) {
+	cache := NewStatsCache(10)
+	cache.Set("a", "a-1", 1)
+	cache.Set("a", "a-2", 2)
+	cache.Set("b", "b-1", 3)
+	cache.Set("all", "all-1", 4)
+	cache.Set("group:官网", "group-1", 5)
+
+	cache.InvalidateWebsite("a")
+
+	for key, want := range map[string]bool{
+		"a-1": false, "a-2": false, "b-1": true, "all-1": false, "group-1": false,
+	} {
+		if _, ok := cache.Get(key, time.Minute); ok != want {
+			t.Errorf("失效站点 a 后键 %s 存在 = %t, 期望 %t", key, ok, want)
+		}
+	}
+	if metrics := cache.Metrics(); metrics.Invalidations != 4 {
+		t.Errorf("失效 %d 项, 期望 4 项", metrics.Invalidations)
+	}
+}
+
+func TestStatsCacheSkipsStaleLoad(t *testing.T) {
+	cache := NewStatsCache(10)
+
+	// 查询期间站点有新数据入库，结果可能已过时，不写入缓存
+	cache.GetOrLoad("a", "key", time.Minute, func() (interface{}, bool, error) {
+		cache.InvalidateWebsite("a")
+		return "old", true, nil
+	})
+	if _, ok := cache.Get("key", time.Minute); ok {
+		t.Error("查询期间失效的结果不应写入缓存")
+	}
+
+	// store 为 false 的结果不写入缓存
+	cache.GetOrLoad("a", "empty", time.Minute, func() (interface{}, bool, error) {
+		return nil, false, nil
+	})
+	if _, ok := cache.Get("empty", time.Minute); ok {
+		t.Error("store 为 false 的结果不应写入缓存")
+	}
+}
+
+func TestStatsCacheGetOrLoadPanic(t *testing.T) {
+	cache := NewStatsCache(10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	// 第一个查询阻塞到等待者加入后再 panic
+	go func() {
+		defer func() { recover() }()
+		cache.GetOrLoad("a", "key", time.Minute, func() (interface{}, bool, error) {
+			close(started)
+			<-release
+			panic("查询失败")
+		})
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	var waitErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, waitErr = cache.GetOrLoad("a", "key", time.Minute, func() (interface{}, bool, error) {
+			return "不应执行", true, nil
+		})
+	}()
+	for cache.Metrics().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("load panic 后等待同一查询的请求没有返回")
+	}
+	if !errors.Is(waitErr, errLoadAborted) {
+		t.Errorf("等待者的错误 = %v, 期望 %v", waitErr, errLoadAborted)
+	}
+
+	// 正在执行的查询已移除，之后的调用重新查询
+	data, err := cache.GetOrLoad("a", "key", time.Minute, func() (interface{}, bool, error) {
+		return "ok", true, nil
+	})
+	if err != nil || data != "ok" {
+		t.Errorf("panic 之后重新查询 = %v, %v, 期望 ok", data, err)
+	}
+}
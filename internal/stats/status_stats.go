@@ -0,0 +1,313 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// StatusStats 状态码统计，统计全部请求而不只是 PV
+type StatusStats struct {
+	Total        int               `json:"total"`         // 请求总数
+	Classes      []StatusClass     `json:"classes"`       // 1xx-5xx 各类状态码的分布
+	Codes        []StatusCodeCount `json:"codes"`         // 各状态码的请求数
+	ErrorSeries  ErrorRateSeries   `json:"error_series"`  // 错误率时间序列
+	NotFound     []BrokenLink      `json:"not_found"`     // 返回 404 最多的 URL 及其来源（死链报告）
+	ServerErrors []ErrorURL        `json:"server_errors"` // 返回 5xx 最多的 URL
+}
+
+// StatusClass 一类状态码的请求数
+type StatusClass struct {
+	Class   string  `json:"class"` // 如 "4xx"
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// StatusCodeCount 单个状态码的请求数
+type StatusCodeCount struct {
+	Code  int `json:"code"`
+	Count int `json:"count"`
+}
+
+// ErrorRateSeries 按时间区间的请求数和错误率
+type ErrorRateSeries struct {
+	Labels       []string  `json:"labels"`
+	Requests     []int     `json:"requests"`
+	ClientErrors []int     `json:"clientErrors"` // 4xx
+	ServerErrors []int     `json:"serverErrors"` // 5xx
+	ErrorRate    []float64 `json:"errorRate"`    // (4xx + 5xx) / 请求数（百分比）
+}
+
+// BrokenLink 返回 404 的 URL 和把访客带到这里的来源
+type BrokenLink struct {
+	URL      string         `json:"url"`
+	Count    int            `json:"count"`
+	Referers []RefererCount `json:"referers"`
+}
+
+// RefererCount 来源及次数
+type RefererCount struct {
+	Referer string `json:"referer"`
+	Count   int    `json:"count"`
+}
+
+// ErrorURL 返回服务端错误的 URL
+type ErrorURL struct {
+	URL      string `json:"url"`
+	Count    int    `json:"count"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+func (s StatusStats) GetType() string {
+	return "status"
+}
+
+// spiderCondition 排除蜘蛛时返回附加的 SQL 条件，column 为 is_spider 列（可带表别名）
+func spiderCondition(column string, exclude bool) string {
+	if !exclude {
+		return ""
+	}
+	return "AND " + column + " = 0"
+}
+
+// brokenLinkReferers 死链报告中每个 URL 列出的来源数
+const brokenLinkReferers = 5
+
+type StatusStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewStatusStatsManager(userRepoPtr *storage.Repository) *StatusStatsManager {
+	return &StatusStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口
+func (s *StatusStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := StatusStats{
+		Classes:      make([]StatusClass, 0),
+		Codes:        make([]StatusCodeCount, 0),
+		NotFound:     make([]BrokenLink, 0),
+		ServerErrors: make([]ErrorURL, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	startTime, endTime, err := queryTimePeriod(query)
+	if err != nil {
+		return result, err
+	}
+
+	excludeSpiders, _ := query.ExtraParam["excludeSpiders"].(bool)
+	filters := queryFilters(query)
+	filterCond, filterArgs := filters.condition("")
+	cond := spiderCondition("is_spider", excludeSpiders) + filterCond
+
+	if err := s.queryCodes(query.WebsiteID, cond, filterArgs, startTime, endTime, &result); err != nil {
+		return result, err
+	}
+
+	timePoints, labels, seriesEnd, err := queryTimePoints(query)
+	if err != nil {
+		return result, err
+	}
+	seriesCond, seriesArgs := filters.condition("l")
+	result.ErrorSeries, err = s.queryErrorSeries(query.WebsiteID,
+		spiderCondition("l.is_spider", excludeSpiders)+seriesCond, seriesArgs, timePoints, seriesEnd)
+	if err != nil {
+		return result, err
+	}
+	result.ErrorSeries.Labels = labels
+
+	if result.NotFound, err = s.queryBrokenLinks(
+		query.WebsiteID, cond, filterArgs, startTime, endTime, limit); err != nil {
+		return result, err
+	}
+	if result.ServerErrors, err = s.queryServerErrors(
+		query.WebsiteID, cond, filterArgs, startTime, endTime, limit); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// queryCodes 统计各状态码的请求数并汇总为状态码类别
+func (s *StatusStatsManager) queryCodes(websiteID, cond string, condArgs []any,
+	startTime, endTime time.Time, result *StatusStats) error {
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT status_code, COUNT(*) AS cnt
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE timestamp >= ? AND timestamp < ? %[2]s
+        GROUP BY status_code
+        ORDER BY cnt DESC`, websiteID, cond),
+		append([]any{startTime.Unix(), endTime.Unix()}, condArgs...)...)
+	if err != nil {
+		return fmt.Errorf("查询状态码统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	classCounts := make([]int, 5)
+	for rows.Next() {
+		var code StatusCodeCount
+		if err := rows.Scan(&code.Code, &code.Count); err != nil {
+			return fmt.Errorf("解析状态码统计失败: %v", err)
+		}
+		result.Codes = append(result.Codes, code)
+		result.Total += code.Count
+		if class := code.Code / 100; class >= 1 && class <= 5 {
+			classCounts[class-1] += code.Count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历状态码统计失败: %v", err)
+	}
+
+	for i, count := range classCounts {
+		result.Classes = append(result.Classes, StatusClass{
+			Class:   fmt.Sprintf("%dxx", i+1),
+			Count:   count,
+			Percent: ratePercent(count, result.Total),
+		})
+	}
+	return nil
+}
+
+// queryErrorSeries 按区间统计请求数、4xx 和 5xx
+func (s *StatusStatsManager) queryErrorSeries(websiteID, cond string, condArgs []any,
+	timePoints []time.Time, endTime time.Time) (ErrorRateSeries, error) {
+
+	n := len(timePoints)
+	series := ErrorRateSeries{
+		Requests:     make([]int, n),
+		ClientErrors: make([]int, n),
+		ServerErrors: make([]int, n),
+		ErrorRate:    make([]float64, n),
+	}
+	if n == 0 {
+		return series, nil
+	}
+
+	args := make([]any, 0, n*2)
+	for i := range timePoints {
+		rangeEnd := endTime
+		if i+1 < n {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
+	}
+	args = append(args, condArgs...)
+
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %[1]s
+        )
+        SELECT
+            tr.range_index,
+            COUNT(l.id) AS requests,
+            COALESCE(SUM(l.status_code BETWEEN 400 AND 499), 0) AS client_errors,
+            COALESCE(SUM(l.status_code BETWEEN 500 AND 599), 0) AS server_errors
+        FROM time_ranges tr
+        LEFT JOIN "%[2]s_nginx_logs" l INDEXED BY idx_%[2]s_timestamp
+            ON l.timestamp >= tr.start_time AND l.timestamp < tr.end_time %[3]s
+        GROUP BY tr.range_index
+        ORDER BY tr.range_index`,
+		formatRangeValues(n), websiteID, cond), args...)
+	if err != nil {
+		return series, fmt.Errorf("查询错误率失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i, requests, clientErrors, serverErrors int
+		if err := rows.Scan(&i, &requests, &clientErrors, &serverErrors); err != nil {
+			return series, fmt.Errorf("解析错误率失败: %v", err)
+		}
+		series.Requests[i] = requests
+		series.ClientErrors[i] = clientErrors
+		series.ServerErrors[i] = serverErrors
+		series.ErrorRate[i] = ratePercent(clientErrors+serverErrors, requests)
+	}
+
+	return series, rows.Err()
+}
+
+// queryBrokenLinks 查询返回 404 最多的 URL，每个 URL 附带最常见的来源
+func (s *StatusStatsManager) queryBrokenLinks(websiteID, cond string, condArgs []any,
+	startTime, endTime time.Time, limit int) ([]BrokenLink, error) {
+
+	links := make([]BrokenLink, 0)
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH nf AS (
+            SELECT url, referer, COUNT(*) AS cnt
+            FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+            WHERE timestamp >= ? AND timestamp < ? AND status_code = 404 %[2]s
+            GROUP BY url, referer
+        ),
+        top_urls AS (
+            SELECT url, SUM(cnt) AS total
+            FROM nf
+            GROUP BY url
+            ORDER BY total DESC
+            LIMIT ?
+        ),
+        ranked AS (
+            SELECT url, referer, cnt,
+                ROW_NUMBER() OVER (PARTITION BY url ORDER BY cnt DESC) AS rn
+            FROM nf
+        )
+        SELECT t.url, t.total, r.referer, r.cnt
+        FROM top_urls t
+        JOIN ranked r ON r.url = t.url AND r.rn <= ?
+        ORDER BY t.total DESC, t.url, r.cnt DESC`, websiteID, cond),
+		append(append([]any{startTime.Unix(), endTime.Unix()}, condArgs...), limit, brokenLinkReferers)...)
+	if err != nil {
+		return links, fmt.Errorf("查询 404 统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url, referer string
+		var total, count int
+		if err := rows.Scan(&url, &total, &referer, &count); err != nil {
+			return links, fmt.Errorf("解析 404 统计失败: %v", err)
+		}
+		if len(links) == 0 || links[len(links)-1].URL != url {
+			links = append(links, BrokenLink{URL: url, Count: total, Referers: make([]RefererCount, 0)})
+		}
+		last := &links[len(links)-1]
+		last.Referers = append(last.Referers, RefererCount{Referer: referer, Count: count})
+	}
+
+	return links, rows.Err()
+}
+
+// queryServerErrors 查询返回 5xx 最多的 URL
+func (s *StatusStatsManager) queryServerErrors(websiteID, cond string, condArgs []any,
+	startTime, endTime time.Time, limit int) ([]ErrorURL, error) {
+
+	urls := make([]ErrorURL, 0)
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        SELECT url, COUNT(*) AS cnt, MAX(timestamp) AS last_seen
+        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE timestamp >= ? AND timestamp < ? AND status_code BETWEEN 500 AND 599 %[2]s
+        GROUP BY url
+        ORDER BY cnt DESC
+        LIMIT ?`, websiteID, cond),
+		append(append([]any{startTime.Unix(), endTime.Unix()}, condArgs...), limit)...)
+	if err != nil {
+		return urls, fmt.Errorf("查询 5xx 统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u ErrorURL
+		if err := rows.Scan(&u.URL, &u.Count, &u.LastSeen); err != nil {
+			return urls, fmt.Errorf("解析 5xx 统计失败: %v", err)
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, rows.Err()
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/storage"
-	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
 type StatPoint struct {
@@ -19,6 +18,24 @@ type TimeSeriesStats struct {
 	Visitors  []int    `json:"visitors"`
 	Pageviews []int    `json:"pageviews"`
 	PvMinusUv []int    `json:"pvMinusUv"` // PV - UV
+
+	// 按会话开始时间归入区间的访问统计
+	Visits           []int     `json:"visits"`
+	BounceRate       []float64 `json:"bounceRate"` // 百分比
+	PagesPerVisit    []float64 `json:"pagesPerVisit"`
+	AvgVisitDuration []int64   `json:"avgVisitDuration"` // 秒
+
+	NewVisitors       []int `json:"newVisitors"`
+	ReturningVisitors []int `json:"returningVisitors"`
+
+	Goals []GoalSeries `json:"goals,omitempty"` // 站点定义的各目标的转化
+
+	// 对比周期中对应区间的数据，未指定 compare 时省略
+	ComparePeriod    string `json:"comparePeriod,omitempty"`
+	CompareVisitors  []int  `json:"compareVisitors,omitempty"`
+	ComparePageviews []int  `json:"comparePageviews,omitempty"`
+
+	visitTotals []visitSummary // 各区间访问指标的原始计数，合并多个站点时按计数相加
 }
 
 // TimeSeriesStats 实现 StatsResult 接口
@@ -39,17 +56,27 @@ func NewTimeSeriesStatsManager(userRepoPtr *storage.Repository) *TimeSeriesStats
 
 // 实现 StatsManager 接口
 func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
-	timeRange := query.ExtraParam["timeRange"].(string)
-	viewType := query.ExtraParam["viewType"].(string)
-	timePoints, labels := util.TimePointsAndLabels(timeRange, viewType)
+	timePoints, labels, endTime, err := queryTimePoints(query)
+	if err != nil {
+		return TimeSeriesStats{}, err
+	}
 	result := TimeSeriesStats{
 		Labels:    labels,
 		Visitors:  make([]int, len(timePoints)),
 		Pageviews: make([]int, len(timePoints)),
 		PvMinusUv: make([]int, len(timePoints)),
+
+		Visits:           make([]int, len(timePoints)),
+		BounceRate:       make([]float64, len(timePoints)),
+		PagesPerVisit:    make([]float64, len(timePoints)),
+		AvgVisitDuration: make([]int64, len(timePoints)),
+
+		NewVisitors:       make([]int, len(timePoints)),
+		ReturningVisitors: make([]int, len(timePoints)),
 	}
 
-	statPoints, err := s.statsByTimePointsForWebsite(query.WebsiteID, timePoints)
+	filters := queryFilters(query)
+	statPoints, err := s.statsByTimePointsForWebsite(query.WebsiteID, timePoints, endTime, filters)
 	if err != nil {
 		return result, fmt.Errorf("获取图表数据失败: %v", err)
 	}
@@ -59,16 +86,86 @@ func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		result.PvMinusUv[i] = point.PV - point.UV
 	}
 
+	visits, err := visitSummariesByTimePoints(s.repo.GetDB(), query.WebsiteID, timePoints, endTime, filters)
+	if err != nil {
+		return result, fmt.Errorf("获取图表数据失败: %v", err)
+	}
+	result.visitTotals = visits
+	for i, visit := range visits {
+		result.Visits[i] = visit.Visits
+		result.BounceRate[i] = visit.bounceRate()
+		result.PagesPerVisit[i] = visit.pagesPerVisit()
+		result.AvgVisitDuration[i] = visit.avgDuration()
+	}
+
+	splits, err := visitorSplitsByTimePoints(s.repo.GetDB(), query.WebsiteID, timePoints, endTime, filters)
+	if err != nil {
+		return result, fmt.Errorf("获取图表数据失败: %v", err)
+	}
+	for i, split := range splits {
+		result.NewVisitors[i] = split.New
+		result.ReturningVisitors[i] = split.Returning
+	}
+
+	goals, err := s.repo.ListGoals(query.WebsiteID)
+	if err != nil {
+		return result, err
+	}
+	for _, goal := range goals {
+		conversions, err := goalConversionsByTimePoints(
+			s.repo.GetDB(), query.WebsiteID, goal, timePoints, endTime, filters)
+		if err != nil {
+			return result, fmt.Errorf("获取图表数据失败: %v", err)
+		}
+		series := GoalSeries{
+			ID:             goal.ID,
+			Name:           goal.Name,
+			Conversions:    conversions,
+			ConversionRate: make([]float64, len(conversions)),
+		}
+		for i, count := range conversions {
+			series.ConversionRate[i] = ratePercent(count, result.Visits[i])
+		}
+		result.Goals = append(result.Goals, series)
+	}
+
+	if compare, _ := query.ExtraParam["compare"].(string); compare != "" && len(timePoints) > 0 {
+		// 每个区间平移到对比周期的对应位置，图表上对比的是整个周期，今天尚未到来的小时也显示昨天的数据
+		timeRange, _ := query.ExtraParam["timeRange"].(string)
+		shift := compareShifter(compare, timeRange, timePoints[0], endTime)
+		comparePoints := make([]time.Time, len(timePoints))
+		for i, t := range timePoints {
+			comparePoints[i] = shift(t)
+		}
+		compareEnd := shift(endTime)
+
+		compareStats, err := s.statsByTimePointsForWebsite(query.WebsiteID, comparePoints, compareEnd, filters)
+		if err != nil {
+			return result, fmt.Errorf("获取对比周期图表数据失败: %v", err)
+		}
+
+		result.ComparePeriod = compare
+		result.CompareVisitors = make([]int, len(compareStats))
+		result.ComparePageviews = make([]int, len(compareStats))
+		for i, point := range compareStats {
+			result.ComparePageviews[i] = point.PV
+			result.CompareVisitors[i] = point.UV
+		}
+	}
+
 	return result, nil
 }
 
-// statsByTimePointsForWebsite 根据多个时间点批量查询统计数据
+// statsByTimePointsForWebsite 根据多个时间点批量查询统计数据。
+// 第 i 个区间为 [timePoints[i], timePoints[i+1])，最后一个区间截止到 endTime。
 func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
-	websiteID string, timePoints []time.Time) ([]StatPoint, error) {
+	websiteID string, timePoints []time.Time, endTime time.Time, filters Filters) ([]StatPoint, error) {
 
 	timePointsSize := len(timePoints)
-	timeOffset := timePoints[1].Sub(timePoints[0])
 	results := make([]StatPoint, timePointsSize)
+	if timePointsSize == 0 {
+		return results, nil
+	}
 
 	tx, err := s.repo.GetDB().Begin()
 	if err != nil {
@@ -82,10 +179,14 @@ func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
 	args := make([]any, 0, timePointsSize*2)
 
 	for i := range timePointsSize {
-		startTime := timePoints[i]
-		endTime := startTime.Add(timeOffset)
-		args = append(args, startTime.Unix(), endTime.Unix())
+		rangeEnd := endTime
+		if i+1 < timePointsSize {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
 	}
+	filterCond, filterArgs := filters.condition("l")
+	args = append(args, filterArgs...)
 
 	// 关键优化点3: 构建一次性批量查询SQL
 	batchQuery := fmt.Sprintf(`
@@ -98,10 +199,10 @@ func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
             COUNT(DISTINCT l.ip) as uv
         FROM time_ranges tr
         LEFT JOIN "%s" l INDEXED BY idx_%s_pv_ts_ip
-            ON l.pageview_flag = 1 AND l.timestamp >= tr.start_time AND l.timestamp < tr.end_time
+            ON l.pageview_flag = 1 AND l.timestamp >= tr.start_time AND l.timestamp < tr.end_time %s
         GROUP BY tr.range_index
         ORDER BY tr.range_index`,
-		formatRangeValues(timePointsSize), tableName, websiteID)
+		formatRangeValues(timePointsSize), tableName, websiteID, filterCond)
 
 	rows, err := tx.Query(batchQuery, args...)
 	if err != nil {
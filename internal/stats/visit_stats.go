@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// visitSummary 一个时间区间内开始的会话的汇总
+type visitSummary struct {
+	Visits    int   // 会话数
+	Bounces   int   // 只浏览了一个页面的会话数
+	Pageviews int   // 会话内的浏览量合计
+	Duration  int64 // 会话时长合计（秒）
+}
+
+// bounceRate 跳出率（百分比，保留一位小数）
+func (v visitSummary) bounceRate() float64 {
+	return ratePercent(v.Bounces, v.Visits)
+}
+
+// pagesPerVisit 平均每次访问的浏览页数（保留两位小数）
+func (v visitSummary) pagesPerVisit() float64 {
+	if v.Visits == 0 {
+		return 0
+	}
+	return math.Round(float64(v.Pageviews)/float64(v.Visits)*100) / 100
+}
+
+// avgDuration 平均访问时长（秒）
+func (v visitSummary) avgDuration() int64 {
+	if v.Visits == 0 {
+		return 0
+	}
+	return v.Duration / int64(v.Visits)
+}
+
+// visitSummariesByTimePoints 按区间汇总会话，会话计入其开始时间所在的区间。
+// 第 i 个区间为 [timePoints[i], timePoints[i+1])，最后一个区间截止到 endTime。
+// 有维度过滤时只统计包含符合条件的请求的会话。
+func visitSummariesByTimePoints(db *sql.DB, websiteID string,
+	timePoints []time.Time, endTime time.Time, filters Filters) ([]visitSummary, error) {
+
+	results := make([]visitSummary, len(timePoints))
+	if len(timePoints) == 0 {
+		return results, nil
+	}
+
+	args := make([]any, 0, len(timePoints)*2)
+	for i := range timePoints {
+		rangeEnd := endTime
+		if i+1 < len(timePoints) {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
+	}
+
+	sessionCond, filterArgs := filters.sessionCondition(websiteID, "s.id")
+	args = append(args, filterArgs...)
+
+	rows, err := db.Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %s
+        )
+        SELECT
+            tr.range_index,
+            COUNT(s.id) AS visits,
+            COALESCE(SUM(s.pageviews = 1), 0) AS bounces,
+            COALESCE(SUM(s.pageviews), 0) AS pageviews,
+            COALESCE(SUM(s.end_time - s.start_time), 0) AS duration
+        FROM time_ranges tr
+        LEFT JOIN "%s_sessions" s
+            ON s.start_time >= tr.start_time AND s.start_time < tr.end_time%s
+        GROUP BY tr.range_index
+        ORDER BY tr.range_index`,
+		formatRangeValues(len(timePoints)), websiteID, sessionCond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询访问统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rangeIdx int
+		var summary visitSummary
+		if err := rows.Scan(&rangeIdx, &summary.Visits, &summary.Bounces,
+			&summary.Pageviews, &summary.Duration); err != nil {
+			return nil, fmt.Errorf("解析访问统计失败: %v", err)
+		}
+		results[rangeIdx] = summary
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,180 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// maxRetentionWeeks 留存报告最多统计的周数
+const maxRetentionWeeks = 12
+
+// visitorSplit 一个时间区间内的新访客和回访访客数
+type visitorSplit struct {
+	New       int
+	Returning int
+}
+
+// visitorSplitsByTimePoints 按区间统计有访问的访客中新访客和回访访客的数量。
+// 访客按指纹识别，首次访问落在区间内的为新访客，在区间开始之前就访问过的为回访访客。
+// 区间划分和维度过滤与 visitSummariesByTimePoints 相同。
+func visitorSplitsByTimePoints(db *sql.DB, websiteID string,
+	timePoints []time.Time, endTime time.Time, filters Filters) ([]visitorSplit, error) {
+
+	results := make([]visitorSplit, len(timePoints))
+	if len(timePoints) == 0 {
+		return results, nil
+	}
+
+	args := make([]any, 0, len(timePoints)*2)
+	for i := range timePoints {
+		rangeEnd := endTime
+		if i+1 < len(timePoints) {
+			rangeEnd = timePoints[i+1]
+		}
+		args = append(args, timePoints[i].Unix(), rangeEnd.Unix())
+	}
+
+	sessionCond, filterArgs := filters.sessionCondition(websiteID, "s.id")
+	args = append(args, filterArgs...)
+
+	rows, err := db.Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %[1]s
+        )
+        SELECT
+            tr.range_index,
+            COUNT(DISTINCT CASE WHEN v.first_seen >= tr.start_time THEN s.fingerprint END) AS new_visitors,
+            COUNT(DISTINCT CASE WHEN v.first_seen < tr.start_time THEN s.fingerprint END) AS returning_visitors
+        FROM time_ranges tr
+        LEFT JOIN "%[2]s_sessions" s
+            ON s.start_time >= tr.start_time AND s.start_time < tr.end_time %[3]s
+        LEFT JOIN "%[2]s_visitors" v ON v.fingerprint = s.fingerprint
+        GROUP BY tr.range_index
+        ORDER BY tr.range_index`,
+		formatRangeValues(len(timePoints)), websiteID, sessionCond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询新老访客失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rangeIdx int
+		var split visitorSplit
+		if err := rows.Scan(&rangeIdx, &split.New, &split.Returning); err != nil {
+			return nil, fmt.Errorf("解析新老访客失败: %v", err)
+		}
+		results[rangeIdx] = split
+	}
+
+	return results, rows.Err()
+}
+
+// RetentionStats 按首次访问所在周划分的访客留存
+type RetentionStats struct {
+	Cohorts []RetentionCohort `json:"cohorts"` // 由早到晚
+}
+
+// RetentionCohort 同一周首次访问的访客在之后各周的回访情况
+type RetentionCohort struct {
+	Week      string    `json:"week"`       // 该周周一的日期
+	StartTime int64     `json:"start_time"` // 该周的开始时间
+	Visitors  int       `json:"visitors"`   // 该周的新访客数
+	Returning []int     `json:"returning"`  // 第 i 项为之后第 i+1 周回访的访客数，尚未到来的周不列出
+	Rate      []float64 `json:"rate"`       // 回访访客占新访客的百分比
+}
+
+func (s RetentionStats) GetType() string {
+	return "retention"
+}
+
+type RetentionStatsManager struct {
+	repo *storage.Repository
+}
+
+func NewRetentionStatsManager(userRepoPtr *storage.Repository) *RetentionStatsManager {
+	return &RetentionStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// 实现 StatsManager 接口，统计截至本周的最近 weeks 周。
+// 回访按会话判断，会话与日志一起过期，超出日志保留期的周没有回访数据。
+func (s *RetentionStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := RetentionStats{Cohorts: make([]RetentionCohort, 0)}
+
+	weeks, _ := query.ExtraParam["weeks"].(int)
+	if weeks > maxRetentionWeeks {
+		return result, fmt.Errorf("weeks 不能超过 %d", maxRetentionWeeks)
+	}
+
+	// 第 i 周为 [weekStarts[i], weekStarts[i+1])，最后一周是本周
+	thisWeek := util.StartOfWeek(time.Now())
+	weekStarts := make([]time.Time, weeks+1)
+	for i := range weekStarts {
+		weekStarts[i] = thisWeek.AddDate(0, 0, 7*(i-weeks+1))
+	}
+
+	args := make([]any, 0, weeks*2)
+	for i := 0; i < weeks; i++ {
+		args = append(args, weekStarts[i].Unix(), weekStarts[i+1].Unix())
+		result.Cohorts = append(result.Cohorts, RetentionCohort{
+			Week:      weekStarts[i].Format("2006-01-02"),
+			StartTime: weekStarts[i].Unix(),
+			Returning: make([]int, weeks-1-i),
+			Rate:      make([]float64, weeks-1-i),
+		})
+	}
+
+	// week_index 为 -1 的行是各群组的新访客数，其余为群组在之后各周的回访数
+	rows, err := s.repo.GetDB().Query(fmt.Sprintf(`
+        WITH weeks(week_index, start_time, end_time) AS (
+            VALUES %[1]s
+        ),
+        cohort AS (
+            SELECT v.fingerprint, w.week_index AS cohort_index
+            FROM "%[2]s_visitors" v INDEXED BY idx_%[2]s_visitors_first_seen
+            JOIN weeks w ON v.first_seen >= w.start_time AND v.first_seen < w.end_time
+        )
+        SELECT cohort_index, -1, COUNT(*) FROM cohort GROUP BY cohort_index
+        UNION ALL
+        SELECT c.cohort_index, w.week_index, COUNT(DISTINCT c.fingerprint)
+        FROM cohort c
+        JOIN "%[2]s_sessions" s INDEXED BY idx_%[2]s_sessions_fingerprint ON s.fingerprint = c.fingerprint
+        JOIN weeks w ON s.start_time >= w.start_time AND s.start_time < w.end_time
+        WHERE w.week_index > c.cohort_index
+        GROUP BY c.cohort_index, w.week_index`,
+		formatRangeValues(weeks), query.WebsiteID), args...)
+	if err != nil {
+		return result, fmt.Errorf("查询访客留存失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cohortIdx, weekIdx, count int
+		if err := rows.Scan(&cohortIdx, &weekIdx, &count); err != nil {
+			return result, fmt.Errorf("解析访客留存失败: %v", err)
+		}
+		cohort := &result.Cohorts[cohortIdx]
+		if weekIdx < 0 {
+			cohort.Visitors = count
+		} else {
+			cohort.Returning[weekIdx-cohortIdx-1] = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("解析访客留存失败: %v", err)
+	}
+
+	for i := range result.Cohorts {
+		cohort := &result.Cohorts[i]
+		for j, count := range cohort.Returning {
+			cohort.Rate[j] = ratePercent(count, cohort.Visitors)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,468 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/notify"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// 告警规则的指标
+const (
+	AlertMetricErrorRate        = "error_rate"        // 窗口内 4xx/5xx 请求的百分比
+	AlertMetricPVDrop           = "pv_drop"           // 窗口内 PV 比一周前同一时段下降的百分比
+	AlertMetricStatus5xx        = "status_5xx"        // 窗口内 5xx 请求数
+	AlertMetricSuspiciousIPs    = "suspicious_ips"    // 窗口内新出现的可疑 IP 数
+	AlertMetricIngestionStalled = "ingestion_stalled" // 最近一条日志距今的分钟数
+	AlertMetricLogMissing       = "log_missing"       // 日志文件不存在或通配符没有匹配到文件
+)
+
+const (
+	alertDefaultWindow   = 15 // 分钟
+	alertDefaultCooldown = 60 // 分钟
+	alertMaxWindow       = 24 * 60
+	alertMinRequests     = 20 // 错误率和 PV 下降在请求数低于该值时不判断，避免低流量站点误报
+	alertRetentionDays   = 90
+)
+
+// alertMetrics 支持的指标及其名称
+var alertMetrics = map[string]string{
+	AlertMetricErrorRate:        "错误率",
+	AlertMetricPVDrop:           "PV 下降",
+	AlertMetricStatus5xx:        "5xx 请求数",
+	AlertMetricSuspiciousIPs:    "新增可疑 IP",
+	AlertMetricIngestionStalled: "日志停止更新",
+	AlertMetricLogMissing:       "日志文件缺失",
+}
+
+// AlertChannel 告警的通知渠道。webhook 类渠道使用 URL，邮件渠道使用 Recipients
+type AlertChannel struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"` // webhook / slack / dingtalk / feishu / wecom / email
+	URL        string   `json:"url,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Enabled    bool     `json:"enabled"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+// AlertRule 告警规则，每次扫描日志后对范围内的每个站点求值，
+// 指标达到阈值且距上次触发超过冷却时间时通知规则的全部渠道
+type AlertRule struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	WebsiteID string  `json:"website_id"` // 站点 ID，或 "all" 表示分别检查每个站点
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	Window    int     `json:"window"`   // 统计窗口（分钟），默认 15
+	Cooldown  int     `json:"cooldown"` // 同一站点两次通知的最小间隔（分钟），默认 60
+	Channels  []int64 `json:"channels"`
+	Enabled   bool    `json:"enabled"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+// AlertEvent 一次触发的告警
+type AlertEvent struct {
+	ID          int64   `json:"id"`
+	RuleID      int64   `json:"rule_id"`
+	RuleName    string  `json:"rule_name"`
+	WebsiteID   string  `json:"website_id"`
+	Metric      string  `json:"metric"`
+	Value       float64 `json:"value"`
+	Threshold   float64 `json:"threshold"`
+	Message     string  `json:"message"`
+	FiredAt     int64   `json:"fired_at"`
+	NotifyError string  `json:"notify_error"` // 通知失败的渠道及原因，全部成功时为空
+}
+
+// validate 检查渠道类型和目标地址，并规范化收件人
+func (ch *AlertChannel) validate() error {
+	ch.Name = strings.TrimSpace(ch.Name)
+	if ch.Name == "" {
+		return fmt.Errorf("渠道名称不能为空")
+	}
+
+	switch ch.Type {
+	case notify.ChannelWebhook, notify.ChannelSlack, notify.ChannelDingTalk,
+		notify.ChannelFeishu, notify.ChannelWeCom:
+		if !strings.HasPrefix(ch.URL, "http://") && !strings.HasPrefix(ch.URL, "https://") {
+			return fmt.Errorf("webhook 地址无效: %s", ch.URL)
+		}
+		ch.Recipients = nil
+	case notify.ChannelEmail:
+		recipients := make([]string, 0, len(ch.Recipients))
+		for _, r := range ch.Recipients {
+			if strings.TrimSpace(r) == "" {
+				continue
+			}
+			addr, err := mail.ParseAddress(r)
+			if err != nil {
+				return fmt.Errorf("收件人地址无效: %s", r)
+			}
+			recipients = append(recipients, addr.Address)
+		}
+		if len(recipients) == 0 {
+			return fmt.Errorf("至少需要一个收件人")
+		}
+		ch.Recipients = recipients
+		ch.URL = ""
+	default:
+		return fmt.Errorf("不支持的渠道类型: %s", ch.Type)
+	}
+	return nil
+}
+
+// validate 检查规则的站点、指标和阈值，未设置的窗口和冷却时间使用默认值
+func (rule *AlertRule) validate() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return fmt.Errorf("规则名称不能为空")
+	}
+	if rule.WebsiteID != util.SiteScopeAll {
+		if _, ok := util.GetWebsiteByID(rule.WebsiteID); !ok {
+			return fmt.Errorf("站点 %s 不存在", rule.WebsiteID)
+		}
+	}
+
+	if _, ok := alertMetrics[rule.Metric]; !ok {
+		return fmt.Errorf("不支持的指标: %s", rule.Metric)
+	}
+	switch {
+	case rule.Metric == AlertMetricLogMissing:
+		rule.Threshold = 1
+	case rule.Threshold <= 0:
+		return fmt.Errorf("阈值必须大于 0")
+	case (rule.Metric == AlertMetricErrorRate || rule.Metric == AlertMetricPVDrop) && rule.Threshold > 100:
+		return fmt.Errorf("百分比阈值不能超过 100")
+	}
+
+	if rule.Window == 0 {
+		rule.Window = alertDefaultWindow
+	}
+	if rule.Window < 1 || rule.Window > alertMaxWindow {
+		return fmt.Errorf("统计窗口应在 1 到 %d 分钟之间", alertMaxWindow)
+	}
+	if rule.Cooldown == 0 {
+		rule.Cooldown = alertDefaultCooldown
+	}
+	if rule.Cooldown < 0 {
+		return fmt.Errorf("冷却时间不能为负数")
+	}
+
+	if len(rule.Channels) == 0 {
+		return fmt.Errorf("至少需要一个通知渠道")
+	}
+	return nil
+}
+
+// createAlertTables 创建告警渠道、规则和历史表
+func (r *Repository) createAlertTables() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS alert_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			recipients TEXT NOT NULL DEFAULT '[]',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS alert_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			website_id TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			threshold REAL NOT NULL,
+			window_minutes INTEGER NOT NULL,
+			cooldown_minutes INTEGER NOT NULL,
+			channels TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS alert_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			rule_name TEXT NOT NULL,
+			website_id TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			value REAL NOT NULL,
+			threshold REAL NOT NULL,
+			message TEXT NOT NULL,
+			fired_at INTEGER NOT NULL,
+			notify_error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_alert_history_rule ON alert_history(rule_id, website_id, fired_at);
+		CREATE INDEX IF NOT EXISTS idx_alert_history_fired ON alert_history(fired_at);
+	`)
+	return err
+}
+
+// ListAlertChannels 获取全部通知渠道
+func (r *Repository) ListAlertChannels() ([]AlertChannel, error) {
+	rows, err := r.db.Query(`
+		SELECT id, name, type, url, recipients, enabled, created_at
+		FROM alert_channels
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("查询通知渠道失败: %v", err)
+	}
+	defer rows.Close()
+
+	channels := make([]AlertChannel, 0)
+	for rows.Next() {
+		var ch AlertChannel
+		var recipients string
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Type, &ch.URL, &recipients,
+			&ch.Enabled, &ch.CreatedAt); err != nil {
+			return nil, fmt.Errorf("解析通知渠道失败: %v", err)
+		}
+		if err := json.Unmarshal([]byte(recipients), &ch.Recipients); err != nil {
+			return nil, fmt.Errorf("解析通知渠道收件人失败: %v", err)
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+// GetAlertChannel 获取一个通知渠道
+func (r *Repository) GetAlertChannel(id int64) (AlertChannel, error) {
+	channels, err := r.ListAlertChannels()
+	if err != nil {
+		return AlertChannel{}, err
+	}
+	for _, ch := range channels {
+		if ch.ID == id {
+			return ch, nil
+		}
+	}
+	return AlertChannel{}, fmt.Errorf("通知渠道 %d 不存在", id)
+}
+
+// SaveAlertChannel 新建（ID 为 0）或修改通知渠道
+func (r *Repository) SaveAlertChannel(ch AlertChannel) (AlertChannel, error) {
+	if err := ch.validate(); err != nil {
+		return ch, err
+	}
+	recipients, _ := json.Marshal(ch.Recipients)
+
+	if ch.ID == 0 {
+		ch.CreatedAt = time.Now().Unix()
+		result, err := r.db.Exec(`
+			INSERT INTO alert_channels (name, type, url, recipients, enabled, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			ch.Name, ch.Type, ch.URL, string(recipients), ch.Enabled, ch.CreatedAt)
+		if err != nil {
+			return ch, fmt.Errorf("保存通知渠道失败: %v", err)
+		}
+		ch.ID, _ = result.LastInsertId()
+		return ch, nil
+	}
+
+	current, err := r.GetAlertChannel(ch.ID)
+	if err != nil {
+		return ch, err
+	}
+	ch.CreatedAt = current.CreatedAt
+	if _, err := r.db.Exec(`
+		UPDATE alert_channels SET name = ?, type = ?, url = ?, recipients = ?, enabled = ?
+		WHERE id = ?`,
+		ch.Name, ch.Type, ch.URL, string(recipients), ch.Enabled, ch.ID); err != nil {
+		return ch, fmt.Errorf("保存通知渠道失败: %v", err)
+	}
+	return ch, nil
+}
+
+// DeleteAlertChannel 删除通知渠道，被规则使用的渠道不能删除
+func (r *Repository) DeleteAlertChannel(id int64) error {
+	rules, err := r.ListAlertRules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		for _, channelID := range rule.Channels {
+			if channelID == id {
+				return fmt.Errorf("渠道正在被规则 %s 使用，请先修改规则", rule.Name)
+			}
+		}
+	}
+	for _, channelID := range util.GetAnomalyConfig().Channels {
+		if channelID == id {
+			return fmt.Errorf("渠道正在用于流量异常通知，请先修改配置文件中的 anomaly.channels")
+		}
+	}
+
+	result, err := r.db.Exec(`DELETE FROM alert_channels WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("删除通知渠道失败: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("通知渠道 %d 不存在", id)
+	}
+	return nil
+}
+
+// ListAlertRules 获取全部告警规则
+func (r *Repository) ListAlertRules() ([]AlertRule, error) {
+	rows, err := r.db.Query(`
+		SELECT id, name, website_id, metric, threshold, window_minutes, cooldown_minutes,
+			channels, enabled, created_at
+		FROM alert_rules
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("查询告警规则失败: %v", err)
+	}
+	defer rows.Close()
+
+	rules := make([]AlertRule, 0)
+	for rows.Next() {
+		var rule AlertRule
+		var channels string
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.WebsiteID, &rule.Metric, &rule.Threshold,
+			&rule.Window, &rule.Cooldown, &channels, &rule.Enabled, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("解析告警规则失败: %v", err)
+		}
+		if err := json.Unmarshal([]byte(channels), &rule.Channels); err != nil {
+			return nil, fmt.Errorf("解析告警规则的渠道失败: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SaveAlertRule 新建（ID 为 0）或修改告警规则，规则引用的渠道必须存在
+func (r *Repository) SaveAlertRule(rule AlertRule) (AlertRule, error) {
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+	for _, channelID := range rule.Channels {
+		if _, err := r.GetAlertChannel(channelID); err != nil {
+			return rule, err
+		}
+	}
+	channels, _ := json.Marshal(rule.Channels)
+
+	if rule.ID == 0 {
+		rule.CreatedAt = time.Now().Unix()
+		result, err := r.db.Exec(`
+			INSERT INTO alert_rules (name, website_id, metric, threshold, window_minutes,
+				cooldown_minutes, channels, enabled, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Name, rule.WebsiteID, rule.Metric, rule.Threshold, rule.Window,
+			rule.Cooldown, string(channels), rule.Enabled, rule.CreatedAt)
+		if err != nil {
+			return rule, fmt.Errorf("保存告警规则失败: %v", err)
+		}
+		rule.ID, _ = result.LastInsertId()
+		return rule, nil
+	}
+
+	var createdAt int64
+	err := r.db.QueryRow(`SELECT created_at FROM alert_rules WHERE id = ?`, rule.ID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return rule, fmt.Errorf("告警规则 %d 不存在", rule.ID)
+	} else if err != nil {
+		return rule, fmt.Errorf("查询告警规则失败: %v", err)
+	}
+	rule.CreatedAt = createdAt
+	if _, err := r.db.Exec(`
+		UPDATE alert_rules SET name = ?, website_id = ?, metric = ?, threshold = ?,
+			window_minutes = ?, cooldown_minutes = ?, channels = ?, enabled = ?
+		WHERE id = ?`,
+		rule.Name, rule.WebsiteID, rule.Metric, rule.Threshold, rule.Window,
+		rule.Cooldown, string(channels), rule.Enabled, rule.ID); err != nil {
+		return rule, fmt.Errorf("保存告警规则失败: %v", err)
+	}
+	return rule, nil
+}
+
+// DeleteAlertRule 删除告警规则，已触发的历史记录保留
+func (r *Repository) DeleteAlertRule(id int64) error {
+	result, err := r.db.Exec(`DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("删除告警规则失败: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("告警规则 %d 不存在", id)
+	}
+	return nil
+}
+
+// ListAlertHistory 按时间倒序列出触发过的告警，websiteID 为空或 ruleID 为 0 时不限
+func (r *Repository) ListAlertHistory(websiteID string, ruleID int64, limit int) ([]AlertEvent, error) {
+	query := `
+		SELECT id, rule_id, rule_name, website_id, metric, value, threshold, message,
+			fired_at, notify_error
+		FROM alert_history
+		WHERE 1 = 1`
+	var args []interface{}
+	if websiteID != "" {
+		query += " AND website_id = ?"
+		args = append(args, websiteID)
+	}
+	if ruleID != 0 {
+		query += " AND rule_id = ?"
+		args = append(args, ruleID)
+	}
+	query += " ORDER BY fired_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询告警历史失败: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]AlertEvent, 0)
+	for rows.Next() {
+		var e AlertEvent
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.RuleName, &e.WebsiteID, &e.Metric, &e.Value,
+			&e.Threshold, &e.Message, &e.FiredAt, &e.NotifyError); err != nil {
+			return nil, fmt.Errorf("解析告警历史失败: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// lastAlertFiredAt 获取规则在站点上最近一次触发的时间，未触发过时为 0
+func (r *Repository) lastAlertFiredAt(ruleID int64, websiteID string) (int64, error) {
+	var firedAt sql.NullInt64
+	err := r.db.QueryRow(`
+		SELECT MAX(fired_at) FROM alert_history WHERE rule_id = ? AND website_id = ?`,
+		ruleID, websiteID).Scan(&firedAt)
+	return firedAt.Int64, err
+}
+
+// saveAlertEvent 记录触发的告警
+func (r *Repository) saveAlertEvent(event *AlertEvent) error {
+	result, err := r.db.Exec(`
+		INSERT INTO alert_history (rule_id, rule_name, website_id, metric, value, threshold,
+			message, fired_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.RuleID, event.RuleName, event.WebsiteID, event.Metric, event.Value, event.Threshold,
+		event.Message, event.FiredAt)
+	if err != nil {
+		return fmt.Errorf("保存告警历史失败: %v", err)
+	}
+	event.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// setAlertNotifyError 记录告警通知失败的原因
+func (r *Repository) setAlertNotifyError(id int64, notifyError string) error {
+	_, err := r.db.Exec(`UPDATE alert_history SET notify_error = ? WHERE id = ?`, notifyError, id)
+	return err
+}
+
+// deleteAlertHistoryBefore 删除 cutoff 之前的告警历史
+func (r *Repository) deleteAlertHistoryBefore(cutoff int64) error {
+	_, err := r.db.Exec(`DELETE FROM alert_history WHERE fired_at < ?`, cutoff)
+	return err
+}
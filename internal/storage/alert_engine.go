@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/notify"
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// alertEngine 每次扫描日志后对告警规则求值并发送通知
+type alertEngine struct {
+	repo *Repository
+}
+
+func newAlertEngine(repo *Repository) *alertEngine {
+	return &alertEngine{repo: repo}
+}
+
+// run 对全部启用的规则求值，websiteIDs 为本次扫描的站点
+func (e *alertEngine) run(websiteIDs []string, now time.Time) {
+	rules, err := e.repo.ListAlertRules()
+	if err != nil {
+		logrus.WithError(err).Error("加载告警规则失败")
+		return
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		for _, id := range websiteIDs {
+			if rule.WebsiteID != util.SiteScopeAll && rule.WebsiteID != id {
+				continue
+			}
+			if err := e.check(rule, id, now); err != nil {
+				logrus.WithError(err).Errorf("检查告警规则 %s（站点 %s）失败", rule.Name, id)
+			}
+		}
+	}
+}
+
+// check 对一个站点求值规则，达到阈值且不在冷却时间内时记录并通知
+func (e *alertEngine) check(rule AlertRule, websiteID string, now time.Time) error {
+	value, firing, err := e.evaluate(rule, websiteID, now)
+	if err != nil || !firing {
+		return err
+	}
+
+	lastFired, err := e.repo.lastAlertFiredAt(rule.ID, websiteID)
+	if err != nil {
+		return err
+	}
+	if lastFired > 0 && now.Unix()-lastFired < int64(rule.Cooldown)*60 {
+		return nil
+	}
+
+	event := AlertEvent{
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		WebsiteID: websiteID,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Message:   alertMessage(rule, websiteID, value),
+		FiredAt:   now.Unix(),
+	}
+	if err := e.repo.saveAlertEvent(&event); err != nil {
+		return err
+	}
+	logrus.Warnf("触发告警: %s", event.Message)
+
+	go e.dispatch(event, rule.Channels)
+	return nil
+}
+
+// evaluate 计算站点在窗口内的指标值，返回是否达到阈值
+func (e *alertEngine) evaluate(rule AlertRule, websiteID string, now time.Time) (float64, bool, error) {
+	end := now.Unix()
+	start := now.Add(-time.Duration(rule.Window) * time.Minute).Unix()
+
+	switch rule.Metric {
+	case AlertMetricErrorRate:
+		requests, errors, _, err := e.windowCounts(websiteID, start, end)
+		if err != nil || requests < alertMinRequests {
+			return 0, false, err
+		}
+		rate := float64(errors) * 100 / float64(requests)
+		return rate, rate >= rule.Threshold, nil
+
+	case AlertMetricStatus5xx:
+		var count int
+		err := e.repo.db.QueryRow(fmt.Sprintf(`
+			SELECT COUNT(*) FROM "%s_nginx_logs"
+			WHERE timestamp >= ? AND timestamp < ? AND status_code >= 500`, websiteID),
+			start, end).Scan(&count)
+		return float64(count), err == nil && float64(count) >= rule.Threshold, err
+
+	case AlertMetricPVDrop:
+		week := int64(7 * 24 * 3600)
+		_, _, current, err := e.windowCounts(websiteID, start, end)
+		if err != nil {
+			return 0, false, err
+		}
+		_, _, previous, err := e.windowCounts(websiteID, start-week, end-week)
+		if err != nil || previous < alertMinRequests {
+			return 0, false, err
+		}
+		drop := float64(previous-current) * 100 / float64(previous)
+		return drop, drop >= rule.Threshold, nil
+
+	case AlertMetricSuspiciousIPs:
+		var count int
+		err := e.repo.db.QueryRow(`
+			SELECT COUNT(*) FROM suspicious_ips
+			WHERE website_id = ? AND first_seen >= ? AND first_seen < ?`,
+			websiteID, start, end).Scan(&count)
+		return float64(count), err == nil && float64(count) >= rule.Threshold, err
+
+	case AlertMetricIngestionStalled:
+		_, maxTs, err := e.repo.GetLogTimeBounds(websiteID)
+		if err != nil || maxTs == 0 {
+			return 0, false, err
+		}
+		minutes := float64(end-maxTs) / 60
+		return minutes, minutes >= rule.Threshold, nil
+
+	case AlertMetricLogMissing:
+		website, ok := util.GetWebsiteByID(websiteID)
+		if !ok {
+			return 0, false, nil
+		}
+		if logFilesMissing(website.LogPath) {
+			return 1, true, nil
+		}
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("不支持的指标: %s", rule.Metric)
+}
+
+// windowCounts 统计时间范围内的请求数、错误数（4xx、5xx）和 PV
+func (e *alertEngine) windowCounts(websiteID string, start, end int64) (int, int, int, error) {
+	var requests, errors, pageviews int
+	err := e.repo.db.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(SUM(status_code >= 400), 0), COALESCE(SUM(pageview_flag = 1), 0)
+		FROM "%s_nginx_logs"
+		WHERE timestamp >= ? AND timestamp < ?`, websiteID),
+		start, end).Scan(&requests, &errors, &pageviews)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("查询窗口统计失败: %v", err)
+	}
+	return requests, errors, pageviews, nil
+}
+
+// logFilesMissing 判断站点的日志文件是否不存在，通配符路径没有匹配到任何文件时同样视为缺失
+func logFilesMissing(logPath string) bool {
+	if strings.Contains(logPath, "*") {
+		matches, err := filepath.Glob(logPath)
+		return err != nil || len(matches) == 0
+	}
+	_, err := os.Stat(logPath)
+	return err != nil
+}
+
+// alertMessage 生成告警的通知文本
+func alertMessage(rule AlertRule, websiteID string, value float64) string {
+	site := websiteID
+	if website, ok := util.GetWebsiteByID(websiteID); ok {
+		site = website.Name
+	}
+
+	var detail string
+	switch rule.Metric {
+	case AlertMetricErrorRate:
+		detail = fmt.Sprintf("最近 %d 分钟错误率 %.1f%%，阈值 %.1f%%", rule.Window, value, rule.Threshold)
+	case AlertMetricPVDrop:
+		detail = fmt.Sprintf("最近 %d 分钟 PV 比一周前同期下降 %.1f%%，阈值 %.1f%%", rule.Window, value, rule.Threshold)
+	case AlertMetricStatus5xx:
+		detail = fmt.Sprintf("最近 %d 分钟 5xx 请求 %.0f 次，阈值 %.0f 次", rule.Window, value, rule.Threshold)
+	case AlertMetricSuspiciousIPs:
+		detail = fmt.Sprintf("最近 %d 分钟新增可疑 IP %.0f 个，阈值 %.0f 个", rule.Window, value, rule.Threshold)
+	case AlertMetricIngestionStalled:
+		detail = fmt.Sprintf("已有 %.0f 分钟没有新的日志，阈值 %.0f 分钟", value, rule.Threshold)
+	case AlertMetricLogMissing:
+		detail = "日志文件不存在"
+		if website, ok := util.GetWebsiteByID(websiteID); ok {
+			detail = fmt.Sprintf("日志文件 %s 不存在", website.LogPath)
+		}
+	}
+	return fmt.Sprintf("[NixVis 告警] %s - %s: %s", rule.Name, site, detail)
+}
+
+// dispatch 将告警发送到规则的各个渠道，停用或已删除的渠道跳过，失败的渠道记录在告警历史中
+func (e *alertEngine) dispatch(event AlertEvent, channelIDs []int64) {
+	var failures []string
+	for _, id := range channelIDs {
+		ch, err := e.repo.GetAlertChannel(id)
+		if err != nil || !ch.Enabled {
+			continue
+		}
+		if err := SendAlert(ch, event); err != nil {
+			logrus.WithError(err).Warnf("通过渠道 %s 发送告警失败", ch.Name)
+			failures = append(failures, fmt.Sprintf("%s: %v", ch.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		if err := e.repo.setAlertNotifyError(event.ID, strings.Join(failures, "; ")); err != nil {
+			logrus.WithError(err).Error("记录告警通知结果失败")
+		}
+	}
+}
+
+// SendAlert 通过一个渠道发送告警
+func SendAlert(ch AlertChannel, event AlertEvent) error {
+	subject := fmt.Sprintf("[NixVis 告警] %s", event.RuleName)
+	return sendToChannel(ch, subject, event.Message, event.FiredAt, event)
+}
+
+// sendToChannel 通过渠道发送一条通知，邮件使用 subject 作为标题，通用 webhook 发送 payload 的 JSON
+func sendToChannel(ch AlertChannel, subject, message string, firedAt int64, payload interface{}) error {
+	if ch.Type == notify.ChannelEmail {
+		body := fmt.Sprintf("<p>%s</p><p style=\"color:#888\">触发时间: %s</p>",
+			html.EscapeString(message), time.Unix(firedAt, 0).Format("2006-01-02 15:04:05"))
+		return notify.SendMail(util.GetSMTPConfig(), ch.Recipients, subject, body)
+	}
+	return notify.PostWebhook(ch.Type, ch.URL, message, payload)
+}
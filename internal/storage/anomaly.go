@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// 异常检测的指标
+const (
+	AnomalyMetricPageviews = "pageviews"
+	AnomalyMetricRequests  = "requests"
+	AnomalyMetricErrorRate = "error_rate" // 百分比
+)
+
+// 异常的方向和严重程度
+const (
+	AnomalySpike = "spike"
+	AnomalyDrop  = "drop"
+
+	AnomalySeverityWarning  = "warning"
+	AnomalySeverityCritical = "critical"
+)
+
+const (
+	anomalyWeeklySamples = 4 // 基线优先使用前几周同一小时的数据
+	anomalyDailySamples  = 7 // 历史不足两周时使用前几天同一小时的数据
+	anomalyMinErrorRate  = 2 // 错误率基线标准差的下限（百分点）
+	anomalyRetentionDays = 90
+	anomalyCatchUpHours  = 24 // 重启或停机后最多补检的小时数
+)
+
+// AnomalyEvent 一个整点小时内某项指标偏离基线的异常
+type AnomalyEvent struct {
+	ID           int64   `json:"id"`
+	WebsiteID    string  `json:"website_id"`
+	Metric       string  `json:"metric"`     // pageviews / requests / error_rate
+	HourStart    int64   `json:"hour_start"` // 异常所在小时的开始时间
+	Value        float64 `json:"value"`
+	Baseline     float64 `json:"baseline"` // 历史同期的平均值
+	Score        float64 `json:"score"`    // 偏离基线的标准差倍数，下降时为负
+	Direction    string  `json:"direction"`
+	Severity     string  `json:"severity"`
+	CreatedAt    int64   `json:"created_at"`
+	Acknowledged bool    `json:"acknowledged"`
+}
+
+// hourCounts 一个小时内的请求统计
+type hourCounts struct {
+	Pageviews int
+	Requests  int
+	Errors    int
+}
+
+// anomalyDetector 在每次扫描后检测各站点到上一个整点小时为止尚未检测的小时的流量是否异常
+type anomalyDetector struct {
+	repo     *Repository
+	lastHour map[string]int64 // 各站点已检测过的最后一个小时
+}
+
+func newAnomalyDetector(repo *Repository) *anomalyDetector {
+	return &anomalyDetector{
+		repo:     repo,
+		lastHour: make(map[string]int64),
+	}
+}
+
+// createAnomalyTable 创建异常事件表，同一站点同一小时的同一指标只记录一次
+func (r *Repository) createAnomalyTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS anomalies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			website_id TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			hour_start INTEGER NOT NULL,
+			value REAL NOT NULL,
+			baseline REAL NOT NULL,
+			score REAL NOT NULL,
+			direction TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			acknowledged INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(website_id, metric, hour_start)
+		);
+		CREATE INDEX IF NOT EXISTS idx_anomalies_website_hour ON anomalies(website_id, hour_start);
+	`)
+	return err
+}
+
+// run 检测各站点上次检测之后直到上一个整点小时的每个小时，每个小时只检测一次。
+// 扫描耗时较长、服务重启或停机错过的小时会补检，已经记录过的异常不会重复通知
+func (d *anomalyDetector) run(websiteIDs []string, now time.Time) {
+	cfg := util.GetAnomalyConfig()
+	if cfg.Disabled {
+		return
+	}
+
+	for _, id := range websiteIDs {
+		for _, hour := range pendingAnomalyHours(d.lastHour[id], now) {
+			d.lastHour[id] = hour
+
+			events, err := d.detect(id, hour, cfg)
+			if err != nil {
+				// 之后的小时留到下次扫描再检测
+				logrus.WithError(err).Errorf("检测站点 %s 的流量异常失败", id)
+				break
+			}
+			for _, event := range events {
+				d.notify(event, cfg)
+			}
+		}
+	}
+}
+
+// pendingAnomalyHours 返回 lastHour 之后直到 now 的上一个整点小时之间待检测的小时，
+// 最多 anomalyCatchUpHours 个。lastHour 为 0（刚启动）时同样补检最近的这些小时
+func pendingAnomalyHours(lastHour int64, now time.Time) []int64 {
+	hour := now.Truncate(time.Hour).Add(-time.Hour).Unix()
+	from := max(lastHour+3600, hour-(anomalyCatchUpHours-1)*3600)
+
+	hours := make([]int64, 0)
+	for h := from; h <= hour; h += 3600 {
+		hours = append(hours, h)
+	}
+	return hours
+}
+
+// detect 计算站点某个小时的指标并与历史同期比较，返回新记录的异常
+func (d *anomalyDetector) detect(websiteID string, hour int64, cfg util.AnomalyConfig) ([]AnomalyEvent, error) {
+	minTs, _, err := d.repo.GetLogTimeBounds(websiteID)
+	if err != nil || minTs == 0 {
+		return nil, err
+	}
+
+	// 第 0 个区间是被检测的小时，之后依次是前几周和前几天的同一小时
+	starts := []int64{hour}
+	for k := 1; k <= anomalyWeeklySamples; k++ {
+		starts = append(starts, hour-int64(k)*7*86400)
+	}
+	for k := 1; k <= anomalyDailySamples; k++ {
+		starts = append(starts, hour-int64(k)*86400)
+	}
+	counts, err := d.hourCounts(websiteID, starts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只使用日志开始之后的完整小时作为样本
+	var samples []hourCounts
+	for i := 1; i <= anomalyWeeklySamples; i++ {
+		if starts[i] >= minTs {
+			samples = append(samples, counts[i])
+		}
+	}
+	if len(samples) < 2 {
+		samples = samples[:0]
+		for i := anomalyWeeklySamples + 1; i < len(starts); i++ {
+			if starts[i] >= minTs {
+				samples = append(samples, counts[i])
+			}
+		}
+		if len(samples) < 3 {
+			return nil, nil
+		}
+	}
+
+	current := counts[0]
+	candidates := []AnomalyEvent{
+		countAnomaly(AnomalyMetricPageviews, current.Pageviews, samples,
+			func(c hourCounts) int { return c.Pageviews }, cfg),
+		countAnomaly(AnomalyMetricRequests, current.Requests, samples,
+			func(c hourCounts) int { return c.Requests }, cfg),
+		errorRateAnomaly(current, samples, cfg),
+	}
+
+	events := make([]AnomalyEvent, 0)
+	for _, event := range candidates {
+		if event.Metric == "" {
+			continue
+		}
+		event.WebsiteID = websiteID
+		event.HourStart = hour
+		event.CreatedAt = time.Now().Unix()
+
+		saved, err := d.repo.saveAnomaly(&event)
+		if err != nil {
+			return events, err
+		}
+		if saved {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// hourCounts 批量查询多个小时的 PV、请求数和错误数
+func (d *anomalyDetector) hourCounts(websiteID string, starts []int64) ([]hourCounts, error) {
+	args := make([]any, 0, len(starts)*2)
+	for _, start := range starts {
+		args = append(args, start, start+3600)
+	}
+
+	values := make([]string, len(starts))
+	for i := range starts {
+		values[i] = fmt.Sprintf("(%d, ?, ?)", i)
+	}
+
+	rows, err := d.repo.db.Query(fmt.Sprintf(`
+        WITH time_ranges(range_index, start_time, end_time) AS (
+            VALUES %[1]s
+        )
+        SELECT
+            tr.range_index,
+            COALESCE(SUM(l.pageview_flag = 1), 0),
+            COUNT(l.id),
+            COALESCE(SUM(l.status_code >= 400), 0)
+        FROM time_ranges tr
+        LEFT JOIN "%[2]s_nginx_logs" l INDEXED BY idx_%[2]s_timestamp
+            ON l.timestamp >= tr.start_time AND l.timestamp < tr.end_time
+        GROUP BY tr.range_index`, strings.Join(values, ", "), websiteID), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询小时统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make([]hourCounts, len(starts))
+	for rows.Next() {
+		var i int
+		var c hourCounts
+		if err := rows.Scan(&i, &c.Pageviews, &c.Requests, &c.Errors); err != nil {
+			return nil, fmt.Errorf("解析小时统计失败: %v", err)
+		}
+		counts[i] = c
+	}
+	return counts, rows.Err()
+}
+
+// countAnomaly 判断计数类指标是否异常，不异常时返回零值。
+// 标准差至少取基线的平方根和 10%，避免历史数据过于平稳时微小的波动也被当作异常。
+func countAnomaly(metric string, value int, samples []hourCounts,
+	pick func(hourCounts) int, cfg util.AnomalyConfig) AnomalyEvent {
+
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = float64(pick(s))
+	}
+	mean, std := meanStd(values)
+	if float64(value) < float64(cfg.MinVolume) && mean < float64(cfg.MinVolume) {
+		return AnomalyEvent{}
+	}
+
+	spread := math.Max(std, math.Max(math.Sqrt(mean), math.Max(mean*0.1, 1)))
+	return scoreAnomaly(metric, float64(value), mean, spread, cfg)
+}
+
+// errorRateAnomaly 判断错误率是否异常升高，请求数不足时不检测
+func errorRateAnomaly(current hourCounts, samples []hourCounts, cfg util.AnomalyConfig) AnomalyEvent {
+	if current.Requests < cfg.MinVolume {
+		return AnomalyEvent{}
+	}
+
+	rates := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if s.Requests > 0 {
+			rates = append(rates, float64(s.Errors)/float64(s.Requests)*100)
+		}
+	}
+	if len(rates) == 0 {
+		return AnomalyEvent{}
+	}
+	mean, std := meanStd(rates)
+	rate := float64(current.Errors) / float64(current.Requests) * 100
+
+	event := scoreAnomaly(AnomalyMetricErrorRate, rate, mean, math.Max(std, anomalyMinErrorRate), cfg)
+	if event.Direction != AnomalySpike {
+		// 错误率下降不是问题
+		return AnomalyEvent{}
+	}
+	return event
+}
+
+// scoreAnomaly 偏离超过阈值时为警告，超过两倍阈值时为严重
+func scoreAnomaly(metric string, value, baseline, spread float64, cfg util.AnomalyConfig) AnomalyEvent {
+	score := (value - baseline) / spread
+	if math.Abs(score) < cfg.Threshold {
+		return AnomalyEvent{}
+	}
+
+	event := AnomalyEvent{
+		Metric:    metric,
+		Value:     math.Round(value*100) / 100,
+		Baseline:  math.Round(baseline*100) / 100,
+		Score:     math.Round(score*100) / 100,
+		Direction: AnomalySpike,
+		Severity:  AnomalySeverityWarning,
+	}
+	if score < 0 {
+		event.Direction = AnomalyDrop
+	}
+	if math.Abs(score) >= cfg.Threshold*2 {
+		event.Severity = AnomalySeverityCritical
+	}
+	return event
+}
+
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// notify 记录日志，并在配置了告警渠道时异步发送通知
+func (d *anomalyDetector) notify(event AnomalyEvent, cfg util.AnomalyConfig) {
+	logrus.Warnf("站点 %s 在 %s 出现流量异常: %s %s，当前 %.2f，基线 %.2f（%s）",
+		event.WebsiteID, time.Unix(event.HourStart, 0).Format("2006-01-02 15:04"),
+		event.Metric, event.Direction, event.Value, event.Baseline, event.Severity)
+
+	if len(cfg.Channels) > 0 {
+		go d.dispatch(event, cfg.Channels)
+	}
+}
+
+// dispatch 通过告警渠道发送异常事件，停用或已删除的渠道跳过。通用 webhook 渠道收到异常事件的 JSON
+func (d *anomalyDetector) dispatch(event AnomalyEvent, channelIDs []int64) {
+	subject, message := anomalyMessage(event)
+	for _, id := range channelIDs {
+		ch, err := d.repo.GetAlertChannel(id)
+		if err != nil || !ch.Enabled {
+			continue
+		}
+		if err := sendToChannel(ch, subject, message, event.CreatedAt, event); err != nil {
+			logrus.WithError(err).Warnf("通过渠道 %s 发送流量异常失败", ch.Name)
+		}
+	}
+}
+
+// anomalyMetricNames 异常指标的显示名称
+var anomalyMetricNames = map[string]string{
+	AnomalyMetricPageviews: "PV",
+	AnomalyMetricRequests:  "请求数",
+	AnomalyMetricErrorRate: "错误率",
+}
+
+// anomalyMessage 生成异常的通知标题和文本
+func anomalyMessage(event AnomalyEvent) (string, string) {
+	site := event.WebsiteID
+	if website, ok := util.GetWebsiteByID(event.WebsiteID); ok {
+		site = website.Name
+	}
+
+	metric := anomalyMetricNames[event.Metric]
+	change := "突增"
+	if event.Direction == AnomalyDrop {
+		change = "骤降"
+	}
+	severity := "警告"
+	if event.Severity == AnomalySeverityCritical {
+		severity = "严重"
+	}
+	unit := ""
+	if event.Metric == AnomalyMetricErrorRate {
+		unit = "%"
+	}
+
+	subject := fmt.Sprintf("[NixVis 流量异常] %s %s%s", site, metric, change)
+	message := fmt.Sprintf("[NixVis 流量异常] %s: %s 点的%s%s，当前 %.1f%s，历史同期 %.1f%s（%s）",
+		site, time.Unix(event.HourStart, 0).Format("2006-01-02 15"),
+		metric, change, event.Value, unit, event.Baseline, unit, severity)
+	return subject, message
+}
+
+// saveAnomaly 记录异常，同一小时的同一指标已记录过时返回 false
+func (r *Repository) saveAnomaly(event *AnomalyEvent) (bool, error) {
+	result, err := r.db.Exec(`
+		INSERT INTO anomalies (
+			website_id, metric, hour_start, value, baseline, score, direction, severity, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(website_id, metric, hour_start) DO NOTHING`,
+		event.WebsiteID, event.Metric, event.HourStart, event.Value, event.Baseline,
+		event.Score, event.Direction, event.Severity, event.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("保存流量异常失败: %v", err)
+	}
+	count, _ := result.RowsAffected()
+	if count == 0 {
+		return false, nil
+	}
+	event.ID, _ = result.LastInsertId()
+	return true, nil
+}
+
+// ListAnomalies 按时间倒序列出异常，websiteID 为空时列出全部站点，severity 为空时不限
+func (r *Repository) ListAnomalies(websiteID, severity string, limit int) ([]AnomalyEvent, error) {
+	query := `
+		SELECT id, website_id, metric, hour_start, value, baseline, score,
+			direction, severity, created_at, acknowledged
+		FROM anomalies
+		WHERE 1 = 1`
+	var args []interface{}
+	if websiteID != "" {
+		query += " AND website_id = ?"
+		args = append(args, websiteID)
+	}
+	if severity != "" {
+		query += " AND severity = ?"
+		args = append(args, severity)
+	}
+	query += " ORDER BY hour_start DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询流量异常失败: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]AnomalyEvent, 0)
+	for rows.Next() {
+		var e AnomalyEvent
+		var acknowledged int
+		if err := rows.Scan(&e.ID, &e.WebsiteID, &e.Metric, &e.HourStart, &e.Value, &e.Baseline,
+			&e.Score, &e.Direction, &e.Severity, &e.CreatedAt, &acknowledged); err != nil {
+			return nil, fmt.Errorf("解析流量异常失败: %v", err)
+		}
+		e.Acknowledged = acknowledged == 1
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// AcknowledgeAnomaly 将异常标记为已确认
+func (r *Repository) AcknowledgeAnomaly(websiteID string, id int64) error {
+	result, err := r.db.Exec(
+		`UPDATE anomalies SET acknowledged = 1 WHERE id = ? AND website_id = ?`, id, websiteID)
+	if err != nil {
+		return fmt.Errorf("确认流量异常失败: %v", err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("异常 %d 不存在", id)
+	}
+	return nil
+}
+
+// deleteAnomaliesBefore 删除 cutoff 之前的异常
+func (r *Repository) deleteAnomaliesBefore(cutoff int64) error {
+	_, err := r.db.Exec(`DELETE FROM anomalies WHERE hour_start < ?`, cutoff)
+	return err
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func TestMeanStd(t *testing.T) {
+	tests := []struct {
+		values []float64
+		mean   float64
+		std    float64
+	}{
+		{nil, 0, 0},
+		{[]float64{3}, 3, 0},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2},
+	}
+
+	for _, tt := range tests {
+		mean, std := meanStd(tt.values)
+		if math.Abs(mean-tt.mean) > 1e-9 || math.Abs(std-tt.std) > 1e-9 {
+			t.Errorf("meanStd(%v) = %v, %v, 期望 %v, %v", tt.values, mean, std, tt.mean, tt.std)
+		}
+	}
+}
+
+func TestCountAnomaly(t *testing.T) {
+	cfg := util.AnomalyConfig{Threshold: 3, MinVolume: 20}
+	samples := func(values ...int) []hourCounts {
+		counts := make([]hourCounts, len(values))
+		for i, v := range values {
+			counts[i].Pageviews = v
+		}
+		return counts
+	}
+
+	tests := []struct {
+		name      string
+		value     int
+		samples   []hourCounts
+		direction string // 为空时不应判为异常
+		severity  string
+		score     float64
+	}{
+		// 基线平稳时标准差取基线的平方根 10
+		{"波动在阈值内", 125, samples(100, 100, 100, 100), "", "", 0},
+		{"突增", 140, samples(100, 100, 100, 100), AnomalySpike, AnomalySeverityWarning, 4},
+		{"超过两倍阈值为严重", 170, samples(100, 100, 100, 100), AnomalySpike, AnomalySeverityCritical, 7},
+		{"骤降", 60, samples(100, 100, 100, 100), AnomalyDrop, AnomalySeverityWarning, -4},
+		{"使用历史的标准差", 140, samples(50, 150, 50, 150), "", "", 0},
+		{"当前值和基线都低于最小量", 15, samples(5, 5, 5), "", "", 0},
+		{"低流量站点突然增加", 30, samples(5, 5, 5), AnomalySpike, AnomalySeverityCritical, 11.18},
+		{"没有历史时标准差至少为 1", 23, samples(0, 0, 0), AnomalySpike, AnomalySeverityCritical, 23},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := countAnomaly(AnomalyMetricPageviews, tt.value, tt.samples,
+				func(c hourCounts) int { return c.Pageviews }, cfg)
+			if tt.direction == "" {
+				if event.Metric != "" {
+					t.Errorf("不应判为异常: %+v", event)
+				}
+				return
+			}
+			if event.Metric != AnomalyMetricPageviews || event.Direction != tt.direction ||
+				event.Severity != tt.severity || event.Score != tt.score {
+				t.Errorf("异常 = %s %s %v, 期望 %s %s %v",
+					event.Direction, event.Severity, event.Score, tt.direction, tt.severity, tt.score)
+			}
+		})
+	}
+}
+
+func TestErrorRateAnomaly(t *testing.T) {
+	cfg := util.AnomalyConfig{Threshold: 3, MinVolume: 20}
+	baseline := []hourCounts{
+		{Requests: 100, Errors: 2},
+		{Requests: 200, Errors: 4},
+		{Requests: 50, Errors: 1},
+	}
+
+	tests := []struct {
+		name     string
+		current  hourCounts
+		samples  []hourCounts
+		severity string // 为空时不应判为异常
+	}{
+		// 基线错误率 2%，标准差取下限 2 个百分点
+		{"错误率升高", hourCounts{Requests: 100, Errors: 10}, baseline, AnomalySeverityWarning},
+		{"错误率大幅升高", hourCounts{Requests: 100, Errors: 14}, baseline, AnomalySeverityCritical},
+		{"波动在阈值内", hourCounts{Requests: 100, Errors: 7}, baseline, ""},
+		{"错误率下降不算异常", hourCounts{Requests: 1000, Errors: 0}, baseline, ""},
+		{"请求数不足", hourCounts{Requests: 10, Errors: 10}, baseline, ""},
+		{"历史没有请求", hourCounts{Requests: 100, Errors: 50}, []hourCounts{{}, {}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := errorRateAnomaly(tt.current, tt.samples, cfg)
+			if tt.severity == "" {
+				if event.Metric != "" {
+					t.Errorf("不应判为异常: %+v", event)
+				}
+				return
+			}
+			if event.Metric != AnomalyMetricErrorRate || event.Direction != AnomalySpike || event.Severity != tt.severity {
+				t.Errorf("异常 = %s %s %s, 期望 %s spike %s",
+					event.Metric, event.Direction, event.Severity, AnomalyMetricErrorRate, tt.severity)
+			}
+			if event.Baseline != 2 {
+				t.Errorf("基线 = %v, 期望 2", event.Baseline)
+			}
+		})
+	}
+}
+
+func TestPendingAnomalyHours(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	previous := time.Date(2024, 5, 10, 11, 0, 0, 0, time.UTC).Unix()
+
+	tests := []struct {
+		name     string
+		lastHour int64
+		want     []int64
+	}{
+		{"已检测过上一个小时", previous, []int64{}},
+		{"只差上一个小时", previous - 3600, []int64{previous}},
+		{"补检错过的小时", previous - 3*3600, []int64{previous - 2*3600, previous - 3600, previous}},
+		{"最晚的检测在未来时不检测", previous + 3600, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pendingAnomalyHours(tt.lastHour, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pendingAnomalyHours = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+
+	// 刚启动或停机很久时最多补检 anomalyCatchUpHours 个小时
+	for _, lastHour := range []int64{0, previous - 100*3600} {
+		got := pendingAnomalyHours(lastHour, now)
+		if len(got) != anomalyCatchUpHours || got[0] != previous-(anomalyCatchUpHours-1)*3600 ||
+			got[len(got)-1] != previous {
+			t.Errorf("pendingAnomalyHours(%d) = %v, 期望最近的 %d 个小时", lastHour, got, anomalyCatchUpHours)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveDateLayout   = "2006-01-02"
+)
+
+var archiveMutex sync.Mutex
+
+// ArchiveEntry 归档清单中的一项，对应某个站点某一天的日志
+type ArchiveEntry struct {
+	WebsiteID string `json:"website_id"`
+	Date      string `json:"date"` // 2006-01-02，本地时区
+	File      string `json:"file"` // 相对于归档目录的路径
+	Format    string `json:"format"`
+	Rows      int    `json:"rows"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// ArchiveManifest 归档清单
+type ArchiveManifest struct {
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+// find 查找指定站点、日期的归档项
+func (m *ArchiveManifest) find(websiteID, date string) (ArchiveEntry, bool) {
+	for _, entry := range m.Entries {
+		if entry.WebsiteID == websiteID && entry.Date == date {
+			return entry, true
+		}
+	}
+	return ArchiveEntry{}, false
+}
+
+// put 添加或替换归档项
+func (m *ArchiveManifest) put(entry ArchiveEntry) {
+	for i := range m.Entries {
+		if m.Entries[i].WebsiteID == entry.WebsiteID && m.Entries[i].Date == entry.Date {
+			m.Entries[i] = entry
+			return
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+}
+
+// loadArchiveManifest 读取归档清单，不存在时返回空清单
+func loadArchiveManifest(dir string) (*ArchiveManifest, error) {
+	manifest := &ArchiveManifest{Entries: make([]ArchiveEntry, 0)}
+
+	data, err := os.ReadFile(filepath.Join(dir, archiveManifestName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取归档清单失败: %v", err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("解析归档清单失败: %v", err)
+	}
+	return manifest, nil
+}
+
+// saveArchiveManifest 先写临时文件再重命名，避免清单写到一半损坏
+func saveArchiveManifest(dir string, manifest *ArchiveManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, archiveManifestName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("写入归档清单失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("保存归档清单失败: %v", err)
+	}
+	return nil
+}
+
+// archiveAndDeleteExpired 将表中 cutoffDay 之前的日志按天归档，归档成功的天才会被删除
+func (r *Repository) archiveAndDeleteExpired(
+	cfg util.ArchiveConfig, tableName string, cutoffDay time.Time) (int, error) {
+
+	websiteID := strings.TrimSuffix(tableName, "_nginx_logs")
+
+	var minTimestamp *int64
+	err := r.db.QueryRow(
+		fmt.Sprintf(`SELECT MIN(timestamp) FROM "%s" WHERE timestamp < ?`, tableName),
+		cutoffDay.Unix()).Scan(&minTimestamp)
+	if err != nil {
+		return 0, fmt.Errorf("查询最早日志时间失败: %v", err)
+	}
+	if minTimestamp == nil {
+		return 0, nil
+	}
+
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(cfg.Dir, websiteID), 0755); err != nil {
+		return 0, fmt.Errorf("创建归档目录失败: %v", err)
+	}
+
+	manifest, err := loadArchiveManifest(cfg.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedCount := 0
+	first := time.Unix(*minTimestamp, 0)
+	for day := startOfDay(first); day.Before(cutoffDay); day = day.AddDate(0, 0, 1) {
+		date := day.Format(archiveDateLayout)
+		dayEnd := day.AddDate(0, 0, 1)
+
+		entry, archived := manifest.find(websiteID, date)
+		if archived {
+			// 已归档的天（例如按需恢复过的数据）只需确认归档文件仍然存在
+			if _, err := os.Stat(filepath.Join(cfg.Dir, entry.File)); err != nil {
+				archived = false
+			}
+		}
+
+		maxID := int64(-1)
+		if !archived {
+			entry, maxID, err = r.exportDay(cfg, tableName, websiteID, day, dayEnd)
+			if err != nil {
+				// 归档失败时保留该天的数据，等待下次重试
+				logrus.WithError(err).Errorf("归档站点 %s 在 %s 的日志失败，跳过删除", websiteID, date)
+				continue
+			}
+			if entry.Rows == 0 {
+				continue
+			}
+			manifest.put(entry)
+			if err := saveArchiveManifest(cfg.Dir, manifest); err != nil {
+				logrus.WithError(err).Errorf("站点 %s 在 %s 的归档清单保存失败，跳过删除", websiteID, date)
+				continue
+			}
+		}
+
+		where := "timestamp >= ? AND timestamp < ?"
+		args := []interface{}{day.Unix(), dayEnd.Unix()}
+		if maxID >= 0 {
+			// 只删除已经写入归档文件的行
+			where += " AND id <= ?"
+			args = append(args, maxID)
+		}
+
+		count, err := r.deleteLogs(websiteID, where, args...)
+		if err != nil {
+			logrus.WithError(err).Errorf("删除站点 %s 在 %s 的已归档日志失败", websiteID, date)
+			continue
+		}
+		deletedCount += int(count)
+	}
+
+	return deletedCount, nil
+}
+
+// exportDay 将某一天的日志写入压缩的 NDJSON 文件，返回归档项和写入的最大行 ID
+func (r *Repository) exportDay(cfg util.ArchiveConfig, tableName, websiteID string,
+	dayStart, dayEnd time.Time) (ArchiveEntry, int64, error) {
+
+	date := dayStart.Format(archiveDateLayout)
+	relPath := filepath.Join(websiteID, date+"."+cfg.Format)
+	path := filepath.Join(cfg.Dir, relPath)
+
+	entry := ArchiveEntry{
+		WebsiteID: websiteID,
+		Date:      date,
+		File:      relPath,
+		Format:    cfg.Format,
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT %s
+        FROM "%s"
+        WHERE timestamp >= ? AND timestamp < ?
+        ORDER BY id`, logRecordColumns, tableName), dayStart.Unix(), dayEnd.Unix())
+	if err != nil {
+		return entry, 0, fmt.Errorf("查询待归档日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	written, err := writeLogsNDJSON(rows, path)
+	if err != nil {
+		return entry, 0, err
+	}
+	if written.Rows == 0 {
+		return entry, 0, nil
+	}
+
+	entry.Rows = written.Rows
+	entry.Size = written.Size
+	entry.SHA256 = written.SHA256
+	entry.CreatedAt = time.Now().Unix()
+
+	logrus.Infof("站点 %s 在 %s 的 %d 条日志已归档到 %s", websiteID, date, entry.Rows, path)
+	return entry, written.MaxID, nil
+}
+
+// logRecordColumns 导出日志时读取的列，顺序与 scanLogRecord 一致
+const logRecordColumns = `id, ip, pageview_flag, timestamp, method, url, status_code, bytes_sent,
+            referer, user_browser, user_os, user_device, browser_major, browser_version, os_major, os_version,
+            device_brand, device_model, domestic_location, global_location, city, isp,
+            is_spider, spider_type, spider_name, is_suspicious, suspicious_type, suspicious_reason,
+            session_id`
+
+// scanLogRecord 将一行 logRecordColumns 查询结果读入记录
+func scanLogRecord(rows *sql.Rows, record *NginxLogRecord) error {
+	var timestamp int64
+	if err := rows.Scan(&record.ID, &record.IP, &record.PageviewFlag, &timestamp,
+		&record.Method, &record.Url, &record.Status, &record.BytesSent, &record.Referer,
+		&record.UserBrowser, &record.UserOs, &record.UserDevice,
+		&record.BrowserMajor, &record.BrowserVersion, &record.OsMajor, &record.OsVersion,
+		&record.DeviceBrand, &record.DeviceModel,
+		&record.DomesticLocation, &record.GlobalLocation, &record.City, &record.ISP,
+		&record.IsSpider, &record.SpiderType, &record.SpiderName,
+		&record.IsSuspicious, &record.SuspiciousType, &record.SuspiciousReason,
+		&record.SessionID); err != nil {
+		return err
+	}
+	record.Timestamp = time.Unix(timestamp, 0)
+	return nil
+}
+
+// ndjsonResult 写入 NDJSON 文件的结果
+type ndjsonResult struct {
+	Rows   int
+	MaxID  int64
+	Size   int64
+	SHA256 string
+}
+
+// writeLogsNDJSON 将查询结果逐行写入 gzip 压缩的 NDJSON 文件。
+// 先写临时文件，成功后再重命名；没有数据时不创建文件。
+func writeLogsNDJSON(rows *sql.Rows, path string) (ndjsonResult, error) {
+	result := ndjsonResult{}
+	tmpPath := path + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return result, fmt.Errorf("创建导出文件失败: %v", err)
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, hash))
+	encoder := json.NewEncoder(gz)
+
+	for rows.Next() {
+		var record NginxLogRecord
+		if err := scanLogRecord(rows, &record); err != nil {
+			return result, fmt.Errorf("读取待导出日志失败: %v", err)
+		}
+		if err := encoder.Encode(&record); err != nil {
+			return result, fmt.Errorf("写入导出文件失败: %v", err)
+		}
+		result.Rows++
+		result.MaxID = record.ID
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("遍历待导出日志失败: %v", err)
+	}
+
+	if result.Rows == 0 {
+		return result, nil
+	}
+
+	if err := gz.Close(); err != nil {
+		return result, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return result, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return result, err
+	}
+	if err := file.Close(); err != nil {
+		return result, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return result, fmt.Errorf("保存导出文件失败: %v", err)
+	}
+
+	result.Size = info.Size()
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return result, nil
+}
+
+// ListArchivedDays 列出站点已归档的日期，按日期倒序
+func (r *Repository) ListArchivedDays(websiteID string) ([]ArchiveEntry, error) {
+	cfg := util.GetArchiveConfig()
+
+	archiveMutex.Lock()
+	manifest, err := loadArchiveManifest(cfg.Dir)
+	archiveMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ArchiveEntry, 0)
+	for _, entry := range manifest.Entries {
+		if entry.WebsiteID == websiteID {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Date > entries[j].Date
+	})
+	return entries, nil
+}
+
+// RestoreArchivedDay 将某一天的归档日志在一个事务中重新导入站点表，返回导入的行数，
+// 中途失败时不会留下半天的数据。导入后按入库时的规则重新划分会话。
+// 恢复的数据会在下次清理时直接删除，不会重复归档。
+// 会话 ID 从已有的最大值开始分配，服务运行时应通过 LogParser.RestoreArchivedDay 调用，避免与入库同时进行
+func (r *Repository) RestoreArchivedDay(websiteID, date string) (int, error) {
+	day, err := time.ParseInLocation(archiveDateLayout, date, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("日期格式无效: %s", date)
+	}
+
+	cfg := util.GetArchiveConfig()
+
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	manifest, err := loadArchiveManifest(cfg.Dir)
+	if err != nil {
+		return 0, err
+	}
+	entry, ok := manifest.find(websiteID, date)
+	if !ok {
+		return 0, fmt.Errorf("站点 %s 在 %s 没有归档数据", websiteID, date)
+	}
+
+	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
+	var existing int
+	err = r.db.QueryRow(
+		fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE timestamp >= ? AND timestamp < ?`, tableName),
+		day.Unix(), day.AddDate(0, 0, 1).Unix()).Scan(&existing)
+	if err != nil {
+		return 0, fmt.Errorf("检查已有数据失败: %v", err)
+	}
+	if existing > 0 {
+		return 0, fmt.Errorf("站点 %s 在 %s 已有 %d 条日志，无需恢复", websiteID, date, existing)
+	}
+
+	file, err := os.Open(filepath.Join(cfg.Dir, entry.File))
+	if err != nil {
+		return 0, fmt.Errorf("打开归档文件失败: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, fmt.Errorf("读取归档文件失败: %v", err)
+	}
+	defer gz.Close()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	const batchSize = 1000
+	batch := make([]NginxLogRecord, 0, batchSize)
+	restored := 0
+
+	decoder := json.NewDecoder(gz)
+	for {
+		var record NginxLogRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return restored, fmt.Errorf("解析归档文件失败: %v", err)
+		}
+		// 旧版本的归档没有城市、运营商、版本和设备品牌
+		if record.City == "" {
+			record.City = "未知"
+		}
+		if record.ISP == "" {
+			record.ISP = "未知"
+		}
+		if record.BrowserMajor == "" {
+			record.BrowserMajor, record.BrowserVersion = record.UserBrowser, record.UserBrowser
+			record.OsMajor, record.OsVersion = record.UserOs, record.UserOs
+			record.DeviceBrand, record.DeviceModel = "未知品牌", "未知型号"
+		}
+		// 归档时的会话已经随日志过期删除，恢复后重新划分
+		record.SessionID = 0
+
+		batch = append(batch, record)
+		if len(batch) >= batchSize {
+			if err := r.insertLogs(tx, websiteID, batch); err != nil {
+				return 0, fmt.Errorf("恢复归档日志失败: %v", err)
+			}
+			restored += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := r.insertLogs(tx, websiteID, batch); err != nil {
+			return 0, fmt.Errorf("恢复归档日志失败: %v", err)
+		}
+		restored += len(batch)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("提交恢复的日志失败: %v", err)
+	}
+	logrus.Infof("站点 %s 在 %s 的 %d 条归档日志已恢复", websiteID, date, restored)
+
+	// 会话划分失败不影响已经恢复的日志，只记录错误
+	if _, err := r.assignSessions(websiteID, day.Unix(), day.AddDate(0, 0, 1).Unix()); err != nil {
+		logrus.WithError(err).Errorf("为站点 %s 在 %s 恢复的日志划分会话失败", websiteID, date)
+	}
+	return restored, nil
+}
+
+// startOfDay 返回指定时间当天的零点
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
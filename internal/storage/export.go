@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// 导出任务的状态
+const (
+	ExportPending = "pending" // 等待空闲的导出槽位
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// exportRetentionDays 导出文件及任务记录的保留天数
+const exportRetentionDays = 7
+
+// ExportJob 后台导出任务，生成的文件保存在数据目录的 exports 下，
+// 保留 exportRetentionDays 天后随任务记录一起删除
+type ExportJob struct {
+	ID         int64             `json:"id"`
+	UserID     int64             `json:"user_id"`
+	WebsiteID  string            `json:"website_id"`
+	Type       string            `json:"type"`   // logs 或统计类型
+	Format     string            `json:"format"` // csv / ndjson / xlsx
+	Params     map[string]string `json:"params"` // 与 /api/stats/:type 相同的查询参数
+	Status     string            `json:"status"`
+	Rows       int               `json:"rows"` // 已写入的行数，导出过程中定期更新
+	Size       int64             `json:"size"` // 文件大小（字节）
+	Error      string            `json:"error"`
+	FileName   string            `json:"file_name"` // 下载时使用的文件名
+	CreatedAt  int64             `json:"created_at"`
+	FinishedAt int64             `json:"finished_at"`
+}
+
+// FilePath 导出文件在磁盘上的路径
+func (j ExportJob) FilePath() string {
+	return filepath.Join(util.DataDir, "exports", fmt.Sprintf("%d.%s", j.ID, j.Format))
+}
+
+// createExportTable 创建导出任务表
+func (r *Repository) createExportTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS export_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			website_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			format TEXT NOT NULL,
+			params TEXT NOT NULL,
+			status TEXT NOT NULL,
+			rows INTEGER NOT NULL DEFAULT 0,
+			size INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			file_name TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			finished_at INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_export_jobs_user ON export_jobs(user_id, created_at);
+	`)
+	return err
+}
+
+const exportColumns = `id, user_id, website_id, type, format, params, status, rows, size, error,
+	file_name, created_at, finished_at`
+
+func scanExportJob(rows interface{ Scan(...interface{}) error }) (ExportJob, error) {
+	var j ExportJob
+	var params string
+	if err := rows.Scan(&j.ID, &j.UserID, &j.WebsiteID, &j.Type, &j.Format, &params, &j.Status,
+		&j.Rows, &j.Size, &j.Error, &j.FileName, &j.CreatedAt, &j.FinishedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return j, err
+		}
+		return j, fmt.Errorf("解析导出任务失败: %v", err)
+	}
+	if err := json.Unmarshal([]byte(params), &j.Params); err != nil {
+		return j, fmt.Errorf("解析导出参数失败: %v", err)
+	}
+	return j, nil
+}
+
+// ListExportJobs 获取用户的导出任务，最新的在前
+func (r *Repository) ListExportJobs(userID int64) ([]ExportJob, error) {
+	rows, err := r.db.Query(`
+		SELECT `+exportColumns+`
+		FROM export_jobs
+		WHERE user_id = ?
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("查询导出任务失败: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]ExportJob, 0)
+	for rows.Next() {
+		j, err := scanExportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// GetExportJob 获取用户的一个导出任务
+func (r *Repository) GetExportJob(userID, id int64) (ExportJob, error) {
+	row := r.db.QueryRow(`
+		SELECT `+exportColumns+`
+		FROM export_jobs
+		WHERE id = ? AND user_id = ?`, id, userID)
+	j, err := scanExportJob(row)
+	if err == sql.ErrNoRows {
+		return j, fmt.Errorf("导出任务不存在")
+	}
+	return j, err
+}
+
+// CreateExportJob 新建等待执行的导出任务，返回带 ID 的任务
+func (r *Repository) CreateExportJob(job ExportJob) (ExportJob, error) {
+	job.Status = ExportPending
+	job.Rows, job.Size, job.Error, job.FinishedAt = 0, 0, "", 0
+	job.CreatedAt = time.Now().Unix()
+	if job.Params == nil {
+		job.Params = map[string]string{}
+	}
+
+	params, _ := json.Marshal(job.Params)
+	result, err := r.db.Exec(`
+		INSERT INTO export_jobs (user_id, website_id, type, format, params, status, file_name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.UserID, job.WebsiteID, job.Type, job.Format, string(params), job.Status, job.FileName, job.CreatedAt)
+	if err != nil {
+		return job, fmt.Errorf("保存导出任务失败: %v", err)
+	}
+	job.ID, _ = result.LastInsertId()
+	return job, nil
+}
+
+// UpdateExportProgress 更新导出任务的状态和已写入的行数
+func (r *Repository) UpdateExportProgress(id int64, status string, rows int) error {
+	if _, err := r.db.Exec(`UPDATE export_jobs SET status = ?, rows = ? WHERE id = ?`,
+		status, rows, id); err != nil {
+		return fmt.Errorf("更新导出任务失败: %v", err)
+	}
+	return nil
+}
+
+// FinishExportJob 记录导出任务的结果，exportErr 不为空时任务失败
+func (r *Repository) FinishExportJob(id int64, rows int, size int64, exportErr error) error {
+	status, errMsg := ExportDone, ""
+	if exportErr != nil {
+		status, errMsg = ExportFailed, exportErr.Error()
+	}
+	if _, err := r.db.Exec(`
+		UPDATE export_jobs SET status = ?, rows = ?, size = ?, error = ?, finished_at = ?
+		WHERE id = ?`,
+		status, rows, size, errMsg, time.Now().Unix(), id); err != nil {
+		return fmt.Errorf("更新导出任务失败: %v", err)
+	}
+	return nil
+}
+
+// FailUnfinishedExportJobs 将未完成的任务标记为失败，用于服务启动时处理上次中断的任务
+func (r *Repository) FailUnfinishedExportJobs() error {
+	if _, err := r.db.Exec(`
+		UPDATE export_jobs SET status = ?, error = '服务重启，导出中断', finished_at = ?
+		WHERE status IN (?, ?)`,
+		ExportFailed, time.Now().Unix(), ExportPending, ExportRunning); err != nil {
+		return fmt.Errorf("更新导出任务失败: %v", err)
+	}
+	return nil
+}
+
+// DeleteExportJob 删除用户的导出任务及其文件
+func (r *Repository) DeleteExportJob(userID, id int64) error {
+	job, err := r.GetExportJob(userID, id)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(`DELETE FROM export_jobs WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("删除导出任务失败: %v", err)
+	}
+	if err := os.Remove(job.FilePath()); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Warnf("删除导出文件 %s 失败", job.FilePath())
+	}
+	return nil
+}
+
+// deleteExportJobsBefore 删除创建时间早于 cutoff 的导出任务及其文件
+func (r *Repository) deleteExportJobsBefore(cutoff int64) error {
+	rows, err := r.db.Query(`SELECT id, format FROM export_jobs WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return fmt.Errorf("查询过期的导出任务失败: %v", err)
+	}
+	var jobs []ExportJob
+	for rows.Next() {
+		var j ExportJob
+		if err := rows.Scan(&j.ID, &j.Format); err != nil {
+			rows.Close()
+			return fmt.Errorf("解析导出任务失败: %v", err)
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		if err := os.Remove(j.FilePath()); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warnf("删除导出文件 %s 失败", j.FilePath())
+		}
+	}
+	if _, err := r.db.Exec(`DELETE FROM export_jobs WHERE created_at < ?`, cutoff); err != nil {
+		return fmt.Errorf("删除过期的导出任务失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 目标的 URL 匹配方式
+const (
+	GoalMatchExact  = "exact"
+	GoalMatchPrefix = "prefix"
+	GoalMatchRegex  = "regex"
+)
+
+// Goal 转化目标，按 URL 匹配请求，可选限定请求方法和状态码。
+// 目标在查询时对日志求值，新建的目标对已入库的数据同样生效。
+type Goal struct {
+	ID        int64  `json:"id"`
+	WebsiteID string `json:"website_id"`
+	Name      string `json:"name"`
+	MatchType string `json:"match_type"` // exact / prefix / regex
+	Pattern   string `json:"pattern"`
+	Method    string `json:"method"` // 为空表示不限
+	Status    int    `json:"status"` // 0 表示不限
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Funnel 漏斗，由按顺序排列的目标组成，在同一次访问内依次达成才计入下一步
+type Funnel struct {
+	ID        int64   `json:"id"`
+	WebsiteID string  `json:"website_id"`
+	Name      string  `json:"name"`
+	Steps     []int64 `json:"steps"` // 目标 ID
+	CreatedAt int64   `json:"created_at"`
+}
+
+// Condition 返回匹配该目标的 SQL 条件及参数，alias 为日志表的别名。
+// 蜘蛛请求不计入目标。
+func (g Goal) Condition(alias string) (string, []interface{}) {
+	col := func(name string) string {
+		return alias + "." + name
+	}
+
+	var conds []string
+	var args []interface{}
+
+	switch g.MatchType {
+	case GoalMatchPrefix:
+		conds = append(conds, fmt.Sprintf("substr(%s, 1, length(?)) = ?", col("url")))
+		args = append(args, g.Pattern, g.Pattern)
+	case GoalMatchRegex:
+		conds = append(conds, fmt.Sprintf("%s REGEXP ?", col("url")))
+		args = append(args, g.Pattern)
+	default:
+		conds = append(conds, fmt.Sprintf("%s = ?", col("url")))
+		args = append(args, g.Pattern)
+	}
+
+	if g.Method != "" {
+		conds = append(conds, fmt.Sprintf("%s = ?", col("method")))
+		args = append(args, g.Method)
+	}
+	if g.Status != 0 {
+		conds = append(conds, fmt.Sprintf("%s = ?", col("status_code")))
+		args = append(args, g.Status)
+	}
+	conds = append(conds, fmt.Sprintf("%s = 0", col("is_spider")))
+
+	return strings.Join(conds, " AND "), args
+}
+
+// validate 检查目标定义并规范化请求方法
+func (g *Goal) validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	g.Method = strings.ToUpper(strings.TrimSpace(g.Method))
+	if g.Name == "" {
+		return fmt.Errorf("目标名称不能为空")
+	}
+	if g.Pattern == "" {
+		return fmt.Errorf("目标的匹配规则不能为空")
+	}
+
+	switch g.MatchType {
+	case GoalMatchExact, GoalMatchPrefix:
+	case GoalMatchRegex:
+		if _, err := regexp.Compile(g.Pattern); err != nil {
+			return fmt.Errorf("正则表达式无效: %v", err)
+		}
+	default:
+		return fmt.Errorf("不支持的匹配方式: %s", g.MatchType)
+	}
+
+	if g.Status != 0 && (g.Status < 100 || g.Status > 599) {
+		return fmt.Errorf("状态码无效: %d", g.Status)
+	}
+	return nil
+}
+
+// createGoalTables 创建目标和漏斗表
+func (r *Repository) createGoalTables() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			website_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			match_type TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			method TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_goals_website ON goals(website_id);
+
+		CREATE TABLE IF NOT EXISTS funnels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			website_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			steps TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_funnels_website ON funnels(website_id);
+	`)
+	return err
+}
+
+// ListGoals 获取站点的全部目标
+func (r *Repository) ListGoals(websiteID string) ([]Goal, error) {
+	rows, err := r.db.Query(`
+		SELECT id, website_id, name, match_type, pattern, method, status, created_at
+		FROM goals
+		WHERE website_id = ?
+		ORDER BY id`, websiteID)
+	if err != nil {
+		return nil, fmt.Errorf("查询目标失败: %v", err)
+	}
+	defer rows.Close()
+
+	goals := make([]Goal, 0)
+	for rows.Next() {
+		var g Goal
+		if err := rows.Scan(&g.ID, &g.WebsiteID, &g.Name, &g.MatchType,
+			&g.Pattern, &g.Method, &g.Status, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("解析目标失败: %v", err)
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// CreateGoal 新建目标，返回带 ID 的目标
+func (r *Repository) CreateGoal(goal Goal) (Goal, error) {
+	if err := goal.validate(); err != nil {
+		return goal, err
+	}
+	goal.CreatedAt = time.Now().Unix()
+
+	result, err := r.db.Exec(`
+		INSERT INTO goals (website_id, name, match_type, pattern, method, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		goal.WebsiteID, goal.Name, goal.MatchType, goal.Pattern, goal.Method, goal.Status, goal.CreatedAt)
+	if err != nil {
+		return goal, fmt.Errorf("保存目标失败: %v", err)
+	}
+	goal.ID, _ = result.LastInsertId()
+	return goal, nil
+}
+
+// DeleteGoal 删除目标，被漏斗引用的目标不能删除
+func (r *Repository) DeleteGoal(websiteID string, goalID int64) error {
+	funnels, err := r.ListFunnels(websiteID)
+	if err != nil {
+		return err
+	}
+	for _, funnel := range funnels {
+		for _, step := range funnel.Steps {
+			if step == goalID {
+				return fmt.Errorf("目标正在被漏斗 %s 使用，请先删除漏斗", funnel.Name)
+			}
+		}
+	}
+
+	result, err := r.db.Exec(`DELETE FROM goals WHERE id = ? AND website_id = ?`, goalID, websiteID)
+	if err != nil {
+		return fmt.Errorf("删除目标失败: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("目标不存在")
+	}
+	return nil
+}
+
+// ListFunnels 获取站点的全部漏斗
+func (r *Repository) ListFunnels(websiteID string) ([]Funnel, error) {
+	rows, err := r.db.Query(`
+		SELECT id, website_id, name, steps, created_at
+		FROM funnels
+		WHERE website_id = ?
+		ORDER BY id`, websiteID)
+	if err != nil {
+		return nil, fmt.Errorf("查询漏斗失败: %v", err)
+	}
+	defer rows.Close()
+
+	funnels := make([]Funnel, 0)
+	for rows.Next() {
+		var f Funnel
+		var steps string
+		if err := rows.Scan(&f.ID, &f.WebsiteID, &f.Name, &steps, &f.CreatedAt); err != nil {
+			return nil, fmt.Errorf("解析漏斗失败: %v", err)
+		}
+		if err := json.Unmarshal([]byte(steps), &f.Steps); err != nil {
+			return nil, fmt.Errorf("解析漏斗步骤失败: %v", err)
+		}
+		funnels = append(funnels, f)
+	}
+	return funnels, rows.Err()
+}
+
+// GetFunnel 获取漏斗及其按顺序排列的目标
+func (r *Repository) GetFunnel(websiteID string, funnelID int64) (Funnel, []Goal, error) {
+	funnels, err := r.ListFunnels(websiteID)
+	if err != nil {
+		return Funnel{}, nil, err
+	}
+
+	for _, funnel := range funnels {
+		if funnel.ID != funnelID {
+			continue
+		}
+		goals, err := r.funnelGoals(websiteID, funnel.Steps)
+		return funnel, goals, err
+	}
+	return Funnel{}, nil, fmt.Errorf("漏斗不存在")
+}
+
+// funnelGoals 按步骤顺序取出目标，目标必须属于该站点
+func (r *Repository) funnelGoals(websiteID string, steps []int64) ([]Goal, error) {
+	goals, err := r.ListGoals(websiteID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Goal, len(goals))
+	for _, g := range goals {
+		byID[g.ID] = g
+	}
+
+	result := make([]Goal, 0, len(steps))
+	for _, id := range steps {
+		g, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("目标 %d 不存在", id)
+		}
+		result = append(result, g)
+	}
+	return result, nil
+}
+
+// CreateFunnel 新建漏斗，至少需要两个步骤
+func (r *Repository) CreateFunnel(funnel Funnel) (Funnel, error) {
+	funnel.Name = strings.TrimSpace(funnel.Name)
+	if funnel.Name == "" {
+		return funnel, fmt.Errorf("漏斗名称不能为空")
+	}
+	if len(funnel.Steps) < 2 {
+		return funnel, fmt.Errorf("漏斗至少需要两个步骤")
+	}
+	if _, err := r.funnelGoals(funnel.WebsiteID, funnel.Steps); err != nil {
+		return funnel, err
+	}
+
+	steps, err := json.Marshal(funnel.Steps)
+	if err != nil {
+		return funnel, err
+	}
+	funnel.CreatedAt = time.Now().Unix()
+
+	result, err := r.db.Exec(`
+		INSERT INTO funnels (website_id, name, steps, created_at)
+		VALUES (?, ?, ?, ?)`,
+		funnel.WebsiteID, funnel.Name, string(steps), funnel.CreatedAt)
+	if err != nil {
+		return funnel, fmt.Errorf("保存漏斗失败: %v", err)
+	}
+	funnel.ID, _ = result.LastInsertId()
+	return funnel, nil
+}
+
+// DeleteFunnel 删除漏斗
+func (r *Repository) DeleteFunnel(websiteID string, funnelID int64) error {
+	result, err := r.db.Exec(`DELETE FROM funnels WHERE id = ? AND website_id = ?`, funnelID, websiteID)
+	if err != nil {
+		return fmt.Errorf("删除漏斗失败: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("漏斗不存在")
+	}
+	return nil
+}
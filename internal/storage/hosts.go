@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// internalHostShare 推断站内域名时，一个来源域名至少占站内跳转来源的比例
+const internalHostShare = 0.2
+
+// InternalHosts 返回站点自身的域名（已规范化），用于区分站内来源和外部来源。
+// 优先使用配置中的 domains；未配置时，取访问中非入口页浏览的来源里占比较高的域名——
+// 同一访问内的后续浏览基本都是从站内页面跳转而来。
+func (r *Repository) InternalHosts(websiteID string) ([]string, error) {
+	hosts := make([]string, 0)
+
+	if website, ok := util.GetWebsiteByID(websiteID); ok && len(website.Domains) > 0 {
+		for _, domain := range website.Domains {
+			if host := NormalizeHost(domain); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		return hosts, nil
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        WITH inner_views AS (
+            SELECT url_host(l.referer) AS host
+            FROM "%[1]s_nginx_logs" l
+            JOIN "%[1]s_sessions" s ON s.id = l.session_id
+            WHERE l.session_id > 0 AND l.pageview_flag = 1 AND l.timestamp > s.start_time
+            ORDER BY l.id DESC
+            LIMIT 10000
+        )
+        SELECT host, COUNT(*) * 1.0 / (SELECT COUNT(*) FROM inner_views WHERE host != '') AS share
+        FROM inner_views
+        WHERE host != ''
+        GROUP BY host
+        HAVING share >= ?
+        ORDER BY share DESC`, websiteID), internalHostShare)
+	if err != nil {
+		return nil, fmt.Errorf("推断站点域名失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var host string
+		var share float64
+		if err := rows.Scan(&host, &share); err != nil {
+			return nil, fmt.Errorf("解析站点域名失败: %v", err)
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, rows.Err()
+}
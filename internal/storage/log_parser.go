@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/netparser"
@@ -44,7 +46,14 @@ type FileState struct {
 type LogParser struct {
 	repo      *Repository
 	statePath string
-	states    map[string]LogScanState // 各网站的扫描状态，以网站ID为键
+	states    map[string]LogScanState  // 各网站的扫描状态，以网站ID为键
+	mu        sync.Mutex               // 保护 states，扫描期间持有
+	checkMu   sync.Mutex               // 串行执行扫描后的异常检测和告警检查
+	live      *Realtime                // 最近请求的内存窗口，入库时同步写入
+	anomalies *anomalyDetector         // 每次扫描后检测整点小时的流量异常
+	alerts    *alertEngine             // 每次扫描后对告警规则求值
+	onCommit  []func(websiteID string) // 站点数据变化后的回调，如清除统计缓存
+	backfill  map[string]bool          // 本次运行中已检查过旧日志会话的站点
 }
 
 func NewLogParser(userRepoPtr *Repository) *LogParser {
@@ -53,12 +62,17 @@ func NewLogParser(userRepoPtr *Repository) *LogParser {
 		repo:      userRepoPtr,
 		statePath: statePath,
 		states:    make(map[string]LogScanState),
+		backfill:  make(map[string]bool),
+		live:      newRealtime(),
+		anomalies: newAnomalyDetector(userRepoPtr),
+		alerts:    newAlertEngine(userRepoPtr),
 	}
 	parser.loadState()
 	netparser.InitPVFilters()
 	netparser.InitSpiderDetector()
 	netparser.InitSuspiciousDetector()
 	netparser.InitIpset()
+	netparser.InitRefererClassifier()
 	return parser
 }
 
@@ -96,8 +110,10 @@ func (p *LogParser) updateState() {
 	}
 }
 
-// CleanOldLogs 清理45天前的日志数据
+// CleanOldLogs 清理45天前的日志数据，并在空闲时段执行增量空间回收
 func (p *LogParser) CleanOldLogs() error {
+	p.repo.RunScheduledVacuum(time.Now())
+
 	today := time.Now().Format("2006-01-02")
 	currentHour := time.Now().Hour()
 
@@ -111,14 +127,95 @@ func (p *LogParser) CleanOldLogs() error {
 	if err != nil {
 		return err
 	}
+	for _, id := range util.GetAllWebsiteIDs() {
+		p.notifyCommit(id)
+	}
 
 	lastCleanupDate = today
 
 	return nil
 }
 
-// ScanNginxLogs 增量扫描Nginx日志文件
+// Realtime 返回实时窗口
+func (p *LogParser) Realtime() *Realtime {
+	return p.live
+}
+
+// OnCommit 注册站点数据变化的回调：每批日志入库、清理旧日志或清除站点数据后调用。
+// 需要在开始扫描之前注册
+func (p *LogParser) OnCommit(fn func(websiteID string)) {
+	p.onCommit = append(p.onCommit, fn)
+}
+
+func (p *LogParser) notifyCommit(websiteID string) {
+	for _, fn := range p.onCommit {
+		fn(websiteID)
+	}
+}
+
+// PurgeWebsite 清除站点的全部数据和扫描状态，export 为 true 时先把日志（包括归档）导出到文件，
+// 返回导出文件的路径和行数。与扫描互斥，清除时不会有扫描在写入该站点的数据
+func (p *LogParser) PurgeWebsite(websiteID string, export bool) (string, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	exportPath, exportedRows := "", 0
+	if export {
+		var err error
+		exportPath, exportedRows, err = p.repo.ExportWebsiteData(websiteID)
+		if err != nil {
+			return "", 0, fmt.Errorf("导出站点数据失败: %v", err)
+		}
+	}
+
+	if err := p.repo.PurgeWebsiteData(websiteID); err != nil {
+		return exportPath, exportedRows, err
+	}
+
+	p.live.forget(websiteID)
+	p.notifyCommit(websiteID)
+	if _, ok := p.states[websiteID]; ok {
+		delete(p.states, websiteID)
+		p.updateState()
+	}
+	return exportPath, exportedRows, nil
+}
+
+// RestoreArchivedDay 恢复站点某一天的归档日志，与扫描互斥以免会话 ID 冲突，恢复后清除统计缓存
+func (p *LogParser) RestoreArchivedDay(websiteID, date string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	restored, err := p.repo.RestoreArchivedDay(websiteID, date)
+	if err != nil {
+		return 0, err
+	}
+	p.notifyCommit(websiteID)
+	return restored, nil
+}
+
+// ScanNginxLogs 增量扫描Nginx日志文件，入库后检测流量异常并检查告警规则
 func (p *LogParser) ScanNginxLogs() []ParserResult {
+	websiteIDs, parserResults := p.scanAll()
+
+	// 检测只读取已入库的数据，在扫描锁之外进行，查询较慢时不阻塞下一次扫描和恢复归档
+	p.checkMu.Lock()
+	defer p.checkMu.Unlock()
+
+	// 3. 检测流量异常
+	p.anomalies.run(websiteIDs, time.Now())
+
+	// 4. 检查告警规则
+	p.alerts.run(websiteIDs, time.Now())
+
+	return parserResults
+}
+
+// scanAll 持有扫描锁扫描全部站点的日志，返回扫描的站点和结果
+func (p *LogParser) scanAll() ([]string, []ParserResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	// 获取所有网站ID
 	websiteIDs := util.GetAllWebsiteIDs()
 	parserResults := make([]ParserResult, len(websiteIDs))
@@ -129,6 +226,25 @@ func (p *LogParser) ScanNginxLogs() []ParserResult {
 		website, _ := util.GetWebsiteByID(id)
 		parserResult := EmptyParserResult(website.Name, id)
 
+		// 为会话划分上线之前的旧日志补划会话，与入库共用扫描锁，会话 ID 不会冲突
+		if !p.backfill[id] {
+			if count, err := p.repo.backfillSessions(id); err != nil {
+				logrus.WithError(err).Errorf("为网站 %s 的旧日志补划会话失败", id)
+			} else {
+				p.backfill[id] = true
+				if count > 0 {
+					p.notifyCommit(id)
+				}
+			}
+		}
+
+		// 同一站点的所有日志文件共用一个会话划分器
+		sessions, err := newSessionTracker(p.repo, id)
+		if err != nil {
+			logrus.WithError(err).Errorf("初始化网站 %s 的会话划分失败，本次扫描不划分会话", id)
+			sessions = nil
+		}
+
 		logPath := website.LogPath
 		if strings.Contains(logPath, "*") {
 			matches, err := filepath.Glob(logPath)
@@ -142,11 +258,11 @@ func (p *LogParser) ScanNginxLogs() []ParserResult {
 				parserResult.Error = errors.New(errstr)
 			} else {
 				for _, matchPath := range matches {
-					p.scanSingleFile(id, matchPath, &parserResult)
+					p.scanSingleFile(id, matchPath, &parserResult, sessions)
 				}
 			}
 		} else {
-			p.scanSingleFile(id, logPath, &parserResult)
+			p.scanSingleFile(id, logPath, &parserResult, sessions)
 		}
 
 		parserResult.Duration = time.Since(startTime)
@@ -156,11 +272,11 @@ func (p *LogParser) ScanNginxLogs() []ParserResult {
 	// 2. 更新并保存状态
 	p.updateState()
 
-	return parserResults
+	return websiteIDs, parserResults
 }
 
 func (p *LogParser) scanSingleFile(
-	websiteID string, logPath string, parserResult *ParserResult) {
+	websiteID string, logPath string, parserResult *ParserResult, sessions *sessionTracker) {
 	file, err := os.Open(logPath)
 	if err != nil {
 		logrus.Errorf("无法打开日志文件 %s: %v", logPath, err)
@@ -183,7 +299,7 @@ func (p *LogParser) scanSingleFile(
 		return
 	}
 
-	entriesCount := p.parseLogLines(file, websiteID, parserResult)
+	entriesCount := p.parseLogLines(file, websiteID, parserResult, sessions)
 
 	p.updateFileState(websiteID, logPath, currentSize)
 
@@ -250,7 +366,7 @@ func (p *LogParser) determineStartOffset(
 
 // parseLogLines 解析日志行并返回解析的记录数
 func (p *LogParser) parseLogLines(
-	file *os.File, websiteID string, parserResult *ParserResult) int {
+	file *os.File, websiteID string, parserResult *ParserResult, sessions *sessionTracker) int {
 	scanner := bufio.NewScanner(file)
 	entriesCount := 0
 
@@ -263,10 +379,35 @@ func (p *LogParser) parseLogLines(
 			return
 		}
 
+		if sessions != nil {
+			for i := range batch {
+				sessions.assign(&batch[i])
+			}
+		}
+
 		if err := p.repo.BatchInsertLogsForWebsite(websiteID, batch); err != nil {
 			logrus.Errorf("批量插入网站 %s 的日志记录失败: %v", websiteID, err)
+			// 日志没有入库，丢弃这批记录对会话的修改，否则会话会统计到不存在的浏览
+			if sessions != nil {
+				if err := sessions.reset(); err != nil {
+					logrus.WithError(err).Errorf("重新载入网站 %s 的会话失败，本文件剩余的日志不再划分会话", websiteID)
+					sessions = nil
+				}
+			}
+			batch = batch[:0]
+			return
+		}
+		p.live.add(websiteID, batch)
+
+		if sessions != nil {
+			if err := sessions.flush(); err != nil {
+				logrus.WithError(err).Errorf("网站 %s 的会话写入失败", websiteID)
+			}
 		}
 
+		// 会话也写入后再通知，避免缓存只包含日志、不包含会话的结果
+		p.notifyCommit(websiteID)
+
 		batch = batch[:0]
 	}
 
@@ -346,8 +487,8 @@ func (p *LogParser) parseNginxLogLine(line string) (*NginxLogRecord, error) {
 		pageviewFlag = netparser.ShouldCountAsPageView(statusCode, decodedPath, matches[1])
 	}
 
-	domesticLocation, globalLocation, _ := netparser.GetIPLocation(matches[1])
-	browser, os, device := netparser.ParseUserAgent(matches[9])
+	location, _ := netparser.GetIPLocation(matches[1])
+	client := netparser.ParseUserAgent(matches[9])
 
 	isSuspicious := 0
 	suspiciousType := ""
@@ -378,17 +519,27 @@ func (p *LogParser) parseNginxLogLine(line string) (*NginxLogRecord, error) {
 		Status:           statusCode,
 		BytesSent:        bytesSent,
 		Referer:          referPath,
-		UserBrowser:      browser,
-		UserOs:           os,
-		UserDevice:       device,
-		DomesticLocation: domesticLocation,
-		GlobalLocation:   globalLocation,
+		UserBrowser:      client.Browser,
+		UserOs:           client.OS,
+		UserDevice:       client.Device,
+		BrowserMajor:     client.BrowserMajor,
+		BrowserVersion:   client.BrowserVersion,
+		OsMajor:          client.OSMajor,
+		OsVersion:        client.OSVersion,
+		DeviceBrand:      client.Brand,
+		DeviceModel:      client.Model,
+		DomesticLocation: location.Domestic,
+		GlobalLocation:   location.Global,
+		City:             location.City,
+		ISP:              location.ISP,
 		IsSpider:         isSpider,
 		SpiderType:       spiderType,
 		SpiderName:       spiderName,
 		IsSuspicious:     isSuspicious,
 		SuspiciousType:   suspiciousType,
 		SuspiciousReason: suspiciousReason,
+		VisitorHash:      visitorHash(matches[1], matches[9]),
+		UserAgent:        matches[9],
 	}, nil
 }
 
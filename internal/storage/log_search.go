@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 日志全文索引：每个站点一张 FTS5 表，对 URL、来源、User-Agent、IP 和国内地域建立 trigram 索引，
+// 支持任意位置的子串搜索（至少 3 个字符）。索引表不保存内容（content=''），rowid 为日志的 id，
+// 原始 User-Agent 只写入索引，不保存在日志表中，因此建立索引之前的日志只能按其他字段搜索。
+
+// logSearchTable 站点全文索引表的名称
+func logSearchTable(websiteID string) string {
+	return websiteID + "_logs_fts"
+}
+
+// createLogSearchIndex 创建站点的全文索引表，新建时为已有的日志补建索引
+func (r *Repository) createLogSearchIndex(websiteID string) error {
+	table := logSearchTable(websiteID)
+	exists, err := r.tableExists(table)
+	if err != nil {
+		return err
+	}
+	if exists {
+		r.searchIndexes.Store(websiteID, true)
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf(`
+        CREATE VIRTUAL TABLE "%s" USING fts5(
+            url, referer, user_agent, ip, location,
+            content='', contentless_delete=1, tokenize='trigram')`, table)); err != nil {
+		return fmt.Errorf("创建全文索引表失败: %v", err)
+	}
+	result, err := tx.Exec(fmt.Sprintf(`
+        INSERT INTO "%s" (rowid, url, referer, user_agent, ip, location)
+        SELECT id, url, referer, '', ip, domestic_location FROM "%s_nginx_logs"`, table, websiteID))
+	if err != nil {
+		return fmt.Errorf("建立全文索引失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交全文索引失败: %v", err)
+	}
+
+	if count, _ := result.RowsAffected(); count > 0 {
+		logrus.Infof("已为站点 %s 的 %d 条日志建立全文索引", websiteID, count)
+	}
+	r.searchIndexes.Store(websiteID, true)
+	return nil
+}
+
+// LogSearchTable 返回站点的全文索引表名，索引不存在（如 SQLite 不支持 FTS5）时返回空字符串
+func (r *Repository) LogSearchTable(websiteID string) string {
+	if ok, cached := r.searchIndexes.Load(websiteID); cached {
+		if ok.(bool) {
+			return logSearchTable(websiteID)
+		}
+		return ""
+	}
+
+	exists, err := r.tableExists(logSearchTable(websiteID))
+	if err != nil {
+		logrus.WithError(err).Warnf("检查站点 %s 的全文索引失败", websiteID)
+		return ""
+	}
+	r.searchIndexes.Store(websiteID, exists)
+	if exists {
+		return logSearchTable(websiteID)
+	}
+	return ""
+}
+
+// prepareLogSearchInsert 在事务中准备写入全文索引的语句，站点没有索引时返回 nil
+func (r *Repository) prepareLogSearchInsert(tx *sql.Tx, websiteID string) (*sql.Stmt, error) {
+	table := r.LogSearchTable(websiteID)
+	if table == "" {
+		return nil, nil
+	}
+	return tx.Prepare(fmt.Sprintf(`
+        INSERT INTO "%s" (rowid, url, referer, user_agent, ip, location)
+        VALUES (?, ?, ?, ?, ?, ?)`, table))
+}
+
+// deleteLogs 删除站点日志表中符合条件的行及其全文索引，where 为不含 WHERE 的条件
+func (r *Repository) deleteLogs(websiteID, where string, args ...interface{}) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	if table := r.LogSearchTable(websiteID); table != "" {
+		if _, err := tx.Exec(fmt.Sprintf(`
+            DELETE FROM "%s" WHERE rowid IN (SELECT id FROM "%s_nginx_logs" WHERE %s)`,
+			table, websiteID, where), args...); err != nil {
+			return 0, fmt.Errorf("删除全文索引失败: %v", err)
+		}
+	}
+	result, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s_nginx_logs" WHERE %s`, websiteID, where), args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("提交删除事务失败: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+func (r *Repository) tableExists(name string) (bool, error) {
+	var count int
+	if err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE name = ?`, name).Scan(&count); err != nil {
+		return false, fmt.Errorf("查询表 %s 失败: %v", name, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// auto_vacuum 的取值，见 https://www.sqlite.org/pragma.html#pragma_auto_vacuum
+const (
+	autoVacuumNone        = 0
+	autoVacuumFull        = 1
+	autoVacuumIncremental = 2
+)
+
+// DatabaseStats 数据库空间使用情况
+type DatabaseStats struct {
+	FileSize   int64       `json:"file_size"` // 数据库文件大小（字节）
+	WalSize    int64       `json:"wal_size"`  // WAL 文件大小（字节）
+	PageSize   int64       `json:"page_size"`
+	PageCount  int64       `json:"page_count"`
+	FreePages  int64       `json:"free_pages"` // 可回收的空闲页
+	FreeBytes  int64       `json:"free_bytes"`
+	AutoVacuum string      `json:"auto_vacuum"` // none / full / incremental
+	Tables     []TableSize `json:"tables"`
+}
+
+// TableSize 单个表（含其索引）占用的空间
+type TableSize struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"` // 字节
+}
+
+// CompactionStatus 后台压缩任务的状态
+type CompactionStatus struct {
+	Running          bool   `json:"running"`
+	Mode             string `json:"mode"` // incremental：逐步回收空闲页；full：完整 VACUUM
+	StartedAt        int64  `json:"started_at"`
+	FinishedAt       int64  `json:"finished_at"`
+	InitialFreePages int64  `json:"initial_free_pages"`
+	FreedPages       int64  `json:"freed_pages"`
+	Progress         int    `json:"progress"` // 0-100
+	Error            string `json:"error"`
+}
+
+// ensureIncrementalAutoVacuum 启用增量回收模式。
+// 空数据库直接切换，已有数据的数据库需要一次完整 VACUUM，留给空闲时段或手动压缩完成。
+func ensureIncrementalAutoVacuum(db *sql.DB) error {
+	// auto_vacuum 的修改只对执行它的连接生效，必须和 VACUUM 使用同一连接
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var mode, pageCount int
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("查询 auto_vacuum 失败: %v", err)
+	}
+	if mode == autoVacuumIncremental {
+		return nil
+	}
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return fmt.Errorf("查询页数失败: %v", err)
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum=INCREMENTAL"); err != nil {
+		return fmt.Errorf("设置 auto_vacuum 失败: %v", err)
+	}
+
+	if pageCount > 1 {
+		logrus.Info("已有数据库需要一次完整压缩才能启用增量回收，将在空闲时段或手动触发压缩时完成")
+		return nil
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "VACUUM"); err != nil {
+		return fmt.Errorf("启用增量回收失败: %v", err)
+	}
+	return nil
+}
+
+// autoVacuumName 返回 auto_vacuum 取值的名称
+func autoVacuumName(mode int) string {
+	switch mode {
+	case autoVacuumFull:
+		return "full"
+	case autoVacuumIncremental:
+		return "incremental"
+	default:
+		return "none"
+	}
+}
+
+// freePages 返回当前空闲页数
+func (r *Repository) freePages() (int64, error) {
+	var free int64
+	if err := r.db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return 0, fmt.Errorf("查询空闲页失败: %v", err)
+	}
+	return free, nil
+}
+
+// GetDatabaseStats 获取数据库大小、空闲页和各表占用空间
+func (r *Repository) GetDatabaseStats() (DatabaseStats, error) {
+	stats := DatabaseStats{Tables: make([]TableSize, 0)}
+
+	var mode int
+	if err := r.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return stats, fmt.Errorf("查询 auto_vacuum 失败: %v", err)
+	}
+	stats.AutoVacuum = autoVacuumName(mode)
+
+	if err := r.db.QueryRow("PRAGMA page_size").Scan(&stats.PageSize); err != nil {
+		return stats, fmt.Errorf("查询页大小失败: %v", err)
+	}
+	if err := r.db.QueryRow("PRAGMA page_count").Scan(&stats.PageCount); err != nil {
+		return stats, fmt.Errorf("查询页数失败: %v", err)
+	}
+	free, err := r.freePages()
+	if err != nil {
+		return stats, err
+	}
+	stats.FreePages = free
+	stats.FreeBytes = free * stats.PageSize
+
+	if info, err := os.Stat(dataSourceName); err == nil {
+		stats.FileSize = info.Size()
+	}
+	if info, err := os.Stat(dataSourceName + "-wal"); err == nil {
+		stats.WalSize = info.Size()
+	}
+
+	// 索引的空间计入所属的表
+	rows, err := r.db.Query(`
+        SELECT m.tbl_name, SUM(s.pgsize) AS size
+        FROM dbstat s
+        JOIN sqlite_master m ON m.name = s.name
+        WHERE s.aggregate = TRUE
+        GROUP BY m.tbl_name
+        ORDER BY size DESC`)
+	if err != nil {
+		return stats, fmt.Errorf("查询表空间失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table TableSize
+		if err := rows.Scan(&table.Name, &table.Size); err != nil {
+			return stats, fmt.Errorf("解析表空间失败: %v", err)
+		}
+		stats.Tables = append(stats.Tables, table)
+	}
+
+	return stats, rows.Err()
+}
+
+// IncrementalVacuumStep 回收最多 maxPages 个空闲页，返回实际回收的页数
+func (r *Repository) IncrementalVacuumStep(maxPages int) (int64, error) {
+	before, err := r.freePages()
+	if err != nil {
+		return 0, err
+	}
+	if before == 0 {
+		return 0, nil
+	}
+
+	// incremental_vacuum 每执行一步只回收一页，需要把结果集读完
+	rows, err := r.db.Query(fmt.Sprintf("PRAGMA incremental_vacuum(%d)", maxPages))
+	if err != nil {
+		return 0, fmt.Errorf("增量回收失败: %v", err)
+	}
+	for rows.Next() {
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("增量回收失败: %v", err)
+	}
+
+	after, err := r.freePages()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// RunScheduledVacuum 在配置的空闲时段内执行一步有界的增量回收。
+// 数据库尚未启用增量模式时，在空闲时段启动一次完整压缩完成切换。
+func (r *Repository) RunScheduledVacuum(now time.Time) {
+	window, stepPages := util.GetMaintenanceConfig()
+	inWindow, err := util.InTimeWindow(window, now)
+	if err != nil {
+		logrus.WithError(err).Warn("空间回收时段配置无效")
+		return
+	}
+	if !inWindow || r.GetCompactionStatus().Running {
+		return
+	}
+
+	var mode int
+	if err := r.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		logrus.WithError(err).Error("查询 auto_vacuum 失败")
+		return
+	}
+	if mode != autoVacuumIncremental {
+		if err := r.StartCompaction(); err != nil {
+			logrus.WithError(err).Error("启动数据库压缩失败")
+		}
+		return
+	}
+
+	freed, err := r.IncrementalVacuumStep(stepPages)
+	if err != nil {
+		logrus.WithError(err).Error("增量回收失败")
+		return
+	}
+	if freed > 0 {
+		logrus.Infof("增量回收了 %d 个空闲页", freed)
+	}
+}
+
+// GetCompactionStatus 获取最近一次压缩任务的状态
+func (r *Repository) GetCompactionStatus() CompactionStatus {
+	r.compactionMu.Lock()
+	defer r.compactionMu.Unlock()
+	return r.compaction
+}
+
+// StartCompaction 在后台启动压缩任务，已有任务运行时返回错误
+func (r *Repository) StartCompaction() error {
+	var mode int
+	if err := r.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("查询 auto_vacuum 失败: %v", err)
+	}
+	free, err := r.freePages()
+	if err != nil {
+		return err
+	}
+
+	r.compactionMu.Lock()
+	if r.compaction.Running {
+		r.compactionMu.Unlock()
+		return fmt.Errorf("压缩任务正在运行")
+	}
+	r.compaction = CompactionStatus{
+		Running:          true,
+		Mode:             "incremental",
+		StartedAt:        time.Now().Unix(),
+		InitialFreePages: free,
+	}
+	if mode != autoVacuumIncremental {
+		r.compaction.Mode = "full"
+	}
+	r.compactionMu.Unlock()
+
+	go r.runCompaction()
+	return nil
+}
+
+// runCompaction 执行压缩任务并更新进度
+func (r *Repository) runCompaction() {
+	status := r.GetCompactionStatus()
+	var err error
+
+	if status.Mode == "full" {
+		// 完整 VACUUM 无法报告中间进度，同时完成增量模式的切换
+		logrus.Info("开始完整压缩数据库")
+		err = r.fullVacuum()
+		if err == nil {
+			r.updateCompaction(func(s *CompactionStatus) {
+				s.FreedPages = s.InitialFreePages
+			})
+		}
+	} else {
+		_, stepPages := util.GetMaintenanceConfig()
+		for {
+			var freed int64
+			freed, err = r.IncrementalVacuumStep(stepPages)
+			if err != nil || freed == 0 {
+				break
+			}
+
+			r.updateCompaction(func(s *CompactionStatus) {
+				s.FreedPages += freed
+				if s.InitialFreePages > 0 {
+					s.Progress = int(s.FreedPages * 100 / s.InitialFreePages)
+					if s.Progress > 99 {
+						s.Progress = 99
+					}
+				}
+			})
+
+			// 每步之间让出数据库，避免长时间阻塞查询
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	r.updateCompaction(func(s *CompactionStatus) {
+		s.Running = false
+		s.FinishedAt = time.Now().Unix()
+		if err != nil {
+			s.Error = err.Error()
+			return
+		}
+		s.Progress = 100
+	})
+
+	if err != nil {
+		logrus.WithError(err).Error("数据库压缩失败")
+	} else {
+		logrus.Infof("数据库压缩完成，共回收 %d 个空闲页", r.GetCompactionStatus().FreedPages)
+	}
+}
+
+// fullVacuum 在同一连接上切换增量模式并执行完整 VACUUM
+func (r *Repository) fullVacuum() error {
+	ctx := context.Background()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum=INCREMENTAL"); err != nil {
+		return fmt.Errorf("设置 auto_vacuum 失败: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("完整压缩失败: %v", err)
+	}
+	return nil
+}
+
+// updateCompaction 在锁内修改压缩任务状态
+func (r *Repository) updateCompaction(update func(s *CompactionStatus)) {
+	r.compactionMu.Lock()
+	defer r.compactionMu.Unlock()
+	update(&r.compaction)
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+const (
+	// realtimeWindow 实时窗口的长度，活跃访客按此窗口统计
+	realtimeWindow = 5 * time.Minute
+	// realtimeRateWindow 计算每秒请求数的时间范围
+	realtimeRateWindow = time.Minute
+	// realtimeMaxEntries 每个站点窗口内最多保留的请求数，超出时丢弃最旧的
+	realtimeMaxEntries = 20000
+	// realtimeTopPages 实时热门页面的数量
+	realtimeTopPages = 10
+	// realtimeSubscriberBuffer 订阅者的缓冲批次数，消费过慢时丢弃新的批次
+	realtimeSubscriberBuffer = 16
+)
+
+// LiveEntry 实时窗口中的一条请求
+type LiveEntry struct {
+	Timestamp int64  `json:"timestamp"`
+	IP        string `json:"ip"`
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Status    int    `json:"status"`
+	BytesSent int    `json:"bytes_sent"`
+	Referer   string `json:"referer"`
+	Browser   string `json:"browser"`
+	OS        string `json:"os"`
+	Location  string `json:"location"`
+	Pageview  bool   `json:"pageview"`
+	IsSpider  bool   `json:"is_spider"`
+	visitor   string
+}
+
+// LivePage 实时热门页面
+type LivePage struct {
+	URL      string `json:"url"`
+	PV       int    `json:"pv"`
+	Visitors int    `json:"visitors"`
+}
+
+// RealtimeSnapshot 某一时刻的实时概况。
+// 窗口只在扫描日志时更新，统计截至最近一次入库的最晚日志时间 DataUntil，而不是当前时间，
+// 两次扫描之间数据不会归零，但会滞后最多一个扫描间隔（taskInterval）
+type RealtimeSnapshot struct {
+	Time              int64      `json:"time"`
+	DataUntil         int64      `json:"data_until"`          // 窗口内最晚的日志时间，没有数据时为 0
+	UpdatedAt         int64      `json:"updated_at"`          // 最近一次有新日志入库的时间
+	ActiveVisitors    int        `json:"active_visitors"`     // DataUntil 前 5 分钟有浏览的访客数（IP + UA）
+	Pageviews         int        `json:"pageviews"`           // DataUntil 前 5 分钟的 PV
+	Requests          int        `json:"requests"`            // DataUntil 前 5 分钟的请求数
+	RequestsPerSecond float64    `json:"requests_per_second"` // DataUntil 前 1 分钟的平均每秒请求数
+	TopPages          []LivePage `json:"top_pages"`
+}
+
+// liveWindow 单个站点的滑动窗口，entries 按入库顺序排列
+type liveWindow struct {
+	entries []LiveEntry
+	latest  int64 // 最晚的日志时间
+	updated int64 // 最近一次写入的时间
+}
+
+// Realtime 各站点最近请求的内存窗口，由日志入库时直接写入，不经过数据库和统计缓存。
+// 日志按扫描间隔批量入库，窗口会多保留一个扫描间隔的日志，避免扫描时丢弃间隔前半段的请求
+type Realtime struct {
+	mu          sync.RWMutex
+	windows     map[string]*liveWindow
+	subscribers map[string]map[chan []LiveEntry]struct{}
+}
+
+func newRealtime() *Realtime {
+	return &Realtime{
+		windows:     make(map[string]*liveWindow),
+		subscribers: make(map[string]map[chan []LiveEntry]struct{}),
+	}
+}
+
+// realtimeRetention 窗口保留日志的时长：实时窗口加上一个扫描间隔
+func realtimeRetention() time.Duration {
+	return realtimeWindow + util.GetTaskInterval()
+}
+
+// add 把一批刚入库的记录加入窗口并推送给订阅者，早于保留时长的历史记录（如首次扫描旧日志）会被忽略
+func (rt *Realtime) add(websiteID string, records []NginxLogRecord) {
+	now := time.Now()
+	cutoff := now.Add(-realtimeRetention()).Unix()
+
+	fresh := make([]LiveEntry, 0, len(records))
+	for _, r := range records {
+		if r.Timestamp.Unix() < cutoff {
+			continue
+		}
+		location := r.DomesticLocation
+		if location == "" || location == "国外" {
+			location = r.GlobalLocation
+		}
+		fresh = append(fresh, LiveEntry{
+			Timestamp: r.Timestamp.Unix(),
+			IP:        r.IP,
+			Method:    r.Method,
+			URL:       r.Url,
+			Status:    r.Status,
+			BytesSent: r.BytesSent,
+			Referer:   r.Referer,
+			Browser:   r.UserBrowser,
+			OS:        r.UserOs,
+			Location:  location,
+			Pageview:  r.PageviewFlag == 1,
+			IsSpider:  r.IsSpider == 1,
+			visitor:   r.VisitorHash,
+		})
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	w, ok := rt.windows[websiteID]
+	if !ok {
+		w = &liveWindow{}
+		rt.windows[websiteID] = w
+	}
+	w.entries = append(w.entries, fresh...)
+	for _, e := range fresh {
+		w.latest = max(w.latest, e.Timestamp)
+	}
+	w.updated = now.Unix()
+	w.prune(cutoff)
+
+	for ch := range rt.subscribers[websiteID] {
+		select {
+		case ch <- fresh:
+		default:
+		}
+	}
+}
+
+// prune 丢弃窗口外和超出数量上限的记录
+func (w *liveWindow) prune(cutoff int64) {
+	start := 0
+	for start < len(w.entries) && w.entries[start].Timestamp < cutoff {
+		start++
+	}
+	if over := len(w.entries) - start - realtimeMaxEntries; over > 0 {
+		start += over
+	}
+	if start > 0 {
+		w.entries = append(w.entries[:0], w.entries[start:]...)
+	}
+}
+
+// Snapshot 统计站点截至最近入库日志的实时概况，超过保留时长没有新日志时视为没有数据
+func (rt *Realtime) Snapshot(websiteID string) RealtimeSnapshot {
+	now := time.Now()
+	snapshot := RealtimeSnapshot{
+		Time:     now.Unix(),
+		TopPages: make([]LivePage, 0),
+	}
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	w, ok := rt.windows[websiteID]
+	if !ok {
+		return snapshot
+	}
+	snapshot.UpdatedAt = w.updated
+	if w.latest < now.Add(-realtimeRetention()).Unix() {
+		return snapshot
+	}
+	snapshot.DataUntil = w.latest
+
+	end := time.Unix(w.latest, 0)
+	cutoff := end.Add(-realtimeWindow).Unix()
+	rateCutoff := end.Add(-realtimeRateWindow).Unix()
+
+	visitors := make(map[string]bool)
+	pages := make(map[string]*LivePage)
+	pageVisitors := make(map[string]map[string]bool)
+	recent := 0
+	for _, e := range w.entries {
+		if e.Timestamp < cutoff {
+			continue
+		}
+		snapshot.Requests++
+		if e.Timestamp >= rateCutoff {
+			recent++
+		}
+		if !e.Pageview || e.IsSpider {
+			continue
+		}
+		snapshot.Pageviews++
+		visitors[e.visitor] = true
+
+		page, ok := pages[e.URL]
+		if !ok {
+			page = &LivePage{URL: e.URL}
+			pages[e.URL] = page
+			pageVisitors[e.URL] = make(map[string]bool)
+		}
+		page.PV++
+		pageVisitors[e.URL][e.visitor] = true
+	}
+
+	snapshot.ActiveVisitors = len(visitors)
+	snapshot.RequestsPerSecond = float64(recent) / realtimeRateWindow.Seconds()
+	for url, page := range pages {
+		page.Visitors = len(pageVisitors[url])
+		snapshot.TopPages = append(snapshot.TopPages, *page)
+	}
+	sort.Slice(snapshot.TopPages, func(i, j int) bool {
+		a, b := snapshot.TopPages[i], snapshot.TopPages[j]
+		if a.Visitors != b.Visitors {
+			return a.Visitors > b.Visitors
+		}
+		if a.PV != b.PV {
+			return a.PV > b.PV
+		}
+		return a.URL < b.URL
+	})
+	if len(snapshot.TopPages) > realtimeTopPages {
+		snapshot.TopPages = snapshot.TopPages[:realtimeTopPages]
+	}
+
+	return snapshot
+}
+
+// Recent 返回站点窗口内最近的 n 条请求，按时间从新到旧
+func (rt *Realtime) Recent(websiteID string, n int) []LiveEntry {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	entries := make([]LiveEntry, 0, n)
+	w, ok := rt.windows[websiteID]
+	if !ok {
+		return entries
+	}
+	for i := len(w.entries) - 1; i >= 0 && len(entries) < n; i-- {
+		entries = append(entries, w.entries[i])
+	}
+	return entries
+}
+
+// Subscribe 订阅站点新入库的请求，每次入库推送一批；返回的函数用于取消订阅
+func (rt *Realtime) Subscribe(websiteID string) (<-chan []LiveEntry, func()) {
+	ch := make(chan []LiveEntry, realtimeSubscriberBuffer)
+
+	rt.mu.Lock()
+	if rt.subscribers[websiteID] == nil {
+		rt.subscribers[websiteID] = make(map[chan []LiveEntry]struct{})
+	}
+	rt.subscribers[websiteID][ch] = struct{}{}
+	rt.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			rt.mu.Lock()
+			delete(rt.subscribers[websiteID], ch)
+			if len(rt.subscribers[websiteID]) == 0 {
+				delete(rt.subscribers, websiteID)
+			}
+			rt.mu.Unlock()
+		})
+	}
+}
+
+// forget 删除站点的实时窗口
+func (rt *Realtime) forget(websiteID string) {
+	rt.mu.Lock()
+	delete(rt.windows, websiteID)
+	rt.mu.Unlock()
+}
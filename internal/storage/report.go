@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// 报表的发送频率
+const (
+	ReportDaily   = "daily"   // 每天发送前一天的报表
+	ReportWeekly  = "weekly"  // 每周一发送上一周（周一至周日）的报表
+	ReportMonthly = "monthly" // 每月 1 日发送上个月的报表
+)
+
+// ReportSubscription 用户订阅的定期邮件报表。WebsiteID 可以是站点 ID 或多站点范围，
+// 报表周期按站点的时区划分（多站点范围使用服务器时区），在发送日的 Hour 点发送
+type ReportSubscription struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"user_id"`
+	WebsiteID  string   `json:"website_id"`
+	Frequency  string   `json:"frequency"` // daily / weekly / monthly
+	Recipients []string `json:"recipients"`
+	Hour       int      `json:"hour"` // 0-23
+	Enabled    bool     `json:"enabled"`
+	LastSentAt int64    `json:"last_sent_at"`
+	LastError  string   `json:"last_error"` // 最近一次发送失败的原因，成功后清空
+	NextSendAt int64    `json:"next_send_at"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+// validate 检查订阅的站点、频率、收件人和发送时间，并规范化收件人地址
+func (s *ReportSubscription) validate() error {
+	if util.IsSiteScope(s.WebsiteID) {
+		if _, err := util.ResolveSiteScope(s.WebsiteID); err != nil {
+			return err
+		}
+	} else if _, ok := util.GetWebsiteByID(s.WebsiteID); !ok {
+		return fmt.Errorf("站点 %s 不存在", s.WebsiteID)
+	}
+
+	switch s.Frequency {
+	case ReportDaily, ReportWeekly, ReportMonthly:
+	default:
+		return fmt.Errorf("不支持的发送频率: %s", s.Frequency)
+	}
+
+	if s.Hour < 0 || s.Hour > 23 {
+		return fmt.Errorf("发送时间无效: %d", s.Hour)
+	}
+
+	recipients := make([]string, 0, len(s.Recipients))
+	for _, r := range s.Recipients {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(r)
+		if err != nil {
+			return fmt.Errorf("收件人地址无效: %s", r)
+		}
+		recipients = append(recipients, addr.Address)
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("至少需要一个收件人")
+	}
+	s.Recipients = recipients
+	return nil
+}
+
+// location 划分报表周期的时区，站点未配置时区或多站点范围时为服务器时区
+func (s ReportSubscription) location() *time.Location {
+	return util.GetWebsiteLocation(s.WebsiteID)
+}
+
+// periodStart 返回 t 所在报表周期的开始时间（报表时区）
+func (s ReportSubscription) periodStart(t time.Time) time.Time {
+	t = t.In(s.location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch s.Frequency {
+	case ReportWeekly:
+		return util.StartOfWeek(day)
+	case ReportMonthly:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// nextPeriodStart 返回下一个报表周期的开始时间
+func (s ReportSubscription) nextPeriodStart(start time.Time) time.Time {
+	switch s.Frequency {
+	case ReportWeekly:
+		return start.AddDate(0, 0, 7)
+	case ReportMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// sendTime 返回 start 开始的周期的发送时间，即当天的 Hour 点，夏令时切换当天同样准确
+func (s ReportSubscription) sendTime(start time.Time) time.Time {
+	return time.Date(start.Year(), start.Month(), start.Day(), s.Hour, 0, 0, 0, start.Location())
+}
+
+// Period 返回 at 时刻可以发送的最近一个完整周期：周期结束后要到发送日的 Hour 点才算可以发送
+func (s ReportSubscription) Period(at time.Time) (time.Time, time.Time) {
+	end := s.periodStart(at)
+	if at.Before(s.sendTime(end)) {
+		end = s.periodStart(end.Add(-time.Second))
+	}
+	prev := s.periodStart(end.Add(-time.Second))
+	return prev, end
+}
+
+// PreviousPeriod 返回 start 开始的周期的上一个周期，用于报表中的环比
+func (s ReportSubscription) PreviousPeriod(start time.Time) (time.Time, time.Time) {
+	return s.periodStart(start.Add(-time.Second)), start
+}
+
+// NextSendTime 返回 after 之后的下一个计划发送时间
+func (s ReportSubscription) NextSendTime(after time.Time) time.Time {
+	start := s.periodStart(after)
+	for {
+		sendAt := s.sendTime(start)
+		if sendAt.After(after) {
+			return sendAt
+		}
+		start = s.nextPeriodStart(start)
+	}
+}
+
+// createReportTable 创建报表订阅表
+func (r *Repository) createReportTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS report_subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			website_id TEXT NOT NULL,
+			frequency TEXT NOT NULL,
+			recipients TEXT NOT NULL,
+			hour INTEGER NOT NULL DEFAULT 8,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			last_sent_at INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_send_at INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_report_subscriptions_user ON report_subscriptions(user_id);
+		CREATE INDEX IF NOT EXISTS idx_report_subscriptions_next ON report_subscriptions(enabled, next_send_at);
+	`)
+	return err
+}
+
+const reportColumns = `id, user_id, website_id, frequency, recipients, hour, enabled,
+	last_sent_at, last_error, next_send_at, created_at`
+
+func scanReportSubscription(rows interface{ Scan(...interface{}) error }) (ReportSubscription, error) {
+	var s ReportSubscription
+	var recipients string
+	if err := rows.Scan(&s.ID, &s.UserID, &s.WebsiteID, &s.Frequency, &recipients, &s.Hour,
+		&s.Enabled, &s.LastSentAt, &s.LastError, &s.NextSendAt, &s.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return s, err
+		}
+		return s, fmt.Errorf("解析报表订阅失败: %v", err)
+	}
+	if err := json.Unmarshal([]byte(recipients), &s.Recipients); err != nil {
+		return s, fmt.Errorf("解析报表收件人失败: %v", err)
+	}
+	return s, nil
+}
+
+func (r *Repository) queryReportSubscriptions(query string, args ...interface{}) ([]ReportSubscription, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询报表订阅失败: %v", err)
+	}
+	defer rows.Close()
+
+	subs := make([]ReportSubscription, 0)
+	for rows.Next() {
+		s, err := scanReportSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// ListReportSubscriptions 获取用户的全部报表订阅
+func (r *Repository) ListReportSubscriptions(userID int64) ([]ReportSubscription, error) {
+	return r.queryReportSubscriptions(`
+		SELECT `+reportColumns+`
+		FROM report_subscriptions
+		WHERE user_id = ?
+		ORDER BY id`, userID)
+}
+
+// DueReportSubscriptions 获取到了发送时间的已启用订阅
+func (r *Repository) DueReportSubscriptions(now time.Time) ([]ReportSubscription, error) {
+	return r.queryReportSubscriptions(`
+		SELECT `+reportColumns+`
+		FROM report_subscriptions
+		WHERE enabled = 1 AND next_send_at <= ?
+		ORDER BY next_send_at`, now.Unix())
+}
+
+// GetReportSubscription 获取用户的一个报表订阅
+func (r *Repository) GetReportSubscription(userID, id int64) (ReportSubscription, error) {
+	row := r.db.QueryRow(`
+		SELECT `+reportColumns+`
+		FROM report_subscriptions
+		WHERE id = ? AND user_id = ?`, id, userID)
+	s, err := scanReportSubscription(row)
+	if err == sql.ErrNoRows {
+		return s, fmt.Errorf("报表订阅不存在")
+	}
+	return s, err
+}
+
+// CreateReportSubscription 新建报表订阅，返回带 ID 和下次发送时间的订阅
+func (r *Repository) CreateReportSubscription(sub ReportSubscription) (ReportSubscription, error) {
+	if err := sub.validate(); err != nil {
+		return sub, err
+	}
+	now := time.Now()
+	sub.CreatedAt = now.Unix()
+	sub.NextSendAt = sub.NextSendTime(now).Unix()
+	sub.LastSentAt, sub.LastError = 0, ""
+
+	recipients, _ := json.Marshal(sub.Recipients)
+	result, err := r.db.Exec(`
+		INSERT INTO report_subscriptions
+			(user_id, website_id, frequency, recipients, hour, enabled, next_send_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sub.UserID, sub.WebsiteID, sub.Frequency, string(recipients), sub.Hour, sub.Enabled,
+		sub.NextSendAt, sub.CreatedAt)
+	if err != nil {
+		return sub, fmt.Errorf("保存报表订阅失败: %v", err)
+	}
+	sub.ID, _ = result.LastInsertId()
+	return sub, nil
+}
+
+// UpdateReportSubscription 修改用户的报表订阅，按新的频率和时间重新计算下次发送时间
+func (r *Repository) UpdateReportSubscription(sub ReportSubscription) (ReportSubscription, error) {
+	current, err := r.GetReportSubscription(sub.UserID, sub.ID)
+	if err != nil {
+		return sub, err
+	}
+	if err := sub.validate(); err != nil {
+		return sub, err
+	}
+	sub.CreatedAt = current.CreatedAt
+	sub.LastSentAt, sub.LastError = current.LastSentAt, current.LastError
+	sub.NextSendAt = sub.NextSendTime(time.Now()).Unix()
+
+	recipients, _ := json.Marshal(sub.Recipients)
+	if _, err := r.db.Exec(`
+		UPDATE report_subscriptions
+		SET website_id = ?, frequency = ?, recipients = ?, hour = ?, enabled = ?, next_send_at = ?
+		WHERE id = ? AND user_id = ?`,
+		sub.WebsiteID, sub.Frequency, string(recipients), sub.Hour, sub.Enabled, sub.NextSendAt,
+		sub.ID, sub.UserID); err != nil {
+		return sub, fmt.Errorf("保存报表订阅失败: %v", err)
+	}
+	return sub, nil
+}
+
+// DeleteReportSubscription 删除用户的报表订阅
+func (r *Repository) DeleteReportSubscription(userID, id int64) error {
+	result, err := r.db.Exec(`DELETE FROM report_subscriptions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("删除报表订阅失败: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("报表订阅不存在")
+	}
+	return nil
+}
+
+// RecordReportSent 记录一次发送的结果。nextSendAt 为 0 时不改变计划发送时间（立即发送）
+func (r *Repository) RecordReportSent(id int64, sentAt time.Time, sendErr error, nextSendAt int64) error {
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+	_, err := r.db.Exec(`
+		UPDATE report_subscriptions
+		SET last_sent_at = CASE WHEN ? = '' THEN ? ELSE last_sent_at END,
+			last_error = ?,
+			next_send_at = CASE WHEN ? > 0 THEN ? ELSE next_send_at END
+		WHERE id = ?`,
+		lastError, sentAt.Unix(), lastError, nextSendAt, nextSendAt, id)
+	if err != nil {
+		return fmt.Errorf("更新报表发送状态失败: %v", err)
+	}
+	return nil
+}
+
+// ReportSuspiciousIP 报表周期内发出可疑请求的 IP
+type ReportSuspiciousIP struct {
+	WebsiteID string
+	IP        string
+	Requests  int    // 周期内的可疑请求数
+	Reason    string // 最常见的可疑类型
+	Blocked   bool
+}
+
+// ReportSuspiciousIPs 获取时间范围内可疑请求最多的 IP
+func (r *Repository) ReportSuspiciousIPs(websiteID string, startTime, endTime time.Time, limit int) ([]ReportSuspiciousIP, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+		SELECT l.ip, COUNT(*) AS requests,
+			(SELECT suspicious_type FROM "%[1]s_nginx_logs"
+			 WHERE ip = l.ip AND is_suspicious = 1 AND timestamp >= ? AND timestamp < ?
+			 GROUP BY suspicious_type ORDER BY COUNT(*) DESC LIMIT 1),
+			COALESCE(s.is_blocked, 0)
+		FROM "%[1]s_nginx_logs" l
+		LEFT JOIN suspicious_ips s ON s.website_id = ? AND s.ip = l.ip
+		WHERE l.is_suspicious = 1 AND l.timestamp >= ? AND l.timestamp < ?
+		GROUP BY l.ip
+		ORDER BY requests DESC
+		LIMIT ?`, websiteID),
+		startTime.Unix(), endTime.Unix(), websiteID, startTime.Unix(), endTime.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询可疑 IP 失败: %v", err)
+	}
+	defer rows.Close()
+
+	ips := make([]ReportSuspiciousIP, 0)
+	for rows.Next() {
+		ip := ReportSuspiciousIP{WebsiteID: websiteID}
+		if err := rows.Scan(&ip.IP, &ip.Requests, &ip.Reason, &ip.Blocked); err != nil {
+			return nil, fmt.Errorf("解析可疑 IP 失败: %v", err)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
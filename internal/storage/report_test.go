@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// withLocal 临时替换服务器时区，未配置时区的站点使用服务器时区
+func withLocal(t *testing.T, loc *time.Location) {
+	t.Helper()
+	saved := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = saved })
+}
+
+func TestReportPeriod(t *testing.T) {
+	tokyo := time.FixedZone("UTC+9", 9*3600)
+	withLocal(t, tokyo)
+
+	at := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	local := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, tokyo)
+	}
+
+	tests := []struct {
+		name       string
+		frequency  string
+		at         string // UTC
+		start, end time.Time
+		nextSend   time.Time
+	}{
+		{
+			// 站点时区已是 5 月 10 日 8:30，UTC 仍是 9 日
+			name: "按站点时区的日期", frequency: ReportDaily, at: "2024-05-09T23:30:00Z",
+			start: local(2024, 5, 9), end: local(2024, 5, 10),
+			nextSend: local(2024, 5, 11).Add(8 * time.Hour),
+		},
+		{
+			name: "未到发送时间时取再前一天", frequency: ReportDaily, at: "2024-05-09T22:30:00Z",
+			start: local(2024, 5, 8), end: local(2024, 5, 9),
+			nextSend: local(2024, 5, 10).Add(8 * time.Hour),
+		},
+		{
+			// 站点时区已是周一 5 月 13 日
+			name: "按站点时区的周", frequency: ReportWeekly, at: "2024-05-12T23:30:00Z",
+			start: local(2024, 5, 6), end: local(2024, 5, 13),
+			nextSend: local(2024, 5, 20).Add(8 * time.Hour),
+		},
+		{
+			name: "按站点时区的月", frequency: ReportMonthly, at: "2024-05-31T23:30:00Z",
+			start: local(2024, 5, 1), end: local(2024, 6, 1),
+			nextSend: local(2024, 7, 1).Add(8 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := ReportSubscription{WebsiteID: "site", Frequency: tt.frequency, Hour: 8}
+			start, end := sub.Period(at(tt.at))
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("Period = %v ~ %v, 期望 %v ~ %v", start, end, tt.start, tt.end)
+			}
+			if start.Location() != tokyo {
+				t.Errorf("周期的时区 = %v, 期望站点时区", start.Location())
+			}
+			if next := sub.NextSendTime(at(tt.at)); !next.Equal(tt.nextSend) {
+				t.Errorf("NextSendTime = %v, 期望 %v", next, tt.nextSend)
+			}
+		})
+	}
+}
+
+func TestReportSendTimeAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("没有时区数据: %v", err)
+	}
+	withLocal(t, newYork)
+
+	// 2024-03-10 凌晨 2 点切换到夏令时，当天仍在 8 点发送
+	sub := ReportSubscription{WebsiteID: "site", Frequency: ReportDaily, Hour: 8}
+	next := sub.NextSendTime(time.Date(2024, 3, 10, 1, 0, 0, 0, newYork))
+	if want := time.Date(2024, 3, 10, 8, 0, 0, 0, newYork); !next.Equal(want) {
+		t.Errorf("NextSendTime = %v, 期望 %v", next, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/util"
@@ -28,18 +30,34 @@ type NginxLogRecord struct {
 	UserBrowser      string    `json:"user_browser"`
 	UserOs           string    `json:"user_os"`
 	UserDevice       string    `json:"user_device"`
+	BrowserMajor     string    `json:"browser_major"` // 带名称的浏览器主版本，如 "Chrome 120"
+	BrowserVersion   string    `json:"browser_version"`
+	OsMajor          string    `json:"os_major"`
+	OsVersion        string    `json:"os_version"`
+	DeviceBrand      string    `json:"device_brand"`
+	DeviceModel      string    `json:"device_model"`
 	DomesticLocation string    `json:"domestic_location"`
 	GlobalLocation   string    `json:"global_location"`
+	City             string    `json:"city"`
+	ISP              string    `json:"isp"` // 运营商
 	IsSpider         int       `json:"is_spider"`
 	SpiderType       string    `json:"spider_type"`
 	SpiderName       string    `json:"spider_name"`
 	IsSuspicious     int       `json:"is_suspicious"`
 	SuspiciousType   string    `json:"suspicious_type"`
 	SuspiciousReason string    `json:"suspicious_reason"`
+	SessionID        int64     `json:"session_id"` // 所属会话，不属于任何会话时为 0
+	VisitorHash      string    `json:"-"`          // 访客标识（IP + UA 哈希），只用于会话划分，不入库
+	UserAgent        string    `json:"-"`          // 原始 User-Agent，只写入全文索引，不入库
 }
 
 type Repository struct {
 	db *sql.DB
+
+	compactionMu sync.Mutex
+	compaction   CompactionStatus // 最近一次压缩任务的状态
+
+	searchIndexes sync.Map // 站点 ID -> 是否有全文索引
 }
 
 func NewRepository() (*Repository, error) {
@@ -54,6 +72,12 @@ func NewRepository() (*Repository, error) {
 		return nil, err
 	}
 
+	// 启用增量空间回收，需在建表和切换 WAL 之前设置
+	if err := ensureIncrementalAutoVacuum(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	// 性能优化设置
 	if _, err := db.Exec(`
         PRAGMA journal_mode=WAL;
@@ -93,40 +117,66 @@ func (r *Repository) BatchInsertLogsForWebsite(websiteID string, logs []NginxLog
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	defer tx.Rollback()
 
+	if err := r.insertLogs(tx, websiteID, logs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertLogs 在事务中写入日志及其全文索引
+func (r *Repository) insertLogs(tx *sql.Tx, websiteID string, logs []NginxLogRecord) error {
 	nginxTable := fmt.Sprintf("%s_nginx_logs", websiteID)
 
 	stmtNginx, err := tx.Prepare(fmt.Sprintf(`
         INSERT INTO "%s" (
         ip, pageview_flag, timestamp, method, url,
         status_code, bytes_sent, referer,
-        user_browser, user_os, user_device, domestic_location, global_location,
-        is_spider, spider_type, spider_name, is_suspicious, suspicious_type, suspicious_reason)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        user_browser, user_os, user_device, browser_major, browser_version, os_major, os_version,
+        device_brand, device_model, domestic_location, global_location, city, isp,
+        is_spider, spider_type, spider_name, is_suspicious, suspicious_type, suspicious_reason,
+        session_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `, nginxTable))
 	if err != nil {
 		return err
 	}
 	defer stmtNginx.Close()
 
+	stmtSearch, err := r.prepareLogSearchInsert(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	if stmtSearch != nil {
+		defer stmtSearch.Close()
+	}
+
 	for _, log := range logs {
-		_, err = stmtNginx.Exec(
+		var result sql.Result
+		result, err = stmtNginx.Exec(
 			log.IP, log.PageviewFlag, log.Timestamp.Unix(), log.Method, log.Url,
 			log.Status, log.BytesSent, log.Referer, log.UserBrowser, log.UserOs, log.UserDevice,
-			log.DomesticLocation, log.GlobalLocation,
+			log.BrowserMajor, log.BrowserVersion, log.OsMajor, log.OsVersion, log.DeviceBrand, log.DeviceModel,
+			log.DomesticLocation, log.GlobalLocation, log.City, log.ISP,
 			log.IsSpider, log.SpiderType, log.SpiderName, log.IsSuspicious, log.SuspiciousType, log.SuspiciousReason,
+			log.SessionID,
 		)
 		if err != nil {
 			return err
 		}
-	}
 
-	return tx.Commit()
+		if stmtSearch != nil {
+			var id int64
+			if id, err = result.LastInsertId(); err != nil {
+				return err
+			}
+			if _, err = stmtSearch.Exec(id, log.Url, log.Referer, log.UserAgent, log.IP, log.DomesticLocation); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (r *Repository) RecordSuspiciousAccess(websiteID, ip string, reasonType, reasonDetail string, timestamp int64) error {
@@ -352,9 +402,24 @@ func (r *Repository) getSpiderIPs(tableName, spiderType, spiderName string, time
 	return results, nil
 }
 
-// CleanOldLogs 清理45天前的日志数据
+// GetLogTimeBounds 获取站点日志最早和最晚的时间戳，没有数据时返回 0, 0
+func (r *Repository) GetLogTimeBounds(websiteID string) (int64, int64, error) {
+	var minTs, maxTs *int64
+	err := r.db.QueryRow(fmt.Sprintf(
+		`SELECT MIN(timestamp), MAX(timestamp) FROM "%s_nginx_logs"`, websiteID)).Scan(&minTs, &maxTs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询日志时间范围失败: %v", err)
+	}
+	if minTs == nil || maxTs == nil {
+		return 0, 0, nil
+	}
+	return *minTs, *maxTs, nil
+}
+
+// CleanOldLogs 清理45天前的日志数据，开启归档时先按天归档再删除
 func (r *Repository) CleanOldLogs() error {
 	cutoffTime := time.Now().AddDate(0, 0, -45).Unix()
+	archiveCfg := util.GetArchiveConfig()
 
 	deletedCount := 0
 
@@ -377,24 +442,51 @@ func (r *Repository) CleanOldLogs() error {
 		tableNames = append(tableNames, tableName)
 	}
 
+	if err := r.deleteAnomaliesBefore(
+		time.Now().AddDate(0, 0, -anomalyRetentionDays).Unix()); err != nil {
+		logrus.WithError(err).Error("清理旧的流量异常失败")
+	}
+	if err := r.deleteAlertHistoryBefore(
+		time.Now().AddDate(0, 0, -alertRetentionDays).Unix()); err != nil {
+		logrus.WithError(err).Error("清理旧的告警历史失败")
+	}
+	if err := r.deleteExportJobsBefore(
+		time.Now().AddDate(0, 0, -exportRetentionDays).Unix()); err != nil {
+		logrus.WithError(err).Error("清理过期的导出文件失败")
+	}
+
 	for _, tableName := range tableNames {
-		result, err := r.db.Exec(
-			fmt.Sprintf(`DELETE FROM "%s" WHERE timestamp < ?`, tableName), cutoffTime,
-		)
-		if err != nil {
-			logrus.WithError(err).Errorf("清理表 %s 的旧日志失败", tableName)
-			continue
+		websiteID := strings.TrimSuffix(tableName, "_nginx_logs")
+		if err := r.deleteVisitorsBefore(websiteID,
+			time.Now().AddDate(0, 0, -visitorRetentionDays).Unix()); err != nil {
+			logrus.WithError(err).Errorf("清理站点 %s 的旧访客失败", websiteID)
 		}
 
-		count, _ := result.RowsAffected()
-		deletedCount += int(count)
+		if archiveCfg.Enabled {
+			// 归档以整天为单位，只处理截止日期之前的完整日期
+			count, err := r.archiveAndDeleteExpired(
+				archiveCfg, tableName, startOfDay(time.Unix(cutoffTime, 0)))
+			if err != nil {
+				logrus.WithError(err).Errorf("归档表 %s 的旧日志失败", tableName)
+			}
+			deletedCount += count
+		} else {
+			count, err := r.deleteLogs(websiteID, "timestamp < ?", cutoffTime)
+			if err != nil {
+				logrus.WithError(err).Errorf("清理表 %s 的旧日志失败", tableName)
+			}
+			deletedCount += int(count)
+		}
+
+		// 日志删除之后再清理会话，没有删除的日志仍能找到所属的会话
+		if err := r.deleteSessionsBefore(websiteID, cutoffTime); err != nil {
+			logrus.WithError(err).Errorf("清理站点 %s 的旧会话失败", websiteID)
+		}
 	}
 
+	// 释放的空间由空闲时段的增量回收处理，不在这里执行阻塞的完整 VACUUM
 	if deletedCount > 0 {
 		logrus.Infof("删除了 %d 条45天前的日志记录", deletedCount)
-		if _, err := r.db.Exec("VACUUM"); err != nil {
-			logrus.WithError(err).Error("数据库压缩失败")
-		}
 	}
 
 	return nil
@@ -413,16 +505,26 @@ func (r *Repository) createTables() error {
 	user_browser TEXT NOT NULL,
 	user_os TEXT NOT NULL,
 	user_device TEXT NOT NULL,
+	browser_major TEXT NOT NULL DEFAULT '',
+	browser_version TEXT NOT NULL DEFAULT '',
+	os_major TEXT NOT NULL DEFAULT '',
+	os_version TEXT NOT NULL DEFAULT '',
+	device_brand TEXT NOT NULL DEFAULT '未知品牌',
+	device_model TEXT NOT NULL DEFAULT '未知型号',
 	domestic_location TEXT NOT NULL,
 	global_location TEXT NOT NULL,
+	city TEXT NOT NULL DEFAULT '未知',
+	isp TEXT NOT NULL DEFAULT '未知',
 	is_spider INTEGER NOT NULL DEFAULT 0,
 	spider_type TEXT NOT NULL DEFAULT '',
 	spider_name TEXT NOT NULL DEFAULT '',
 	is_suspicious INTEGER NOT NULL DEFAULT 0,
 	suspicious_type TEXT NOT NULL DEFAULT '',
-	suspicious_reason TEXT NOT NULL DEFAULT ''`
+	suspicious_reason TEXT NOT NULL DEFAULT '',
+	session_id INTEGER NOT NULL DEFAULT 0`
 
-	for _, id := range util.GetAllWebsiteIDs() {
+	// 已隐藏的站点也要建表和升级，恢复显示后才能继续写入
+	for _, id := range append(util.GetAllWebsiteIDs(), util.GetHiddenWebsiteIDs()...) {
 		tableName := fmt.Sprintf("%s_nginx_logs", id)
 
 		// 创建表
@@ -432,6 +534,12 @@ func (r *Repository) createTables() error {
 			continue
 		}
 
+		if err := r.createSessionTable(id); err != nil {
+			logrus.WithError(err).Errorf("创建站点 %s 的会话表失败", id)
+		} else if err := r.createVisitorTable(id); err != nil {
+			logrus.WithError(err).Errorf("创建站点 %s 的访客表失败", id)
+		}
+
 		// 尝试添加新字段（如果已存在会报错，忽略）
 		alterQueries := []string{
 			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN is_spider INTEGER NOT NULL DEFAULT 0;`, id),
@@ -440,6 +548,14 @@ func (r *Repository) createTables() error {
 			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN is_suspicious INTEGER NOT NULL DEFAULT 0;`, id),
 			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN suspicious_type TEXT NOT NULL DEFAULT '';`, id),
 			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN suspicious_reason TEXT NOT NULL DEFAULT '';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN session_id INTEGER NOT NULL DEFAULT 0;`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN city TEXT NOT NULL DEFAULT '未知';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN isp TEXT NOT NULL DEFAULT '未知';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN browser_version TEXT NOT NULL DEFAULT '';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN os_major TEXT NOT NULL DEFAULT '';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN os_version TEXT NOT NULL DEFAULT '';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN device_brand TEXT NOT NULL DEFAULT '未知品牌';`, id),
+			fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN device_model TEXT NOT NULL DEFAULT '未知型号';`, id),
 		}
 		for _, alterQ := range alterQueries {
 			if _, err := r.db.Exec(alterQ); err != nil {
@@ -448,6 +564,17 @@ func (r *Repository) createTables() error {
 			}
 		}
 
+		// 旧版本的日志没有版本信息，新增字段时用浏览器和系统名称补齐
+		if _, err := r.db.Exec(fmt.Sprintf(
+			`ALTER TABLE "%s_nginx_logs" ADD COLUMN browser_major TEXT NOT NULL DEFAULT '';`, id)); err == nil {
+			if _, err := r.db.Exec(fmt.Sprintf(`
+                UPDATE "%s_nginx_logs"
+                SET browser_major = user_browser, browser_version = user_browser,
+                    os_major = user_os, os_version = user_os`, id)); err != nil {
+				logrus.WithError(err).Errorf("补齐表 %s 的版本信息失败", tableName)
+			}
+		}
+
 		// 创建单列索引
 		indexQueries := []string{
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_timestamp ON "%s_nginx_logs"(timestamp);`, id, id),
@@ -457,10 +584,17 @@ func (r *Repository) createTables() error {
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_browser ON "%s_nginx_logs"(user_browser);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_os ON "%s_nginx_logs"(user_os);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_device ON "%s_nginx_logs"(user_device);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_browser_major ON "%s_nginx_logs"(browser_major);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_os_major ON "%s_nginx_logs"(os_major);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_device_brand ON "%s_nginx_logs"(device_brand);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_domestic_location ON "%s_nginx_logs"(domestic_location);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_global_location ON "%s_nginx_logs"(global_location);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_city ON "%s_nginx_logs"(city);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_isp ON "%s_nginx_logs"(isp);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_is_spider ON "%s_nginx_logs"(is_spider);`, id, id),
 			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_is_suspicious ON "%s_nginx_logs"(is_suspicious);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_session_id ON "%s_nginx_logs"(session_id);`, id, id),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_status_code ON "%s_nginx_logs"(status_code);`, id, id),
 		}
 		for _, idxQ := range indexQueries {
 			if _, err := r.db.Exec(idxQ); err != nil {
@@ -473,12 +607,38 @@ func (r *Repository) createTables() error {
 		if err != nil {
 			logrus.WithError(err).Warnf("创建复合索引失败 [%s]", tableName)
 		}
+
+		// 全文索引不可用时关键字搜索退回到 LIKE 匹配
+		if err := r.createLogSearchIndex(id); err != nil {
+			logrus.WithError(err).Warnf("创建站点 %s 的全文索引失败", id)
+			r.searchIndexes.Store(id, false)
+		}
 	}
 
 	if err := r.createSuspiciousIPTable(); err != nil {
 		return err
 	}
 
+	if err := r.createGoalTables(); err != nil {
+		return err
+	}
+
+	if err := r.createAnomalyTable(); err != nil {
+		return err
+	}
+
+	if err := r.createReportTable(); err != nil {
+		return err
+	}
+
+	if err := r.createAlertTables(); err != nil {
+		return err
+	}
+
+	if err := r.createExportTable(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -525,14 +685,23 @@ func (r *Repository) CreateTableForWebsite(websiteID string) error {
 	user_browser TEXT NOT NULL,
 	user_os TEXT NOT NULL,
 	user_device TEXT NOT NULL,
+	browser_major TEXT NOT NULL DEFAULT '',
+	browser_version TEXT NOT NULL DEFAULT '',
+	os_major TEXT NOT NULL DEFAULT '',
+	os_version TEXT NOT NULL DEFAULT '',
+	device_brand TEXT NOT NULL DEFAULT '未知品牌',
+	device_model TEXT NOT NULL DEFAULT '未知型号',
 	domestic_location TEXT NOT NULL,
 	global_location TEXT NOT NULL,
+	city TEXT NOT NULL DEFAULT '未知',
+	isp TEXT NOT NULL DEFAULT '未知',
 	is_spider INTEGER NOT NULL DEFAULT 0,
 	spider_type TEXT NOT NULL DEFAULT '',
 	spider_name TEXT NOT NULL DEFAULT '',
 	is_suspicious INTEGER NOT NULL DEFAULT 0,
 	suspicious_type TEXT NOT NULL DEFAULT '',
-	suspicious_reason TEXT NOT NULL DEFAULT ''`
+	suspicious_reason TEXT NOT NULL DEFAULT '',
+	session_id INTEGER NOT NULL DEFAULT 0`
 
 	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
 
@@ -542,6 +711,14 @@ func (r *Repository) CreateTableForWebsite(websiteID string) error {
 		return fmt.Errorf("创建表 %s 失败: %v", tableName, err)
 	}
 
+	if err := r.createSessionTable(websiteID); err != nil {
+		return err
+	}
+
+	if err := r.createVisitorTable(websiteID); err != nil {
+		return err
+	}
+
 	// 创建单列索引
 	indexQueries := []string{
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_timestamp ON "%s_nginx_logs"(timestamp);`, websiteID, websiteID),
@@ -551,10 +728,17 @@ func (r *Repository) CreateTableForWebsite(websiteID string) error {
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_browser ON "%s_nginx_logs"(user_browser);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_os ON "%s_nginx_logs"(user_os);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_user_device ON "%s_nginx_logs"(user_device);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_browser_major ON "%s_nginx_logs"(browser_major);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_os_major ON "%s_nginx_logs"(os_major);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_device_brand ON "%s_nginx_logs"(device_brand);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_domestic_location ON "%s_nginx_logs"(domestic_location);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_global_location ON "%s_nginx_logs"(global_location);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_city ON "%s_nginx_logs"(city);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_isp ON "%s_nginx_logs"(isp);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_is_spider ON "%s_nginx_logs"(is_spider);`, websiteID, websiteID),
 		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_is_suspicious ON "%s_nginx_logs"(is_suspicious);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_session_id ON "%s_nginx_logs"(session_id);`, websiteID, websiteID),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_status_code ON "%s_nginx_logs"(status_code);`, websiteID, websiteID),
 	}
 	for _, idxQ := range indexQueries {
 		if _, err := r.db.Exec(idxQ); err != nil {
@@ -568,6 +752,13 @@ func (r *Repository) CreateTableForWebsite(websiteID string) error {
 		logrus.WithError(err).Warnf("创建复合索引失败 [%s]", tableName)
 	}
 
+	// 站点可能是清除数据后重新添加的，之前缓存的索引状态已经无效
+	r.searchIndexes.Delete(websiteID)
+	if err := r.createLogSearchIndex(websiteID); err != nil {
+		logrus.WithError(err).Warnf("创建站点 %s 的全文索引失败", websiteID)
+		r.searchIndexes.Store(websiteID, false)
+	}
+
 	logrus.Infof("站点 %s 的数据库表创建成功", websiteID)
 	return nil
 }
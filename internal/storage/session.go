@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// SessionRecord 一次访问（会话）的汇总，同一访客相邻两次浏览间隔不超过超时时间即属于同一会话
+type SessionRecord struct {
+	ID          int64  `json:"id"`
+	VisitorHash string `json:"visitor_hash"`
+	Fingerprint string `json:"fingerprint"` // 识别回访访客的指纹，见 visitorFingerprint
+	IP          string `json:"ip"`
+	StartTime   int64  `json:"start_time"`
+	EndTime     int64  `json:"end_time"`
+	Pageviews   int    `json:"pageviews"`
+	EntryURL    string `json:"entry_url"`
+	ExitURL     string `json:"exit_url"`
+}
+
+// visitorHash 根据 IP 和 UA 生成访客标识
+func visitorHash(ip, userAgent string) string {
+	sum := md5.Sum([]byte(ip + "|" + userAgent))
+	return hex.EncodeToString(sum[:8])
+}
+
+// createSessionTable 创建站点的会话汇总表
+func (r *Repository) createSessionTable(websiteID string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%[1]s_sessions" (
+            id INTEGER PRIMARY KEY,
+            visitor_hash TEXT NOT NULL,
+            ip TEXT NOT NULL,
+            start_time INTEGER NOT NULL,
+            end_time INTEGER NOT NULL,
+            pageviews INTEGER NOT NULL,
+            entry_url TEXT NOT NULL,
+            exit_url TEXT NOT NULL,
+            fingerprint TEXT NOT NULL DEFAULT ''
+        );
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_sessions_start ON "%[1]s_sessions"(start_time);
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_sessions_end ON "%[1]s_sessions"(end_time);`,
+		websiteID))
+	if err != nil {
+		return fmt.Errorf("创建会话表失败: %v", err)
+	}
+	return nil
+}
+
+// deleteSessionsBefore 删除在 cutoff 之前结束、且日志已经全部删除的会话。
+// 归档失败而保留下来的日志仍然引用其会话，这些会话留到日志删除后再清理
+func (r *Repository) deleteSessionsBefore(websiteID string, cutoff int64) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        DELETE FROM "%[1]s_sessions"
+        WHERE end_time < ?
+          AND NOT EXISTS (SELECT 1 FROM "%[1]s_nginx_logs" l WHERE l.session_id = "%[1]s_sessions".id)`,
+		websiteID), cutoff)
+	return err
+}
+
+// saveSessions 在一个事务中写入新建或更新过的会话，并更新访客的首次访问时间
+func (r *Repository) saveSessions(websiteID string, sessions []*SessionRecord) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+        INSERT INTO "%s_sessions" (
+            id, visitor_hash, ip, start_time, end_time, pageviews, entry_url, exit_url, fingerprint)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(id) DO UPDATE SET
+            start_time = excluded.start_time,
+            end_time = excluded.end_time,
+            pageviews = excluded.pageviews,
+            entry_url = excluded.entry_url,
+            exit_url = excluded.exit_url`, websiteID))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range sessions {
+		if _, err := stmt.Exec(s.ID, s.VisitorHash, s.IP, s.StartTime, s.EndTime,
+			s.Pageviews, s.EntryURL, s.ExitURL, s.Fingerprint); err != nil {
+			return err
+		}
+	}
+
+	if err := saveVisitors(tx, websiteID, sessions); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sessionTracker 在一次扫描中把站点的 PV 记录划分为会话。
+// 启动时从数据库载入仍可能延续的会话，因此会话可以跨越多次扫描。
+type sessionTracker struct {
+	repo      *Repository
+	websiteID string
+	timeout   int64
+	mode      string // 访客指纹的生成方式
+	nextID    int64
+	latest    int64                     // 已处理的最晚时间戳
+	open      map[string]*SessionRecord // 各访客最近的会话
+	dirty     map[int64]*SessionRecord  // 尚未写入数据库的会话
+}
+
+// newSessionTracker 创建站点的会话划分器并载入未结束的会话
+func newSessionTracker(repo *Repository, websiteID string) (*sessionTracker, error) {
+	t, err := newEmptySessionTracker(repo, websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int64
+	if err := repo.db.QueryRow(fmt.Sprintf(
+		`SELECT COALESCE(MAX(end_time), 0) FROM "%s_sessions"`, websiteID)).Scan(&latest); err != nil {
+		return nil, fmt.Errorf("查询会话状态失败: %v", err)
+	}
+	t.latest = latest
+
+	rows, err := repo.db.Query(fmt.Sprintf(`
+        SELECT id, visitor_hash, ip, start_time, end_time, pageviews, entry_url, exit_url, fingerprint
+        FROM "%s_sessions"
+        WHERE end_time >= ?`, websiteID), latest-t.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("载入未结束的会话失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &SessionRecord{}
+		if err := rows.Scan(&s.ID, &s.VisitorHash, &s.IP, &s.StartTime, &s.EndTime,
+			&s.Pageviews, &s.EntryURL, &s.ExitURL, &s.Fingerprint); err != nil {
+			return nil, fmt.Errorf("解析会话失败: %v", err)
+		}
+		if current, ok := t.open[s.VisitorHash]; !ok || s.EndTime > current.EndTime {
+			t.open[s.VisitorHash] = s
+		}
+	}
+
+	return t, rows.Err()
+}
+
+// newEmptySessionTracker 创建不载入已有会话的会话划分器，只确定下一个会话的 ID
+func newEmptySessionTracker(repo *Repository, websiteID string) (*sessionTracker, error) {
+	t := &sessionTracker{
+		repo:      repo,
+		websiteID: websiteID,
+		timeout:   int64(util.GetSessionTimeout() / time.Second),
+		mode:      util.GetVisitorFingerprint(),
+		open:      make(map[string]*SessionRecord),
+		dirty:     make(map[int64]*SessionRecord),
+	}
+
+	// 会话写入晚于日志，取两者中较大的 ID，避免中断后复用已被日志引用的 ID
+	var maxSessionID, maxLogSessionID int64
+	err := repo.db.QueryRow(fmt.Sprintf(`
+        SELECT
+            (SELECT COALESCE(MAX(id), 0) FROM "%[1]s_sessions"),
+            (SELECT COALESCE(MAX(session_id), 0) FROM "%[1]s_nginx_logs")`,
+		websiteID)).Scan(&maxSessionID, &maxLogSessionID)
+	if err != nil {
+		return nil, fmt.Errorf("查询会话状态失败: %v", err)
+	}
+	t.nextID = max(maxSessionID, maxLogSessionID) + 1
+	return t, nil
+}
+
+// assign 为 PV 记录分配会话。非 PV 的访客请求（如表单提交）落在已有会话内时
+// 只记录所属会话，不影响会话的浏览量和起止时间，便于按访问统计目标。
+func (t *sessionTracker) assign(entry *NginxLogRecord) {
+	ts := entry.Timestamp.Unix()
+	s, ok := t.open[entry.VisitorHash]
+	if !ok {
+		// 补划的会话没有 UA，只按 IP 区分，同一 IP 的请求可以延续补划出的会话
+		s, ok = t.open[visitorHash(entry.IP, "")]
+	}
+
+	if entry.PageviewFlag != 1 {
+		if entry.IsSpider == 0 && ok && ts >= s.StartTime && ts <= s.EndTime+t.timeout {
+			entry.SessionID = s.ID
+		}
+		return
+	}
+
+	// 多个日志文件交错时记录可能乱序，落在会话前后超时范围内的都并入该会话
+	if ok && ts <= s.EndTime+t.timeout && ts >= s.StartTime-t.timeout {
+		s.Pageviews++
+		if ts < s.StartTime {
+			s.StartTime = ts
+			s.EntryURL = entry.Url
+		}
+		if ts >= s.EndTime {
+			s.EndTime = ts
+			s.ExitURL = entry.Url
+		}
+	} else {
+		s = &SessionRecord{
+			ID:          t.nextID,
+			VisitorHash: entry.VisitorHash,
+			IP:          entry.IP,
+			StartTime:   ts,
+			EndTime:     ts,
+			Pageviews:   1,
+			EntryURL:    entry.Url,
+			ExitURL:     entry.Url,
+		}
+		s.Fingerprint = visitorFingerprint(t.mode, s)
+		t.nextID++
+		if current, found := t.open[entry.VisitorHash]; !found || ts > current.EndTime {
+			t.open[entry.VisitorHash] = s
+		}
+	}
+
+	entry.SessionID = s.ID
+	t.dirty[s.ID] = s
+	if ts > t.latest {
+		t.latest = ts
+	}
+}
+
+// reset 丢弃尚未写入的会话，从数据库重新载入未结束的会话
+func (t *sessionTracker) reset() error {
+	fresh, err := newSessionTracker(t.repo, t.websiteID)
+	if err != nil {
+		return err
+	}
+	*t = *fresh
+	return nil
+}
+
+// flush 写入有变化的会话，并丢弃已经不可能延续的会话
+func (t *sessionTracker) flush() error {
+	sessions := make([]*SessionRecord, 0, len(t.dirty))
+	for _, s := range t.dirty {
+		sessions = append(sessions, s)
+	}
+	if err := t.repo.saveSessions(t.websiteID, sessions); err != nil {
+		return fmt.Errorf("保存会话失败: %v", err)
+	}
+	t.dirty = make(map[int64]*SessionRecord)
+
+	for hash, s := range t.open {
+		if s.EndTime < t.latest-t.timeout {
+			delete(t.open, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionBackfillBatch 补划会话时每批读取的日志数
+const sessionBackfillBatch = 5000
+
+// backfillSessions 为会话划分上线之前入库的日志补划会话。这些日志的 session_id 都为 0，
+// 目标转化、漏斗和访问统计都按会话计算，补划之后同样可以统计。
+// 只处理早于最早一个会话的日志：之后入库的日志已经划分过，其中 session_id 为 0 的是蜘蛛
+// 或不属于任何访问的请求。返回补划了会话的日志数
+func (r *Repository) backfillSessions(websiteID string) (int, error) {
+	var earliest sql.NullInt64
+	if err := r.db.QueryRow(fmt.Sprintf(
+		`SELECT MIN(start_time) FROM "%s_sessions"`, websiteID)).Scan(&earliest); err != nil {
+		return 0, fmt.Errorf("查询最早的会话失败: %v", err)
+	}
+	before := int64(math.MaxInt64)
+	if earliest.Valid {
+		before = earliest.Int64
+	}
+
+	// 旧日志的浏览记录一定没有会话，没有这样的记录就不需要补划
+	var pending bool
+	if err := r.db.QueryRow(fmt.Sprintf(`
+        SELECT EXISTS (
+            SELECT 1 FROM "%s_nginx_logs"
+            WHERE pageview_flag = 1 AND session_id = 0 AND timestamp < ?)`, websiteID),
+		before).Scan(&pending); err != nil {
+		return 0, fmt.Errorf("查询没有会话的日志失败: %v", err)
+	}
+	if !pending {
+		return 0, nil
+	}
+
+	logrus.Infof("开始为站点 %s 的旧日志补划会话", websiteID)
+	assigned, err := r.assignSessions(websiteID, 0, before)
+	if err != nil {
+		return assigned, err
+	}
+	logrus.Infof("站点 %s 的 %d 条旧日志已补划会话", websiteID, assigned)
+	return assigned, nil
+}
+
+// assignSessions 为时间范围 [start, end) 内没有会话的日志按时间顺序划分会话，超时规则与入库时相同。
+// 日志表不保存 User-Agent，这里的访客只按 IP 区分：同一 IP 下的多个设备会合并为一次访问，
+// 会话的访客指纹也总是使用 IP，回访判断见 saveVisitors。
+// 调用方需要保证同一站点没有同时在入库，否则两边会分配出相同的会话 ID
+func (r *Repository) assignSessions(websiteID string, start, end int64) (int, error) {
+	tracker, err := newEmptySessionTracker(r, websiteID)
+	if err != nil {
+		return 0, err
+	}
+	tracker.mode = util.VisitorFingerprintIP
+
+	assigned := 0
+	lastTs, lastID := start, int64(0)
+	for {
+		batch, ids, err := r.unassignedLogs(websiteID, lastTs, lastID, end)
+		if err != nil {
+			return assigned, err
+		}
+		if len(batch) == 0 {
+			return assigned, nil
+		}
+
+		for i := range batch {
+			tracker.assign(&batch[i])
+		}
+		count, err := r.updateLogSessions(websiteID, batch, ids)
+		if err != nil {
+			return assigned, err
+		}
+		if err := tracker.flush(); err != nil {
+			return assigned, err
+		}
+		assigned += count
+
+		lastTs, lastID = batch[len(batch)-1].Timestamp.Unix(), ids[len(ids)-1]
+	}
+}
+
+// unassignedLogs 按（时间, id）的顺序读取 (lastTs, lastID) 之后、end 之前没有会话的一批日志
+func (r *Repository) unassignedLogs(websiteID string, lastTs, lastID, end int64) ([]NginxLogRecord, []int64, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT id, ip, timestamp, url, pageview_flag, is_spider
+        FROM "%s_nginx_logs" INDEXED BY idx_%[1]s_timestamp
+        WHERE session_id = 0 AND timestamp >= ? AND timestamp < ?
+            AND (timestamp > ? OR id > ?)
+        ORDER BY timestamp, id
+        LIMIT ?`, websiteID), lastTs, end, lastTs, lastID, sessionBackfillBatch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取没有会话的日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	var batch []NginxLogRecord
+	var ids []int64
+	for rows.Next() {
+		var id, ts int64
+		var entry NginxLogRecord
+		if err := rows.Scan(&id, &entry.IP, &ts, &entry.Url, &entry.PageviewFlag, &entry.IsSpider); err != nil {
+			return nil, nil, fmt.Errorf("解析日志失败: %v", err)
+		}
+		entry.Timestamp = time.Unix(ts, 0)
+		entry.VisitorHash = visitorHash(entry.IP, "")
+		batch = append(batch, entry)
+		ids = append(ids, id)
+	}
+	return batch, ids, rows.Err()
+}
+
+// updateLogSessions 在一个事务中写入日志所属的会话，返回更新的日志数
+func (r *Repository) updateLogSessions(websiteID string, batch []NginxLogRecord, ids []int64) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`UPDATE "%s_nginx_logs" SET session_id = ? WHERE id = ?`, websiteID))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	count := 0
+	for i, entry := range batch {
+		if entry.SessionID == 0 {
+			continue
+		}
+		if _, err := stmt.Exec(entry.SessionID, ids[i]); err != nil {
+			return 0, fmt.Errorf("更新日志的会话失败: %v", err)
+		}
+		count++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("提交会话更新失败: %v", err)
+	}
+	return count, nil
+}
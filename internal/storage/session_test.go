@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func TestSessionTrackerAssign(t *testing.T) {
+	const base = 1700000000
+	const timeout = 1800
+
+	// 一条请求：访客、相对 base 的秒数、URL、是否 PV、是否蜘蛛
+	type request struct {
+		visitor string
+		offset  int64
+		url     string
+		pv      bool
+		spider  bool
+	}
+	type session struct {
+		start, end int64 // 相对 base 的秒数
+		pageviews  int
+		entry      string
+		exit       string
+	}
+
+	tests := []struct {
+		name         string
+		requests     []request
+		wantIDs      []int64 // 每条请求分配到的会话，0 表示不属于任何会话
+		wantSessions map[int64]session
+	}{
+		{
+			name: "超时之内的浏览属于同一会话",
+			requests: []request{
+				{"a", 0, "/", true, false},
+				{"a", 600, "/pricing", true, false},
+				{"a", 600 + timeout, "/signup", true, false},
+			},
+			wantIDs: []int64{1, 1, 1},
+			wantSessions: map[int64]session{
+				1: {0, 600 + timeout, 3, "/", "/signup"},
+			},
+		},
+		{
+			name: "间隔超过超时开始新会话",
+			requests: []request{
+				{"a", 0, "/", true, false},
+				{"a", timeout + 1, "/blog", true, false},
+			},
+			wantIDs: []int64{1, 2},
+			wantSessions: map[int64]session{
+				1: {0, 0, 1, "/", "/"},
+				2: {timeout + 1, timeout + 1, 1, "/blog", "/blog"},
+			},
+		},
+		{
+			name: "不同访客交错",
+			requests: []request{
+				{"a", 0, "/a1", true, false},
+				{"b", 10, "/b1", true, false},
+				{"a", 20, "/a2", true, false},
+				{"b", 30, "/b2", true, false},
+			},
+			wantIDs: []int64{1, 2, 1, 2},
+			wantSessions: map[int64]session{
+				1: {0, 20, 2, "/a1", "/a2"},
+				2: {10, 30, 2, "/b1", "/b2"},
+			},
+		},
+		{
+			name: "非浏览请求只归入已有会话",
+			requests: []request{
+				{"a", 0, "/api/ping", false, false},
+				{"a", 10, "/", true, false},
+				{"a", 20, "/api/submit", false, false},
+				{"a", 30, "/robots.txt", false, true},
+				{"a", 10 + timeout + 1, "/api/late", false, false},
+			},
+			wantIDs: []int64{0, 1, 1, 0, 0},
+			wantSessions: map[int64]session{
+				1: {10, 10, 1, "/", "/"},
+			},
+		},
+		{
+			name: "乱序的记录并入会话并更新入口页",
+			requests: []request{
+				{"a", 1000, "/second", true, false},
+				{"a", 400, "/first", true, false},
+				{"a", 400 - timeout - 1, "/too-early", true, false},
+			},
+			wantIDs: []int64{1, 1, 2},
+			wantSessions: map[int64]session{
+				1: {400, 1000, 2, "/first", "/second"},
+				2: {400 - timeout - 1, 400 - timeout - 1, 1, "/too-early", "/too-early"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &sessionTracker{
+				timeout: timeout,
+				mode:    util.VisitorFingerprintIPUA,
+				nextID:  1,
+				open:    make(map[string]*SessionRecord),
+				dirty:   make(map[int64]*SessionRecord),
+			}
+
+			ids := make([]int64, len(tt.requests))
+			for i, req := range tt.requests {
+				entry := NginxLogRecord{
+					IP:          req.visitor,
+					Timestamp:   time.Unix(base+req.offset, 0),
+					Url:         req.url,
+					VisitorHash: visitorHash(req.visitor, "UA"),
+				}
+				if req.pv {
+					entry.PageviewFlag = 1
+				}
+				if req.spider {
+					entry.IsSpider = 1
+				}
+				tracker.assign(&entry)
+				ids[i] = entry.SessionID
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("会话 ID = %v, 期望 %v", ids, tt.wantIDs)
+			}
+			if len(tracker.dirty) != len(tt.wantSessions) {
+				t.Errorf("待写入 %d 个会话, 期望 %d 个", len(tracker.dirty), len(tt.wantSessions))
+			}
+			for id, want := range tt.wantSessions {
+				s, ok := tracker.dirty[id]
+				if !ok {
+					t.Errorf("缺少会话 %d", id)
+					continue
+				}
+				got := session{s.StartTime - base, s.EndTime - base, s.Pageviews, s.EntryURL, s.ExitURL}
+				if got != want {
+					t.Errorf("会话 %d = %+v, 期望 %+v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVisitorFingerprint(t *testing.T) {
+	s := &SessionRecord{IP: "1.2.3.4", VisitorHash: visitorHash("1.2.3.4", "UA")}
+
+	if got := visitorFingerprint(util.VisitorFingerprintIP, s); got != "1.2.3.4" {
+		t.Errorf("按 IP 识别的指纹 = %q, 期望 IP", got)
+	}
+	if got := visitorFingerprint(util.VisitorFingerprintIPUA, s); got != s.VisitorHash {
+		t.Errorf("按 IP 和 UA 识别的指纹 = %q, 期望访客标识", got)
+	}
+	if visitorHash("1.2.3.4", "UA") == visitorHash("1.2.3.4", "UA2") {
+		t.Error("UA 不同的访客标识应不同")
+	}
+}
+
+func TestSessionTrackerContinuesBackfilledSession(t *testing.T) {
+	const base = 1700000000
+	const timeout = 1800
+
+	// 补划出的会话只按 IP 区分，指纹为 IP
+	backfilled := &SessionRecord{
+		ID: 1, VisitorHash: visitorHash("1.2.3.4", ""), Fingerprint: "1.2.3.4", IP: "1.2.3.4",
+		StartTime: base, EndTime: base, Pageviews: 1, EntryURL: "/", ExitURL: "/",
+	}
+	tracker := &sessionTracker{
+		timeout: timeout,
+		mode:    util.VisitorFingerprintIPUA,
+		nextID:  2,
+		open:    map[string]*SessionRecord{backfilled.VisitorHash: backfilled},
+		dirty:   make(map[int64]*SessionRecord),
+	}
+
+	tests := []struct {
+		name   string
+		ip     string
+		offset int64
+		wantID int64
+	}{
+		{"同一 IP 在超时之内延续补划的会话", "1.2.3.4", 600, 1},
+		{"其他 IP 开始新会话", "5.6.7.8", 700, 2},
+		{"超时之后开始新会话", "1.2.3.4", 600 + timeout + 1, 3},
+		{"之后按 IP 和 UA 延续新会话", "1.2.3.4", 600 + timeout + 60, 3},
+	}
+
+	for _, tt := range tests {
+		entry := NginxLogRecord{
+			IP:           tt.ip,
+			Timestamp:    time.Unix(base+tt.offset, 0),
+			Url:          "/page",
+			PageviewFlag: 1,
+			VisitorHash:  visitorHash(tt.ip, "UA"),
+		}
+		tracker.assign(&entry)
+		if entry.SessionID != tt.wantID {
+			t.Errorf("%s: 会话 ID = %d, 期望 %d", tt.name, entry.SessionID, tt.wantID)
+		}
+	}
+
+	if backfilled.Pageviews != 2 || backfilled.EndTime != base+600 {
+		t.Errorf("补划的会话 = %+v, 期望延续到第 600 秒", backfilled)
+	}
+	if s := tracker.dirty[3]; s == nil || s.Fingerprint != visitorHash("1.2.3.4", "UA") || s.Pageviews != 2 {
+		t.Errorf("新会话 = %+v, 期望按 IP 和 UA 识别并包含 2 次浏览", s)
+	}
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// WebsiteLogCount 返回站点日志表中的记录数，表不存在时返回 0
+func (r *Repository) WebsiteLogCount(websiteID string) (int, error) {
+	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
+
+	var exists int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name = ?`, tableName).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("查询站点表失败: %v", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var count int
+	if err := r.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, tableName)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("统计站点日志失败: %v", err)
+	}
+	return count, nil
+}
+
+// ExportWebsiteData 将站点的全部日志（包括已归档的天）导出为压缩的 NDJSON 文件，返回文件路径和行数。
+// 归档的日志按日期在前，之后是日志表中的记录。站点没有数据时不生成文件，返回空路径。
+// 归档文件缺失或损坏时导出失败，避免随后清除站点时丢失这部分数据
+func (r *Repository) ExportWebsiteData(websiteID string) (string, int, error) {
+	cfg := util.GetArchiveConfig()
+
+	// 导出期间持有归档锁，某一天不会在读完归档之后才被归档而漏导
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	manifest, err := loadArchiveManifest(cfg.Dir)
+	if err != nil {
+		return "", 0, err
+	}
+	archives := make([]ArchiveEntry, 0)
+	for _, entry := range manifest.Entries {
+		if entry.WebsiteID == websiteID {
+			archives = append(archives, entry)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Date < archives[j].Date
+	})
+
+	count, err := r.WebsiteLogCount(websiteID)
+	if err != nil {
+		return "", 0, err
+	}
+	if count == 0 && len(archives) == 0 {
+		return "", 0, nil
+	}
+
+	exportDir := filepath.Join(util.DataDir, "exports")
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return "", 0, fmt.Errorf("创建导出目录失败: %v", err)
+	}
+	path := filepath.Join(exportDir,
+		fmt.Sprintf("%s-%s.ndjson.gz", websiteID, time.Now().Format("20060102150405")))
+	tmpPath := path + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("创建导出文件失败: %v", err)
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+	written := 0
+
+	for _, entry := range archives {
+		n, err := copyArchivedLogs(filepath.Join(cfg.Dir, entry.File), encoder)
+		if err != nil {
+			return "", 0, fmt.Errorf("导出站点 %s 在 %s 的归档日志失败: %v", websiteID, entry.Date, err)
+		}
+		written += n
+	}
+
+	if count > 0 {
+		rows, err := r.db.Query(fmt.Sprintf(`
+            SELECT %s
+            FROM "%s_nginx_logs"
+            ORDER BY id`, logRecordColumns, websiteID))
+		if err != nil {
+			return "", 0, fmt.Errorf("查询站点日志失败: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var record NginxLogRecord
+			if err := scanLogRecord(rows, &record); err != nil {
+				return "", 0, fmt.Errorf("读取待导出日志失败: %v", err)
+			}
+			if err := encoder.Encode(&record); err != nil {
+				return "", 0, fmt.Errorf("写入导出文件失败: %v", err)
+			}
+			written++
+		}
+		if err := rows.Err(); err != nil {
+			return "", 0, fmt.Errorf("遍历待导出日志失败: %v", err)
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return "", 0, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", 0, fmt.Errorf("保存导出文件失败: %v", err)
+	}
+
+	logrus.Infof("站点 %s 的 %d 条日志（含 %d 天归档）已导出到 %s", websiteID, written, len(archives), path)
+	return path, written, nil
+}
+
+// copyArchivedLogs 将归档文件中的日志原样写入导出文件，返回行数
+func copyArchivedLogs(path string, encoder *json.Encoder) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("打开归档文件失败: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, fmt.Errorf("读取归档文件失败: %v", err)
+	}
+	defer gz.Close()
+
+	copied := 0
+	decoder := json.NewDecoder(gz)
+	for {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return copied, fmt.Errorf("解析归档文件失败: %v", err)
+		}
+		if err := encoder.Encode(record); err != nil {
+			return copied, fmt.Errorf("写入导出文件失败: %v", err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// PurgeWebsiteData 在一个事务中删除站点的日志表（连同索引和全文索引）、会话表、访客表、可疑 IP 记录、
+// 目标、漏斗和导出任务，提交后再删除归档和导出文件。
+// 只删除数据库中的数据，站点的配置由调用方在删除成功后再移除。
+// 服务运行时应通过 LogParser.PurgeWebsite 调用，避免与扫描同时进行
+func (r *Repository) PurgeWebsiteData(websiteID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exportJobs []ExportJob
+	rows, err := tx.Query(`SELECT id, format FROM export_jobs WHERE website_id = ?`, websiteID)
+	if err != nil {
+		return fmt.Errorf("查询站点导出任务失败: %v", err)
+	}
+	for rows.Next() {
+		var j ExportJob
+		if err := rows.Scan(&j.ID, &j.Format); err != nil {
+			rows.Close()
+			return fmt.Errorf("解析导出任务失败: %v", err)
+		}
+		exportJobs = append(exportJobs, j)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s_nginx_logs"`, websiteID)); err != nil {
+		return fmt.Errorf("删除站点日志表失败: %v", err)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, logSearchTable(websiteID))); err != nil {
+		return fmt.Errorf("删除站点全文索引失败: %v", err)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s_sessions"`, websiteID)); err != nil {
+		return fmt.Errorf("删除站点会话表失败: %v", err)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s_visitors"`, websiteID)); err != nil {
+		return fmt.Errorf("删除站点访客表失败: %v", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM suspicious_ips WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点可疑 IP 记录失败: %v", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM goals WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点目标失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM funnels WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点漏斗失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM anomalies WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点流量异常失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM report_subscriptions WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点报表订阅失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM alert_rules WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点告警规则失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM alert_history WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点告警历史失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM export_jobs WHERE website_id = ?`, websiteID); err != nil {
+		return fmt.Errorf("删除站点导出任务失败: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交删除事务失败: %v", err)
+	}
+
+	r.searchIndexes.Delete(websiteID)
+	r.purgeArchives(websiteID)
+	for _, j := range exportJobs {
+		if err := os.Remove(j.FilePath()); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Warnf("删除导出文件 %s 失败", j.FilePath())
+		}
+	}
+
+	logrus.Infof("站点 %s 的数据已清除", websiteID)
+	return nil
+}
+
+// purgeArchives 删除站点的归档文件和归档清单记录，失败只记录日志
+func (r *Repository) purgeArchives(websiteID string) {
+	cfg := util.GetArchiveConfig()
+
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	manifest, err := loadArchiveManifest(cfg.Dir)
+	if err != nil {
+		logrus.WithError(err).Warnf("清除站点 %s 的归档记录失败", websiteID)
+		return
+	}
+
+	entries := make([]ArchiveEntry, 0, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		if entry.WebsiteID != websiteID {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != len(manifest.Entries) {
+		manifest.Entries = entries
+		if err := saveArchiveManifest(cfg.Dir, manifest); err != nil {
+			logrus.WithError(err).Warnf("清除站点 %s 的归档记录失败", websiteID)
+			return
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(cfg.Dir, websiteID)); err != nil {
+		logrus.WithError(err).Warnf("删除站点 %s 的归档文件失败", websiteID)
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"modernc.org/sqlite"
+)
+
+// regexpCache 缓存已编译的正则，同一查询会对每一行调用 regexp 函数
+var regexpCache sync.Map
+
+// 注册查询中使用的自定义函数，需在打开连接前完成：
+//   - SQLite 没有内置 REGEXP 的实现，"X REGEXP Y" 会调用 regexp(Y, X)
+//   - url_host(referer) 返回来源的规范化域名
+//   - url_ext(url) 返回请求路径的扩展名
+//   - referer_category(referer, hosts) 返回来源类别，hosts 为逗号分隔的站点域名
+//   - search_keyword(referer) 返回来源中的搜索关键词
+//   - ip_in_cidr(ip, cidr) 判断 IP 是否属于 CIDR 网段，IP 无效时为 false
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			pattern, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("REGEXP 的模式必须是字符串")
+			}
+			if args[1] == nil {
+				return false, nil
+			}
+
+			re, err := compileCachedRegexp(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(sqlText(args[1])), nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("url_host", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return URLHost(sqlText(args[0])), nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("url_ext", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return URLExtension(sqlText(args[0])), nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("referer_category", 2,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			hosts := strings.Split(sqlText(args[1]), ",")
+			return netparser.ClassifyReferer(sqlText(args[0]), hosts).Category, nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("search_keyword", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return netparser.ClassifyReferer(sqlText(args[0]), nil).Keyword, nil
+		})
+	sqlite.MustRegisterDeterministicScalarFunction("ip_in_cidr", 2,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			prefix, err := netip.ParsePrefix(sqlText(args[1]))
+			if err != nil {
+				return nil, fmt.Errorf("ip_in_cidr 的网段无效: %v", err)
+			}
+			addr, err := netip.ParseAddr(sqlText(args[0]))
+			if err != nil {
+				return false, nil
+			}
+			return prefix.Contains(addr.Unmap()), nil
+		})
+}
+
+// sqlText 将 SQLite 传入的值转为字符串，NULL 为空字符串
+func sqlText(value driver.Value) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// URLHost 从完整 URL 中取出域名：小写、去掉端口和 "www." 前缀，无法解析时返回空字符串
+func URLHost(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return NormalizeHost(u.Hostname())
+}
+
+// NormalizeHost 规范化域名，小写并去掉 "www." 前缀
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	return strings.TrimPrefix(host, "www.")
+}
+
+// URLExtension 返回请求路径的小写扩展名（不含点），忽略查询参数，没有扩展名时返回空字符串
+func URLExtension(requestURL string) string {
+	if i := strings.IndexAny(requestURL, "?#"); i >= 0 {
+		requestURL = requestURL[:i]
+	}
+	ext := path.Ext(requestURL)
+	if ext == "" || strings.Contains(ext, "/") {
+		return ""
+	}
+	return strings.ToLower(ext[1:])
+}
+
+// compileCachedRegexp 编译正则并缓存
+func compileCachedRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// visitorRetentionDays 访客超过该天数没有再访问时从首次访问表中删除
+const visitorRetentionDays = 400
+
+// visitorFingerprint 按配置的方式生成会话所属访客的指纹：
+// ip_ua 时与会话划分使用的访客标识相同，ip 时直接使用 IP
+func visitorFingerprint(mode string, s *SessionRecord) string {
+	if mode == util.VisitorFingerprintIP {
+		return s.IP
+	}
+	return s.VisitorHash
+}
+
+// createVisitorTable 创建站点的访客首次访问表。日志的保留期有限，
+// 这张表记录每个访客最早和最近一次访问的时间，用于区分新访客和回访访客。
+// 新建时用已有的会话补齐，旧版本的会话表会先补上 fingerprint 列。
+func (r *Repository) createVisitorTable(websiteID string) error {
+	if _, err := r.db.Exec(fmt.Sprintf(
+		`ALTER TABLE "%s_sessions" ADD COLUMN fingerprint TEXT NOT NULL DEFAULT ''`, websiteID)); err != nil {
+		// 字段可能已存在，忽略错误
+		logrus.WithError(err).Debugf("字段可能已存在，跳过 ALTER TABLE")
+	}
+
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%[1]s_visitors" (
+            fingerprint TEXT PRIMARY KEY,
+            first_seen INTEGER NOT NULL,
+            last_seen INTEGER NOT NULL
+        ) WITHOUT ROWID;
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_visitors_first_seen ON "%[1]s_visitors"(first_seen);
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_sessions_fingerprint ON "%[1]s_sessions"(fingerprint);`,
+		websiteID))
+	if err != nil {
+		return fmt.Errorf("创建访客表失败: %v", err)
+	}
+
+	return r.backfillVisitors(websiteID)
+}
+
+// backfillVisitors 为没有指纹的会话补上指纹，并把这些会话计入访客表
+func (r *Repository) backfillVisitors(websiteID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	column := "visitor_hash"
+	if util.GetVisitorFingerprint() == util.VisitorFingerprintIP {
+		column = "ip"
+	}
+	result, err := tx.Exec(fmt.Sprintf(
+		`UPDATE "%s_sessions" SET fingerprint = %s WHERE fingerprint = ''`, websiteID, column))
+	if err != nil {
+		return fmt.Errorf("补齐会话指纹失败: %v", err)
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`
+        INSERT INTO "%[1]s_visitors" (fingerprint, first_seen, last_seen)
+        SELECT fingerprint, MIN(start_time), MAX(end_time)
+        FROM "%[1]s_sessions"
+        GROUP BY fingerprint
+        ON CONFLICT(fingerprint) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen)`, websiteID))
+	if err != nil {
+		return fmt.Errorf("补齐访客表失败: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logrus.Infof("站点 %s 的访客表已根据已有会话补齐", websiteID)
+	return nil
+}
+
+// saveVisitors 在写入会话的事务中更新会话所属访客的首次和最近访问时间。
+// 补划会话的旧日志没有 UA，指纹总是 IP；按 IP 和 UA 识别访客时，首次访问时间也参考
+// 同一 IP 在旧日志中的记录，旧日志里出现过的访客再次访问时算作回访
+func saveVisitors(tx *sql.Tx, websiteID string, sessions []*SessionRecord) error {
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+        INSERT INTO "%[1]s_visitors" (fingerprint, first_seen, last_seen)
+        VALUES (?, MIN(?, COALESCE((SELECT first_seen FROM "%[1]s_visitors" WHERE fingerprint = ?), ?)), ?)
+        ON CONFLICT(fingerprint) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen)`, websiteID))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range sessions {
+		if _, err := stmt.Exec(s.Fingerprint, s.StartTime, s.IP, s.StartTime, s.EndTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVisitorsBefore 删除最近一次访问早于 cutoff 的访客
+func (r *Repository) deleteVisitorsBefore(websiteID string, cutoff int64) error {
+	_, err := r.db.Exec(
+		fmt.Sprintf(`DELETE FROM "%s_visitors" WHERE last_seen < ?`, websiteID), cutoff)
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var (
@@ -116,7 +117,10 @@ func writeDefaultConfig() error {
   ],
   "system": {
     "logDestination": "file",
-    "taskInterval": "5m"
+    "taskInterval": "5m",
+    "maintenanceWindow": "02:00-05:00",
+    "vacuumStepPages": 2000,
+    "sessionTimeout": "30m"
   },
   "server": {
     "Port": ":8088"
@@ -138,6 +142,11 @@ func writeDefaultConfig() error {
       "feed.xml$",
       "atom.xml$"
     ]
+  },
+  "archive": {
+    "enabled": false,
+    "dir": "./nixvis_data/archive",
+    "format": "ndjson.gz"
   }
 }
 `
@@ -164,9 +173,24 @@ func validateConfig() bool {
 		return true
 	}
 
-	// 检查每个日志文件是否存在
+	// 检查站点时区
+	for _, site := range cfg.Websites {
+		if site.Timezone == "" {
+			continue
+		}
+		if _, err := time.LoadLocation(site.Timezone); err != nil {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的 timezone 无效: %v\n", site.Name, err)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+	}
+
+	// 检查每个日志文件是否存在，已隐藏的站点不再扫描日志，其日志文件可以已被删除
 	var missingLogs []string
 	for _, site := range cfg.Websites {
+		if site.Hidden {
+			continue
+		}
 		if site.LogPath == "" {
 			missingLogs = append(missingLogs,
 				fmt.Sprintf("'%s' (缺少日志文件路径配置)", site.Name))
@@ -214,6 +238,44 @@ func validateConfig() bool {
 		return true
 	}
 
+	// 检查空间回收时段
+	if cfg.System.MaintenanceWindow != "" {
+		if _, err := InTimeWindow(cfg.System.MaintenanceWindow, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "配置文件错误: system.maintenanceWindow %v\n", err)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+	}
+
+	if cfg.System.SessionTimeout != "" {
+		if timeout, err := time.ParseDuration(cfg.System.SessionTimeout); err != nil || timeout <= 0 {
+			fmt.Fprintf(os.Stderr, "配置文件错误: system.sessionTimeout 必须是正的时长，如 30m\n")
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+	}
+
+	switch cfg.System.VisitorFingerprint {
+	case "", VisitorFingerprintIPUA, VisitorFingerprintIP:
+	default:
+		fmt.Fprintf(os.Stderr, "配置文件错误: system.visitorFingerprint 只能是 ip_ua 或 ip\n")
+		fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+		return true
+	}
+
+	if err := validateSiteGroups(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "配置文件错误: groups %v\n", err)
+		fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+		return true
+	}
+
+	// 检查归档配置
+	if cfg.Archive.Format != "" && cfg.Archive.Format != "ndjson.gz" {
+		fmt.Fprintf(os.Stderr, "配置文件错误: archive.format 仅支持 ndjson.gz\n")
+		fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+		return true
+	}
+
 	return false
 }
 
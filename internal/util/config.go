@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
+	_ "time/tzdata" // 内置时区数据，系统缺少 zoneinfo 时站点时区仍然可用
 
 	"github.com/sirupsen/logrus"
 )
@@ -22,22 +24,76 @@ type Config struct {
 	Server   ServerConfig    `json:"server"`
 	Websites []WebsiteConfig `json:"websites"`
 	PVFilter PVFilterConfig  `json:"pvFilter"`
+	Archive  ArchiveConfig   `json:"archive"`
+	Groups   []SiteGroup     `json:"groups,omitempty"` // 站点组，用于多站点汇总统计
+	Anomaly  AnomalyConfig   `json:"anomaly"`
+	SMTP     SMTPConfig      `json:"smtp"` // 发送邮件报表的 SMTP 服务器
 }
 
 type WebsiteConfig struct {
 	Name    string `json:"name"`
 	LogPath string `json:"logPath"`
+	Hidden  bool   `json:"hidden,omitempty"` // 软删除：保留数据但不再扫描和展示
+
+	// 站点自身的域名，用于区分站内来源和外部来源；未配置时根据站内跳转自动推断
+	Domains []string `json:"domains,omitempty"`
+
+	// 站点所在的时区（IANA 名称，如 "Asia/Shanghai"），用于按星期和小时的统计；未配置时使用服务器时区
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// SiteGroup 站点组，Sites 可以是站点名称或站点 ID
+type SiteGroup struct {
+	Name  string   `json:"name"`
+	Sites []string `json:"sites"`
 }
 
 type SystemConfig struct {
-	LogDestination string `json:"logDestination"`
-	TaskInterval   string `json:"taskInterval"` // "5m" "25s"
+	LogDestination     string `json:"logDestination"`
+	TaskInterval       string `json:"taskInterval"`       // "5m" "25s"
+	MaintenanceWindow  string `json:"maintenanceWindow"`  // 空间回收的空闲时段，如 "02:00-05:00"
+	VacuumStepPages    int    `json:"vacuumStepPages"`    // 每次增量回收的最大页数
+	SessionTimeout     string `json:"sessionTimeout"`     // 会话的不活动超时，如 "30m"
+	RefererRules       string `json:"refererRules"`       // 来源分类规则文件，默认 nixvis_data/referer_rules.json
+	VisitorFingerprint string `json:"visitorFingerprint"` // 识别回访访客的依据，"ip_ua"（默认）或 "ip"
+	StatsCacheSize     int    `json:"statsCacheSize"`     // 统计缓存最多保存的查询结果数，默认 1000
 }
 
 type ServerConfig struct {
 	Port string `json:"Port"`
 }
 
+type ArchiveConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir"`    // 归档目录，默认 nixvis_data/archive
+	Format  string `json:"format"` // 目前仅支持 "ndjson.gz"
+}
+
+// AnomalyConfig 流量异常检测，每次扫描日志后检测各站点上一个整点小时的数据
+type AnomalyConfig struct {
+	Disabled  bool    `json:"disabled,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"` // 偏离基线多少倍标准差视为异常，默认 3
+	MinVolume int     `json:"minVolume,omitempty"` // 当前值和基线都低于该值时不检测，默认 20
+	Channels  []int64 `json:"channels,omitempty"`  // 发现异常时通知的告警渠道 ID
+}
+
+// SMTP 连接的加密方式
+const (
+	SMTPEncryptionStartTLS = "starttls" // 明文连接后升级为 TLS（默认，端口 587）
+	SMTPEncryptionTLS      = "tls"      // 直接使用 TLS 连接（端口 465）
+	SMTPEncryptionNone     = "none"     // 不加密，仅用于本机或内网的邮件服务
+)
+
+// SMTPConfig 发送邮件的 SMTP 服务器，Username 为空时不进行认证
+type SMTPConfig struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	From       string `json:"from"`                 // 发件人，如 "NixVis <nixvis@example.com>"
+	Encryption string `json:"encryption,omitempty"` // starttls / tls / none
+}
+
 type PVFilterConfig struct {
 	StatusCodeInclude []int    `json:"statusCodeInclude"`
 	ExcludePatterns   []string `json:"excludePatterns"`
@@ -98,11 +154,38 @@ func GetWebsiteByID(id string) (WebsiteConfig, bool) {
 	return WebsiteConfig{}, false
 }
 
-// GetAllWebsiteIDs 获取所有网站的 ID 列表
+// GetWebsiteLocation 获取站点的时区，站点不存在或未配置时区时为服务器时区
+func GetWebsiteLocation(id string) *time.Location {
+	website, ok := GetWebsiteByID(id)
+	if !ok || website.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(website.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// GetAllWebsiteIDs 获取所有网站的 ID 列表（不含已隐藏的站点）
 func GetAllWebsiteIDs() []string {
 	var ids []string
 	websiteIDMap.Range(func(key, value interface{}) bool {
-		ids = append(ids, key.(string))
+		if !value.(WebsiteConfig).Hidden {
+			ids = append(ids, key.(string))
+		}
+		return true
+	})
+	return ids
+}
+
+// GetHiddenWebsiteIDs 获取已隐藏（软删除）站点的 ID 列表
+func GetHiddenWebsiteIDs() []string {
+	var ids []string
+	websiteIDMap.Range(func(key, value interface{}) bool {
+		if value.(WebsiteConfig).Hidden {
+			ids = append(ids, key.(string))
+		}
 		return true
 	})
 	return ids
@@ -163,6 +246,9 @@ func AddWebsite(name, logPath string) error {
 	// 检查是否已存在同名站点
 	for _, site := range cfg.Websites {
 		if site.Name == name {
+			if site.Hidden {
+				return fmt.Errorf("站点 %s 已存在（已隐藏），请恢复该站点", name)
+			}
 			return fmt.Errorf("站点 %s 已存在", name)
 		}
 	}
@@ -175,7 +261,7 @@ func AddWebsite(name, logPath string) error {
 	return SaveConfig(cfg)
 }
 
-// RemoveWebsite 删除站点
+// RemoveWebsite 删除站点，同时从所在的站点组中移除，移除后没有成员的站点组一并删除
 func RemoveWebsite(id string) error {
 	cfg, err := ReadRawConfig()
 	if err != nil {
@@ -196,6 +282,7 @@ func RemoveWebsite(id string) error {
 	}
 
 	cfg.Websites = newWebsites
+	cfg.Groups = removeSiteFromGroups(cfg.Groups, website.Name)
 	if err := SaveConfig(cfg); err != nil {
 		return err
 	}
@@ -206,6 +293,62 @@ func RemoveWebsite(id string) error {
 	return nil
 }
 
+// removeSiteFromGroups 从站点组中移除站点（组成员可以是站点名称或 ID）
+func removeSiteFromGroups(groups []SiteGroup, name string) []SiteGroup {
+	id := generateID(name)
+	kept := make([]SiteGroup, 0, len(groups))
+	for _, group := range groups {
+		sites := make([]string, 0, len(group.Sites))
+		for _, site := range group.Sites {
+			if site != name && site != id {
+				sites = append(sites, site)
+			}
+		}
+		if len(sites) == 0 {
+			continue
+		}
+		group.Sites = sites
+		kept = append(kept, group)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+// SetWebsiteHidden 隐藏或恢复站点，隐藏的站点数据保留但不再扫描和展示
+func SetWebsiteHidden(id string, hidden bool) error {
+	cfg, err := ReadRawConfig()
+	if err != nil {
+		return err
+	}
+
+	website, ok := GetWebsiteByID(id)
+	if !ok {
+		return fmt.Errorf("站点 %s 不存在", id)
+	}
+
+	found := false
+	for i := range cfg.Websites {
+		if cfg.Websites[i].Name == website.Name {
+			cfg.Websites[i].Hidden = hidden
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("站点 %s 不存在", id)
+	}
+
+	if err := SaveConfig(cfg); err != nil {
+		return err
+	}
+
+	website.Hidden = hidden
+	websiteIDMap.Store(id, website)
+
+	return nil
+}
+
 // ReloadConfig 重新加载配置并更新映射
 func ReloadConfig() error {
 	// 读取配置文件
@@ -249,6 +392,118 @@ func GetPVFilterConfig() PVFilterConfig {
 	return cfg.PVFilter
 }
 
+// GetArchiveConfig 获取日志归档配置，未配置的字段使用默认值
+func GetArchiveConfig() ArchiveConfig {
+	cfg := ReadConfig()
+	archive := cfg.Archive
+	if archive.Dir == "" {
+		archive.Dir = filepath.Join(DataDir, "archive")
+	}
+	if archive.Format == "" {
+		archive.Format = "ndjson.gz"
+	}
+	return archive
+}
+
+// GetAnomalyConfig 获取异常检测配置，未配置的项使用默认值
+func GetAnomalyConfig() AnomalyConfig {
+	cfg := ReadConfig()
+	anomaly := cfg.Anomaly
+	if anomaly.Threshold <= 0 {
+		anomaly.Threshold = 3
+	}
+	if anomaly.MinVolume <= 0 {
+		anomaly.MinVolume = 20
+	}
+	return anomaly
+}
+
+// GetSMTPConfig 获取 SMTP 配置，未配置的端口按加密方式取默认值
+func GetSMTPConfig() SMTPConfig {
+	cfg := ReadConfig()
+	smtp := cfg.SMTP
+	if smtp.Encryption == "" {
+		smtp.Encryption = SMTPEncryptionStartTLS
+	}
+	if smtp.Port <= 0 {
+		switch smtp.Encryption {
+		case SMTPEncryptionTLS:
+			smtp.Port = 465
+		case SMTPEncryptionNone:
+			smtp.Port = 25
+		default:
+			smtp.Port = 587
+		}
+	}
+	if smtp.From == "" {
+		smtp.From = smtp.Username
+	}
+	return smtp
+}
+
+// GetMaintenanceConfig 获取空间回收的空闲时段和每步回收页数
+func GetMaintenanceConfig() (string, int) {
+	cfg := ReadConfig()
+	window := cfg.System.MaintenanceWindow
+	if window == "" {
+		window = "02:00-05:00"
+	}
+	stepPages := cfg.System.VacuumStepPages
+	if stepPages <= 0 {
+		stepPages = 2000
+	}
+	return window, stepPages
+}
+
+// GetTaskInterval 获取定时扫描日志的间隔，未配置或无效时为 5 分钟
+func GetTaskInterval() time.Duration {
+	cfg := ReadConfig()
+	return ParseInterval(cfg.System.TaskInterval, 5*time.Minute)
+}
+
+// GetSessionTimeout 获取会话的不活动超时，未配置或无效时为 30 分钟
+func GetSessionTimeout() time.Duration {
+	cfg := ReadConfig()
+	timeout, err := time.ParseDuration(cfg.System.SessionTimeout)
+	if err != nil || timeout <= 0 {
+		return 30 * time.Minute
+	}
+	return timeout
+}
+
+// 访客指纹的取值
+const (
+	VisitorFingerprintIPUA = "ip_ua" // IP 和 UA 都相同才算同一访客
+	VisitorFingerprintIP   = "ip"    // 只按 IP 识别，同一出口下的多个设备会被合并
+)
+
+// GetVisitorFingerprint 获取识别访客的方式，未配置时为 ip_ua
+func GetVisitorFingerprint() string {
+	cfg := ReadConfig()
+	if cfg.System.VisitorFingerprint == VisitorFingerprintIP {
+		return VisitorFingerprintIP
+	}
+	return VisitorFingerprintIPUA
+}
+
+// GetStatsCacheSize 获取统计缓存的容量
+func GetStatsCacheSize() int {
+	cfg := ReadConfig()
+	if cfg.System.StatsCacheSize > 0 {
+		return cfg.System.StatsCacheSize
+	}
+	return 1000
+}
+
+// GetRefererRulesFile 获取来源分类规则文件路径，未配置时为数据目录下的 referer_rules.json
+func GetRefererRulesFile() string {
+	cfg := ReadConfig()
+	if cfg.System.RefererRules != "" {
+		return cfg.System.RefererRules
+	}
+	return filepath.Join(DataDir, "referer_rules.json")
+}
+
 // AddExcludePattern 添加排除模式
 func AddExcludePattern(pattern string) error {
 	cfg, err := ReadRawConfig()
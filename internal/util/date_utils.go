@@ -27,12 +27,108 @@ func TimePeriod(timeRange string) (time.Time, time.Time, error) {
 	case "last30days":
 		startTime = setTime(now.AddDate(0, 0, -29), 0, 0, 0)
 	default:
-		startTime = setTime(now, 0, 0, 0)
+		return time.Time{}, time.Time{}, fmt.Errorf("不支持的时间范围: %s", timeRange)
 	}
 
 	return startTime, endTime, nil
 }
 
+// IsNamedTimeRange 判断是否为支持的命名时间范围
+func IsNamedTimeRange(timeRange string) bool {
+	switch timeRange {
+	case "today", "yesterday", "week", "last7days", "month", "last30days":
+		return true
+	}
+	return false
+}
+
+// rangeBoundLayouts 自定义时间范围支持的格式，不带时区的按本地时间解析
+var rangeBoundLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// ParseRangeBound 解析自定义时间范围的起止时间。
+// 只给出日期时，结束时间包含当天整天（即取次日零点）。
+func ParseRangeBound(value string, isEnd bool) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if isEnd {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+
+	for _, layout := range rangeBoundLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("时间格式无效: %s，应为 2006-01-02 或 2006-01-02 15:04:05", value)
+}
+
+// TimePointsForRange 为任意时间段选择合适的粒度，返回各区间的起点和标签。
+// 最后一个区间的终点为 endTime。viewType 为 hourly 且跨度不超过 7 天时按小时统计。
+func TimePointsForRange(
+	startTime, endTime time.Time, viewType string) ([]time.Time, []string) {
+
+	var timePoints []time.Time
+	var labels []string
+
+	span := endTime.Sub(startTime)
+	multiDay := span > 24*time.Hour
+
+	switch {
+	case span <= 2*24*time.Hour || (viewType == "hourly" && span <= 7*24*time.Hour):
+		// 按小时
+		for t := setTime(startTime, startTime.Hour(), 0, 0); t.Before(endTime); t = t.Add(time.Hour) {
+			if t.Before(startTime) {
+				timePoints = append(timePoints, startTime)
+			} else {
+				timePoints = append(timePoints, t)
+			}
+			if multiDay {
+				labels = append(labels, fmt.Sprintf("%s %d:00", FormatDateWithWeekday(t, false), t.Hour()))
+			} else {
+				labels = append(labels, fmt.Sprintf("%d:00", t.Hour()))
+			}
+		}
+	case span <= 92*24*time.Hour:
+		// 按天
+		for day := setTime(startTime, 0, 0, 0); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+			timePoints = append(timePoints, maxTime(day, startTime))
+			labels = append(labels, FormatDateWithWeekday(day, span <= 14*24*time.Hour))
+		}
+	case span <= 2*366*24*time.Hour:
+		// 按周，从周一开始
+		week, _ := weekBounds(startTime)
+		for ; week.Before(endTime); week = week.AddDate(0, 0, 7) {
+			timePoints = append(timePoints, maxTime(week, startTime))
+			labels = append(labels, FormatDateWithWeekday(week, false)+" 周")
+		}
+	default:
+		// 按月
+		month := time.Date(startTime.Year(), startTime.Month(), 1, 0, 0, 0, 0, startTime.Location())
+		for ; month.Before(endTime); month = month.AddDate(0, 1, 0) {
+			timePoints = append(timePoints, maxTime(month, startTime))
+			labels = append(labels, fmt.Sprintf("%d.%d", month.Year(), month.Month()))
+		}
+	}
+
+	return timePoints, labels
+}
+
+// maxTime 返回两个时间中较晚的一个
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
 // TimePointsAndLabels 根据时间范围类型和视图类型直接返回时间点数组和标签数组
 func TimePointsAndLabels(
 	timeRangeType string, viewType string) ([]time.Time, []string) {
@@ -95,6 +191,23 @@ func TimePointsAndLabels(
 	return timePoints, labels
 }
 
+// InTimeWindow 判断时间是否落在 "HH:MM-HH:MM" 格式的时段内，支持跨零点的时段
+func InTimeWindow(window string, t time.Time) (bool, error) {
+	var startHour, startMin, endHour, endMin int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin); err != nil {
+		return false, fmt.Errorf("时段格式无效: %s", window)
+	}
+
+	start := startHour*60 + startMin
+	end := endHour*60 + endMin
+	current := t.Hour()*60 + t.Minute()
+
+	if start <= end {
+		return current >= start && current < end, nil
+	}
+	return current >= start || current < end, nil
+}
+
 // FormatDateWithWeekday 返回格式化的日期字符串，可选是否包含星期
 // 格式：M.D 或 M.D 周X
 func FormatDateWithWeekday(date time.Time, includeWeekday bool) string {
@@ -108,6 +221,12 @@ func FormatDateWithWeekday(date time.Time, includeWeekday bool) string {
 	return monthDay
 }
 
+// StartOfWeek 返回指定时间所在周的周一零点
+func StartOfWeek(t time.Time) time.Time {
+	weekStart, _ := weekBounds(t)
+	return weekStart
+}
+
 // weekBounds 返回包含指定日期的那一周的开始和结束时间
 func weekBounds(t time.Time) (time.Time, time.Time) {
 	// 获取包含 t 的那一周的周一
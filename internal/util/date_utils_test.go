@@ -0,0 +1,212 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRangeBound(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		isEnd   bool
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "日期作为开始时间取当天零点",
+			value: "2026-01-05",
+			want:  time.Date(2026, 1, 5, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "日期作为结束时间取次日零点",
+			value: "2026-01-05",
+			isEnd: true,
+			want:  time.Date(2026, 1, 6, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "月末日期作为结束时间跨到下月",
+			value: "2026-01-31",
+			isEnd: true,
+			want:  time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "带时间的结束时间不再顺延",
+			value: "2026-01-05 08:30:15",
+			isEnd: true,
+			want:  time.Date(2026, 1, 5, 8, 30, 15, 0, time.Local),
+		},
+		{
+			name:  "T 分隔且省略秒",
+			value: "2026-01-05T08:30",
+			want:  time.Date(2026, 1, 5, 8, 30, 0, 0, time.Local),
+		},
+		{
+			name:  "空格分隔且省略秒",
+			value: "2026-01-05 08:30",
+			isEnd: true,
+			want:  time.Date(2026, 1, 5, 8, 30, 0, 0, time.Local),
+		},
+		{
+			name:  "RFC3339 按给定时区解析",
+			value: "2026-01-05T08:30:00+08:00",
+			want:  time.Date(2026, 1, 5, 0, 30, 0, 0, time.UTC),
+		},
+		{name: "空字符串", value: "", wantErr: true},
+		{name: "斜杠分隔", value: "2026/01/05", wantErr: true},
+		{name: "月份越界", value: "2026-13-01", wantErr: true},
+		{name: "缺少日", value: "2026-01", isEnd: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRangeBound(tt.value, tt.isEnd)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseRangeBound(%q) 应返回错误，实际得到 %v", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRangeBound(%q) 出错: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseRangeBound(%q, %v) = %v, 期望 %v", tt.value, tt.isEnd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimePointsForRange(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name           string
+		start, end     time.Time
+		viewType       string
+		wantCount      int
+		wantFirst      time.Time
+		wantLast       time.Time
+		wantFirstLabel string
+		wantLastLabel  string
+	}{
+		{
+			name:           "一天内按小时，首个区间从开始时间起",
+			start:          date(2026, 1, 5, 8, 30),
+			end:            date(2026, 1, 5, 12, 0),
+			wantCount:      4,
+			wantFirst:      date(2026, 1, 5, 8, 30),
+			wantLast:       date(2026, 1, 5, 11, 0),
+			wantFirstLabel: "8:00",
+			wantLastLabel:  "11:00",
+		},
+		{
+			name:           "恰好两天仍按小时，标签带日期",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 1, 7, 0, 0),
+			wantCount:      48,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 1, 6, 23, 0),
+			wantFirstLabel: "1.5 0:00",
+			wantLastLabel:  "1.6 23:00",
+		},
+		{
+			name:           "超过两天按天，两周内标签带星期",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 1, 7, 1, 0),
+			wantCount:      3,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 1, 7, 0, 0),
+			wantFirstLabel: "1.5 周一",
+			wantLastLabel:  "1.7 周三",
+		},
+		{
+			name:           "hourly 视图恰好七天仍按小时",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 1, 12, 0, 0),
+			viewType:       "hourly",
+			wantCount:      168,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 1, 11, 23, 0),
+			wantFirstLabel: "1.5 0:00",
+			wantLastLabel:  "1.11 23:00",
+		},
+		{
+			name:           "hourly 视图超过七天改为按天",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 1, 12, 1, 0),
+			viewType:       "hourly",
+			wantCount:      8,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 1, 12, 0, 0),
+			wantFirstLabel: "1.5 周一",
+			wantLastLabel:  "1.12 周一",
+		},
+		{
+			name:           "超过两周按天，标签不带星期",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 1, 25, 0, 0),
+			wantCount:      20,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 1, 24, 0, 0),
+			wantFirstLabel: "1.5",
+			wantLastLabel:  "1.24",
+		},
+		{
+			name:           "恰好 92 天仍按天",
+			start:          date(2026, 1, 5, 0, 0),
+			end:            date(2026, 4, 7, 0, 0),
+			wantCount:      92,
+			wantFirst:      date(2026, 1, 5, 0, 0),
+			wantLast:       date(2026, 4, 6, 0, 0),
+			wantFirstLabel: "1.5",
+			wantLastLabel:  "4.6",
+		},
+		{
+			name:           "超过 92 天按周，区间从周一开始",
+			start:          date(2026, 1, 7, 0, 0),
+			end:            date(2026, 5, 1, 0, 0),
+			wantCount:      17,
+			wantFirst:      date(2026, 1, 7, 0, 0),
+			wantLast:       date(2026, 4, 27, 0, 0),
+			wantFirstLabel: "1.5 周",
+			wantLastLabel:  "4.27 周",
+		},
+		{
+			name:           "超过两年按月",
+			start:          date(2024, 3, 15, 0, 0),
+			end:            date(2026, 6, 10, 0, 0),
+			wantCount:      28,
+			wantFirst:      date(2024, 3, 15, 0, 0),
+			wantLast:       date(2026, 6, 1, 0, 0),
+			wantFirstLabel: "2024.3",
+			wantLastLabel:  "2026.6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, labels := TimePointsForRange(tt.start, tt.end, tt.viewType)
+			if len(points) != tt.wantCount || len(labels) != tt.wantCount {
+				t.Fatalf("区间数 = %d, 标签数 = %d, 期望 %d", len(points), len(labels), tt.wantCount)
+			}
+			if first := points[0]; !first.Equal(tt.wantFirst) {
+				t.Errorf("首个区间 = %v, 期望 %v", first, tt.wantFirst)
+			}
+			if last := points[len(points)-1]; !last.Equal(tt.wantLast) {
+				t.Errorf("最后区间 = %v, 期望 %v", last, tt.wantLast)
+			}
+			if labels[0] != tt.wantFirstLabel || labels[len(labels)-1] != tt.wantLastLabel {
+				t.Errorf("标签首尾 = %q, %q, 期望 %q, %q",
+					labels[0], labels[len(labels)-1], tt.wantFirstLabel, tt.wantLastLabel)
+			}
+			for i := 1; i < len(points); i++ {
+				if !points[i].After(points[i-1]) {
+					t.Errorf("区间起点未递增: %v 之后为 %v", points[i-1], points[i])
+				}
+			}
+		})
+	}
+}
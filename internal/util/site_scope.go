@@ -0,0 +1,103 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 多站点范围：统计接口的 id 参数为 "all" 时表示全部站点，"group:<名称>" 表示配置中的站点组
+const (
+	SiteScopeAll    = "all"
+	SiteGroupPrefix = "group:"
+)
+
+// IsSiteScope 判断 id 是否为多站点范围
+func IsSiteScope(id string) bool {
+	return id == SiteScopeAll || strings.HasPrefix(id, SiteGroupPrefix)
+}
+
+// ResolveSiteScope 将多站点范围解析为站点 ID 列表（不含已隐藏的站点），按 ID 排序
+func ResolveSiteScope(scope string) ([]string, error) {
+	var ids []string
+	if scope == SiteScopeAll {
+		ids = GetAllWebsiteIDs()
+	} else {
+		name := strings.TrimPrefix(scope, SiteGroupPrefix)
+		group, ok := findSiteGroup(ReadConfig().Groups, name)
+		if !ok {
+			return nil, fmt.Errorf("站点组 %s 不存在", name)
+		}
+		for _, site := range group.Sites {
+			id, ok := resolveSite(site)
+			if !ok {
+				return nil, fmt.Errorf("站点组 %s 中的站点 %s 不存在", name, site)
+			}
+			if website, _ := GetWebsiteByID(id); !website.Hidden {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s 中没有可统计的站点", scope)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GetSiteGroups 获取配置的站点组
+func GetSiteGroups() []SiteGroup {
+	return ReadConfig().Groups
+}
+
+// validateSiteGroups 检查站点组名称唯一且成员都是已配置的站点
+func validateSiteGroups(cfg *Config) error {
+	names := make(map[string]bool)
+	for _, group := range cfg.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("站点组名称不能为空")
+		}
+		if names[group.Name] {
+			return fmt.Errorf("站点组 %s 重复", group.Name)
+		}
+		names[group.Name] = true
+
+		for _, site := range group.Sites {
+			if !siteConfigured(cfg, site) {
+				return fmt.Errorf("站点组 %s 中的站点 %s 不存在", group.Name, site)
+			}
+		}
+	}
+	return nil
+}
+
+func findSiteGroup(groups []SiteGroup, name string) (SiteGroup, bool) {
+	for _, group := range groups {
+		if group.Name == name {
+			return group, true
+		}
+	}
+	return SiteGroup{}, false
+}
+
+// resolveSite 把站点名称或 ID 解析为站点 ID
+func resolveSite(site string) (string, bool) {
+	if _, ok := GetWebsiteByID(site); ok {
+		return site, true
+	}
+	id := generateID(site)
+	if _, ok := GetWebsiteByID(id); ok {
+		return id, true
+	}
+	return "", false
+}
+
+func siteConfigured(cfg *Config, site string) bool {
+	for _, website := range cfg.Websites {
+		if website.Name == site || generateID(website.Name) == site {
+			return true
+		}
+	}
+	return false
+}
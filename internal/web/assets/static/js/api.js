@@ -12,23 +12,78 @@ export async function fetchWebsites() {
     }
 }
 
+// 获取站点和站点组，站点组的 id 形如 "group:<名称>"
+export async function fetchSiteScopes() {
+    try {
+        const response = await fetch('/api/websites');
+        if (!response.ok) {
+            throw new Error('网络响应不正常');
+        }
+        const data = await response.json();
+        return { websites: data.websites || [], groups: data.groups || [] };
+    } catch (error) {
+        console.error('获取网站列表失败:', error);
+        throw error;
+    }
+}
+
+// 当前生效的维度过滤，如 { browser: 'Chrome', location: '北京' }，作用于所有统计查询
+const activeFilters = {};
+
+// 获取当前的维度过滤
+export function getFilters() {
+    return { ...activeFilters };
+}
+
+// 设置或移除（value 为空时）某个维度的过滤，并通知页面刷新
+export function setFilter(dimension, value) {
+    if (value === undefined || value === null || value === '') {
+        delete activeFilters[dimension];
+    } else {
+        activeFilters[dimension] = value;
+    }
+    document.dispatchEvent(new CustomEvent('filterschange', { detail: getFilters() }));
+}
+
+// 清空所有维度过滤
+export function clearFilters() {
+    Object.keys(activeFilters).forEach(dimension => delete activeFilters[dimension]);
+    document.dispatchEvent(new CustomEvent('filterschange', { detail: {} }));
+}
+
+// 生成统计接口的查询参数，附加当前的维度过滤
+function buildStatsParams(params) {
+    const queryParams = new URLSearchParams();
+
+    // 添加所有参数到查询字符串
+    Object.entries(params).forEach(([key, value]) => {
+        if (value !== undefined && value !== null) {
+            queryParams.append(key, value);
+        }
+    });
+
+    // 附加维度过滤，参数名为 filter.<维度>
+    Object.entries(activeFilters).forEach(([dimension, value]) => {
+        queryParams.append(`filter.${dimension}`, value);
+    });
+
+    return queryParams;
+}
+
 // 查询接口
 async function fetchStats(type, params = {}) {
     try {
-        const queryParams = new URLSearchParams();
-
-        // 添加所有参数到查询字符串
-        Object.entries(params).forEach(([key, value]) => {
-            if (value !== undefined && value !== null) {
-                queryParams.append(key, value);
-            }
-        });
+        const queryParams = buildStatsParams(params);
 
         const url = `/api/stats/${type}?${queryParams.toString()}`;
         const response = await fetch(url);
 
         if (!response.ok) {
-            throw new Error(`请求失败，状态码: ${response.status}`);
+            // 错误响应带有错误信息，日志查询语法错误时还有出错位置 position
+            const data = await response.json().catch(() => ({}));
+            const error = new Error(data.error || `请求失败，状态码: ${response.status}`);
+            error.position = data.position;
+            throw error;
         }
 
         return await response.json();
@@ -43,8 +98,8 @@ export async function fetchTimeSeriesStats(websiteId, timeRange, viewType) {
     return fetchStats('timeseries', { id: websiteId, timeRange, viewType });
 }
 
-export async function fetchOverallStats(websiteId, timeRange) {
-    return fetchStats('overall', { id: websiteId, timeRange });
+export async function fetchOverallStats(websiteId, timeRange, compare) {
+    return fetchStats('overall', { id: websiteId, timeRange, compare });
 }
 
 export async function fetchUrlStats(websiteId, timeRange, limit = 10) {
@@ -55,23 +110,42 @@ export async function fetchRefererStats(websiteId, timeRange, limit = 10) {
     return fetchStats('referer', { id: websiteId, timeRange, limit });
 }
 
-export async function fetchBrowserStats(websiteId, timeRange, limit = 10) {
-    return fetchStats('browser', { id: websiteId, timeRange, limit });
+// level 为汇总粒度：浏览器和系统为 name/major/full，设备为 class/brand/model
+export async function fetchBrowserStats(websiteId, timeRange, limit = 10, level = 'name') {
+    return fetchStats('browser', { id: websiteId, timeRange, limit, level });
 }
 
-export async function fetchOSStats(websiteId, timeRange, limit = 10) {
-    return fetchStats('os', { id: websiteId, timeRange, limit });
+export async function fetchOSStats(websiteId, timeRange, limit = 10, level = 'name') {
+    return fetchStats('os', { id: websiteId, timeRange, limit, level });
 }
 
-export async function fetchDeviceStats(websiteId, timeRange, limit = 10) {
-    return fetchStats('device', { id: websiteId, timeRange, limit });
+export async function fetchDeviceStats(websiteId, timeRange, limit = 10, level = 'class') {
+    return fetchStats('device', { id: websiteId, timeRange, limit, level });
 }
 
 export async function fectchLocationStats(websiteId, timeRange, locationType, limit = 99) {
     return fetchStats('location', { id: websiteId, locationType, timeRange, limit });
 }
 
-export async function fetchLogs(websiteId, page, pageSize, sortField, sortOrder, filter) {
+export async function fetchRetentionStats(websiteId, weeks = 8) {
+    return fetchStats('retention', { id: websiteId, weeks });
+}
+
+export async function fetchHeatmapStats(websiteId, timeRange, metric = 'pageviews') {
+    return fetchStats('heatmap', { id: websiteId, timeRange, metric });
+}
+
+export async function fetchSitesStats(websiteId, timeRange) {
+    return fetchStats('sites', { id: websiteId, timeRange });
+}
+
+// 是否为多站点范围（全部站点或站点组）
+export function isSiteScope(websiteId) {
+    return websiteId === 'all' || websiteId.startsWith('group:');
+}
+
+// filter 为日志查询语句，start 为起始时间或相对时长（如 1h、7d），cursor 为上一页返回的 nextCursor
+export async function fetchLogs(websiteId, page, pageSize, sortField, sortOrder, filter, start, cursor) {
     const params = {
         id: websiteId,
         page: page,
@@ -83,7 +157,72 @@ export async function fetchLogs(websiteId, page, pageSize, sortField, sortOrder,
     if (filter) {
         params.filter = filter;
     }
+    if (start) {
+        params.start = start;
+    }
+    if (cursor) {
+        // 从上一页末尾的游标继续读取，page 须与游标所指的页码一致
+        params.cursor = cursor;
+    }
 
     return fetchStats('logs', params);
 }
 
+// 直接下载统计结果或日志（type 为 logs）的地址，参数与统计接口相同，format 为 csv/ndjson/xlsx
+export function buildExportUrl(type, params, format) {
+    const queryParams = buildStatsParams(params);
+    queryParams.set('format', format);
+    return `/api/export/${type}?${queryParams.toString()}`;
+}
+
+// 新建后台导出任务，适合数据量大的导出，完成后通过 /api/exports/:id/download 下载
+export async function createExportJob(type, format, params) {
+    const response = await fetch('/api/exports', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            type,
+            format,
+            params: Object.fromEntries(buildStatsParams(params))
+        })
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || `请求失败，状态码: ${response.status}`);
+    }
+    return data.export;
+}
+
+// 获取站点已归档（已从日志表中删除）的日期，按日期倒序
+export async function fetchArchives(websiteId) {
+    const response = await fetch(`/api/archive?id=${encodeURIComponent(websiteId)}`);
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || `请求失败，状态码: ${response.status}`);
+    }
+    return data.archives;
+}
+
+// 将某一天的归档日志恢复到日志表，返回恢复的行数
+export async function restoreArchive(websiteId, date) {
+    const response = await fetch('/api/archive/restore', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ website_id: websiteId, date })
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || `请求失败，状态码: ${response.status}`);
+    }
+    return data.restored;
+}
+
+// 查询后台导出任务的状态和进度
+export async function fetchExportJob(id) {
+    const response = await fetch(`/api/exports/${id}`);
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || `请求失败，状态码: ${response.status}`);
+    }
+    return data.export;
+}
@@ -0,0 +1,109 @@
+import {
+    fetchHeatmapStats,
+    isSiteScope,
+} from './api.js';
+
+import {
+    updateChartsTheme,
+} from './theme.js';
+
+// 热力图实例
+let heatmapChart = null;
+let currentMetric = 'pageviews';
+let currentWebsiteId = '';
+let range = 'today';
+
+// 更新 WebsiteId 和 range
+export function updateHeatmapWebsiteIdAndRange(websiteId, newRange) {
+    currentWebsiteId = websiteId;
+    range = newRange;
+    updateHeatmap();
+}
+
+// 初始化星期 x 小时热力图
+export function initHeatmap() {
+    const container = document.getElementById('heatmap-chart');
+    if (!container) {
+        return;
+    }
+
+    heatmapChart = echarts.init(container);
+    window.heatmapChart = heatmapChart;
+
+    const toggleBtns = document.querySelectorAll('.heatmap-toggle-btn');
+    toggleBtns.forEach(btn => {
+        btn.addEventListener('click', function () {
+            currentMetric = this.dataset.metric;
+
+            toggleBtns.forEach(b => b.classList.remove('active'));
+            this.classList.add('active');
+
+            updateHeatmap();
+        });
+    });
+}
+
+// 查询并渲染热力图，多站点范围时不显示
+async function updateHeatmap() {
+    const section = document.getElementById('heatmap-section');
+    if (!heatmapChart || isSiteScope(currentWebsiteId)) {
+        section.style.display = 'none';
+        return;
+    }
+    section.style.display = '';
+    heatmapChart.resize();
+
+    const data = await fetchHeatmapStats(currentWebsiteId, range, currentMetric);
+    const isRequests = data.metric === 'requests';
+    const values = isRequests ? data.requests : data.pv;
+    const extra = isRequests ? data.errors : data.uv;
+    const valueName = isRequests ? '请求数' : '浏览量';
+    const extraName = isRequests ? '错误数' : '访客数';
+
+    // ECharts 热力图的数据为 [小时, 星期, 值]
+    const points = [];
+    let maxValue = 0;
+    values.forEach((row, day) => {
+        row.forEach((value, hour) => {
+            points.push([hour, day, value, extra[day][hour]]);
+            maxValue = Math.max(maxValue, value);
+        });
+    });
+
+    heatmapChart.setOption({
+        tooltip: {
+            formatter: params => {
+                const [hour, day, value, extraValue] = params.data;
+                return `${data.days[day]} ${hour}:00<br/>${valueName}: ${value.toLocaleString()}<br/>${extraName}: ${extraValue.toLocaleString()}`;
+            }
+        },
+        grid: { left: 50, right: 20, top: 10, bottom: 70 },
+        xAxis: {
+            type: 'category',
+            data: Array.from({ length: 24 }, (_, i) => `${i}`),
+            splitArea: { show: true }
+        },
+        yAxis: {
+            type: 'category',
+            data: data.days,
+            inverse: true,
+            splitArea: { show: true }
+        },
+        visualMap: {
+            min: 0,
+            max: Math.max(maxValue, 1),
+            orient: 'horizontal',
+            left: 'center',
+            bottom: 0,
+            calculable: true
+        },
+        series: [{
+            name: valueName,
+            type: 'heatmap',
+            data: points
+        }]
+    }, true);
+    updateChartsTheme();
+
+    document.getElementById('heatmap-timezone').textContent = `时区: ${data.timezone}`;
+}
@@ -1,6 +1,11 @@
 import {
     fetchLogs,
-    fetchWebsites
+    fetchWebsites,
+    buildExportUrl,
+    createExportJob,
+    fetchExportJob,
+    fetchArchives,
+    restoreArchive
 } from './api.js';
 
 import {
@@ -13,19 +18,36 @@ import {
     initThemeManager,
 } from './theme.js';
 
+// 匹配的日志超过该行数时作为后台任务导出，完成后再下载
+const EXPORT_SYNC_LIMIT = 50000;
+// 查询后台导出进度的间隔（毫秒）
+const EXPORT_POLL_INTERVAL = 2000;
+// 按页码跳转时最多跳过的行数，与服务端的限制一致，更深的页只能逐页翻到
+const MAX_JUMP_OFFSET = 100000;
+
 // 状态变量
 let currentWebsiteId = '';
 let currentPage = 1;
 let pageSize = 100;
 let totalPages = 0;
+let totalLogs = 0;
+// 总数超过服务端的计数上限时只有下限（capped）或估算值（estimated）
+let totalCapped = false;
+let totalEstimated = false;
+// 各页的游标，翻到下一页时记录，有游标的页不需要跳过前面的行
+let pageCursors = {};
+let hasNextPage = false;
 let sortField = 'timestamp';
 let sortOrder = 'desc';
 let searchFilter = '';
+let sinceRange = '';
 
 // DOM 元素
 let websiteSelector;
 let searchInput;
 let searchButton;
+let sinceSelect;
+let searchError;
 let sortFieldSelect;
 let sortOrderSelect;
 let pageSizeSelect;
@@ -36,6 +58,13 @@ let currentPageSpan;
 let totalPagesSpan;
 let pageJumpInput;
 let pageJumpBtn;
+let exportFormatSelect;
+let exportButton;
+let exportStatus;
+let archiveControls;
+let archiveDateSelect;
+let archiveRestoreButton;
+let archiveStatus;
 
 // 初始化应用
 async function initApp() {
@@ -43,6 +72,8 @@ async function initApp() {
     websiteSelector = document.getElementById('website-selector');
     searchInput = document.getElementById('logs-search');
     searchButton = document.getElementById('search-btn');
+    sinceSelect = document.getElementById('logs-since');
+    searchError = document.getElementById('search-error');
     sortFieldSelect = document.getElementById('sort-field');
     sortOrderSelect = document.getElementById('sort-order');
     pageSizeSelect = document.getElementById('page-size');
@@ -53,6 +84,13 @@ async function initApp() {
     totalPagesSpan = document.getElementById('total-pages');
     pageJumpInput = document.getElementById('page-jump-input');
     pageJumpBtn = document.getElementById('page-jump-btn');
+    exportFormatSelect = document.getElementById('export-format');
+    exportButton = document.getElementById('export-btn');
+    exportStatus = document.getElementById('export-status');
+    archiveControls = document.getElementById('archive-controls');
+    archiveDateSelect = document.getElementById('archive-date');
+    archiveRestoreButton = document.getElementById('archive-restore-btn');
+    archiveStatus = document.getElementById('archive-status');
 
     // 初始化主题
     initThemeManager();
@@ -64,6 +102,13 @@ async function initApp() {
     pageSize = parseInt(getUserPreference('logsPageSize', '100'));
     sortField = getUserPreference('logsSortField', 'timestamp');
     sortOrder = getUserPreference('logsSortOrder', 'desc');
+    if (!Array.from(sortFieldSelect.options).some(option => option.value === sortField)) {
+        // 保存的排序字段已不再支持
+        sortField = 'timestamp';
+    }
+    exportFormatSelect.value = getUserPreference('logsExportFormat', 'csv');
+    sinceRange = getUserPreference('logsSince', '');
+    sinceSelect.value = sinceRange;
 
     // 设置下拉框默认值
     pageSizeSelect.value = pageSize;
@@ -75,6 +120,7 @@ async function initApp() {
 
     // 加载日志数据
     loadLogs();
+    loadArchives();
 }
 
 // 初始化网站选择器
@@ -149,14 +195,15 @@ function bindEventListeners() {
         currentWebsiteId = this.value;
         saveUserPreference('selectedWebsite', currentWebsiteId);
         updateURL(currentWebsiteId);
-        currentPage = 1;
+        resetPaging();
         loadLogs();
+        loadArchives();
     });
 
     // 搜索按钮点击
     searchButton.addEventListener('click', function () {
         searchFilter = searchInput.value.trim();
-        currentPage = 1;
+        resetPaging();
         loadLogs();
     });
 
@@ -164,15 +211,24 @@ function bindEventListeners() {
     searchInput.addEventListener('keyup', function (event) {
         if (event.key === 'Enter') {
             searchFilter = this.value.trim();
-            currentPage = 1;
+            resetPaging();
             loadLogs();
         }
     });
 
+    // 时间范围变化
+    sinceSelect.addEventListener('change', function () {
+        sinceRange = this.value;
+        saveUserPreference('logsSince', sinceRange);
+        resetPaging();
+        loadLogs();
+    });
+
     // 排序字段变化
     sortFieldSelect.addEventListener('change', function () {
         sortField = this.value;
         saveUserPreference('logsSortField', sortField);
+        resetPaging();
         loadLogs();
     });
 
@@ -180,6 +236,7 @@ function bindEventListeners() {
     sortOrderSelect.addEventListener('change', function () {
         sortOrder = this.value;
         saveUserPreference('logsSortOrder', sortOrder);
+        resetPaging();
         loadLogs();
     });
 
@@ -187,7 +244,7 @@ function bindEventListeners() {
     pageSizeSelect.addEventListener('change', function () {
         pageSize = parseInt(this.value);
         saveUserPreference('logsPageSize', pageSize);
-        currentPage = 1;
+        resetPaging();
         loadLogs();
     });
 
@@ -201,7 +258,7 @@ function bindEventListeners() {
 
     // 下一页按钮
     nextPageBtn.addEventListener('click', function () {
-        if (currentPage < totalPages) {
+        if (hasNextPage) {
             currentPage++;
             loadLogs();
         }
@@ -210,11 +267,13 @@ function bindEventListeners() {
     // 页面跳转按钮
     pageJumpBtn.addEventListener('click', function () {
         const pageNum = parseInt(pageJumpInput.value);
-        if (pageNum && pageNum > 0 && pageNum <= totalPages) {
+        if (!pageNum || pageNum <= 0 || pageNum > totalPages) {
+            alert(`请输入有效的页码 (1-${totalPages})`);
+        } else if (!pageCursors[pageNum] && (pageNum - 1) * pageSize > MAX_JUMP_OFFSET) {
+            alert(`最多跳转到第 ${Math.floor(MAX_JUMP_OFFSET / pageSize) + 1} 页，更后面的页请逐页翻阅或缩小查询范围`);
+        } else {
             currentPage = pageNum;
             loadLogs();
-        } else {
-            alert(`请输入有效的页码 (1-${totalPages})`);
         }
     });
 
@@ -224,6 +283,114 @@ function bindEventListeners() {
             pageJumpBtn.click();
         }
     });
+
+    // 导出按钮
+    exportButton.addEventListener('click', exportLogs);
+
+    // 恢复归档按钮
+    archiveRestoreButton.addEventListener('click', restoreArchivedDay);
+}
+
+// 加载站点已归档的日期，没有归档时隐藏恢复控件
+async function loadArchives() {
+    archiveStatus.textContent = '';
+    archiveDateSelect.innerHTML = '';
+    if (!currentWebsiteId) {
+        archiveControls.hidden = true;
+        return;
+    }
+
+    try {
+        const archives = await fetchArchives(currentWebsiteId);
+        archives.forEach(archive => {
+            const option = document.createElement('option');
+            option.value = archive.date;
+            option.textContent = `${archive.date}（${archive.rows} 条）`;
+            archiveDateSelect.appendChild(option);
+        });
+        archiveControls.hidden = archives.length === 0;
+    } catch (error) {
+        console.error('加载归档列表失败:', error);
+        archiveControls.hidden = true;
+    }
+}
+
+// 将选中日期的归档日志恢复到日志表，恢复后重新加载日志
+async function restoreArchivedDay() {
+    const date = archiveDateSelect.value;
+    if (!date || !confirm(`确定将 ${date} 的归档日志恢复到日志表吗？\n恢复的日志会在下次清理时再次删除。`)) {
+        return;
+    }
+
+    archiveRestoreButton.disabled = true;
+    archiveStatus.textContent = '正在恢复...';
+    try {
+        const restored = await restoreArchive(currentWebsiteId, date);
+        archiveStatus.textContent = `已恢复 ${date} 的 ${restored} 条日志`;
+        resetPaging();
+        loadLogs();
+    } catch (error) {
+        console.error('恢复归档失败:', error);
+        archiveStatus.textContent = `恢复失败: ${error.message}`;
+    } finally {
+        archiveRestoreButton.disabled = false;
+    }
+}
+
+// 按当前的搜索和排序导出全部匹配的日志
+async function exportLogs() {
+    if (!currentWebsiteId) {
+        return;
+    }
+
+    const format = exportFormatSelect.value;
+    saveUserPreference('logsExportFormat', format);
+    const params = {
+        id: currentWebsiteId,
+        sortField: sortField,
+        sortOrder: sortOrder
+    };
+    if (searchFilter) {
+        params.filter = searchFilter;
+    }
+    if (sinceRange) {
+        params.start = sinceRange;
+    }
+
+    // 数据量不大时直接下载，总数不确定时按大数据量处理
+    if (!totalCapped && !totalEstimated && totalLogs <= EXPORT_SYNC_LIMIT) {
+        window.location.href = buildExportUrl('logs', params, format);
+        return;
+    }
+
+    exportButton.disabled = true;
+    exportStatus.textContent = '正在创建导出任务...';
+    try {
+        let job = await createExportJob('logs', format, params);
+        while (job.status === 'pending' || job.status === 'running') {
+            exportStatus.textContent = job.status === 'pending'
+                ? '导出任务排队中...'
+                : `正在导出，已完成 ${job.rows} / ${formatTotalLogs()} 行...`;
+            await new Promise(resolve => setTimeout(resolve, EXPORT_POLL_INTERVAL));
+            job = await fetchExportJob(job.id);
+        }
+
+        if (job.status === 'done') {
+            exportStatus.innerHTML = '';
+            const link = document.createElement('a');
+            link.href = `/api/exports/${job.id}/download`;
+            link.textContent = `下载 ${job.file_name}（${job.rows} 行，${formatTraffic(job.size)}）`;
+            exportStatus.appendChild(link);
+            window.location.href = link.href;
+        } else {
+            exportStatus.textContent = `导出失败: ${job.error}`;
+        }
+    } catch (error) {
+        console.error('导出日志失败:', error);
+        exportStatus.textContent = `导出失败: ${error.message}`;
+    } finally {
+        exportButton.disabled = false;
+    }
 }
 
 // 更新加载日志数据函数
@@ -248,19 +415,65 @@ async function loadLogs() {
             pageSize,
             sortField,
             sortOrder,
-            searchFilter
+            searchFilter,
+            sinceRange,
+            pageCursors[currentPage]
         );
+        showSearchError(null);
 
         // 更新分页信息
         totalPages = data.pagination.pages;
+        totalLogs = data.pagination.total;
+        totalCapped = data.pagination.capped;
+        totalEstimated = data.pagination.estimated;
         currentPage = data.pagination.page;
+        hasNextPage = Boolean(data.pagination.nextCursor);
+        if (hasNextPage) {
+            pageCursors[currentPage + 1] = data.pagination.nextCursor;
+        }
         updatePaginationControls();
 
         // 渲染日志表格
         renderLogsTable(data.logs);
     } catch (error) {
         console.error('加载日志数据失败:', error);
-        displayError('加载日志数据失败，请重试');
+        if (error.position !== undefined) {
+            showSearchError(error);
+            displayError('查询语句有误，请修改后重试');
+        } else {
+            showSearchError(null);
+            displayError('加载日志数据失败，请重试');
+        }
+    }
+}
+
+// 显示查询语句的错误，标出出错的字符并将光标移到该处；error 为空时隐藏
+function showSearchError(error) {
+    if (!error) {
+        searchError.hidden = true;
+        searchError.textContent = '';
+        return;
+    }
+
+    // position 按字符计数，与 JS 字符串的 UTF-16 下标不同
+    const chars = Array.from(searchFilter);
+    const pos = Math.min(error.position, chars.length);
+    const code = document.createElement('code');
+    code.appendChild(document.createTextNode(chars.slice(0, pos).join('')));
+    const mark = document.createElement('span');
+    mark.className = 'search-error-mark';
+    mark.textContent = chars[pos] || ' ';
+    code.appendChild(mark);
+    code.appendChild(document.createTextNode(chars.slice(pos + 1).join('')));
+
+    searchError.textContent = error.message;
+    searchError.appendChild(code);
+    searchError.hidden = false;
+
+    if (searchInput.value.trim() === searchFilter) {
+        const offset = searchInput.value.indexOf(searchFilter) + chars.slice(0, pos).join('').length;
+        searchInput.focus();
+        searchInput.setSelectionRange(offset, offset + (chars[pos] || '').length);
     }
 }
 
@@ -283,10 +496,17 @@ function renderLogsTable(logs) {
         cell.title = log.time;
         row.appendChild(cell);
 
-        // IP列
+        // IP列，蜘蛛和可疑请求加上标记
         cell = document.createElement('td');
         cell.textContent = log.ip;
         cell.title = log.ip;
+        if (log.is_spider) {
+            cell.appendChild(createLogTag('蜘蛛', log.spider_name || log.spider_type, 'spider'));
+        }
+        if (log.is_suspicious) {
+            const detail = [log.suspicious_type, log.suspicious_reason].filter(Boolean).join(': ');
+            cell.appendChild(createLogTag('可疑', detail, 'suspicious'));
+        }
         row.appendChild(cell);
 
         // 位置列
@@ -358,17 +578,45 @@ function renderLogsTable(logs) {
     });
 }
 
+// 创建日志行中的标记
+function createLogTag(text, title, type) {
+    const tag = document.createElement('span');
+    tag.className = `log-tag ${type}`;
+    tag.textContent = text;
+    tag.title = title || text;
+    return tag;
+}
+
+// 回到第一页，查询条件或排序变化后之前的游标都不再可用
+function resetPaging() {
+    currentPage = 1;
+    pageCursors = {};
+}
+
+// 日志总数的显示文本，超过计数上限时为 "10000+"，估算时为 "约 N"
+function formatTotalLogs() {
+    if (totalCapped) {
+        return `${totalLogs}+`;
+    }
+    return totalEstimated ? `约 ${totalLogs}` : `${totalLogs}`;
+}
+
 // 更新分页控件
 function updatePaginationControls(loading = false) {
     // 更新当前页和总页数显示
     currentPageSpan.textContent = currentPage;
-    totalPagesSpan.textContent = totalPages;
+    if (totalCapped) {
+        totalPagesSpan.textContent = `${totalPages}+`;
+    } else {
+        totalPagesSpan.textContent = totalEstimated ? `约 ${totalPages}` : totalPages;
+    }
+    totalPagesSpan.title = `共 ${formatTotalLogs()} 条日志`;
 
     // 启用/禁用上一页按钮
     prevPageBtn.disabled = loading || currentPage <= 1;
 
     // 启用/禁用下一页按钮
-    nextPageBtn.disabled = loading || currentPage >= totalPages;
+    nextPageBtn.disabled = loading || !hasNextPage;
 
     // 更新页面跳转输入框
     pageJumpInput.disabled = loading;
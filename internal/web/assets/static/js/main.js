@@ -5,6 +5,12 @@ import {
     fetchBrowserStats,
     fetchOSStats,
     fetchDeviceStats,
+    fetchSitesStats,
+    fetchRetentionStats,
+    isSiteScope,
+    getFilters,
+    setFilter,
+    clearFilters,
 } from './api.js';
 
 import {
@@ -30,6 +36,15 @@ import {
     updateGeoMapWebsiteIdAndRange,
 } from './maps.js';
 
+import {
+    initHeatmap,
+    updateHeatmapWebsiteIdAndRange,
+} from './heatmap.js';
+
+import {
+    updateRealtimeWebsiteId,
+} from './realtime.js';
+
 import {
     formatTraffic,
 } from './utils.js';
@@ -38,11 +53,38 @@ import {
     initThemeManager,
 } from './theme.js';
 
+// 过滤维度的显示名称
+const filterLabels = {
+    url: '页面',
+    referer: '来源',
+    browser: '浏览器',
+    os: '操作系统',
+    device: '设备',
+    location: '地区',
+    city: '城市',
+    isp: '运营商',
+    browser_version: '浏览器版本',
+    os_version: '系统版本',
+    brand: '品牌',
+    model: '型号',
+    status: '状态码',
+    spider: '蜘蛛',
+    suspicious: '可疑',
+};
+
 // 模块级变量
 let websiteSelector = null;
 let dateRange = null;
 let currentWebsiteId = '';
 
+// 浏览器、系统和设备排行的汇总粒度，由表头的下拉框切换
+const rankingLevels = { browser: 'name', os: 'name', device: 'class' };
+const clientRankings = {
+    browser: { fetch: fetchBrowserStats, update: updateBrowserTable },
+    os: { fetch: fetchOSStats, update: updateOsTable },
+    device: { fetch: fetchDeviceStats, update: updateDeviceTable },
+};
+
 // 初始化应用
 function initApp() {
     // 获取控件元素
@@ -52,6 +94,7 @@ function initApp() {
     initThemeManager(); // 初始化主题
     initChart(); // 初始化图表
     initGeoMap(); // 初始化地图
+    initHeatmap(); // 初始化星期 x 小时热力图
     initSites(); // 初始化网站选择器并绑定回调
     bindEventListeners();  // 绑定事件监听器
 }
@@ -63,6 +106,7 @@ async function initSites() {
 
         // 初始化时也要更新导航链接
         updateNavLinks(currentWebsiteId);
+        updateRealtimeWebsiteId(currentWebsiteId);
 
         refreshData();
 
@@ -78,6 +122,7 @@ function handleWebsiteSelected(websiteId) {
 
     // 更新导航链接，带上当前站点ID
     updateNavLinks(websiteId);
+    updateRealtimeWebsiteId(websiteId);
 
     refreshData();
 }
@@ -87,6 +132,11 @@ function updateNavLinks(websiteId) {
     const spidersLink = document.getElementById('spiders-link');
     const logsLink = document.getElementById('logs-link');
 
+    // 蜘蛛和日志页面只支持单个站点
+    if (isSiteScope(websiteId)) {
+        return;
+    }
+
     if (spidersLink && websiteId) {
         spidersLink.href = `/spiders?id=${websiteId}`;
     }
@@ -98,6 +148,54 @@ function updateNavLinks(websiteId) {
 // 绑定事件监听器
 function bindEventListeners() {
     dateRange.addEventListener('change', handleDateRangeChange);
+    document.addEventListener('filterschange', handleFiltersChange);
+    document.getElementById('clear-filters').addEventListener('click', clearFilters);
+    document.querySelectorAll('.ranking-level').forEach(select => {
+        select.addEventListener('change', () => handleRankingLevelChange(select.dataset.stats, select.value));
+    });
+}
+
+// 切换排行的汇总粒度，只刷新对应的表格
+async function handleRankingLevelChange(stats, level) {
+    rankingLevels[stats] = level;
+    const ranking = clientRankings[stats];
+    try {
+        const data = await ranking.fetch(currentWebsiteId, dateRange.value, 10, level);
+        ranking.update(data, level);
+    } catch (error) {
+        console.error('加载排行失败:', error);
+    }
+}
+
+// 处理维度过滤变化：更新过滤条并刷新整个看板
+function handleFiltersChange() {
+    renderFilterBar();
+    refreshData();
+}
+
+// 显示当前生效的过滤条件，每个条件可单独移除
+function renderFilterBar() {
+    const filterBar = document.getElementById('filter-bar');
+    const chips = document.getElementById('filter-chips');
+    const filters = getFilters();
+
+    chips.innerHTML = '';
+    Object.entries(filters).forEach(([dimension, value]) => {
+        const chip = document.createElement('span');
+        chip.className = 'filter-chip';
+        chip.textContent = `${filterLabels[dimension] || dimension}: ${value}`;
+
+        const remove = document.createElement('button');
+        remove.className = 'filter-chip-remove';
+        remove.textContent = '×';
+        remove.title = '移除此过滤';
+        remove.addEventListener('click', () => setFilter(dimension, ''));
+        chip.appendChild(remove);
+
+        chips.appendChild(chip);
+    });
+
+    filterBar.style.display = Object.keys(filters).length > 0 ? '' : 'none';
 }
 
 // 处理日期范围变化
@@ -115,24 +213,28 @@ async function refreshData() {
 
         updateChartWebsiteIdAndRange(currentWebsiteId, range);
         updateGeoMapWebsiteIdAndRange(currentWebsiteId, range);
+        updateHeatmapWebsiteIdAndRange(currentWebsiteId, range);
 
         const [overallData, urlStats, refererStats,
             browserStats, osStats, deviceStats] =
             await Promise.all([
-                fetchOverallStats(currentWebsiteId, range),
+                fetchOverallStats(currentWebsiteId, range, 'previous'),
                 fetchUrlStats(currentWebsiteId, range, 10),
                 fetchRefererStats(currentWebsiteId, range, 10),
-                fetchBrowserStats(currentWebsiteId, range, 10),
-                fetchOSStats(currentWebsiteId, range, 10),
-                fetchDeviceStats(currentWebsiteId, range, 10)
+                fetchBrowserStats(currentWebsiteId, range, 10, rankingLevels.browser),
+                fetchOSStats(currentWebsiteId, range, 10, rankingLevels.os),
+                fetchDeviceStats(currentWebsiteId, range, 10, rankingLevels.device)
             ]);
 
         updateOverallStats(overallData);
         updateUrlRankingTable(urlStats);
         updaterefererRankingTable(refererStats);
-        updateBrowserTable(browserStats);
-        updateOsTable(osStats);
-        updateDeviceTable(deviceStats);
+        updateBrowserTable(browserStats, rankingLevels.browser);
+        updateOsTable(osStats, rankingLevels.os);
+        updateDeviceTable(deviceStats, rankingLevels.device);
+
+        await updateSitesTable(currentWebsiteId, range);
+        await updateRetentionTable(currentWebsiteId);
 
     } catch (error) {
         console.error('加载网站数据失败:', error);
@@ -149,6 +251,90 @@ function updateOverallStats(overall) {
     document.getElementById('total-uv').textContent = overall.uv.toLocaleString();
     document.getElementById('total-pv').textContent = overall.pv.toLocaleString();
     document.getElementById('total-traffic').textContent = trafficDisplay;
+    document.getElementById('visitor-split').textContent =
+        `${(overall.new_visitors || 0).toLocaleString()} / ${(overall.returning_visitors || 0).toLocaleString()}`;
+
+    const compare = overall.compare || {};
+    updateStatChange('uv-change', compare.uv_change);
+    updateStatChange('pv-change', compare.pv_change);
+    updateStatChange('traffic-change', compare.traffic_change);
+}
+
+// 多站点范围时显示各站点对比表
+async function updateSitesTable(websiteId, range) {
+    const section = document.getElementById('sites-section');
+    if (!isSiteScope(websiteId)) {
+        section.style.display = 'none';
+        return;
+    }
+
+    const data = await fetchSitesStats(websiteId, range);
+    const tableBody = document.querySelector('#sites-table tbody');
+    tableBody.innerHTML = '';
+
+    data.sites.concat([data.total]).forEach(site => {
+        const row = document.createElement('tr');
+        row.innerHTML = `
+            <td class="item-path" title="${site.name}">${site.name}</td>
+            <td>${site.uv.toLocaleString()}</td>
+            <td>${site.pv.toLocaleString()}</td>
+            <td>${formatTraffic(site.traffic)}</td>
+            <td>${site.error_rate}%</td>
+        `;
+        tableBody.appendChild(row);
+    });
+    tableBody.lastElementChild.classList.add('total-row');
+    section.style.display = '';
+}
+
+// 显示按首次访问周划分的访客留存，多站点范围时不显示
+async function updateRetentionTable(websiteId) {
+    const section = document.getElementById('retention-section');
+    if (isSiteScope(websiteId)) {
+        section.style.display = 'none';
+        return;
+    }
+
+    const data = await fetchRetentionStats(websiteId, 8);
+    const cohorts = data.cohorts || [];
+    const table = document.getElementById('retention-table');
+
+    let header = '<tr><th class="url-col">首次访问周</th><th>新访客</th>';
+    for (let i = 1; i < cohorts.length; i++) {
+        header += `<th>第${i}周</th>`;
+    }
+    table.querySelector('thead').innerHTML = header + '</tr>';
+
+    const tableBody = table.querySelector('tbody');
+    tableBody.innerHTML = '';
+    cohorts.forEach(cohort => {
+        const row = document.createElement('tr');
+        let cells = `<td class="item-path">${cohort.week}</td><td>${cohort.visitors.toLocaleString()}</td>`;
+        cohort.rate.forEach((rate, i) => {
+            cells += `<td title="${cohort.returning[i]} 人">${rate}%</td>`;
+        });
+        row.innerHTML = cells;
+        tableBody.appendChild(row);
+    });
+    section.style.display = cohorts.length > 0 ? '' : 'none';
+}
+
+// 显示相对上一周期的变化，如 "+12% 较上期"
+function updateStatChange(elementId, change) {
+    const element = document.getElementById(elementId);
+    element.classList.remove('up', 'down');
+
+    if (change === undefined || change === null) {
+        element.textContent = '';
+        return;
+    }
+
+    element.textContent = `${change > 0 ? '+' : ''}${change}% 较上期`;
+    if (change > 0) {
+        element.classList.add('up');
+    } else if (change < 0) {
+        element.classList.add('down');
+    }
 }
 
 // 页面加载时初始化应用
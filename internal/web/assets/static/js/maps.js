@@ -1,7 +1,13 @@
 import {
     fectchLocationStats,
+    getFilters,
+    setFilter,
 } from './api.js';
 
+import {
+    bindFilterRow,
+} from './ranking.js';
+
 import {
     updateChartsTheme,
 } from './theme.js';
@@ -9,6 +15,7 @@ import {
 // 初始化地图实例
 let geoMapChart = null;
 let currentMapView = 'china'; // 默认显示中国地图
+let currentTableView = 'region'; // 排名表显示地区或运营商
 let currentWebsiteId = '';
 let range = 'today';
 
@@ -35,17 +42,47 @@ export function initGeoMap() {
 
     // 绑定地图视图切换事件
     bindMapViewToggle();
+    bindTableViewToggle();
+
+    // 点击地图区域按地区过滤，全球地图上点击中国时切换到国内地图继续下钻
+    geoMapChart.on('click', params => {
+        if (!params.name || !params.value) {
+            return;
+        }
+        if (currentMapView === 'world' && params.name === '中国') {
+            selectMapView('china');
+        }
+        setFilter('location', params.name);
+    });
 }
 
 // 绑定地图视图切换事件
 function bindMapViewToggle() {
-    const mapToggleBtns = document.querySelectorAll('.data-map-toggle-btn');
+    document.querySelectorAll('.data-map-toggle-btn').forEach(btn => {
+        btn.addEventListener('click', function () {
+            selectMapView(this.dataset.mapView);
+            updateGeoMap();
+        });
+    });
+}
 
-    mapToggleBtns.forEach(btn => {
+// 切换地图视图并更新按钮状态
+function selectMapView(view) {
+    currentMapView = view;
+    document.querySelectorAll('.data-map-toggle-btn').forEach(b => {
+        b.classList.toggle('active', b.dataset.mapView === view);
+    });
+}
+
+// 绑定排名表切换事件（地区 / 运营商）
+function bindTableViewToggle() {
+    const tableToggleBtns = document.querySelectorAll('.geo-table-toggle-btn');
+
+    tableToggleBtns.forEach(btn => {
         btn.addEventListener('click', function () {
-            currentMapView = this.dataset.mapView;
+            currentTableView = this.dataset.tableView;
 
-            mapToggleBtns.forEach(b => b.classList.remove('active'));
+            tableToggleBtns.forEach(b => b.classList.remove('active'));
             this.classList.add('active');
 
             updateGeoMap();
@@ -149,9 +186,10 @@ function renderWorldMap(geoData) {
     geoMapChart.setOption(option, true);
 }
 
-// 更新地区排名表格
-function updateGeoRankingTable(data) {
+// 更新地区排名表格，dimension 为点击行时设置的过滤维度
+function updateGeoRankingTable(data, title, dimension) {
     const tableBody = document.querySelector('#geo-ranking-table tbody');
+    document.querySelector('#geo-ranking-table .region-col').textContent = title;
 
     // 清空表格内容
     tableBody.innerHTML = '';
@@ -180,36 +218,55 @@ function updateGeoRankingTable(data) {
                 </div>
             </td>`;
 
+        bindFilterRow(row, dimension, item.name);
         tableBody.appendChild(row);
     });
 }
 
+// 将统计结果转换为地图和表格使用的数据，去掉无法定位的项
+function toGeoData(statsData) {
+    return statsData.key.map((location, index) => ({
+        name: location,
+        value: statsData.uv[index],
+        percentage: statsData.uv_percent[index]
+    })).filter(item => item.name !== '国外' && item.name !== '未知');
+}
+
+// 排名表的数据：运营商，或按当前地区过滤下钻一级（国家/省份 → 城市）
+async function fetchRankingData(geoData) {
+    if (currentTableView === 'isp') {
+        const statsData = await fectchLocationStats(currentWebsiteId, range, "isp", 10);
+        return { data: toGeoData(statsData), title: '运营商', dimension: 'isp' };
+    }
+
+    const location = getFilters().location;
+    if (location && location !== '中国') {
+        const statsData = await fectchLocationStats(currentWebsiteId, range, "city", 10);
+        return { data: toGeoData(statsData), title: `${location} · 城市`, dimension: 'city' };
+    }
+
+    const title = currentMapView === 'china' ? '省份' : '国家';
+    return { data: geoData.slice(0, 10), title, dimension: 'location' };
+}
+
 // 修改 updateGeoMap 函数来同时更新地图和排名表
 async function updateGeoMap() {
     let geoData;
 
     if (currentMapView === 'china') {
         const statsData = await fectchLocationStats(currentWebsiteId, range, "domestic", 99)
-        geoData = statsData.key.map((location, index) => ({
-            name: location,
-            value: statsData.uv[index],
-            percentage: statsData.uv_percent[index]
-        })).filter(item => item.name !== '国外' && item.name !== '未知');
+        geoData = toGeoData(statsData);
 
         renderChinaMap(geoData);
     } else {
         const statsData = await fectchLocationStats(currentWebsiteId, range, "global", 99)
-        geoData = statsData.key.map((location, index) => ({
-            name: location,
-            value: statsData.uv[index],
-            percentage: statsData.uv_percent[index]
-        })).filter(item => item.name !== '国外' && item.name !== '未知');
+        geoData = toGeoData(statsData);
 
         renderWorldMap(geoData);
     }
     updateChartsTheme();
 
     // 更新地区排名表格,去前10
-    geoData = geoData.slice(0, 10);
-    updateGeoRankingTable(geoData);
+    const ranking = await fetchRankingData(geoData);
+    updateGeoRankingTable(ranking.data, ranking.title, ranking.dimension);
 }
\ No newline at end of file
@@ -1,31 +1,45 @@
+import {
+    setFilter,
+} from './api.js';
+
 // 更新引荐来源排名表格
 export function updaterefererRankingTable(data) {
-    updateClientTable('referer-ranking-table', data);
+    updateClientTable('referer-ranking-table', data, false, 'referer');
 }
 
-// 更新浏览器统计表格
-export function updateBrowserTable(data) {
-    updateClientTable('browser-ranking-table', data);
+// 更新浏览器统计表格，按版本汇总时点击行按版本过滤
+export function updateBrowserTable(data, level = 'name') {
+    updateClientTable('browser-ranking-table', data, false, level === 'name' ? 'browser' : 'browser_version');
 }
 
 // 更新操作系统统计表格
-export function updateOsTable(data) {
-    updateClientTable('os-ranking-table', data);
+export function updateOsTable(data, level = 'name') {
+    updateClientTable('os-ranking-table', data, false, level === 'name' ? 'os' : 'os_version');
 }
 
-// 更新设备统计表格
-export function updateDeviceTable(data) {
-    updateClientTable('device-ranking-table', data);
+// 更新设备统计表格，level 为 class/brand/model
+export function updateDeviceTable(data, level = 'class') {
+    updateClientTable('device-ranking-table', data, false, level === 'class' ? 'device' : level);
 }
 
 // 更新URL排名表格
 export function updateUrlRankingTable(data) {
-    updateClientTable('url-ranking-table', data, true);
+    updateClientTable('url-ranking-table', data, true, 'url');
 }
 
 
+// 点击排名行时将该项设为过滤条件
+export function bindFilterRow(row, dimension, value) {
+    if (!dimension || !value) {
+        return;
+    }
+    row.classList.add('filterable-row');
+    row.title = '点击按此项过滤';
+    row.addEventListener('click', () => setFilter(dimension, value));
+}
+
 // 通用客户端表格更新函数 - 简化版本
-function updateClientTable(tableId, data, showPv = false) {
+function updateClientTable(tableId, data, showPv = false, dimension = '') {
     const tableBody = document.querySelector(`#${tableId} tbody`);
 
     // 清空表格内容
@@ -88,6 +102,7 @@ function updateClientTable(tableId, data, showPv = false) {
                     </div>
                 </td>`;
         }
+        bindFilterRow(row, dimension, itemlab);
         tableBody.appendChild(row);
     });
 }
\ No newline at end of file
@@ -0,0 +1,101 @@
+import {
+    isSiteScope,
+} from './api.js';
+
+// 最近请求列表显示的条数
+const recentLimit = 20;
+
+let eventSource = null;
+let recentRequests = [];
+
+// 切换站点时重新订阅实时数据，多站点范围时不显示
+export function updateRealtimeWebsiteId(websiteId) {
+    const section = document.getElementById('realtime-section');
+    if (!section) {
+        return;
+    }
+
+    if (eventSource) {
+        eventSource.close();
+        eventSource = null;
+    }
+    recentRequests = [];
+
+    if (!websiteId || isSiteScope(websiteId)) {
+        section.style.display = 'none';
+        return;
+    }
+    section.style.display = '';
+
+    // 认证信息在 Cookie 中，EventSource 会自动带上；断开后浏览器会自动重连
+    eventSource = new EventSource(`/api/realtime/stream?id=${encodeURIComponent(websiteId)}`);
+    eventSource.addEventListener('snapshot', event => {
+        updateSnapshot(JSON.parse(event.data));
+    });
+    eventSource.addEventListener('tail', event => {
+        addRecentRequests(JSON.parse(event.data));
+    });
+}
+
+// 更新实时概况
+function updateSnapshot(snapshot) {
+    document.getElementById('realtime-active').textContent = snapshot.active_visitors.toLocaleString();
+    document.getElementById('realtime-pv').textContent = snapshot.pageviews.toLocaleString();
+    document.getElementById('realtime-rps').textContent = snapshot.requests_per_second.toFixed(2);
+
+    // 数据只在扫描日志时更新，显示统计截至的日志时间
+    const time = document.getElementById('realtime-time');
+    if (snapshot.data_until) {
+        const until = new Date(snapshot.data_until * 1000).toLocaleTimeString();
+        const lag = Math.max(0, Math.round((snapshot.time - snapshot.data_until) / 60));
+        time.textContent = lag > 0 ? `数据截至 ${until}（${lag} 分钟前）` : `数据截至 ${until}`;
+    } else {
+        time.textContent = '最近没有新的访问';
+    }
+
+    const tableBody = document.querySelector('#realtime-pages-table tbody');
+    tableBody.innerHTML = '';
+    if (snapshot.top_pages.length === 0) {
+        tableBody.innerHTML = '<tr><td colspan="3">暂无数据</td></tr>';
+        return;
+    }
+    snapshot.top_pages.forEach(page => {
+        tableBody.appendChild(createRow([page.url, page.visitors.toLocaleString(), page.pv.toLocaleString()], 0));
+    });
+}
+
+// 把新入库的请求加到最近请求列表，新的在前
+function addRecentRequests(entries) {
+    const sorted = entries.slice().sort((a, b) => b.timestamp - a.timestamp);
+    recentRequests = sorted.concat(recentRequests).slice(0, recentLimit);
+
+    const tableBody = document.querySelector('#realtime-recent-table tbody');
+    tableBody.innerHTML = '';
+    if (recentRequests.length === 0) {
+        tableBody.innerHTML = '<tr><td colspan="4">暂无数据</td></tr>';
+        return;
+    }
+    recentRequests.forEach(entry => {
+        tableBody.appendChild(createRow([
+            new Date(entry.timestamp * 1000).toLocaleTimeString(),
+            `${entry.method} ${entry.url}`,
+            entry.status,
+            entry.location || entry.ip,
+        ], 1));
+    });
+}
+
+// 创建表格行，URL 等内容来自日志，按文本写入；pathIndex 列显示为可截断的路径
+function createRow(cells, pathIndex) {
+    const row = document.createElement('tr');
+    cells.forEach((value, i) => {
+        const cell = document.createElement('td');
+        cell.textContent = value;
+        if (i === pathIndex) {
+            cell.className = 'item-path';
+            cell.title = value;
+        }
+        row.appendChild(cell);
+    });
+    return row;
+}
@@ -54,21 +54,24 @@ async function scanLogs(path) {
     return await response.json();
 }
 
-async function addSite(name, logPath) {
+async function addSite(name, logPath, existingData = '') {
     const response = await fetch('/api/settings/add', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ name, logPath })
+        body: JSON.stringify({ name, logPath, existingData })
     });
     if (!response.ok) {
         const error = await response.json();
-        throw new Error(error.error || 'Failed to add site');
+        const err = new Error(error.error || 'Failed to add site');
+        err.status = response.status;
+        throw err;
     }
     return await response.json();
 }
 
-async function removeSite(id) {
-    const response = await fetch(`/api/settings/remove/${id}`, {
+async function removeSite(id, mode = 'purge', exportData = false) {
+    const params = new URLSearchParams({ mode, export: exportData });
+    const response = await fetch(`/api/settings/remove/${id}?${params.toString()}`, {
         method: 'DELETE'
     });
     if (!response.ok) {
@@ -78,6 +81,17 @@ async function removeSite(id) {
     return await response.json();
 }
 
+async function restoreSite(id) {
+    const response = await fetch(`/api/settings/restore/${id}`, {
+        method: 'POST'
+    });
+    if (!response.ok) {
+        const error = await response.json();
+        throw new Error(error.error || 'Failed to restore site');
+    }
+    return await response.json();
+}
+
 async function triggerLogScan() {
     const response = await fetch('/api/settings/scan-logs', {
         method: 'POST',
@@ -151,18 +165,22 @@ function renderSitesList() {
     tbody.innerHTML = sites.map(site => `
         <tr>
             <td><code>${escapeHtml(site.id)}</code></td>
-            <td>${escapeHtml(site.name)}</td>
+            <td>${escapeHtml(site.name)}${site.hidden ? '（已隐藏）' : ''}</td>
             <td><code>${escapeHtml(site.logPath)}</code></td>
             <td>
+                ${site.hidden ? `<button class="btn-restore" data-id="${escapeHtml(site.id)}">恢复</button>` : ''}
                 <button class="btn-delete" data-id="${escapeHtml(site.id)}" data-name="${escapeHtml(site.name)}">删除</button>
             </td>
         </tr>
     `).join('');
 
     // Attach delete button handlers
-    document.querySelectorAll('.btn-delete').forEach(btn => {
+    document.querySelectorAll('#sites-list .btn-delete').forEach(btn => {
         btn.addEventListener('click', handleDeleteSite);
     });
+    document.querySelectorAll('#sites-list .btn-restore').forEach(btn => {
+        btn.addEventListener('click', handleRestoreSite);
+    });
 }
 
 function renderExcludePatterns() {
@@ -296,15 +314,41 @@ async function handleDeleteSite(e) {
         return;
     }
 
+    // 确定：删除站点及全部数据；取消：仅隐藏站点，保留数据
+    const purge = confirm('是否同时删除该站点的全部数据？\n\n确定：删除全部数据，无法恢复\n取消：仅隐藏站点，数据保留');
+
     try {
-        await removeSite(id);
-        await loadSites();
-        alert('站点删除成功');
+        if (purge) {
+            const exportData = confirm('删除前是否导出站点数据？');
+            const result = await removeSite(id, 'purge', exportData);
+            await loadSites();
+            if (result.exportPath) {
+                alert(`站点删除成功，${result.exportedRows} 条数据已导出到 ${result.exportPath}`);
+            } else {
+                alert('站点删除成功');
+            }
+        } else {
+            await removeSite(id, 'soft');
+            await loadSites();
+            alert('站点已隐藏，数据已保留');
+        }
     } catch (error) {
         alert('删除失败: ' + error.message);
     }
 }
 
+async function handleRestoreSite(e) {
+    const id = e.target.dataset.id;
+
+    try {
+        await restoreSite(id);
+        await loadSites();
+        alert('站点已恢复');
+    } catch (error) {
+        alert('恢复失败: ' + error.message);
+    }
+}
+
 async function handleConfirmAddSite() {
     const name = document.getElementById('site-name').value.trim();
     const logPath = document.getElementById('site-log-path').value.trim();
@@ -315,8 +359,16 @@ async function handleConfirmAddSite() {
     }
 
     try {
-        // 添加站点
-        await addSite(name, logPath);
+        // 添加站点，同名站点存在遗留数据时需要用户选择保留或清除
+        try {
+            await addSite(name, logPath);
+        } catch (error) {
+            if (error.status !== 409) {
+                throw error;
+            }
+            const keep = confirm(`${error.message}\n\n确定：沿用历史数据\n取消：清除历史数据`);
+            await addSite(name, logPath, keep ? 'keep' : 'purge');
+        }
         await loadSites();
         hideModal();
 
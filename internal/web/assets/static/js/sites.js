@@ -1,12 +1,12 @@
-import { fetchWebsites } from './api.js';
+import { fetchSiteScopes } from './api.js';
 import { saveUserPreference, getUserPreference } from './utils.js';
 import { displayErrorMessage } from './charts.js';
 
 
 export async function initWebsiteSelector(selector, onWebsiteSelected) {
     try {
-        // 获取网站列表
-        const websites = await fetchWebsites();
+        // 获取网站列表和站点组
+        const { websites, groups } = await fetchSiteScopes();
 
         // 清空网站选择器
         selector.innerHTML = '';
@@ -27,6 +27,18 @@ export async function initWebsiteSelector(selector, onWebsiteSelected) {
             selector.appendChild(option);
         });
 
+        // 多个站点时提供汇总选项
+        if (websites.length > 1 || groups.length > 0) {
+            const scopes = [{ id: 'all', name: '全部站点' }]
+                .concat(groups.map(group => ({ id: group.id, name: `站点组: ${group.name}` })));
+            scopes.forEach(scope => {
+                const option = document.createElement('option');
+                option.value = scope.id;
+                option.textContent = scope.name;
+                selector.appendChild(option);
+            });
+        }
+
         // 尝试从localStorage获取上次选择的网站
         const lastSelected = getUserPreference('selectedWebsite', '');
         let currentWebsiteId = '';
@@ -69,4 +69,13 @@ export function updateChartsTheme() {
 
         window.geoMapChart.setOption(theme, false);
     }
+
+    if (window.heatmapChart) {
+        window.heatmapChart.setOption({
+            visualMap: {
+                inRange: { color: isDarkMode ? ['#2a3440', '#7eb9ff'] : ['#f5fbff', '#006edd'] },
+                textStyle: { color: isDarkMode ? '#e0e0e0' : '#333' }
+            }
+        }, false);
+    }
 }
\ No newline at end of file
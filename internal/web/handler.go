@@ -2,16 +2,22 @@ package web
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/auth"
+	"github.com/beyondxinxin/nixvis/internal/export"
 	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"github.com/beyondxinxin/nixvis/internal/report"
 	"github.com/beyondxinxin/nixvis/internal/stats"
 	"github.com/beyondxinxin/nixvis/internal/storage"
 	"github.com/beyondxinxin/nixvis/internal/util"
@@ -20,6 +26,13 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+const (
+	// realtimeTailSize 实时接口首次返回的最近请求数
+	realtimeTailSize = 50
+	// realtimeSnapshotInterval 实时流推送概况的间隔
+	realtimeSnapshotInterval = 5 * time.Second
+)
+
 // LogFile represents a discovered log file
 type LogFile struct {
 	Name string `json:"name"`
@@ -111,6 +124,16 @@ func isAccessLog(path string) bool {
 		(strings.HasSuffix(lower, ".log") && !strings.Contains(lower, "error"))
 }
 
+// queryError 查询参数错误的响应，日志查询语法错误时附带出错位置（从 0 开始的字符序号）
+func queryError(err error) gin.H {
+	resp := gin.H{"error": err.Error()}
+	var qe *stats.QueryError
+	if errors.As(err, &qe) {
+		resp["position"] = qe.Pos
+	}
+	return resp
+}
+
 func SetupRoutes(
 	router *gin.Engine,
 	statsFactory *stats.StatsFactory,
@@ -118,6 +141,16 @@ func SetupRoutes(
 	logParser *storage.LogParser,
 	db *sql.DB) {
 
+	// 站点有新日志入库或数据被清除时删除相关的统计缓存
+	logParser.OnCommit(statsFactory.InvalidateWebsite)
+
+	// 定期邮件报表
+	reports := report.NewScheduler(statsFactory, repo)
+	reports.Start()
+
+	// 统计结果和日志导出
+	exports := export.NewManager(statsFactory, repo)
+
 	// 加载模板
 	tmpl, err := LoadTemplates()
 	if err != nil {
@@ -179,8 +212,18 @@ func SetupRoutes(
 				})
 			}
 
+			// 站点组以 "group:<名称>" 作为 id，可直接用于统计接口
+			groups := make([]map[string]string, 0)
+			for _, group := range util.GetSiteGroups() {
+				groups = append(groups, map[string]string{
+					"id":   util.SiteGroupPrefix + group.Name,
+					"name": group.Name,
+				})
+			}
+
 			c.JSON(http.StatusOK, gin.H{
 				"websites": websites,
+				"groups":   groups,
 			})
 		})
 
@@ -196,9 +239,7 @@ func SetupRoutes(
 			query, err := statsFactory.BuildQueryFromRequest(statsType, params)
 
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": err.Error(),
-				})
+				c.JSON(http.StatusBadRequest, queryError(err))
 				return
 			}
 
@@ -474,23 +515,793 @@ func SetupRoutes(
 			c.JSON(http.StatusOK, blockResult)
 		})
 
+		// ========== Archive API ==========
+
+		// GET /api/archive - 列出站点已归档的日期
+		protectedAPI.GET("/archive", func(c *gin.Context) {
+			websiteID := c.Query("id")
+			if websiteID == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少网站 ID"})
+				return
+			}
+
+			entries, err := repo.ListArchivedDays(websiteID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"archives": entries,
+			})
+		})
+
+		// POST /api/archive/restore - 按需恢复某一天的归档日志
+		protectedAPI.POST("/archive/restore", func(c *gin.Context) {
+			var req struct {
+				WebsiteID string `json:"website_id"`
+				Date      string `json:"date"`
+			}
+
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+
+			if req.WebsiteID == "" || req.Date == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "站点 ID 和日期不能为空"})
+				return
+			}
+
+			restored, err := logParser.RestoreArchivedDay(req.WebsiteID, req.Date)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success":  true,
+				"restored": restored,
+			})
+		})
+
+		// ========== Database Admin API ==========
+
+		// GET /api/admin/database - 数据库大小、空闲页和各表占用空间
+		protectedAPI.GET("/admin/database", func(c *gin.Context) {
+			dbStats, err := repo.GetDatabaseStats()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"database":   dbStats,
+				"compaction": repo.GetCompactionStatus(),
+			})
+		})
+
+		// POST /api/admin/database/compact - 在后台启动压缩任务
+		protectedAPI.POST("/admin/database/compact", func(c *gin.Context) {
+			if err := repo.StartCompaction(); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusAccepted, gin.H{
+				"success":    true,
+				"compaction": repo.GetCompactionStatus(),
+			})
+		})
+
+		// GET /api/admin/database/compact - 查询压缩任务进度
+		protectedAPI.GET("/admin/database/compact", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"compaction": repo.GetCompactionStatus(),
+			})
+		})
+
+		// GET /api/admin/cache - 统计缓存的容量和命中情况
+		protectedAPI.GET("/admin/cache", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"cache": statsFactory.CacheMetrics(),
+			})
+		})
+
+		// ========== Realtime API ==========
+
+		// GET /api/realtime?id= - 当前的实时概况和最近的请求
+		protectedAPI.GET("/realtime", func(c *gin.Context) {
+			id := c.Query("id")
+			if _, ok := util.GetWebsiteByID(id); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", id)})
+				return
+			}
+
+			live := logParser.Realtime()
+			c.JSON(http.StatusOK, gin.H{
+				"snapshot": live.Snapshot(id),
+				"recent":   live.Recent(id, realtimeTailSize),
+			})
+		})
+
+		// GET /api/realtime/stream?id= - 以 SSE 推送实时数据：
+		// snapshot 事件定时推送实时概况，tail 事件推送新入库的请求
+		protectedAPI.GET("/realtime/stream", func(c *gin.Context) {
+			id := c.Query("id")
+			if _, ok := util.GetWebsiteByID(id); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", id)})
+				return
+			}
+
+			live := logParser.Realtime()
+			entries, unsubscribe := live.Subscribe(id)
+			defer unsubscribe()
+
+			ticker := time.NewTicker(realtimeSnapshotInterval)
+			defer ticker.Stop()
+
+			c.Header("Cache-Control", "no-cache")
+			c.Header("X-Accel-Buffering", "no") // 避免经 Nginx 反向代理时被缓冲
+
+			c.SSEvent("snapshot", live.Snapshot(id))
+			c.SSEvent("tail", live.Recent(id, realtimeTailSize))
+			c.Stream(func(w io.Writer) bool {
+				select {
+				case <-c.Request.Context().Done():
+					return false
+				case batch := <-entries:
+					c.SSEvent("tail", batch)
+				case <-ticker.C:
+					c.SSEvent("snapshot", live.Snapshot(id))
+				}
+				return true
+			})
+		})
+
+		// ========== Anomaly API ==========
+
+		// GET /api/anomalies?id=&severity=&limit= - 按时间倒序列出流量异常，不传 id 时列出全部站点
+		protectedAPI.GET("/anomalies", func(c *gin.Context) {
+			id := c.Query("id")
+			if id != "" {
+				if _, ok := util.GetWebsiteByID(id); !ok {
+					c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", id)})
+					return
+				}
+			}
+
+			severity := c.Query("severity")
+			if severity != "" && severity != storage.AnomalySeverityWarning &&
+				severity != storage.AnomalySeverityCritical {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "severity 只能是 warning 或 critical"})
+				return
+			}
+
+			limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+			if err != nil || limit <= 0 || limit > 500 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须在 1 到 500 之间"})
+				return
+			}
+
+			anomalies, err := repo.ListAnomalies(id, severity, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"anomalies": anomalies,
+			})
+		})
+
+		// POST /api/anomalies/:anomalyId/ack?id= - 确认流量异常
+		protectedAPI.POST("/anomalies/:anomalyId/ack", func(c *gin.Context) {
+			anomalyID, err := strconv.ParseInt(c.Param("anomalyId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "异常 ID 无效"})
+				return
+			}
+
+			if err := repo.AcknowledgeAnomaly(c.Query("id"), anomalyID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// ========== Goals API ==========
+
+		// GET /api/goals?id= - 获取站点的目标和漏斗
+		protectedAPI.GET("/goals", func(c *gin.Context) {
+			id := c.Query("id")
+			if _, ok := util.GetWebsiteByID(id); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", id)})
+				return
+			}
+
+			goals, err := repo.ListGoals(id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			funnels, err := repo.ListFunnels(id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"goals":   goals,
+				"funnels": funnels,
+			})
+		})
+
+		// POST /api/goals - 新建目标，对已入库的日志同样生效
+		protectedAPI.POST("/goals", func(c *gin.Context) {
+			var req storage.Goal
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			if _, ok := util.GetWebsiteByID(req.WebsiteID); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", req.WebsiteID)})
+				return
+			}
+
+			goal, err := repo.CreateGoal(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"goal":    goal,
+			})
+		})
+
+		// DELETE /api/goals/:goalId?id= - 删除目标
+		protectedAPI.DELETE("/goals/:goalId", func(c *gin.Context) {
+			goalID, err := strconv.ParseInt(c.Param("goalId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "目标 ID 无效"})
+				return
+			}
+
+			if err := repo.DeleteGoal(c.Query("id"), goalID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// POST /api/funnels - 新建漏斗，steps 为按顺序排列的目标 ID
+		protectedAPI.POST("/funnels", func(c *gin.Context) {
+			var req storage.Funnel
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			if _, ok := util.GetWebsiteByID(req.WebsiteID); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", req.WebsiteID)})
+				return
+			}
+
+			funnel, err := repo.CreateFunnel(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"funnel":  funnel,
+			})
+		})
+
+		// DELETE /api/funnels/:funnelId?id= - 删除漏斗
+		protectedAPI.DELETE("/funnels/:funnelId", func(c *gin.Context) {
+			funnelID, err := strconv.ParseInt(c.Param("funnelId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "漏斗 ID 无效"})
+				return
+			}
+
+			if err := repo.DeleteFunnel(c.Query("id"), funnelID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// ========== Reports API ==========
+
+		// GET /api/reports - 获取当前用户的报表订阅
+		protectedAPI.GET("/reports", func(c *gin.Context) {
+			userID, _ := auth.GetUserID(c)
+			subs, err := repo.ListReportSubscriptions(userID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"reports": subs})
+		})
+
+		// POST /api/reports - 新建报表订阅
+		protectedAPI.POST("/reports", func(c *gin.Context) {
+			var req storage.ReportSubscription
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.UserID, _ = auth.GetUserID(c)
+
+			sub, err := repo.CreateReportSubscription(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"report":  sub,
+			})
+		})
+
+		// PUT /api/reports/:reportId - 修改报表订阅
+		protectedAPI.PUT("/reports/:reportId", func(c *gin.Context) {
+			reportID, err := strconv.ParseInt(c.Param("reportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "报表 ID 无效"})
+				return
+			}
+			var req storage.ReportSubscription
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.ID = reportID
+			req.UserID, _ = auth.GetUserID(c)
+
+			sub, err := repo.UpdateReportSubscription(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"report":  sub,
+			})
+		})
+
+		// DELETE /api/reports/:reportId - 删除报表订阅
+		protectedAPI.DELETE("/reports/:reportId", func(c *gin.Context) {
+			reportID, err := strconv.ParseInt(c.Param("reportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "报表 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			if err := repo.DeleteReportSubscription(userID, reportID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// GET /api/reports/:reportId/preview - 在浏览器中查看最近一期报表
+		protectedAPI.GET("/reports/:reportId/preview", func(c *gin.Context) {
+			reportID, err := strconv.ParseInt(c.Param("reportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "报表 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			sub, err := repo.GetReportSubscription(userID, reportID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			_, html, err := reports.Preview(sub, time.Now())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+		})
+
+		// POST /api/reports/:reportId/send - 立即发送最近一期报表，不影响定期发送的时间
+		protectedAPI.POST("/reports/:reportId/send", func(c *gin.Context) {
+			reportID, err := strconv.ParseInt(c.Param("reportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "报表 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			sub, err := repo.GetReportSubscription(userID, reportID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			now := time.Now()
+			sendErr := reports.Send(sub, now)
+			if err := repo.RecordReportSent(sub.ID, now, sendErr, 0); err != nil {
+				logrus.WithError(err).Error("更新报表发送状态失败")
+			}
+			if sendErr != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": sendErr.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success":    true,
+				"recipients": sub.Recipients,
+			})
+		})
+
+		// ========== Alerts API ==========
+
+		// GET /api/alerts/channels - 获取全部通知渠道
+		protectedAPI.GET("/alerts/channels", func(c *gin.Context) {
+			channels, err := repo.ListAlertChannels()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"channels": channels})
+		})
+
+		// POST /api/alerts/channels - 新建通知渠道
+		protectedAPI.POST("/alerts/channels", func(c *gin.Context) {
+			var req storage.AlertChannel
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.ID = 0
+
+			channel, err := repo.SaveAlertChannel(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"channel": channel,
+			})
+		})
+
+		// PUT /api/alerts/channels/:channelId - 修改通知渠道
+		protectedAPI.PUT("/alerts/channels/:channelId", func(c *gin.Context) {
+			channelID, err := strconv.ParseInt(c.Param("channelId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "渠道 ID 无效"})
+				return
+			}
+			var req storage.AlertChannel
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.ID = channelID
+
+			channel, err := repo.SaveAlertChannel(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"channel": channel,
+			})
+		})
+
+		// DELETE /api/alerts/channels/:channelId - 删除通知渠道
+		protectedAPI.DELETE("/alerts/channels/:channelId", func(c *gin.Context) {
+			channelID, err := strconv.ParseInt(c.Param("channelId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "渠道 ID 无效"})
+				return
+			}
+
+			if err := repo.DeleteAlertChannel(channelID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// POST /api/alerts/channels/:channelId/test - 通过渠道发送一条测试消息
+		protectedAPI.POST("/alerts/channels/:channelId/test", func(c *gin.Context) {
+			channelID, err := strconv.ParseInt(c.Param("channelId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "渠道 ID 无效"})
+				return
+			}
+			channel, err := repo.GetAlertChannel(channelID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			event := storage.AlertEvent{
+				RuleName: "测试消息",
+				Message:  fmt.Sprintf("[NixVis 告警] 这是一条来自渠道 %s 的测试消息", channel.Name),
+				FiredAt:  time.Now().Unix(),
+			}
+			if err := storage.SendAlert(channel, event); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// GET /api/alerts/rules - 获取全部告警规则
+		protectedAPI.GET("/alerts/rules", func(c *gin.Context) {
+			rules, err := repo.ListAlertRules()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"rules": rules})
+		})
+
+		// POST /api/alerts/rules - 新建告警规则
+		protectedAPI.POST("/alerts/rules", func(c *gin.Context) {
+			var req storage.AlertRule
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.ID = 0
+
+			rule, err := repo.SaveAlertRule(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"rule":    rule,
+			})
+		})
+
+		// PUT /api/alerts/rules/:ruleId - 修改告警规则
+		protectedAPI.PUT("/alerts/rules/:ruleId", func(c *gin.Context) {
+			ruleID, err := strconv.ParseInt(c.Param("ruleId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "规则 ID 无效"})
+				return
+			}
+			var req storage.AlertRule
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.ID = ruleID
+
+			rule, err := repo.SaveAlertRule(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"rule":    rule,
+			})
+		})
+
+		// DELETE /api/alerts/rules/:ruleId - 删除告警规则
+		protectedAPI.DELETE("/alerts/rules/:ruleId", func(c *gin.Context) {
+			ruleID, err := strconv.ParseInt(c.Param("ruleId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "规则 ID 无效"})
+				return
+			}
+
+			if err := repo.DeleteAlertRule(ruleID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
+		// GET /api/alerts/history?id=&rule=&limit= - 按时间倒序列出触发过的告警，不传 id 时列出全部站点
+		protectedAPI.GET("/alerts/history", func(c *gin.Context) {
+			limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+			if err != nil || limit <= 0 || limit > 500 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须在 1 到 500 之间"})
+				return
+			}
+			var ruleID int64
+			if value := c.Query("rule"); value != "" {
+				parsed, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "规则 ID 无效"})
+					return
+				}
+				ruleID = parsed
+			}
+
+			events, err := repo.ListAlertHistory(c.Query("id"), ruleID, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"alerts": events})
+		})
+
+		// ========== Export API ==========
+
+		// GET /api/export/:type?format= - 直接下载统计结果或日志（type 为 logs），
+		// 查询参数与 /api/stats/:type 相同，内容边查询边写出
+		protectedAPI.GET("/export/:type", func(c *gin.Context) {
+			exportType := c.Param("type")
+			format := c.DefaultQuery("format", export.FormatCSV)
+			if err := export.ValidFormat(format); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			params := make(map[string]string)
+			for key, values := range c.Request.URL.Query() {
+				if len(values) > 0 && key != "format" {
+					params[key] = values[0]
+				}
+			}
+
+			query, err := exports.Prepare(exportType, params)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, queryError(err))
+				return
+			}
+
+			fileName := export.FileName(exportType, query.WebsiteID, format, time.Now())
+			c.Header("Content-Type", export.ContentType(format))
+			c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+			rows, err := exports.Write(c.Request.Context(), c.Writer, format, exportType, query, nil)
+			if err != nil {
+				if !c.Writer.Written() {
+					c.Writer.Header().Del("Content-Disposition")
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				// 已经开始写出，只能中断下载
+				logrus.WithError(err).Errorf("导出[%s]在第 %d 行后中断", exportType, rows)
+			}
+		})
+
+		// GET /api/exports - 获取当前用户的后台导出任务
+		protectedAPI.GET("/exports", func(c *gin.Context) {
+			userID, _ := auth.GetUserID(c)
+			jobs, err := repo.ListExportJobs(userID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"exports": jobs})
+		})
+
+		// POST /api/exports - 新建后台导出任务，适合数据量大的导出，完成后通过 download 下载
+		protectedAPI.POST("/exports", func(c *gin.Context) {
+			var req storage.ExportJob
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+				return
+			}
+			req.UserID, _ = auth.GetUserID(c)
+
+			job, err := exports.Submit(req)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, queryError(err))
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"export":  job,
+			})
+		})
+
+		// GET /api/exports/:exportId - 获取导出任务的状态和进度
+		protectedAPI.GET("/exports/:exportId", func(c *gin.Context) {
+			exportID, err := strconv.ParseInt(c.Param("exportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "导出任务 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			job, err := repo.GetExportJob(userID, exportID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"export": job})
+		})
+
+		// GET /api/exports/:exportId/download - 下载已完成的导出文件
+		protectedAPI.GET("/exports/:exportId/download", func(c *gin.Context) {
+			exportID, err := strconv.ParseInt(c.Param("exportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "导出任务 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			job, err := repo.GetExportJob(userID, exportID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			if job.Status != storage.ExportDone {
+				c.JSON(http.StatusConflict, gin.H{"error": "导出任务尚未完成"})
+				return
+			}
+
+			c.Header("Content-Type", export.ContentType(job.Format))
+			c.FileAttachment(job.FilePath(), job.FileName)
+		})
+
+		// DELETE /api/exports/:exportId - 删除导出任务及文件，未完成的任务会被取消
+		protectedAPI.DELETE("/exports/:exportId", func(c *gin.Context) {
+			exportID, err := strconv.ParseInt(c.Param("exportId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "导出任务 ID 无效"})
+				return
+			}
+
+			userID, _ := auth.GetUserID(c)
+			if err := exports.Delete(userID, exportID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"success": true})
+		})
+
 		// ========== Settings API ==========
 
 		// GET /api/settings - 获取当前配置
 		protectedAPI.GET("/settings", func(c *gin.Context) {
-			websiteIDs := util.GetAllWebsiteIDs()
+			websiteIDs := append(util.GetAllWebsiteIDs(), util.GetHiddenWebsiteIDs()...)
 
-			websites := make([]map[string]string, 0, len(websiteIDs))
+			websites := make([]map[string]interface{}, 0, len(websiteIDs))
 			for _, id := range websiteIDs {
 				website, ok := util.GetWebsiteByID(id)
 				if !ok {
 					continue
 				}
 
-				websites = append(websites, map[string]string{
+				websites = append(websites, map[string]interface{}{
 					"id":      id,
 					"name":    website.Name,
 					"logPath": website.LogPath,
+					"hidden":  website.Hidden,
 				})
 			}
 
@@ -534,8 +1345,9 @@ func SetupRoutes(
 		// POST /api/settings/add - 添加站点
 		protectedAPI.POST("/settings/add", func(c *gin.Context) {
 			var req struct {
-				Name    string `json:"name"`
-				LogPath string `json:"logPath"`
+				Name         string `json:"name"`
+				LogPath      string `json:"logPath"`
+				ExistingData string `json:"existingData"` // 同名站点遗留数据的处理方式: keep / purge
 			}
 
 			if err := c.ShouldBindJSON(&req); err != nil {
@@ -548,6 +1360,31 @@ func SetupRoutes(
 				return
 			}
 
+			// 同名站点的旧数据不能被悄悄沿用，需要明确保留或清除
+			if _, exists := util.GetWebsiteByID(util.GenerateID(req.Name)); !exists {
+				leftover, err := repo.WebsiteLogCount(util.GenerateID(req.Name))
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				if leftover > 0 {
+					switch req.ExistingData {
+					case "keep":
+					case "purge":
+						if _, _, err := logParser.PurgeWebsite(util.GenerateID(req.Name), false); err != nil {
+							c.JSON(http.StatusInternalServerError, gin.H{"error": "清除遗留数据失败: " + err.Error()})
+							return
+						}
+					default:
+						c.JSON(http.StatusConflict, gin.H{
+							"error":        fmt.Sprintf("站点 %s 存在 %d 条历史数据，请选择保留或清除", req.Name, leftover),
+							"existingRows": leftover,
+						})
+						return
+					}
+				}
+			}
+
 			if err := util.AddWebsite(req.Name, req.LogPath); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
@@ -572,6 +1409,8 @@ func SetupRoutes(
 		})
 
 		// DELETE /api/settings/remove/:id - 删除站点
+		// mode=purge（默认）删除配置和全部数据（包括归档和导出任务），export=true 时先导出日志和归档；
+		// mode=soft 仅隐藏站点，数据保留，可通过 restore 恢复
 		protectedAPI.DELETE("/settings/remove/:id", func(c *gin.Context) {
 			id := c.Param("id")
 			if id == "" {
@@ -579,7 +1418,67 @@ func SetupRoutes(
 				return
 			}
 
-			if err := util.RemoveWebsite(id); err != nil {
+			if _, ok := util.GetWebsiteByID(id); !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("站点 %s 不存在", id)})
+				return
+			}
+
+			mode := c.DefaultQuery("mode", "purge")
+			switch mode {
+			case "soft":
+				if err := util.SetWebsiteHidden(id, true); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "隐藏站点失败: " + err.Error()})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{
+					"success": true,
+					"mode":    mode,
+				})
+
+			case "purge":
+				// 先删除数据再移除配置：删除失败时站点保持原样，可以重试
+				exports.CancelWebsite(id)
+				exportPath, exportedRows, err := logParser.PurgeWebsite(id, c.Query("export") == "true")
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "删除站点失败，站点未删除: " + err.Error()})
+					return
+				}
+
+				if err := util.RemoveWebsite(id); err != nil {
+					// 数据已经删除，站点仍在配置中，重建空表让站点可以继续使用
+					logrus.WithError(err).Errorf("站点 %s 的数据已删除，但从配置中移除失败", id)
+					if err := repo.CreateTableForWebsite(id); err != nil {
+						logrus.WithError(err).Errorf("重建站点 %s 的数据表失败", id)
+					}
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"error":      "站点数据已删除，但从配置中移除站点失败，请重试或手动修改配置文件: " + err.Error(),
+						"dataPurged": true,
+					})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{
+					"success":      true,
+					"mode":         mode,
+					"exportPath":   exportPath,
+					"exportedRows": exportedRows,
+				})
+
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "mode 参数无效，必须为 purge 或 soft"})
+			}
+		})
+
+		// POST /api/settings/restore/:id - 恢复已隐藏的站点
+		protectedAPI.POST("/settings/restore/:id", func(c *gin.Context) {
+			id := c.Param("id")
+			if id == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "缺少站点 ID"})
+				return
+			}
+
+			if err := util.SetWebsiteHidden(id, false); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}